@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Access log formats understood by GoAccess, awstats and friends
+const (
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+)
+
+// newAccessLog writes one line per request to out in Apache common or
+// combined log format (AFROBASE_ACCESS_LOG_FORMAT, default combined)
+func newAccessLog(out io.Writer, format string) (fiber.Handler, error) {
+	switch format {
+	case "":
+		format = accessLogCombined
+	case accessLogCommon, accessLogCombined:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		if err := c.Next(); err != nil {
+			// Let the error handler set the status that is actually sent
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				c.Status(500)
+			}
+		}
+
+		line := accessLogLine(c, start, format == accessLogCombined)
+		if _, err := io.WriteString(out, line); err != nil {
+			log.Printf("Error writing access log: %v", err)
+		}
+		return nil
+	}, nil
+}
+
+// accessLogLine formats a request as
+//
+//	host ident authuser [date] "request" status bytes
+//
+// followed by "referer" "user-agent" in combined format
+func accessLogLine(c *fiber.Ctx, start time.Time, combined bool) string {
+	size := "-"
+	if n := len(c.Response().Body()); n > 0 {
+		size = strconv.Itoa(n)
+	}
+	request := c.Method() + " " + c.OriginalURL() + " " + string(c.Request().Header.Protocol())
+	// The API key's name stands in for the authenticated user
+	user := "-"
+	if key := apiKeyName(c); key != "" {
+		user = strings.Map(logNameRune, key)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s [%s] %s %d %s", c.IP(), user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		quoteLogField(request), c.Response().StatusCode(), size)
+	if combined {
+		fmt.Fprintf(&b, " %s %s", quoteLogField(c.Get("Referer")), quoteLogField(c.Get("User-Agent")))
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// logNameRune keeps unquoted fields to one token
+func logNameRune(r rune) rune {
+	if r <= ' ' || r == '"' || r == 0x7f {
+		return '_'
+	}
+	return r
+}
+
+// quoteLogField quotes a value the way Apache does, escaping quotes and
+// control characters so a request can't forge log lines
+func quoteLogField(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
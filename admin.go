@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	expvarmw "github.com/gofiber/fiber/v2/middleware/expvar"
+	"github.com/gofiber/fiber/v2/middleware/pprof"
+)
+
+// startAdminListener serves runtime debugging endpoints on their own
+// address (AFROBASE_ADMIN_ADDR), so they're never reachable through the
+// public port:
+//
+//	/debug/pprof/  heap, CPU, goroutine and other profiles
+//	/debug/vars    expvar counters, including the processing queue and
+//	               per-route latency percentiles
+//	/metrics       the same and more for Prometheus: uploads, rejections,
+//	               latency histograms, error counts, storage usage and
+//	               goroutine and heap figures
+//	/api/admin/    administrative API: the audit log, bulk metadata
+//	               export and import as CSV, storage statistics and
+//	               legal holds
+//
+// Every request needs "Authorization: Bearer <AFROBASE_ADMIN_TOKEN>", e.g.
+//
+//	curl -H "Authorization: Bearer $TOKEN" http://127.0.0.1:6060/debug/pprof/heap > heap.out
+//	go tool pprof heap.out
+func startAdminListener(addr, token string) {
+	expvar.Publish("variant_queue_length", expvar.Func(func() any {
+		return len(variantQueue)
+	}))
+	expvar.Publish("transforms_running", expvar.Func(func() any {
+		if p, ok := imageProcessor.(limitedProcessor); ok {
+			return len(p.slots)
+		}
+		return 0
+	}))
+	expvar.Publish("latency_ms", expvar.Func(func() any {
+		return latencySnapshot()
+	}))
+
+	admin := fiber.New(fiber.Config{DisableStartupMessage: true})
+	admin.Use(requireAdminToken(token))
+	admin.Use(pprof.New())
+	admin.Use(expvarmw.New())
+	admin.Get("/metrics", getMetrics)
+	admin.Get("/api/admin/audit", getAuditLog)
+	admin.Get("/api/admin/metadata", exportMetadata)
+	admin.Post("/api/admin/metadata", importMetadata)
+	admin.Get("/api/admin/stats/breakdown", getStatsBreakdown)
+	admin.Get("/api/admin/legal-holds", getLegalHolds)
+	admin.Put("/api/admin/images/:id/legal-hold", setLegalHold)
+	admin.Put("/api/admin/albums/:id/legal-hold", setAlbumLegalHold)
+	admin.Get("/api/admin/invites", listInvites)
+	admin.Post("/api/admin/invites", createInvite)
+	admin.Delete("/api/admin/invites/:id", deleteInvite)
+	if eventSourcing {
+		admin.Get("/api/admin/events", listMetadataEvents)
+		admin.Get("/api/admin/events/state", getMetadataStateAt)
+	}
+
+	go func() {
+		log.Printf("Admin listener on %s", addr)
+		if err := admin.Listen(addr); err != nil {
+			log.Fatal("Failed to start admin listener:", err)
+		}
+	}()
+}
+
+// requireAdminToken rejects requests that don't carry the admin bearer token
+func requireAdminToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		given, ok := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Set("WWW-Authenticate", "Bearer")
+			return c.Status(401).JSON(fiber.Map{
+				"error":   "Admin token required",
+				"success": false,
+			})
+		}
+		return c.Next()
+	}
+}
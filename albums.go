@@ -0,0 +1,792 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// album groups images under a name. Images are kept by stored filename in
+// the order they were added; the cover is one of them. Albums created with
+// an access token belong to that user, who alone may change them. Images
+// uploaded with a moderated upload link wait in Pending until approved.
+// Albums nest like folders: Parent is the album one sits in, "" at the top.
+// Write-once albums are sealed when published.
+type album struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Cover       string      `json:"cover,omitempty"`
+	Images      []string    `json:"images"`
+	Created     int64       `json:"created"`
+	Updated     int64       `json:"updated"`
+	LegalHold   *legalHold  `json:"legal_hold,omitempty"`
+	Owner       string      `json:"owner,omitempty"`
+	Pending     []string    `json:"pending,omitempty"`
+	Parent      string      `json:"parent,omitempty"`
+	WriteOnce   bool        `json:"write_once,omitempty"`
+	Published   int64       `json:"published,omitempty"`
+	Seal        *albumSeal  `json:"seal,omitempty"`
+	SealBreaks  []sealBreak `json:"seal_breaks,omitempty"`
+	// Version counts the album's changes, for If-Match
+	Version int64 `json:"version"`
+}
+
+// maxAlbumNameLength bounds album names
+const maxAlbumNameLength = 200
+
+var albumsBucket = []byte("albums")
+
+var (
+	errAlbumNotFound = errors.New("album not found")
+	errAlbumCycle    = errors.New("album can't be put inside itself")
+)
+
+// contains reports whether an image is in the album
+func (a *album) contains(name string) bool {
+	return slices.Contains(a.Images, name)
+}
+
+// coverImage is the album's cover, or its first image if none was chosen
+func (a *album) coverImage() string {
+	if a.Cover != "" {
+		return a.Cover
+	}
+	if len(a.Images) > 0 {
+		return a.Images[0]
+	}
+	return ""
+}
+
+// view is the API representation of an album
+func (a *album) view() fiber.Map {
+	images := make([]string, len(a.Images))
+	for i, name := range a.Images {
+		images[i] = imageID(name)
+	}
+	view := fiber.Map{
+		"id":          a.ID,
+		"name":        a.Name,
+		"description": a.Description,
+		"images":      images,
+		"image_count": len(a.Images),
+		"images_url":  "/api/images?album=" + a.ID,
+		"created_at":  a.Created,
+		"updated_at":  a.Updated,
+		"legal_hold":  a.LegalHold != nil,
+		"write_once":  a.WriteOnce,
+		"sealed":      a.Seal != nil,
+		"version":     a.Version,
+		// A PDF of the album's thumbnails, for review
+		"contact_sheet_url": "/api/contact-sheet?album=" + a.ID,
+	}
+	if a.Owner != "" {
+		view["owner"] = a.Owner
+	}
+	if a.Parent != "" {
+		view["parent"] = a.Parent
+	}
+	if a.Published != 0 {
+		view["published_at"] = a.Published
+	}
+	if a.Seal != nil {
+		view["sealed_since"] = a.Seal.Since
+	}
+	if len(a.SealBreaks) > 0 {
+		view["seal_breaks"] = a.SealBreaks
+	}
+	if cover := a.coverImage(); cover != "" {
+		view["cover_id"] = imageID(cover)
+		view["cover_url"] = a.coverURL()
+	}
+	return view
+}
+
+// coverURL is the path of the album cover's thumbnail, or of the cover
+// itself until one is ready
+func (a *album) coverURL() string {
+	cover := a.coverImage()
+	urls, _ := variantURLs(cover)
+	if thumb := thumbnailPath(cover, urls); thumb != "" {
+		return thumb
+	}
+	return displayPath(cover)
+}
+
+// putAlbum saves an album
+func (s *metadataStore) putAlbum(a album) error {
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx, albumsBucket, []byte(a.ID), value)
+	})
+}
+
+// album loads an album by ID
+func (s *metadataStore) album(id string) (album, bool) {
+	var a album
+	found := false
+	if s == nil {
+		return a, false
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(albumsBucket)
+		if bucket == nil {
+			return nil
+		}
+		if value := bucket.Get([]byte(id)); value != nil {
+			found = json.Unmarshal(value, &a) == nil
+		}
+		return nil
+	})
+	return a, found
+}
+
+// albums returns every album, oldest first
+func (s *metadataStore) albums() ([]album, error) {
+	var albums []album
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(albumsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var a album
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			albums = append(albums, a)
+			return nil
+		})
+	})
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].Created != albums[j].Created {
+			return albums[i].Created < albums[j].Created
+		}
+		return albums[i].ID < albums[j].ID
+	})
+	return albums, err
+}
+
+// updateAlbum applies change to an album in one transaction, so
+// concurrent edits don't lose each other's changes
+func (s *metadataStore) updateAlbum(id string, change func(a *album) error) (album, error) {
+	var a album
+	err := s.transact(func(t *metadataTx) (err error) {
+		a, err = t.updateAlbum(id, change)
+		return err
+	})
+	return a, err
+}
+
+// updateAlbum applies change to an album within a transaction
+func (t *metadataTx) updateAlbum(id string, change func(a *album) error) (album, error) {
+	var a album
+	bucket := t.tx.Bucket(albumsBucket)
+	value := bucket.Get([]byte(id))
+	if value == nil {
+		return a, errAlbumNotFound
+	}
+	if err := json.Unmarshal(value, &a); err != nil {
+		return a, err
+	}
+	if err := change(&a); err != nil {
+		return a, err
+	}
+	a.Updated = serverClock.Now().Unix()
+	a.Version++
+	value, err := json.Marshal(a)
+	if err != nil {
+		return a, err
+	}
+	return a, putRecord(t.tx, albumsBucket, []byte(id), value)
+}
+
+// deleteAlbum removes an album, leaving its images alone. Albums inside
+// it move up into its parent.
+func (s *metadataStore) deleteAlbum(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(albumsBucket)
+		var deleted album
+		if value := bucket.Get([]byte(id)); value != nil {
+			if err := json.Unmarshal(value, &deleted); err != nil {
+				return err
+			}
+		}
+		children := map[string][]byte{}
+		err := bucket.ForEach(func(k, v []byte) error {
+			var a album
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if a.Parent != id {
+				return nil
+			}
+			a.Parent = deleted.Parent
+			a.Updated = serverClock.Now().Unix()
+			a.Version++
+			value, err := json.Marshal(a)
+			children[string(k)] = value
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// Buckets can't be changed while they are iterated over
+		for k, value := range children {
+			if err := putRecord(tx, albumsBucket, []byte(k), value); err != nil {
+				return err
+			}
+		}
+		return deleteRecord(tx, albumsBucket, []byte(id))
+	})
+}
+
+// checkParent reports why an album with ID id can't be put in parent, if
+// it can't: parent must be an album the request may change, and not id or
+// one inside it. id is "" for new albums.
+func checkParent(c *fiber.Ctx, id, parent string) error {
+	return metadata.db.View(func(tx *bolt.Tx) error {
+		return (&metadataTx{tx: tx}).checkParent(c, id, parent)
+	})
+}
+
+// checkParent checks a parent within a transaction. Moves check it in the
+// transaction that makes them, so two made at once can't form a cycle
+// that neither would alone.
+func (t *metadataTx) checkParent(c *fiber.Ctx, id, parent string) error {
+	bucket := t.tx.Bucket(albumsBucket)
+	get := func(id string) (album, bool) {
+		var a album
+		value := bucket.Get([]byte(id))
+		return a, value != nil && json.Unmarshal(value, &a) == nil
+	}
+	p, ok := get(parent)
+	if !ok {
+		return errAlbumNotFound
+	}
+	if !ownedByCaller(c, p.Owner) {
+		return errNotOwner
+	}
+	seen := map[string]bool{}
+	for p.ID != "" && !seen[p.ID] {
+		if p.ID == id {
+			return errAlbumCycle
+		}
+		seen[p.ID] = true
+		p, _ = get(p.Parent)
+	}
+	return nil
+}
+
+// sendParentError answers a request whose parent checkParent refused
+func sendParentError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Parent album not found",
+			"success": false,
+		})
+	case errors.Is(err, errNotOwner):
+		return sendNotOwner(c, "Parent album belongs to another user")
+	default:
+		return c.Status(422).JSON(fiber.Map{
+			"error":   "An album can't be put inside itself",
+			"success": false,
+		})
+	}
+}
+
+// removeFromAlbums takes a deleted image out of every album
+func (s *metadataStore) removeFromAlbums(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		changed := map[string][]byte{}
+		err := tx.Bucket(albumsBucket).ForEach(func(k, v []byte) error {
+			var a album
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if !a.contains(name) && !slices.Contains(a.Pending, name) {
+				return nil
+			}
+			a.Images = slices.DeleteFunc(a.Images, func(image string) bool { return image == name })
+			a.Pending = slices.DeleteFunc(a.Pending, func(image string) bool { return image == name })
+			if a.Cover == name {
+				a.Cover = ""
+			}
+			a.Updated = serverClock.Now().Unix()
+			a.Version++
+			value, err := json.Marshal(a)
+			changed[string(k)] = value
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// Buckets can't be changed while they are iterated over
+		for k, value := range changed {
+			if err := putRecord(tx, albumsBucket, []byte(k), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// albumsOf returns the albums an image is in
+func (s *metadataStore) albumsOf(name string) []album {
+	albums, err := s.albums()
+	if err != nil {
+		log.Printf("Error reading albums: %v", err)
+		return nil
+	}
+	return slices.DeleteFunc(albums, func(a album) bool { return !a.contains(name) })
+}
+
+// albumRequest is the body of album create and update requests, where
+// any field may be left out
+type albumRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Cover       *string `json:"cover"`
+	Parent      *string `json:"parent"`
+	WriteOnce   *bool   `json:"write_once"`
+}
+
+// parseAlbumRequest reads and checks an album request
+func parseAlbumRequest(c *fiber.Ctx) (albumRequest, error) {
+	var req albumRequest
+	if err := c.BodyParser(&req); err != nil {
+		return req, errors.New("Body must be JSON")
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return req, errors.New("Album name can't be empty")
+		}
+		if utf8.RuneCountInString(name) > maxAlbumNameLength {
+			return req, errors.New("Album name is too long")
+		}
+		req.Name = &name
+	}
+	if req.Description != nil && utf8.RuneCountInString(*req.Description) > maxDescriptionLength {
+		return req, errors.New("Album description is too long")
+	}
+	return req, nil
+}
+
+// listAlbums lists every album: GET /api/albums. With an access token
+// only the user's own albums are listed, unless ?scope=all.
+func listAlbums(c *fiber.Ctx) error {
+	owner, err := listingOwner(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+	albums, err := metadata.albums()
+	if err != nil {
+		log.Printf("Error reading albums: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read albums",
+			"success": false,
+		})
+	}
+	views := make([]fiber.Map, 0, len(albums))
+	for i := range albums {
+		if owner == "" || albums[i].Owner == owner {
+			views = append(views, albums[i].view())
+		}
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"albums":  views,
+	})
+}
+
+// createAlbum creates an empty album: POST /api/albums with
+// {"name": "...", "description": "..."}, "parent" to put it inside
+// another album and "write_once": true to seal it when it is published
+func createAlbum(c *fiber.Ctx) error {
+	req, err := parseAlbumRequest(c)
+	if err == nil && req.Name == nil {
+		err = errors.New("Album name is required")
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+	if req.Parent != nil && *req.Parent != "" {
+		if err := checkParent(c, "", *req.Parent); err != nil {
+			return sendParentError(c, err)
+		}
+	}
+
+	id, err := newID(8)
+	if err != nil {
+		log.Printf("Error generating album ID: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create album",
+			"success": false,
+		})
+	}
+	now := serverClock.Now().Unix()
+	a := album{ID: id, Name: *req.Name, Images: []string{}, Created: now, Updated: now}
+	if req.Description != nil {
+		a.Description = *req.Description
+	}
+	if req.Parent != nil {
+		a.Parent = *req.Parent
+	}
+	if req.WriteOnce != nil {
+		a.WriteOnce = *req.WriteOnce
+	}
+	if u, ok := requestUser(c); ok {
+		a.Owner = u.ID
+	}
+	if err := metadata.putAlbum(a); err != nil {
+		log.Printf("Error saving album: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create album",
+			"success": false,
+		})
+	}
+	audit(c, "album_create", a.ID)
+	log.Printf("Album created: %s (%s)", a.ID, a.Name)
+
+	return c.Status(201).JSON(fiber.Map{
+		"success": true,
+		"album":   a.view(),
+	})
+}
+
+// getAlbum returns one album: GET /api/albums/:id. Its images are listed
+// in full by GET /api/images?album=:id. The ETag is the album's version.
+func getAlbum(c *fiber.Ctx) error {
+	a, ok := metadata.album(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	}
+	c.Set(fiber.HeaderETag, versionTag(a.Version))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"album":   a.view(),
+	})
+}
+
+// updateAlbum renames an album, changes its description, sets its cover or
+// moves it: PATCH /api/albums/:id with any of {"name", "description",
+// "cover", "parent", "write_once"}. The cover is the ID of an image in the
+// album, or "" to go back to the first; the parent is an album to move it
+// into, or "" for the top. A sealed album stays write-once until its seal
+// is broken. If-Match must carry the album's version: a 428 answers
+// requests without it and a 412, with the current album, those made
+// against another version.
+func updateAlbum(c *fiber.Ctx) error {
+	req, err := parseAlbumRequest(c)
+	if err == nil && req.Name == nil && req.Description == nil && req.Cover == nil && req.Parent == nil && req.WriteOnce == nil {
+		err = errors.New("Nothing to change: give a name, description, cover, parent or write_once")
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	var cover string
+	if req.Cover != nil && *req.Cover != "" {
+		var ok bool
+		if cover, ok = findUpload(*req.Cover); !ok {
+			return c.Status(404).JSON(fiber.Map{
+				"error":   "Cover image not found",
+				"success": false,
+			})
+		}
+	}
+
+	errNotInAlbum := errors.New("cover is not in the album")
+	var stale album
+	var parentErr error
+	var a album
+	err = metadata.transact(func(t *metadataTx) (err error) {
+		if req.Parent != nil && *req.Parent != "" {
+			if parentErr = t.checkParent(c, c.Params("id"), *req.Parent); parentErr != nil {
+				return parentErr
+			}
+		}
+		a, err = t.updateAlbum(c.Params("id"), func(a *album) error {
+			if !ownedByCaller(c, a.Owner) {
+				return errNotOwner
+			}
+			if err := checkVersion(c, a.Version); err != nil {
+				stale = *a
+				return err
+			}
+			if req.Cover != nil {
+				if cover != "" && !a.contains(cover) {
+					return errNotInAlbum
+				}
+				a.Cover = cover
+			}
+			if req.Name != nil {
+				a.Name = *req.Name
+			}
+			if req.Description != nil {
+				a.Description = *req.Description
+			}
+			if req.Parent != nil {
+				a.Parent = *req.Parent
+			}
+			if req.WriteOnce != nil {
+				if !*req.WriteOnce && a.Seal != nil {
+					return errAlbumSealed
+				}
+				a.WriteOnce = *req.WriteOnce
+			}
+			return nil
+		})
+		return err
+	})
+	if parentErr != nil {
+		return sendParentError(c, parentErr)
+	}
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	case errors.Is(err, errNotOwner):
+		return sendNotOwner(c, "Album belongs to another user")
+	case errors.Is(err, errAlbumSealed):
+		return sendSealed(c, "album_update", c.Params("id"), "Album is sealed: break the seal before making it writable")
+	case errors.Is(err, errVersionRequired):
+		return sendVersionRequired(c)
+	case errors.Is(err, errStaleVersion):
+		return c.Status(412).JSON(fiber.Map{
+			"error":   "Album has changed since the version in If-Match",
+			"success": false,
+			"current": stale.view(),
+			"yours":   req,
+		})
+	case errors.Is(err, errNotInAlbum):
+		return c.Status(422).JSON(fiber.Map{
+			"error":   "Cover image is not in the album",
+			"success": false,
+		})
+	case err != nil:
+		log.Printf("Error updating album %s: %v", c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update album",
+			"success": false,
+		})
+	}
+	audit(c, "album_update", a.ID)
+
+	c.Set(fiber.HeaderETag, versionTag(a.Version))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"album":   a.view(),
+	})
+}
+
+// deleteAlbumHandler deletes an album but not its images:
+// DELETE /api/albums/:id
+func deleteAlbumHandler(c *fiber.Ctx) error {
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+
+	a, ok := metadata.album(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	}
+	if !ownedByCaller(c, a.Owner) {
+		return sendNotOwner(c, "Album belongs to another user")
+	}
+	if a.LegalHold != nil {
+		audit(c, "album_delete_refused", a.ID)
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Album is under legal hold",
+			"success": false,
+		})
+	}
+	if a.Seal != nil {
+		return sendSealed(c, "album_delete", a.ID, "Album is sealed")
+	}
+	if err := metadata.deleteAlbum(a.ID); err != nil {
+		log.Printf("Error deleting album %s: %v", a.ID, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to delete album",
+			"success": false,
+		})
+	}
+	audit(c, "album_delete", a.ID)
+	log.Printf("Album deleted: %s (%s)", a.ID, a.Name)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      a.ID,
+	})
+}
+
+// addAlbumImages adds images to an album: POST /api/albums/:id/images
+// with {"images": ["<image id>", ...]}. Images already in it stay where
+// they are.
+func addAlbumImages(c *fiber.Ctx) error {
+	var body struct {
+		Images []string `json:"images"`
+	}
+	if err := c.BodyParser(&body); err != nil || len(body.Images) == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Body must be JSON with a non-empty images array",
+			"success": false,
+		})
+	}
+	names := make([]string, 0, len(body.Images))
+	for _, id := range body.Images {
+		name, ok := findUpload(id)
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{
+				"error":   "Image not found: " + id,
+				"success": false,
+			})
+		}
+		if !mayChange(c, name) {
+			return sendNotOwner(c, "Image belongs to another user: "+id)
+		}
+		names = append(names, name)
+	}
+
+	var added []string
+	a, err := metadata.updateAlbum(c.Params("id"), func(a *album) error {
+		if !ownedByCaller(c, a.Owner) {
+			return errNotOwner
+		}
+		if a.Seal != nil {
+			return errAlbumSealed
+		}
+		for _, name := range names {
+			if !a.contains(name) {
+				a.Images = append(a.Images, name)
+				added = append(added, name)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errAlbumNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	}
+	if errors.Is(err, errNotOwner) {
+		return sendNotOwner(c, "Album belongs to another user")
+	}
+	if errors.Is(err, errAlbumSealed) {
+		return sendSealed(c, "album_add", c.Params("id"), "Album is sealed")
+	}
+	if err != nil {
+		log.Printf("Error updating album %s: %v", c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update album",
+			"success": false,
+		})
+	}
+	for _, name := range added {
+		audit(c, "album_add", a.ID+"/"+name)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"album":   a.view(),
+	})
+}
+
+// removeAlbumImage takes an image out of an album without deleting it:
+// DELETE /api/albums/:id/images/:image
+func removeAlbumImage(c *fiber.Ctx) error {
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+
+	name, ok := findUpload(c.Params("image"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	errHeld := errors.New("album is under legal hold")
+	errNotInAlbum := errors.New("image is not in the album")
+	a, err := metadata.updateAlbum(c.Params("id"), func(a *album) error {
+		if !ownedByCaller(c, a.Owner) {
+			return errNotOwner
+		}
+		if !a.contains(name) {
+			return errNotInAlbum
+		}
+		if a.LegalHold != nil {
+			return errHeld
+		}
+		if a.Seal != nil {
+			return errAlbumSealed
+		}
+		a.Images = slices.DeleteFunc(a.Images, func(image string) bool { return image == name })
+		if a.Cover == name {
+			a.Cover = ""
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	case errors.Is(err, errNotOwner):
+		return sendNotOwner(c, "Album belongs to another user")
+	case errors.Is(err, errNotInAlbum):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image is not in the album",
+			"success": false,
+		})
+	case errors.Is(err, errHeld):
+		audit(c, "album_remove_refused", c.Params("id")+"/"+name)
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Album is under legal hold",
+			"success": false,
+		})
+	case errors.Is(err, errAlbumSealed):
+		return sendSealed(c, "album_remove", c.Params("id")+"/"+name, "Album is sealed")
+	case err != nil:
+		log.Printf("Error updating album %s: %v", c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update album",
+			"success": false,
+		})
+	}
+	audit(c, "album_remove", a.ID+"/"+name)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"album":   a.view(),
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiKeyHeader carries an API key for clients that can't set Authorization
+const apiKeyHeader = "X-API-Key"
+
+// apiKey is an accepted key. Secret keys may do anything. Publishable
+// keys are meant to be embedded in web pages: they only read, and only
+// from pages on their origins.
+type apiKey struct {
+	name        string
+	publishable bool
+	origins     map[string]bool
+}
+
+// apiKeys maps the SHA-256 of each accepted key to what it allows. Keys
+// are hashed so lookups don't leak them through timing.
+type apiKeys map[[sha256.Size]byte]apiKey
+
+// requireReadKey makes reads of the JSON API need a key as well
+// (AFROBASE_REQUIRE_READ_KEY=on). Images and pages stay public.
+var requireReadKey = os.Getenv("AFROBASE_REQUIRE_READ_KEY") == "on"
+
+// loadAPIKeys reads keys from AFROBASE_API_KEYS, a comma-separated list of
+// name:key pairs, and from the file named by AFROBASE_API_KEYS_FILE, which
+// holds one key per line with # comments:
+//
+//	ci          <key>
+//	publishable gallery <key> https://example.com https://www.example.com
+//
+// It returns nil when neither is set.
+func loadAPIKeys() (apiKeys, error) {
+	list, path := os.Getenv("AFROBASE_API_KEYS"), os.Getenv("AFROBASE_API_KEYS_FILE")
+	if list == "" && path == "" {
+		return nil, nil
+	}
+
+	keys := apiKeys{}
+	add := func(k apiKey, key, where string) error {
+		if k.name == "" || key == "" {
+			return fmt.Errorf("%s: expected a name and a key", where)
+		}
+		if len(key) < 16 {
+			return fmt.Errorf("%s: key for %s is shorter than 16 characters", where, k.name)
+		}
+		hash := sha256.Sum256([]byte(key))
+		if _, ok := keys[hash]; ok {
+			return fmt.Errorf("%s: key for %s is already in use", where, k.name)
+		}
+		keys[hash] = k
+		return nil
+	}
+
+	if list != "" {
+		for _, pair := range strings.Split(list, ",") {
+			name, key, _ := strings.Cut(strings.TrimSpace(pair), ":")
+			if err := add(apiKey{name: name}, key, "AFROBASE_API_KEYS"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			where := fmt.Sprintf("%s:%d", path, line)
+			fields := strings.Fields(text)
+			var err error
+			switch {
+			case fields[0] == "publishable" && len(fields) >= 4:
+				k := apiKey{name: fields[1], publishable: true, origins: map[string]bool{}}
+				for _, origin := range fields[3:] {
+					if !isOrigin(origin) {
+						return nil, fmt.Errorf("%s: %q is not an origin such as https://example.com", where, origin)
+					}
+					k.origins[strings.ToLower(origin)] = true
+				}
+				err = add(k, fields[2], where)
+			case fields[0] == "publishable":
+				err = fmt.Errorf("%s: publishable keys need a name, a key and at least one origin", where)
+			case len(fields) == 2:
+				err = add(apiKey{name: fields[0]}, fields[1], where)
+			default:
+				err = fmt.Errorf("%s: expected a name and a key", where)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no API keys configured")
+	}
+	return keys, nil
+}
+
+// isOrigin reports whether s is a bare scheme://host[:port]
+func isOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// requireAPIKey checks the key a request carries, as "Authorization:
+// Bearer <key>" or in X-API-Key. Anything that changes data needs a secret
+// key. Reads need no key unless requireReadKey is set for /api/, but any
+// key given must be valid. Publishable keys may also come as ?key= on
+// reads, are refused for writes and from other origins, and answer CORS
+// for their own origin only. The key's name is kept for the logs. Users
+// with an access token need no key, nor do uploads made with an album's
+// upload link, and anyone may register or log in.
+func requireAPIKey(keys apiKeys) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := requestUser(c); ok || strings.HasPrefix(c.Path(), "/api/auth/") {
+			return c.Next()
+		}
+		if _, ok := requestUploadLink(c); ok {
+			return c.Next()
+		}
+		var read bool
+		switch c.Method() {
+		case fiber.MethodOptions:
+			return c.Next()
+		case fiber.MethodGet, fiber.MethodHead:
+			read = true
+		}
+
+		key, fromQuery := c.Get(apiKeyHeader), false
+		if key == "" {
+			key, _ = strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		}
+		if key == "" && read {
+			key, fromQuery = c.Query("key"), true
+		}
+
+		if key == "" {
+			if read && !(requireReadKey && strings.HasPrefix(c.Path(), "/api/")) {
+				return c.Next()
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(401).JSON(fiber.Map{
+				"error":   "A valid API key is required",
+				"success": false,
+			})
+		}
+
+		k, ok := keys[sha256.Sum256([]byte(key))]
+		if !ok || (fromQuery && !k.publishable) {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(401).JSON(fiber.Map{
+				"error":   "A valid API key is required",
+				"success": false,
+			})
+		}
+		if k.publishable {
+			if !read {
+				return c.Status(403).JSON(fiber.Map{
+					"error":   "Publishable keys are read-only",
+					"success": false,
+				})
+			}
+			origin := strings.ToLower(c.Get(fiber.HeaderOrigin))
+			if origin != "" && !k.origins[origin] {
+				return c.Status(403).JSON(fiber.Map{
+					"error":   "Origin is not allowed for this key",
+					"success": false,
+				})
+			}
+			if origin != "" {
+				c.Set(fiber.HeaderAccessControlAllowOrigin, strings.Clone(c.Get(fiber.HeaderOrigin)))
+				c.Vary(fiber.HeaderOrigin)
+			}
+		}
+		c.Locals("api_key", k.name)
+		c.Locals("api_key_publishable", k.publishable)
+		return c.Next()
+	}
+}
+
+// apiKeyName is the name of the key a request was made with, or ""
+func apiKeyName(c *fiber.Ctx) string {
+	name, _ := c.Locals("api_key").(string)
+	return name
+}
+
+// hasSecretKey reports whether a request was made with a secret key.
+// Publishable keys are in page source for anyone to take, so they grant
+// nothing an anonymous request doesn't have.
+func hasSecretKey(c *fiber.Ctx) bool {
+	publishable, _ := c.Locals("api_key_publishable").(bool)
+	return apiKeyName(c) != "" && !publishable
+}
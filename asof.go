@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// asOfColumns are the fields of historical image records. Images deleted
+// since have no URL.
+var asOfColumns = []string{
+	"id", "name", "size", "upload_time", "title", "description", "hash",
+	"seq", "changed_at", "deleted", "url",
+}
+
+// parseAsOf parses a point in time given as Unix seconds or RFC 3339
+func parseAsOf(v string) (int64, bool) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+		return n, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.Unix(), true
+	}
+	return 0, false
+}
+
+// getImageListAsOf lists the library as the change journal says it was at
+// a point in time: GET /api/images?as_of=2026-01-31T00:00:00Z. Titles and
+// descriptions are the ones current then, and seq is the journal entry
+// that last changed each image. Images journaled before metadata was
+// recorded show their filename as the title. With an owner, only images
+// that user had then are listed. Images hidden from the caller's listings,
+// now or then, are left out.
+func getImageListAsOf(c *fiber.Ctx, v string, owner string) error {
+	at, ok := parseAsOf(v)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "as_of must be Unix seconds or an RFC 3339 time",
+			"success": false,
+		})
+	}
+
+	state := changes.stateAt(at)
+	names := make([]string, 0, len(state))
+	for name, version := range state {
+		if owner != "" && (version.Meta == nil || version.Meta.Owner != owner) {
+			continue
+		}
+		if hiddenChange(c, version.changeEvent) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	p, ok := listingPage(c, len(names))
+	if !ok {
+		return sendPageError(c)
+	}
+	names = names[p.start:p.end]
+
+	// Flag the images that have been deleted since
+	objects, err := uploadStore.List(c.Context(), "")
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+	stored := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		stored[object.Key] = true
+	}
+
+	images := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		version := state[name]
+		meta := imageMeta{Title: imageID(name), UploadTime: version.Created}
+		if version.Meta != nil {
+			meta = *version.Meta
+			if meta.Title == "" {
+				meta.Title = imageID(name)
+			}
+		}
+		record := map[string]interface{}{
+			"id":          version.ID,
+			"name":        name,
+			"size":        version.Size,
+			"upload_time": meta.UploadTime,
+			"title":       meta.Title,
+			"description": meta.Description,
+			"hash":        version.Hash,
+			"seq":         version.Seq,
+			"changed_at":  version.At,
+			"deleted":     !stored[name],
+		}
+		if stored[name] {
+			record["url"] = publicBaseURL + displayPath(name)
+		}
+		images = append(images, record)
+	}
+	return sendImagePage(c, images, asOfColumns, p, fiber.Map{"as_of": at})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	blobIndexMu sync.Mutex
+	// blobIndex maps content hashes to the uploads with that content. It
+	// fills as uploads are hashed, which the change journal does for every
+	// file at startup and for each new upload. Owners don't share uploads,
+	// so the same content can be stored once for each.
+	blobIndex = make(map[string][]string)
+)
+
+// indexBlob records that an upload has the given content hash
+func indexBlob(hash, name string) {
+	blobIndexMu.Lock()
+	if !slices.Contains(blobIndex[hash], name) {
+		blobIndex[hash] = append(blobIndex[hash], name)
+	}
+	blobIndexMu.Unlock()
+}
+
+// lookupBlob finds an upload by content hash, the first indexed that match
+// accepts, or any if match is nil. Uploads may have been deleted or
+// replaced since they were indexed, so their hash is checked again.
+func lookupBlob(hash string, match func(name string) bool) (string, bool, error) {
+	blobIndexMu.Lock()
+	names := slices.Clone(blobIndex[hash])
+	blobIndexMu.Unlock()
+	for _, name := range names {
+		if match != nil && !match(name) {
+			continue
+		}
+		object, err := uploadStore.Stat(context.Background(), name)
+		if isMissing(err) {
+			continue
+		}
+		if err != nil {
+			return name, false, err
+		}
+		current, err := uploadHash(object.Info())
+		if err != nil {
+			return name, false, err
+		}
+		if current == hash {
+			return name, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// findDuplicate returns an upload with exactly this content and the same
+// set of variants that a request may be given in place of its own, if
+// there is one
+func findDuplicate(c *fiber.Ctx, data []byte, specs []variantSpec) (string, bool) {
+	sum := sha256.Sum256(data)
+	name, ok, err := lookupBlob(hex.EncodeToString(sum[:]), duplicateScope(c))
+	if err != nil {
+		log.Printf("Error checking for a duplicate of %s: %v", name, err)
+		return "", false
+	}
+	if !ok || len(specs) == 0 || variantSetFor(name)[0].Dir != specs[0].Dir {
+		return "", false
+	}
+	return name, true
+}
+
+// duplicateScope reports which uploads a request's upload may be a
+// duplicate of: those already in the album of the upload link it was made
+// with, or else those of the user who made it. Anything wider would tell
+// the uploader of other people's images.
+func duplicateScope(c *fiber.Ctx) func(name string) bool {
+	if key, ok := requestUploadLink(c); ok {
+		l, _ := metadata.uploadLink(key)
+		a, _ := metadata.album(l.Album)
+		return func(name string) bool { return a.contains(name) || slices.Contains(a.Pending, name) }
+	}
+	var owner string
+	if u, ok := requestUser(c); ok {
+		owner = u.ID
+	}
+	return func(name string) bool { return imageInfo(name).Owner == owner }
+}
+
+// blobURL is the checksum-addressed URL of an upload, or "" if it can't be
+// hashed
+func blobURL(info os.FileInfo) string {
+	hash, err := uploadHash(info)
+	if err != nil {
+		return ""
+	}
+	return publicBaseURL + "/blob/" + hash + path.Ext(info.Name())
+}
+
+// serveBlob serves an original upload by the SHA-256 of its content:
+// GET /blob/<sha256>, optionally followed by the file extension. The
+// content behind a URL never changes, so responses may be cached forever.
+func serveBlob(c *fiber.Ctx) error {
+	hash := strings.ToLower(c.Params("hash"))
+	if ext := path.Ext(hash); ext != "" {
+		hash = strings.TrimSuffix(hash, ext)
+	}
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != 64 {
+		return blobNotFound(c)
+	}
+	name, ok, err := lookupBlob(hash, nil)
+	if err != nil {
+		log.Printf("Error reading %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read file",
+			"success": false,
+		})
+	}
+	if !ok {
+		return blobNotFound(c)
+	}
+
+	if denied, err := privateDenied(c, name); denied {
+		return err
+	}
+	etag := `"` + hash + `"`
+	if isPrivate(name) {
+		c.Set(fiber.HeaderCacheControl, "private")
+	} else {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+	}
+	c.Set(fiber.HeaderETag, etag)
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(304)
+	}
+
+	r, object, err := uploadStore.Get(c.Context(), name)
+	if isMissing(err) {
+		return blobNotFound(c)
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read file",
+			"success": false,
+		})
+	}
+	// Lets clients check what they received against the URL (RFC 9530)
+	sum, _ := hex.DecodeString(hash)
+	c.Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	c.Type(strings.TrimPrefix(path.Ext(name), "."))
+	return c.SendStream(r, int(object.Size))
+}
+
+func blobNotFound(c *fiber.Ctx) error {
+	return c.Status(404).JSON(fiber.Map{
+		"error":   "Blob not found",
+		"success": false,
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// uploadBodyLimit bounds upload request bodies, which carry base64 images
+// or multipart forms
+const uploadBodyLimit = 50 * 1024 * 1024
+
+// defaultBodyLimit bounds bodies of routes without their own limit
+const defaultBodyLimit = 1 << 20
+
+// routeBodyLimits are the request body limits by "METHOD /path"
+var routeBodyLimits = map[string]int{
+	"POST /upload":      uploadBodyLimit,
+	"POST /upload/url":  64 << 10,
+	"POST /api/compare": 64 << 10,
+}
+
+// bodyLimitFor picks the body limit for a request from its headers alone.
+// Bodies are streamed, so fasthttp reads only the first few KB of them
+// before the handler runs; bufferBody and uploadBodyStream hold the rest of
+// the body to this limit. Uploads with a profile are held to the base64 size of the profile's
+// max_bytes, plus room for the rest of the JSON.
+func bodyLimitFor(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+	path, query, _ := strings.Cut(string(header.RequestURI()), "?")
+	route := string(header.Method()) + " " + path
+	limit, ok := routeBodyLimits[route]
+	if !ok {
+		limit = defaultBodyLimit
+	}
+	// Two-phase uploads PUT raw image bytes to /api/uploads/:id, and
+	// resumable ones PATCH chunks of them to /api/tus/:id
+	if string(header.Method()) == "PUT" && strings.HasPrefix(path, "/api/uploads/") ||
+		string(header.Method()) == "PATCH" && strings.HasPrefix(path, "/api/tus/") {
+		limit = uploadBodyLimit
+	}
+
+	if route == "POST /upload" && query != "" {
+		values, _ := url.ParseQuery(query)
+		if p, ok := uploadProfiles[values.Get("profile")]; ok && p.MaxBytes > 0 {
+			limit = min(limit, int(p.MaxBytes*4/3)+64<<10)
+		}
+	}
+	return fasthttp.RequestConfig{MaxRequestBodySize: limit}
+}
+
+// streamsBody reports whether a route reads its request body as a stream,
+// rather than having it buffered by bufferBody. Uploads to /upload are
+// decoded into a spool file as they arrive, so the encoded body is never
+// held in memory.
+func streamsBody(c *fiber.Ctx) bool {
+	return c.Method() == fiber.MethodPost && c.Path() == "/upload"
+}
+
+// buffersLate reports whether a route's body is buffered by readBody in
+// the route itself, after authentication and upload limits have passed.
+// Two-phase and resumable uploads carry up to uploadBodyLimit of raw bytes,
+// which shouldn't be read for requests that are turned away.
+func buffersLate(c *fiber.Ctx) bool {
+	return c.Method() == fiber.MethodPut && strings.HasPrefix(c.Path(), "/api/uploads/") ||
+		c.Method() == fiber.MethodPatch && strings.HasPrefix(c.Path(), "/api/tus/")
+}
+
+// bufferBody reads the request bodies of routes that don't stream them or
+// buffer them late
+func bufferBody(c *fiber.Ctx) error {
+	if streamsBody(c) || buffersLate(c) {
+		return c.Next()
+	}
+	return readBody(c)
+}
+
+// readBody reads a streamed request body, refusing those over the route's
+// limit with 413. A body that is refused part read can't be skipped, so
+// the connection is closed after the response.
+func readBody(c *fiber.Ctx) error {
+	req := c.Request()
+	if !req.IsBodyStream() {
+		return c.Next()
+	}
+	limit := bodyLimitFor(&req.Header).MaxRequestBodySize
+	if req.Header.ContentLength() > limit {
+		c.Context().SetConnectionClose()
+		return fiber.ErrRequestEntityTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(req.BodyStream(), int64(limit)+1))
+	if err != nil {
+		c.Context().SetConnectionClose()
+		return fiber.ErrBadRequest
+	}
+	if len(body) > limit {
+		c.Context().SetConnectionClose()
+		return fiber.ErrRequestEntityTooLarge
+	}
+	req.SetBody(body)
+	return c.Next()
+}
+
+// requestBodySize is the size of a request's body. Bodies still streamed,
+// which are never buffered or not yet, are taken at their Content-Length,
+// or 0 if they are chunked.
+func requestBodySize(c *fiber.Ctx) int {
+	if streamsBody(c) || c.Request().IsBodyStream() {
+		return max(0, c.Request().Header.ContentLength())
+	}
+	return len(c.Body())
+}
+
+// errBodyTooLarge is returned by a streamed body that runs past its limit
+var errBodyTooLarge = errors.New("request body exceeds the limit")
+
+// uploadBodyStream returns the body of a streamed request, which fails
+// with errBodyTooLarge past the route's limit, and a function that closes
+// the connection after the response if the handler left the body unread.
+func uploadBodyStream(c *fiber.Ctx) (*limitedBody, func()) {
+	req := c.Request()
+	body := &limitedBody{r: req.BodyStream(), left: int64(bodyLimitFor(&req.Header).MaxRequestBodySize)}
+	if body.r == nil {
+		body.r = bytes.NewReader(req.Body())
+	}
+	if n := req.Header.ContentLength(); n > 0 && int64(n) > body.left {
+		body.err = errBodyTooLarge
+	}
+	return body, func() {
+		if body.err != io.EOF {
+			c.Context().SetConnectionClose()
+		}
+	}
+}
+
+// limitedBody reads a request body up to a limit. Its err is io.EOF once
+// the body has been read to the end.
+type limitedBody struct {
+	r    io.Reader
+	left int64
+	read int64
+	err  error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	// Read one byte past the limit, to tell a body that ends there from
+	// one that goes on
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.r.Read(p)
+	if int64(n) > b.left {
+		b.err = errBodyTooLarge
+		return 0, b.err
+	}
+	b.left -= int64(n)
+	b.read += int64(n)
+	b.err = err
+	return n, err
+}
+
+// errorEnvelope renders errors that reach Fiber, such as unknown routes and
+// oversized bodies, in the same shape as handler errors
+func errorEnvelope(c *fiber.Ctx, err error) error {
+	code, message := 500, "Internal server error"
+	var e *fiber.Error
+	if errors.As(err, &e) {
+		code, message = e.Code, e.Message
+	}
+	return c.Status(code).JSON(fiber.Map{
+		"error":   message,
+		"success": false,
+	})
+}
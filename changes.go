@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// dataDir holds server state that isn't image content
+const dataDir = "./data"
+
+// Change types recorded in the journal
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeDelete = "delete"
+)
+
+// changeEvent is one entry of the change feed. Seq is strictly increasing
+// and doubles as the sync cursor. Creates and updates carry the image's
+// size and metadata at the time, so the journal can be replayed to any
+// point in the past.
+type changeEvent struct {
+	Seq  int64      `json:"seq"`
+	Type string     `json:"type"`
+	ID   string     `json:"id"`
+	Name string     `json:"name"`
+	Hash string     `json:"hash,omitempty"`
+	Size int64      `json:"size,omitempty"`
+	Meta *imageMeta `json:"meta,omitempty"`
+	At   int64      `json:"at"`
+}
+
+// changeJournal is an append-only log of changes to the library, kept in
+// memory and in a JSON-lines file so cursors survive restarts
+type changeJournal struct {
+	mu     sync.Mutex
+	file   *os.File
+	events []changeEvent
+}
+
+var changes *changeJournal
+
+// openChangeJournal loads the journal and records any changes made to the
+// uploads directory while the server was not running. The sandbox's
+// journal starts empty and is only kept in memory.
+func openChangeJournal() (*changeJournal, error) {
+	if sandbox {
+		j := &changeJournal{}
+		return j, j.reconcile()
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dataDir, "changes.jsonl")
+
+	j := &changeJournal{}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var event changeEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				log.Printf("Skipping corrupt change journal entry: %v", err)
+				continue
+			}
+			j.events = append(j.events, event)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+
+	if err := j.reconcile(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// reconcile compares the state the journal describes with the uploads
+// directory and metadata and appends events for whatever differs
+func (j *changeJournal) reconcile() error {
+	known := make(map[string]changeEvent)
+	for _, event := range j.events {
+		if event.Type == changeDelete {
+			delete(known, event.Name)
+		} else {
+			known[event.Name] = event
+		}
+	}
+
+	files, err := uploadedFiles()
+	if err != nil {
+		return err
+	}
+	onDisk := make(map[string]bool, len(files))
+	for _, file := range files {
+		onDisk[file.Name()] = true
+		hash, err := uploadHash(file)
+		if err != nil {
+			log.Printf("Error hashing %s: %v", file.Name(), err)
+			continue
+		}
+		event := changeEvent{Name: file.Name(), Hash: hash, Size: file.Size(), Meta: storedMeta(file.Name())}
+		previous, ok := known[file.Name()]
+		switch {
+		case !ok:
+			event.Type = changeCreate
+			j.append(event)
+		// Entries written before metadata was journaled aren't compared
+		case previous.Hash != hash || (previous.Meta != nil && !sameMeta(previous.Meta, event.Meta)):
+			event.Type = changeUpdate
+			j.append(event)
+		}
+	}
+	for name := range known {
+		if !onDisk[name] {
+			j.append(changeEvent{Type: changeDelete, Name: name})
+		}
+	}
+	return nil
+}
+
+// record appends a change for an upload, hashing its current content and
+// capturing its current metadata
+func (j *changeJournal) record(kind, name string) {
+	event := changeEvent{Type: kind, Name: name}
+	if kind != changeDelete {
+		object, err := uploadStore.Stat(context.Background(), name)
+		if err != nil {
+			log.Printf("Error recording %s of %s: %v", kind, name, err)
+			return
+		}
+		if event.Hash, err = uploadHash(object.Info()); err != nil {
+			log.Printf("Error hashing %s: %v", name, err)
+		}
+		event.Size = object.Size
+		event.Meta = storedMeta(name)
+	}
+	j.append(event)
+}
+
+// storedMeta returns an upload's stored metadata, or nil if it has none
+func storedMeta(name string) *imageMeta {
+	if meta, ok := metadata.get(name); ok {
+		return &meta
+	}
+	return nil
+}
+
+func sameMeta(a, b *imageMeta) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Title == b.Title && a.Description == b.Description && a.OriginalFilename == b.OriginalFilename &&
+		a.UploadTime == b.UploadTime && slices.Equal(a.Tags, b.Tags) && a.Private == b.Private &&
+		a.Unlisted == b.Unlisted && a.Pending == b.Pending &&
+		(a.FocalPoint == nil) == (b.FocalPoint == nil) && (a.FocalPoint == nil || *a.FocalPoint == *b.FocalPoint)
+}
+
+// append numbers and timestamps an event and writes it out
+func (j *changeJournal) append(event changeEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	event.Seq = 1
+	event.ID = imageID(event.Name)
+	event.At = serverClock.Now().Unix()
+	if n := len(j.events); n > 0 {
+		event.Seq = j.events[n-1].Seq + 1
+	}
+
+	line, _ := json.Marshal(event)
+	if j.file != nil {
+		if _, err := j.file.Write(append(line, '\n')); err != nil {
+			log.Printf("Error writing change journal: %v", err)
+		}
+	}
+	j.events = append(j.events, event)
+}
+
+// since returns up to limit events after cursor and whether more remain
+func (j *changeJournal) since(cursor int64, limit int) ([]changeEvent, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	start := sort.Search(len(j.events), func(i int) bool {
+		return j.events[i].Seq > cursor
+	})
+	end := min(start+limit, len(j.events))
+	out := make([]changeEvent, end-start)
+	copy(out, j.events[start:end])
+	return out, end < len(j.events)
+}
+
+// getChanges serves the change feed: GET /api/changes?since=<cursor>
+// returns creates, updates and deletes in order, plus the cursor to pass
+// next time. Changes to images the caller can't list are left out.
+func getChanges(c *fiber.Ctx) error {
+	cursor := int64(0)
+	if since := c.Query("since"); since != "" {
+		n, err := strconv.ParseInt(since, 10, 64)
+		if err != nil || n < 0 {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Invalid cursor",
+				"success": false,
+			})
+		}
+		cursor = n
+	}
+	limit := c.QueryInt("limit", 500)
+	if limit < 1 || limit > 1000 {
+		limit = 500
+	}
+
+	events, more := changes.since(cursor, limit)
+	next := cursor
+	if len(events) > 0 {
+		next = events[len(events)-1].Seq
+	}
+	// The cursor still moves past events left out here
+	visible := slices.DeleteFunc(events, func(event changeEvent) bool { return hiddenChange(c, event) })
+	return c.JSON(fiber.Map{
+		"success":  true,
+		"changes":  visible,
+		"cursor":   strconv.FormatInt(next, 10),
+		"has_more": more,
+	})
+}
+
+// hiddenChange reports whether a change is left out of a request's feed:
+// changes to images hidden from its listings, and those that described an
+// image as private, unlisted or pending at the time
+func hiddenChange(c *fiber.Ctx, event changeEvent) bool {
+	if hiddenFrom(c, event.Name) {
+		return true
+	}
+	m := event.Meta
+	return m != nil && (m.Private || m.Unlisted || m.Pending) && !seesPrivate(c, event.Name)
+}
+
+// imageVersion is an image as the journal last described it
+type imageVersion struct {
+	changeEvent
+	// Created is when the image was first journaled
+	Created int64
+}
+
+// stateAt replays the journal up to and including at, returning the images
+// that existed then keyed by stored filename
+func (j *changeJournal) stateAt(at int64) map[string]imageVersion {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	state := make(map[string]imageVersion)
+	for _, event := range j.events {
+		if event.At > at {
+			break
+		}
+		if event.Type == changeDelete {
+			delete(state, event.Name)
+			continue
+		}
+		version := imageVersion{changeEvent: event, Created: event.At}
+		if previous, ok := state[event.Name]; ok {
+			version.Created = previous.Created
+		}
+		state[event.Name] = version
+	}
+	return state
+}
+
+// event returns the event journaled for an upload with sequence number seq
+func (j *changeJournal) event(name string, seq int64) (changeEvent, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	i, found := sort.Find(len(j.events), func(i int) int {
+		return cmp.Compare(seq, j.events[i].Seq)
+	})
+	if !found || j.events[i].Name != name {
+		return changeEvent{}, false
+	}
+	return j.events[i], true
+}
+
+// latest returns the last event journaled for an upload
+func (j *changeJournal) latest(name string) (changeEvent, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for i := len(j.events) - 1; i >= 0; i-- {
+		if j.events[i].Name == name {
+			return j.events[i], true
+		}
+	}
+	return changeEvent{}, false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"AfroBaseServer/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// chaosConfig is the faults injected for resilience testing, set with
+// AFROBASE_CHAOS, e.g. "latency=500ms,errors=0.05,partial=0.02,storage=0.1".
+// It is for development only: clients see requests fail that would have
+// succeeded.
+type chaosConfig struct {
+	// Latency is the most delay added before a request, drawn uniformly
+	Latency time.Duration
+	// Errors is the fraction of requests answered with a random 5xx
+	Errors float64
+	// Partial is the fraction of responses cut off halfway through the body
+	Partial float64
+	// Storage is the fraction of storage operations that fail
+	Storage float64
+}
+
+var chaos chaosConfig
+
+// parseChaos reads an AFROBASE_CHAOS spec of comma-separated key=value pairs
+func parseChaos(v string) (chaosConfig, error) {
+	var cfg chaosConfig
+	for _, field := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return cfg, fmt.Errorf("%q is not key=value", field)
+		}
+		if key == "latency" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return cfg, fmt.Errorf("invalid latency %q", value)
+			}
+			cfg.Latency = d
+			continue
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return cfg, fmt.Errorf("%s must be a fraction between 0 and 1, not %q", key, value)
+		}
+		switch key {
+		case "errors":
+			cfg.Errors = rate
+		case "partial":
+			cfg.Partial = rate
+		case "storage":
+			cfg.Storage = rate
+		default:
+			return cfg, fmt.Errorf("unknown fault %q", key)
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg chaosConfig) String() string {
+	return fmt.Sprintf("latency up to %v, %g of requests failing, %g of responses cut short, %g of storage operations failing",
+		cfg.Latency, cfg.Errors, cfg.Partial, cfg.Storage)
+}
+
+// chaosStatuses are the errors injected requests are answered with
+var chaosStatuses = []int{500, 502, 503, 504}
+
+// injectChaos delays requests, fails them outright, or truncates their
+// responses as chaos is configured. Injected failures carry X-Chaos so
+// they can be told apart from real ones.
+func injectChaos(c *fiber.Ctx) error {
+	if chaos.Latency > 0 {
+		time.Sleep(rand.N(chaos.Latency))
+	}
+	if rand.Float64() < chaos.Errors {
+		c.Set("X-Chaos", "error")
+		return c.Status(chaosStatuses[rand.IntN(len(chaosStatuses))]).JSON(fiber.Map{
+			"error":   "Injected failure",
+			"success": false,
+		})
+	}
+
+	err := c.Next()
+
+	if rand.Float64() < chaos.Partial {
+		// fasthttp closes the connection when the body stream fails short
+		// of its length, as a server dying mid-response would
+		body := bytes.Clone(c.Response().Body())
+		if len(body) > 1 {
+			c.Set("X-Chaos", "partial")
+			c.Response().SetBodyStream(io.MultiReader(bytes.NewReader(body[:len(body)/2]), chaosReader{}), len(body))
+		}
+	}
+	return err
+}
+
+// chaosReader fails every read
+type chaosReader struct{}
+
+func (chaosReader) Read([]byte) (int, error) {
+	return 0, errChaos
+}
+
+var errChaos = errors.New("chaos: injected failure")
+
+// chaosStorage fails a fraction of the operations on the store it wraps.
+// Failed Puts read part of their input first, like a write that dies
+// midway.
+type chaosStorage struct {
+	storage.Storage
+	rate float64
+}
+
+func (s chaosStorage) fail() bool {
+	return rand.Float64() < s.rate
+}
+
+func (s chaosStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if s.fail() {
+		io.CopyN(io.Discard, r, size/2)
+		return errChaos
+	}
+	return s.Storage.Put(ctx, key, r, size)
+}
+
+func (s chaosStorage) Get(ctx context.Context, key string) (io.ReadCloser, storage.Object, error) {
+	if s.fail() {
+		return nil, storage.Object{}, errChaos
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func (s chaosStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, storage.Object, error) {
+	if s.fail() {
+		return nil, storage.Object{}, errChaos
+	}
+	return s.Storage.GetRange(ctx, key, offset, length)
+}
+
+func (s chaosStorage) Stat(ctx context.Context, key string) (storage.Object, error) {
+	if s.fail() {
+		return storage.Object{}, errChaos
+	}
+	return s.Storage.Stat(ctx, key)
+}
+
+func (s chaosStorage) Delete(ctx context.Context, key string) error {
+	if s.fail() {
+		return errChaos
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+func (s chaosStorage) List(ctx context.Context, prefix string) ([]storage.Object, error) {
+	if s.fail() {
+		return nil, errChaos
+	}
+	return s.Storage.List(ctx, prefix)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"AfroBaseServer/storage/storagetest"
+)
+
+// runCheckStorage implements `check-storage`, running the storage
+// conformance checks against the configured upload storage, so a backend
+// or bucket can be tried before it holds real uploads. It writes only
+// under a scratch directory and deletes what it wrote.
+func runCheckStorage(args []string) {
+	flags := flag.NewFlagSet("check-storage", flag.ExitOnError)
+	flags.Parse(args)
+
+	store, err := openUploadStore()
+	if err != nil {
+		log.Fatal("Failed to open upload storage:", err)
+	}
+	id, err := randomIDs{}.NewID(8)
+	if err != nil {
+		log.Fatal("Failed to generate scratch directory name:", err)
+	}
+	if err := storagetest.TestStorage(context.Background(), store, "storagetest-"+id); err != nil {
+		log.Fatalf("Storage %s failed conformance checks:\n%v", store.Name(), err)
+	}
+	log.Printf("Storage %s passed conformance checks", store.Name())
+}
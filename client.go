@@ -0,0 +1,653 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// serverVersion identifies the build by its module version, which Go
+// stamps with the VCS revision, or by the bare revision if it has none
+var serverVersion = func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return "devel"
+}()
+
+// getTypeScriptClient serves typed bindings for the JSON API that ship
+// with the server, so integrations pick up changes when they're deployed:
+// GET /api/client.ts. The file is compiled into the binary and updated
+// along with the handlers it describes.
+func getTypeScriptClient(c *fiber.Ctx) error {
+	body := strings.NewReplacer("{{BASE_URL}}", publicBaseURL, "{{VERSION}}", serverVersion).Replace(typeScriptClient)
+	sum := sha256.Sum256([]byte(body))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	if notModified(c, etag, time.Time{}) {
+		return c.SendStatus(304)
+	}
+	c.Set(fiber.HeaderContentType, "application/typescript; charset=utf-8")
+	c.Set("X-AfroBase-Version", serverVersion)
+	return c.SendString(body)
+}
+
+const typeScriptClient = `// AfroBase API client for server version {{VERSION}}.
+// Served by the server at /api/client.ts; fetch it again after upgrading.
+
+export const SERVER_VERSION = "{{VERSION}}";
+export const DEFAULT_BASE_URL = "{{BASE_URL}}";
+
+export interface VariantURLs {
+  [size: string]: string;
+}
+
+export interface PrintSize {
+  width_in: number;
+  height_in: number;
+  width_cm: number;
+  height_cm: number;
+}
+
+export interface ImageRecord {
+  id: string;
+  name: string;
+  size: number;
+  upload_time: number;
+  title: string;
+  /** Derived from the title, without accents */
+  slug: string;
+  description: string;
+  tags: string[];
+  url: string;
+  thumbnail_url: string;
+  blob_url: string;
+  page_url: string;
+  variants: VariantURLs;
+  variants_ready: boolean;
+  /** Only served through signed links from shareImage */
+  private?: boolean;
+  /** Left out of listings and search, but served to anyone with a link */
+  unlisted?: boolean;
+  /** How often an unlisted image's page has been opened */
+  views?: number;
+  /** Uploaded with a moderated upload link and not yet approved */
+  pending?: boolean;
+  /** The subject crops keep in frame, when it isn't the centre */
+  focal_point?: FocalPoint;
+  /** ID of the user who uploaded it, for images uploaded while logged in */
+  owner?: string;
+  raw?: boolean;
+  original_url?: string;
+  width?: number;
+  height?: number;
+  color_space?: "rgb" | "cmyk" | "gray" | "indexed";
+  bit_depth?: number;
+  print_sizes?: { [dpi: string]: PrintSize };
+  /** Change journal sequence number, on single-image reads */
+  version?: number;
+}
+
+export interface ImagePage {
+  success: true;
+  images: ImageRecord[];
+  page: number;
+  limit: number;
+  total: number;
+  total_pages: number;
+}
+
+export interface SearchResults extends ImagePage {
+  query: string;
+}
+
+export interface ImageGroup {
+  /** The local date, "2026-01-31" by day or "2026-01" by month */
+  key: string;
+  /** RFC 3339, with the time zone's offset */
+  start: string;
+  /** Images in the group on every page; a group can continue on the next */
+  count: number;
+  images: ImageRecord[];
+}
+
+export interface GroupedImages extends Omit<ImagePage, "images"> {
+  by: "day" | "month";
+  tz: string;
+  groups: ImageGroup[];
+}
+
+export interface GroupImagesOptions {
+  page?: number;
+  limit?: number;
+  tag?: string;
+  scope?: "mine" | "all";
+}
+
+export interface ListImagesOptions {
+  page?: number;
+  limit?: number;
+  /** Only images in this album */
+  album?: string;
+  /** Only images with this tag */
+  tag?: string;
+  /** The library as it was at a Unix time or RFC 3339 timestamp */
+  as_of?: string | number;
+  /** Order by title instead of upload time */
+  sort?: "title" | "-title";
+  /** Language to sort titles for, such as "sw" or "fr" */
+  locale?: string;
+  /** When logged in, "all" lists every user's images instead of your own */
+  scope?: "mine" | "all";
+}
+
+export interface UploadOptions {
+  title?: string;
+  description?: string;
+  filename?: string;
+  /** "screenshot" trims uniform borders */
+  mode?: string;
+  /** A configured upload profile */
+  profile?: string;
+  /** Lowercased and deduplicated by the server; no commas */
+  tags?: string[];
+  /** An album's upload link token, which adds the upload to the album */
+  uploadToken?: string;
+}
+
+export interface UploadResult {
+  success: true;
+  url: string;
+  variants_ready: boolean;
+  duplicate?: boolean;
+  id?: string;
+  raw?: boolean;
+  original_url?: string;
+  profile?: string;
+  metadata_stripped?: boolean;
+  screenshot?: boolean;
+  trimmed_to?: { x: number; y: number; width: number; height: number };
+  variants_deferred_until?: string;
+}
+
+export interface ImageChanges {
+  title?: string;
+  description?: string;
+  /** Replaces every tag */
+  tags?: string[];
+  private?: boolean;
+  unlisted?: boolean;
+  /** Moves the subject cropped variants keep in frame; { x: 0.5, y: 0.5 } clears it */
+  focal_point?: FocalPoint;
+}
+
+/** A point as fractions of an image's width and height from the top left */
+export interface FocalPoint {
+  x: number;
+  y: number;
+}
+
+export interface ShareLink {
+  success: true;
+  id: string;
+  url: string;
+  variants: VariantURLs;
+  /** Unix time the links stop working */
+  expires_at: number;
+}
+
+export interface TagCount {
+  name: string;
+  count: number;
+}
+
+export interface Album {
+  id: string;
+  name: string;
+  description: string;
+  /** Image IDs in the order they were added */
+  images: string[];
+  image_count: number;
+  images_url: string;
+  /** PDF of the album's thumbnails with captions */
+  contact_sheet_url: string;
+  cover_id?: string;
+  cover_url?: string;
+  created_at: number;
+  updated_at: number;
+  legal_hold: boolean;
+  /** ID of the user who created it, for albums created while logged in */
+  owner?: string;
+  /** The album it sits in, for nested albums */
+  parent?: string;
+  /** Sealed when published, after which its images can't be changed */
+  write_once: boolean;
+  sealed: boolean;
+  published_at?: number;
+  sealed_since?: number;
+  seal_breaks?: SealBreak[];
+  /** Counts the album's changes, for updateAlbum */
+  version: number;
+}
+
+export interface SealBreak {
+  /** When the broken seal was made */
+  since: number;
+  at: number;
+  /** The user or API key who broke it */
+  by: string;
+  reason: string;
+}
+
+export interface AlbumChanges {
+  name?: string;
+  description?: string;
+  /** An image in the album, or "" for the first image */
+  cover?: string;
+  /** An album to move it into, or "" for the top */
+  parent?: string;
+  /** Can't be turned off while the album is sealed */
+  write_once?: boolean;
+}
+
+export interface TreeNode {
+  id: string;
+  name: string;
+  /** Images in the album itself */
+  image_count: number;
+  /** Images in it or any album inside it, each counted once */
+  total_count: number;
+  cover_id?: string;
+  cover_url?: string;
+  children: TreeNode[];
+}
+
+export interface SlideshowItem {
+  id: string;
+  title: string;
+  /** The variant asked for where it's ready, the display image otherwise */
+  url: string;
+  /** Every variant ready, for preloading */
+  variants: VariantURLs;
+  /** Seconds to show it for */
+  duration: number;
+  width?: number;
+  height?: number;
+}
+
+export interface Slideshow {
+  album: string;
+  name: string;
+  loop: true;
+  items: SlideshowItem[];
+  count: number;
+  total_duration: number;
+  updated_at: number;
+}
+
+export interface UploadLink {
+  id: string;
+  album: string;
+  uploads: number;
+  /** Whether it can take another upload */
+  usable: boolean;
+  created_at: string;
+  expires_at?: string;
+  max_uploads?: number;
+  /** Uploads wait for the album owner's approval */
+  moderated?: boolean;
+  /** Only returned when the link is created */
+  token?: string;
+  upload_url?: string;
+  /** Event pages of moderated links, only returned when the link is created */
+  kiosk_url?: string;
+  slideshow_url?: string;
+}
+
+/** The body of a 412 from an update made against an old version */
+export interface Conflict<T, C> {
+  error: string;
+  success: false;
+  /** What was being changed as it is now, with its version */
+  current: T;
+  /** The changes that weren't saved */
+  yours: C;
+  /** For images, the metadata at the version the changes were made against, if still journaled */
+  base?: { title: string; description: string; tags?: string[]; private?: boolean; unlisted?: boolean };
+}
+
+export type ImageConflict = Conflict<ImageRecord, ImageChanges>;
+
+function ifMatch(version: number): Record<string, string> {
+  return { "If-Match": '"' + version + '"' };
+}
+
+/** A failed request, carrying the server's error message */
+export class AfroBaseError extends Error {
+  readonly status: number;
+  readonly body: unknown;
+
+  constructor(status: number, message: string, body: unknown) {
+    super(message);
+    this.name = "AfroBaseError";
+    this.status = status;
+    this.body = body;
+  }
+}
+
+export interface User {
+  id: string;
+  username: string;
+  created_at: number;
+  /** Counts changes to the profile, for updateProfile */
+  version: number;
+}
+
+export interface ProfileChanges {
+  username?: string;
+  password?: string;
+  /** Needed to change the password, unless the account has none yet */
+  current_password?: string;
+}
+
+export interface Session {
+  success: true;
+  user: User;
+  access_token: string;
+  token_type: "Bearer";
+  /** RFC 3339 */
+  expires_at: string;
+}
+
+export interface LoginProvider {
+  name: "google" | "github";
+  /** Open in the browser to log in */
+  url: string;
+}
+
+export interface ClientOptions {
+  baseURL?: string;
+  /** Sent as X-API-Key */
+  apiKey?: string;
+  /** An access token from login, sent as Authorization: Bearer */
+  token?: string;
+  fetch?: typeof fetch;
+}
+
+export class AfroBaseClient {
+  readonly baseURL: string;
+  private readonly apiKey?: string;
+  /** Set by register and login */
+  token?: string;
+  private readonly fetchImpl: typeof fetch;
+
+  constructor(options: ClientOptions = {}) {
+    this.baseURL = (options.baseURL ?? DEFAULT_BASE_URL).replace(/\/+$/, "");
+    this.apiKey = options.apiKey;
+    this.token = options.token;
+    this.fetchImpl = options.fetch ?? fetch.bind(globalThis);
+  }
+
+  private async request<T>(method: string, path: string, body?: unknown, extraHeaders: Record<string, string> = {}): Promise<T> {
+    const headers: Record<string, string> = { Accept: "application/json", ...extraHeaders };
+    if (this.apiKey) headers["X-API-Key"] = this.apiKey;
+    if (this.token) headers["Authorization"] = "Bearer " + this.token;
+    let payload: BodyInit | undefined;
+    if (body instanceof FormData) {
+      payload = body;
+    } else if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+      payload = JSON.stringify(body);
+    }
+    const response = await this.fetchImpl(this.baseURL + path, { method, headers, body: payload });
+    const data = await response.json().catch(() => null);
+    if (!response.ok) {
+      const message = data && typeof data.error === "string" ? data.error : "Request failed (" + response.status + ")";
+      throw new AfroBaseError(response.status, message, data);
+    }
+    return data as T;
+  }
+
+  /** Creates an account and logs in as it */
+  async register(username: string, password: string): Promise<Session> {
+    const session = await this.request<Session>("POST", "/api/auth/register", { username, password });
+    this.token = session.access_token;
+    return session;
+  }
+
+  async login(username: string, password: string): Promise<Session> {
+    const session = await this.request<Session>("POST", "/api/auth/login", { username, password });
+    this.token = session.access_token;
+    return session;
+  }
+
+  /** Services that can be logged in with instead of a password */
+  async listLoginProviders(): Promise<LoginProvider[]> {
+    const data = await this.request<{ providers: LoginProvider[] }>("GET", "/api/auth/providers");
+    return data.providers;
+  }
+
+  /**
+   * Where to send the browser to log in with a provider, or to link it to
+   * the logged-in account. Afterwards the browser is sent to next, with
+   * the access token or an error in the fragment.
+   */
+  async loginURL(provider: string, next: string): Promise<string> {
+    const path = "/api/auth/oauth/" + encodeURIComponent(provider) + "?next=" + encodeURIComponent(next);
+    const data = await this.request<{ url: string }>("GET", path);
+    return data.url;
+  }
+
+  /** The user the token belongs to */
+  async me(): Promise<User> {
+    const data = await this.request<{ user: User }>("GET", "/api/auth/me");
+    return data.user;
+  }
+
+  /** Changes the username or password, made at version, failing with a 412 if the profile has changed since */
+  async updateProfile(changes: ProfileChanges, version: number): Promise<User> {
+    const data = await this.request<{ user: User }>("PATCH", "/api/auth/me", changes, ifMatch(version));
+    return data.user;
+  }
+
+  listImages(options: ListImagesOptions = {}): Promise<ImagePage> {
+    const query = new URLSearchParams();
+    for (const [key, value] of Object.entries(options)) {
+      if (value !== undefined) query.set(key, String(value));
+    }
+    const qs = query.toString();
+    return this.request("GET", "/api/images" + (qs ? "?" + qs : ""));
+  }
+
+  /** Images whose title, description or tags contain every word of query */
+  searchImages(query: string, options: { page?: number; limit?: number; scope?: "mine" | "all" } = {}): Promise<SearchResults> {
+    const params = new URLSearchParams({ q: query });
+    if (options.page !== undefined) params.set("page", String(options.page));
+    if (options.limit !== undefined) params.set("limit", String(options.limit));
+    if (options.scope !== undefined) params.set("scope", options.scope);
+    return this.request("GET", "/api/images/search?" + params.toString());
+  }
+
+  /** Images newest first, grouped by the day or month they were uploaded in an IANA time zone */
+  groupImages(by: "day" | "month", tz: string, options: GroupImagesOptions = {}): Promise<GroupedImages> {
+    const query = new URLSearchParams({ by, tz });
+    for (const [key, value] of Object.entries(options)) {
+      if (value !== undefined) query.set(key, String(value));
+    }
+    return this.request("GET", "/api/images/grouped?" + query.toString());
+  }
+
+  async getImage(id: string): Promise<ImageRecord> {
+    const data = await this.request<{ image: ImageRecord }>("GET", "/api/images/" + encodeURIComponent(id));
+    return data.image;
+  }
+
+  /**
+   * Edits an image made at version, its record's version. If the image has
+   * changed since, this fails with a 412 AfroBaseError whose body is a Conflict.
+   */
+  async updateImage(id: string, changes: ImageChanges, version: number): Promise<ImageRecord> {
+    const path = "/api/images/" + encodeURIComponent(id);
+    const data = await this.request<{ image: ImageRecord }>("PATCH", path, changes, ifMatch(version));
+    return data.image;
+  }
+
+  /** Moves an image between albums and edits it as one change: all of it is saved or none */
+  moveImage(id: string, move: { from?: string; to?: string } & ImageChanges): Promise<{ success: true; image: ImageRecord; from?: Album; to?: Album }> {
+    return this.request("POST", "/api/images/" + encodeURIComponent(id) + "/move", move);
+  }
+
+  /** Signed links that open the image even if it is private */
+  shareImage(id: string, expiresIn?: string): Promise<ShareLink> {
+    return this.request("POST", "/api/images/" + encodeURIComponent(id) + "/share", expiresIn ? { expires_in: expiresIn } : {});
+  }
+
+  deleteImage(id: string): Promise<{ success: true; id: string; name: string }> {
+    return this.request("DELETE", "/api/images/" + encodeURIComponent(id));
+  }
+
+  randomImage(orientation?: "landscape" | "portrait" | "square"): Promise<ImageRecord> {
+    return this.request("GET", "/api/images/random" + (orientation ? "?orientation=" + orientation : ""));
+  }
+
+  /** Uploads a file or blob as multipart form data */
+  upload(file: Blob, options: UploadOptions = {}): Promise<UploadResult> {
+    const form = new FormData();
+    form.set("image", file, options.filename ?? (file as File).name ?? "image");
+    for (const key of ["title", "description", "filename", "mode"] as const) {
+      const value = options[key];
+      if (value !== undefined) form.set(key, value);
+    }
+    if (options.tags) form.set("tags", options.tags.join(","));
+    const query = new URLSearchParams();
+    if (options.profile) query.set("profile", options.profile);
+    if (options.uploadToken) query.set("upload_token", options.uploadToken);
+    const path = "/upload" + (query.size ? "?" + query : "");
+    return this.request("POST", path, form);
+  }
+
+  /** Has the server fetch an image from another host and store it */
+  uploadFromURL(url: string, options: UploadOptions = {}): Promise<UploadResult> {
+    const { profile, uploadToken, ...fields } = options;
+    const path = "/upload/url" + (profile ? "?profile=" + encodeURIComponent(profile) : "");
+    return this.request("POST", path, { url, ...fields });
+  }
+
+  /** Tags in use, most used first */
+  async listTags(): Promise<TagCount[]> {
+    const data = await this.request<{ tags: TagCount[] }>("GET", "/api/tags");
+    return data.tags;
+  }
+
+  async listAlbums(): Promise<Album[]> {
+    const data = await this.request<{ albums: Album[] }>("GET", "/api/albums");
+    return data.albums;
+  }
+
+  async getAlbum(id: string): Promise<Album> {
+    const data = await this.request<{ album: Album }>("GET", "/api/albums/" + encodeURIComponent(id));
+    return data.album;
+  }
+
+  async createAlbum(name: string, description?: string, parent?: string): Promise<Album> {
+    const data = await this.request<{ album: Album }>("POST", "/api/albums", { name, description, parent });
+    return data.album;
+  }
+
+  /** Albums nested as folders, ordered by name */
+  async tree(): Promise<TreeNode[]> {
+    const data = await this.request<{ tree: TreeNode[] }>("GET", "/api/tree");
+    return data.tree;
+  }
+
+  /** Edits an album made at version, failing with a 412 if it has changed since */
+  async updateAlbum(id: string, changes: AlbumChanges, version: number): Promise<Album> {
+    const path = "/api/albums/" + encodeURIComponent(id);
+    const data = await this.request<{ album: Album }>("PATCH", path, changes, ifMatch(version));
+    return data.album;
+  }
+
+  deleteAlbum(id: string): Promise<{ success: true; id: string }> {
+    return this.request("DELETE", "/api/albums/" + encodeURIComponent(id));
+  }
+
+  /** Publishes an album, sealing it if it is write-once */
+  async publishAlbum(id: string): Promise<Album> {
+    const data = await this.request<{ album: Album }>("POST", "/api/albums/" + encodeURIComponent(id) + "/publish");
+    return data.album;
+  }
+
+  /** Breaks a sealed album's seal, recording the reason */
+  async breakAlbumSeal(id: string, reason: string): Promise<Album> {
+    const path = "/api/albums/" + encodeURIComponent(id) + "/seal/break";
+    const data = await this.request<{ album: Album }>("POST", path, { reason });
+    return data.album;
+  }
+
+  async addToAlbum(id: string, images: string[]): Promise<Album> {
+    const data = await this.request<{ album: Album }>("POST", "/api/albums/" + encodeURIComponent(id) + "/images", { images });
+    return data.album;
+  }
+
+  async removeFromAlbum(id: string, image: string): Promise<Album> {
+    const path = "/api/albums/" + encodeURIComponent(id) + "/images/" + encodeURIComponent(image);
+    const data = await this.request<{ album: Album }>("DELETE", path);
+    return data.album;
+  }
+
+  /** An album as a looping playlist for displays */
+  slideshow(album: string, options: { size?: string; duration?: number } = {}): Promise<Slideshow> {
+    const query = new URLSearchParams();
+    if (options.size) query.set("size", options.size);
+    if (options.duration) query.set("duration", String(options.duration));
+    const path = "/api/albums/" + encodeURIComponent(album) + "/slideshow" + (query.size ? "?" + query : "");
+    return this.request("GET", path);
+  }
+
+  /** Makes a link guests can upload into an album with; the token is only returned here */
+  async createUploadLink(album: string, options: { expires_in?: string; max_uploads?: number; moderated?: boolean } = {}): Promise<UploadLink> {
+    const path = "/api/albums/" + encodeURIComponent(album) + "/upload-links";
+    const data = await this.request<{ link: UploadLink }>("POST", path, options);
+    return data.link;
+  }
+
+  async listUploadLinks(album: string): Promise<UploadLink[]> {
+    const path = "/api/albums/" + encodeURIComponent(album) + "/upload-links";
+    const data = await this.request<{ links: UploadLink[] }>("GET", path);
+    return data.links;
+  }
+
+  revokeUploadLink(album: string, id: string): Promise<{ success: true; id: string }> {
+    return this.request("DELETE", "/api/albums/" + encodeURIComponent(album) + "/upload-links/" + encodeURIComponent(id));
+  }
+
+  /** Uploads awaiting approval for an album, oldest first */
+  async listPending(album: string): Promise<ImageRecord[]> {
+    const data = await this.request<{ images: ImageRecord[] }>("GET", "/api/albums/" + encodeURIComponent(album) + "/pending");
+    return data.images;
+  }
+
+  approvePending(album: string, image: string): Promise<{ success: true; id: string }> {
+    return this.request("POST", "/api/albums/" + encodeURIComponent(album) + "/pending/" + encodeURIComponent(image));
+  }
+
+  /** Turns down an upload, deleting it unless it was stored before as a duplicate */
+  rejectPending(album: string, image: string): Promise<{ success: true; id: string; deleted: boolean }> {
+    return this.request("DELETE", "/api/albums/" + encodeURIComponent(album) + "/pending/" + encodeURIComponent(image));
+  }
+}
+`
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// clock tells the time. Anything that stamps filenames and records, expires
+// uploads or decides when work runs asks serverClock rather than calling
+// time.Now, so it can be pinned. Durations that are only measured, like
+// request latency, still use the real time.
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// frozenClock always reports the same time, set with -frozen-clock
+type frozenClock time.Time
+
+func (c frozenClock) Now() time.Time {
+	return time.Time(c)
+}
+
+var serverClock clock = systemClock{}
+
+// frozenClockUsage documents the -frozen-clock flag
+const frozenClockUsage = "RFC 3339 time to stop the clock at, for reproducible development and tests"
+
+// parseFrozenClock parses a -frozen-clock time
+func parseFrozenClock(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, errors.New("frozen clock must be an RFC 3339 time such as 2024-01-01T00:00:00Z")
+	}
+	return t, nil
+}
+
+// freezeClock stops serverClock at a -frozen-clock time, if one is given,
+// for subcommands that stamp what they write
+func freezeClock(v string) {
+	if v == "" {
+		return
+	}
+	t, err := parseFrozenClock(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serverClock = frozenClock(t)
+}
+
+// idGenerator hands out the hex IDs of albums and upload sessions
+type idGenerator interface {
+	NewID(n int) (string, error)
+}
+
+// randomIDs are n random bytes
+type randomIDs struct{}
+
+func (randomIDs) NewID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sequentialIDs hash a counter, so every run hands out the same IDs in the
+// same order
+type sequentialIDs struct {
+	prefix string
+	count  atomic.Uint64
+}
+
+func (s *sequentialIDs) NewID(n int) (string, error) {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s-%d", s.prefix, s.count.Add(1)))
+	return hex.EncodeToString(sum[:min(n, len(sum))]), nil
+}
+
+var ids idGenerator = randomIDs{}
+
+// newID returns an ID of n bytes from ids
+func newID(n int) (string, error) {
+	return ids.NewID(n)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+)
+
+// Colour spaces reported for uploads and accepted in profiles
+const (
+	colorSpaceRGB     = "rgb"
+	colorSpaceCMYK    = "cmyk"
+	colorSpaceGray    = "gray"
+	colorSpaceIndexed = "indexed"
+)
+
+// colorInfo names the colour space and bits per channel of a decoded image
+// header's colour model. JPEG and WebP store RGB as YCbCr, which is
+// reported as RGB since that is what prepress tools call it.
+func colorInfo(model color.Model) (string, int) {
+	// Palettes are slices and can't be compared with the models below
+	if _, ok := model.(color.Palette); ok {
+		return colorSpaceIndexed, 8
+	}
+	switch model {
+	case color.CMYKModel:
+		return colorSpaceCMYK, 8
+	case color.GrayModel, color.AlphaModel:
+		return colorSpaceGray, 8
+	case color.Gray16Model, color.Alpha16Model:
+		return colorSpaceGray, 16
+	case color.RGBA64Model, color.NRGBA64Model:
+		return colorSpaceRGB, 16
+	default:
+		return colorSpaceRGB, 8
+	}
+}
+
+// validColorSpace reports whether s is a colour space profiles can name
+func validColorSpace(s string) bool {
+	switch s {
+	case colorSpaceRGB, colorSpaceCMYK, colorSpaceGray, colorSpaceIndexed:
+		return true
+	}
+	return false
+}
+
+// contentInfo describes an upload's stored file: the size and SHA-256 of
+// the original and the header of its displayable form. It is recorded in
+// the metadata at upload so listings needn't read the file back, which is
+// slow and billed per request with originals in cold storage.
+type contentInfo struct {
+	Size       int64  `json:"size"`
+	Hash       string `json:"hash"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	ColorSpace string `json:"color_space"`
+	BitDepth   int    `json:"bit_depth"`
+}
+
+// describeContent describes an upload from its original and displayable
+// bytes, which are the same but for RAW uploads
+func describeContent(original, display []byte) *contentInfo {
+	sum := sha256.Sum256(original)
+	info := &contentInfo{Size: int64(len(original)), Hash: hex.EncodeToString(sum[:])}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(display)); err == nil {
+		info.Width, info.Height = config.Width, config.Height
+		info.ColorSpace, info.BitDepth = colorInfo(config.ColorModel)
+	}
+	return info
+}
+
+// imageHeader returns the dimensions and colour of an upload's displayable
+// form, as recorded at upload or else read from the file's header
+func imageHeader(name string) (contentInfo, error) {
+	if meta, _ := metadata.get(name); meta.Content != nil && meta.Content.Width > 0 {
+		return *meta.Content, nil
+	}
+	config, err := imageConfig(name)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	colorSpace, bitDepth := colorInfo(config.ColorModel)
+	return contentInfo{Width: config.Width, Height: config.Height, ColorSpace: colorSpace, BitDepth: bitDepth}, nil
+}
+
+// imageConfig reads a stored upload's header
+func imageConfig(name string) (image.Config, error) {
+	f, _, err := uploadStore.Get(context.Background(), displayKey(name))
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	return config, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+)
+
+// compareSide caps the resolution images are compared at, which keeps the
+// comparison fast and makes it tolerant of small rescaling differences
+const compareSide = 512
+
+// ssimWindow is the side of the square windows SSIM is computed over
+const ssimWindow = 8
+
+type ComparePayload struct {
+	A    string `json:"a"`
+	B    string `json:"b"`
+	Diff bool   `json:"diff"`
+}
+
+// handleCompare scores how structurally similar two images are (SSIM, 1.0
+// meaning identical) and can return a diff image highlighting changes.
+// Private images can only be compared by those who may see them, and
+// users with an access token compare their own unless ?scope=all.
+func handleCompare(c *fiber.Ctx) error {
+	var payload ComparePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"success": false,
+		})
+	}
+
+	owner, err := listingOwner(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	var images [2]image.Image
+	for i, id := range []string{payload.A, payload.B} {
+		name, ok := findUpload(id)
+		if !ok || (owner != "" && imageInfo(name).Owner != owner) {
+			return c.Status(404).JSON(fiber.Map{
+				"error":   "Image not found: " + id,
+				"success": false,
+			})
+		}
+		if isPrivate(name) && !seesPrivate(c, name) {
+			return c.Status(403).JSON(fiber.Map{
+				"error":   "This image is private: " + id,
+				"success": false,
+			})
+		}
+		img, err := decodeUpload(name)
+		if err != nil {
+			log.Printf("Error decoding %s for comparison: %v", name, err)
+			return c.Status(422).JSON(fiber.Map{
+				"error":   "Unable to decode image: " + id,
+				"success": false,
+			})
+		}
+		images[i] = img
+	}
+
+	// Compare at a common size, taken from the first image
+	bounds := images[0].Bounds()
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), compareSide, compareSide)
+	a := scaleTo(images[0], width, height)
+	b := scaleTo(images[1], width, height)
+
+	response := fiber.Map{
+		"success":     true,
+		"ssim":        round4(ssim(a, b)),
+		"compared_at": fiber.Map{"width": width, "height": height},
+	}
+
+	if payload.Diff {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, diffImage(a, b)); err != nil {
+			return err
+		}
+		response["diff_image"] = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	return c.JSON(response)
+}
+
+// decodeUpload fully decodes a stored upload, after checking its pixel budget
+func decodeUpload(name string) (image.Image, error) {
+	data, err := readDisplaySource(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPixelBudget(data); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// scaleTo resizes img to exactly width x height
+func scaleTo(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// luminance returns the Rec. 601 luma of the pixel at offset i
+func luminance(img *image.RGBA, i int) float64 {
+	return 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+}
+
+// ssim computes the mean structural similarity of the luma channels of two
+// equally sized images over non-overlapping windows
+func ssim(a, b *image.RGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	bounds := a.Bounds()
+	var total float64
+	windows := 0
+	for y0 := 0; y0 < bounds.Dy(); y0 += ssimWindow {
+		for x0 := 0; x0 < bounds.Dx(); x0 += ssimWindow {
+			var sumA, sumB, sumAA, sumBB, sumAB, n float64
+			for y := y0; y < min(y0+ssimWindow, bounds.Dy()); y++ {
+				for x := x0; x < min(x0+ssimWindow, bounds.Dx()); x++ {
+					i := a.PixOffset(x, y)
+					la, lb := luminance(a, i), luminance(b, i)
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+					n++
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
+
+// diffImage renders b faded to grey with changed pixels painted red, more
+// opaque the larger the change
+func diffImage(a, b *image.RGBA) *image.RGBA {
+	bounds := a.Bounds()
+	out := image.NewRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			i := a.PixOffset(x, y)
+			delta := math.Abs(luminance(a, i) - luminance(b, i))
+			grey := uint8(128 + luminance(b, i)/4)
+			if delta < 8 {
+				out.SetRGBA(x, y, color.RGBA{grey, grey, grey, 255})
+				continue
+			}
+			strength := math.Min(1, delta/64)
+			out.SetRGBA(x, y, color.RGBA{
+				R: uint8(float64(grey)*(1-strength) + 255*strength),
+				G: uint8(float64(grey) * (1 - strength)),
+				B: uint8(float64(grey) * (1 - strength)),
+				A: 255,
+			})
+		}
+	}
+	return out
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
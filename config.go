@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPort is the port the server listens on unless configured
+const defaultPort = 5174
+
+// publicBaseURL prefixes the absolute URLs the API hands out. It defaults
+// to http://localhost:<port> and is set from -base-url or AFROBASE_BASE_URL
+// when the server is reached through another name or a proxy.
+var publicBaseURL = "http://localhost:" + strconv.Itoa(defaultPort)
+
+// listenConfig is where the server listens and how clients reach it
+type listenConfig struct {
+	Host    string
+	Port    int
+	BaseURL string
+	// Sandbox keeps everything in memory, seeded with sample images
+	Sandbox bool
+	// FrozenClock, if set, is the only time the server ever sees
+	FrozenClock time.Time
+}
+
+// Addr is the address to listen on
+func (l listenConfig) Addr() string {
+	return net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+}
+
+// parseListenConfig reads -host, -port, -base-url, -sandbox and
+// -frozen-clock from args, defaulting to AFROBASE_HOST, AFROBASE_PORT,
+// AFROBASE_BASE_URL, AFROBASE_SANDBOX and AFROBASE_FROZEN_CLOCK. An empty
+// host listens on every interface.
+func parseListenConfig(args []string) (listenConfig, error) {
+	port := defaultPort
+	if v := os.Getenv("AFROBASE_PORT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return listenConfig{}, errors.New("invalid AFROBASE_PORT " + strconv.Quote(v))
+		}
+		port = n
+	}
+
+	flags := flag.NewFlagSet("afrobase", flag.ExitOnError)
+	host := flags.String("host", os.Getenv("AFROBASE_HOST"), "interface to listen on (default all)")
+	flags.IntVar(&port, "port", port, "port to listen on")
+	baseURL := flags.String("base-url", os.Getenv("AFROBASE_BASE_URL"), "public URL the server is reached at (default http://localhost:<port>)")
+	sandbox := flags.Bool("sandbox", os.Getenv("AFROBASE_SANDBOX") == "on", "run in memory with seeded sample images, writing nothing to disk")
+	frozen := flags.String("frozen-clock", os.Getenv("AFROBASE_FROZEN_CLOCK"), frozenClockUsage)
+	flags.Parse(args)
+
+	cfg := listenConfig{Host: *host, Port: port, Sandbox: *sandbox}
+	if *frozen != "" {
+		t, err := parseFrozenClock(*frozen)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.FrozenClock = t
+	}
+	if port < 1 || port > 65535 {
+		return cfg, errors.New("port must be between 1 and 65535")
+	}
+	if *baseURL == "" {
+		cfg.BaseURL = "http://localhost:" + strconv.Itoa(port)
+		return cfg, nil
+	}
+
+	// URLs are built by appending paths, so the base has no trailing slash,
+	// query or fragment
+	u, err := url.Parse(strings.TrimSuffix(*baseURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return cfg, errors.New("base URL must be an http or https URL such as https://images.example.com")
+	}
+	cfg.BaseURL = u.String()
+	return cfg, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// imageTypeExtensions maps image MIME types to the extensions uploads are
+// stored with
+var imageTypeExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/tiff": ".tif",
+}
+
+// splitDataURI accepts either plain base64 or a data URI such as
+// "data:image/png;base64,iVBOR...", returning the base64 payload and the
+// declared MIME type ("" for plain base64). The type is only a hint: the
+// stored format is still sniffed from the decoded bytes.
+func splitDataURI(s string) (string, string, error) {
+	rest, ok := strings.CutPrefix(s, "data:")
+	if !ok {
+		return s, "", nil
+	}
+	header, payload, found := strings.Cut(rest, ",")
+	if !found {
+		return "", "", errors.New("data URI has no payload")
+	}
+	params := strings.Split(header, ";")
+	if !strings.EqualFold(params[len(params)-1], "base64") {
+		return "", "", errors.New("data URI must be base64-encoded")
+	}
+	return payload, strings.ToLower(strings.TrimSpace(params[0])), nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadDeadlineHeader lets clients say when they will stop waiting for an
+// upload, as an RFC 3339 timestamp
+const uploadDeadlineHeader = "X-Upload-Deadline"
+
+// pipelineChunkSize is how much work the upload pipeline does between
+// cancellation checks
+const pipelineChunkSize = 1 << 20
+
+var errInvalidDeadline = errors.New("invalid " + uploadDeadlineHeader + " header")
+
+// uploadsCtx is cancelled when a graceful shutdown runs out of time, so
+// uploads still in progress are abandoned and their partial writes removed
+var uploadsCtx, cancelUploads = context.WithCancel(context.Background())
+
+// disconnectPollInterval is how often an upload checks that its client is
+// still connected
+const disconnectPollInterval = 250 * time.Millisecond
+
+// uploadContext returns the context an upload runs under. It isn't derived
+// from the fiber request context, which is cancelled as soon as shutdown
+// starts; uploads get until the shutdown timeout to finish. It is cancelled
+// once the client disconnects, which fasthttp doesn't report, and expires
+// at the client's deadline if one was sent.
+func uploadContext(c *fiber.Ctx) (context.Context, context.CancelFunc, error) {
+	var deadline time.Time
+	if header := c.Get(uploadDeadlineHeader); header != "" {
+		var err error
+		if deadline, err = time.Parse(time.RFC3339, header); err != nil {
+			return nil, nil, errInvalidDeadline
+		}
+	}
+
+	ctx, disconnected := context.WithCancel(uploadsCtx)
+	go watchClient(ctx, disconnected, c.Context().Conn())
+	if deadline.IsZero() {
+		return ctx, disconnected, nil
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return ctx, func() { cancel(); disconnected() }, nil
+}
+
+// watchClient cancels an upload when its client disconnects, until ctx is
+// done
+func watchClient(ctx context.Context, cancel context.CancelFunc, conn net.Conn) {
+	ticker := time.NewTicker(disconnectPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if clientGone(conn) {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// abortedUpload converts a context error into the response for an upload
+// that was abandoned mid-pipeline
+func abortedUpload(c *fiber.Ctx, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return c.Status(408).JSON(fiber.Map{
+			"error":   "Upload deadline exceeded",
+			"success": false,
+		})
+	}
+	return c.Status(503).JSON(fiber.Map{
+		"error":   "Upload cancelled",
+		"success": false,
+	})
+}
+
+// copyContext copies src to dst in chunks, stopping as soon as ctx is
+// done, and returns the number of bytes copied
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, pipelineChunkSize)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// newBase64Decoder decodes base64 as it is read. The standard and URL-safe
+// alphabets are both accepted, with or without padding, as is whitespace
+// such as line breaks from wrapping encoders.
+func newBase64Decoder(r io.Reader) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, &base64Normalizer{r: r})
+}
+
+// base64Normalizer rewrites base64 in either alphabet as padded standard
+// base64, dropping whitespace
+type base64Normalizer struct {
+	r      io.Reader
+	count  int // characters passed on, not counting padding
+	padded bool
+	eof    bool
+	tail   []byte // padding still to be added at the end
+}
+
+func (n *base64Normalizer) Read(p []byte) (int, error) {
+	for {
+		if n.eof {
+			k := copy(p, n.tail)
+			n.tail = n.tail[k:]
+			if len(n.tail) == 0 {
+				return k, io.EOF
+			}
+			return k, nil
+		}
+		m, err := n.r.Read(p)
+		out := p[:0]
+		for _, c := range p[:m] {
+			switch c {
+			case ' ', '\t', '\n', '\r', '\v', '\f':
+				continue
+			case '-':
+				c = '+'
+			case '_':
+				c = '/'
+			case '=':
+				n.padded = true
+				n.count--
+			}
+			n.count++
+			out = append(out, c)
+		}
+		if err == io.EOF {
+			n.eof = true
+			if !n.padded && n.count%4 != 0 {
+				n.tail = []byte("==="[:4-n.count%4])
+			}
+			if len(out) == 0 {
+				continue
+			}
+			err = nil
+		}
+		if len(out) > 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// writeFileContext writes data to path in chunks, removing the partial file
+// if ctx is done before the write completes
+func writeFileContext(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	for len(data) > 0 {
+		if err := ctx.Err(); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		n := min(len(data), pipelineChunkSize)
+		if _, err := f.Write(data[:n]); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		data = data[n:]
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// deleteImage removes an upload along with everything derived from it:
+// DELETE /api/images/:id
+func deleteImage(c *fiber.Ctx) error {
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	if !mayChange(c, name) {
+		return sendNotOwner(c, "Image belongs to another user")
+	}
+	if imageHeld(name) {
+		audit(c, "delete_refused", name)
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Image is under legal hold",
+			"success": false,
+		})
+	}
+	if imageSealed(name) {
+		return sendSealed(c, "delete", name, "Image is in a sealed album")
+	}
+
+	if err := removeUpload(name); err != nil {
+		log.Printf("Error deleting %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to delete image",
+			"success": false,
+		})
+	}
+	audit(c, "delete", name)
+	log.Printf("Image deleted: %s", name)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      imageID(name),
+		"name":    name,
+	})
+}
+
+// removeUpload deletes an upload and everything derived from it. The
+// caller checks it isn't held.
+func removeUpload(name string) error {
+	// The mirror copy goes first, its deletion recorded, so reconciliation
+	// can't restore the original from either side
+	if uploadMirror != nil {
+		uploadMirror.remove(name)
+	}
+	if err := uploadStore.Delete(context.Background(), name); err != nil {
+		return err
+	}
+	removeDerived(name)
+	changes.record(changeDelete, name)
+	return nil
+}
+
+// removeDerived removes an upload's variants, RAW preview and metadata,
+// and takes it out of its albums. Failures are only logged, since the original is already gone.
+func removeDerived(name string) {
+	keys := map[string]bool{}
+	for _, specs := range variantSets() {
+		for _, spec := range specs {
+			for _, key := range variantFiles(name, spec) {
+				keys[key] = true
+			}
+		}
+	}
+	if isRaw(name) {
+		keys[previewKey(name)] = true
+	}
+	for key := range keys {
+		if uploadMirror != nil {
+			uploadMirror.remove(key)
+		}
+		if err := uploadStore.Delete(context.Background(), key); err != nil {
+			log.Printf("Error deleting %s: %v", key, err)
+		}
+	}
+
+	if err := metadata.delete(name); err != nil {
+		log.Printf("Error deleting metadata for %s: %v", name, err)
+	}
+	if err := metadata.removeFromAlbums(name); err != nil {
+		log.Printf("Error removing %s from albums: %v", name, err)
+	}
+}
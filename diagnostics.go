@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadFailure describes a rejected upload for client developers
+// debugging their encoders
+type uploadFailure struct {
+	RequestID      string `json:"request_id"`
+	At             int64  `json:"at"`
+	Status         int    `json:"status"`
+	Rule           string `json:"rule"`
+	Error          string `json:"error"`
+	Profile        string `json:"profile,omitempty"`
+	DeclaredType   string `json:"declared_type,omitempty"`
+	DetectedFormat string `json:"detected_format,omitempty"`
+	Bytes          int    `json:"bytes"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	ColorSpace     string `json:"color_space,omitempty"`
+	BitDepth       int    `json:"bit_depth,omitempty"`
+	UserAgent      string `json:"user_agent,omitempty"`
+	APIKey         string `json:"api_key,omitempty"`
+}
+
+// ruleError is a validation failure naming the rule it broke
+type ruleError struct {
+	rule string
+	msg  string
+}
+
+func (e *ruleError) Error() string {
+	return e.msg
+}
+
+// maxUploadFailures is how many recent failures are kept
+const maxUploadFailures = 200
+
+// failureLog keeps recent upload failures, and appends them to
+// ./data/upload-failures.jsonl when AFROBASE_PERSIST_UPLOAD_FAILURES=on so
+// they survive restarts
+type failureLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	failures []uploadFailure
+}
+
+var uploadFailures = &failureLog{}
+
+// openFailureLog loads persisted failures and keeps the file open for
+// appending
+func openFailureLog() (*failureLog, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dataDir, "upload-failures.jsonl")
+
+	l := &failureLog{}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var failure uploadFailure
+			if json.Unmarshal(scanner.Bytes(), &failure) == nil {
+				l.keep(failure)
+			}
+		}
+		f.Close()
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *failureLog) keep(failure uploadFailure) {
+	l.failures = append(l.failures, failure)
+	if len(l.failures) > maxUploadFailures {
+		l.failures = l.failures[len(l.failures)-maxUploadFailures:]
+	}
+}
+
+func (l *failureLog) add(failure uploadFailure) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keep(failure)
+	if l.file != nil {
+		line, _ := json.Marshal(failure)
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			log.Printf("Error writing upload failure log: %v", err)
+		}
+	}
+}
+
+// recent returns up to limit failures, newest first
+func (l *failureLog) recent(limit int) []uploadFailure {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]uploadFailure, 0, min(limit, len(l.failures)))
+	for i := len(l.failures) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, l.failures[i])
+	}
+	return out
+}
+
+// uploadAttempt collects what is known about an upload as it is validated,
+// so a rejection can say what the server saw
+type uploadAttempt struct {
+	profile      string
+	declaredType string
+	data         []byte
+	stored       string // filename the upload was saved as
+}
+
+// reject records a validation failure and responds with it
+func (a *uploadAttempt) reject(c *fiber.Ctx, status int, rule, message string) error {
+	failure := uploadFailure{
+		At:           serverClock.Now().Unix(),
+		Status:       status,
+		Rule:         rule,
+		Error:        message,
+		Profile:      a.profile,
+		DeclaredType: a.declaredType,
+		Bytes:        len(a.data),
+		UserAgent:    strings.Clone(c.Get("User-Agent")),
+		APIKey:       apiKeyName(c),
+	}
+	if id, ok := c.Locals("requestid").(string); ok {
+		failure.RequestID = strings.Clone(id)
+	}
+	if len(a.data) > 0 {
+		if config, format, err := image.DecodeConfig(bytes.NewReader(a.data)); err == nil {
+			failure.DetectedFormat = format
+			failure.Width, failure.Height = config.Width, config.Height
+			failure.ColorSpace, failure.BitDepth = colorInfo(config.ColorModel)
+		}
+		if ext := rawFormat(a.data); ext != "" {
+			failure.DetectedFormat = ext[1:]
+		}
+		if failure.DetectedFormat == "" {
+			failure.DetectedFormat = "unknown"
+		}
+	}
+	uploadFailures.add(failure)
+	countRejection(rule)
+
+	return c.Status(status).JSON(fiber.Map{
+		"error":       message,
+		"success":     false,
+		"diagnostics": failure,
+	})
+}
+
+// rejectRule rejects with the rule named by err if it is a ruleError
+func (a *uploadAttempt) rejectRule(c *fiber.Ctx, status int, fallbackRule, prefix string, err error) error {
+	rule := fallbackRule
+	var re *ruleError
+	if errors.As(err, &re) {
+		rule = re.rule
+	}
+	return a.reject(c, status, rule, prefix+err.Error())
+}
+
+// getUploadFailures lists recent rejected uploads, newest first:
+// GET /api/uploads/failures?limit=50
+func getUploadFailures(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > maxUploadFailures {
+		limit = 50
+	}
+	failures := uploadFailures.recent(limit)
+	return c.JSON(fiber.Map{
+		"success":  true,
+		"count":    len(failures),
+		"failures": failures,
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// clientGone can only tell on Unix systems, so uploads elsewhere run until
+// they finish or their deadline passes
+func clientGone(conn net.Conn) bool {
+	return false
+}
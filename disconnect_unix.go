@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// clientGone reports whether the client has closed conn. It peeks without
+// blocking, so request bytes still to be read are left in place.
+func clientGone(conn net.Conn) bool {
+	for {
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapped.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var gone bool
+	// Control doesn't take the read lock, so a blocked body read can't
+	// hold it up. Go keeps sockets non-blocking, so the peek returns at once.
+	err = raw.Control(func(fd uintptr) {
+		var b [1]byte
+		n, _, err := unix.Recvfrom(int(fd), b[:], unix.MSG_PEEK)
+		gone = (n == 0 && err == nil) || errors.Is(err, unix.ECONNRESET) || errors.Is(err, unix.EPIPE)
+	})
+	return err == nil && gone
+}
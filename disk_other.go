@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// diskSpace is only supported on Unix systems
+func diskSpace(dir string) (size, free uint64, err error) {
+	return 0, 0, errors.New("disk space is only reported on Unix systems")
+}
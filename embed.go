@@ -0,0 +1,117 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getEmbedScript serves the loader bloggers paste into their pages:
+//
+//	<script src="https://host/embed.js" data-limit="12"></script>
+//
+// It replaces itself with an auto-sizing iframe of the gallery widget.
+func getEmbedScript(c *fiber.Ctx) error {
+	c.Type("js")
+	c.Set("Cache-Control", "public, max-age=3600")
+	return c.SendString(strings.ReplaceAll(embedScript, "{{BASE_URL}}", publicBaseURL))
+}
+
+const embedScript = `(function () {
+  var script = document.currentScript;
+  if (!script) return;
+  var limit = script.getAttribute("data-limit") || "12";
+  var frame = document.createElement("iframe");
+  frame.src = "{{BASE_URL}}/embed/gallery?limit=" + encodeURIComponent(limit);
+  frame.title = "AfroBase gallery";
+  frame.loading = "lazy";
+  frame.style.width = "100%";
+  frame.style.border = "0";
+  frame.style.height = "400px";
+  window.addEventListener("message", function (event) {
+    if (event.source === frame.contentWindow && event.data && event.data.afrobaseHeight) {
+      frame.style.height = event.data.afrobaseHeight + "px";
+    }
+  });
+  script.parentNode.insertBefore(frame, script);
+})();
+`
+
+// getEmbedGallery renders the responsive gallery shown inside the widget's
+// iframe. Private and unlisted images are left out.
+func getEmbedGallery(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 12)
+	if limit < 1 || limit > 100 {
+		limit = 12
+	}
+
+	files, err := uploadedFiles()
+	if err != nil {
+		return c.Status(500).SendString("Failed to read uploads directory")
+	}
+
+	type item struct {
+		Title, Thumbnail, PageURL string
+	}
+	items := make([]item, 0, len(files))
+	for _, file := range files {
+		if len(items) == limit {
+			break
+		}
+		if isPrivate(file.Name()) || isUnlisted(file.Name()) {
+			continue
+		}
+		thumbnail := publicBaseURL + displayPath(file.Name())
+		if variants, _ := variantURLs(file.Name()); variants["200"] != "" {
+			thumbnail = publicBaseURL + variants["200"]
+		}
+		items = append(items, item{
+			Title:     imageInfo(file.Name()).Title,
+			Thumbnail: thumbnail,
+			PageURL:   imagePageURL(file.Name()),
+		})
+	}
+
+	var page strings.Builder
+	if err := embedGalleryTemplate.Execute(&page, items); err != nil {
+		return err
+	}
+	// The widget is meant to be framed by other sites
+	c.Set("Content-Security-Policy", "frame-ancestors *")
+	c.Type("html")
+	return c.SendString(page.String())
+}
+
+var embedGalleryTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+* { margin: 0; padding: 0; box-sizing: border-box; }
+body { font-family: 'Ubuntu', Arial, sans-serif; background: transparent; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(140px, 1fr)); gap: 8px; }
+.grid a { display: block; aspect-ratio: 1; overflow: hidden; border-radius: 8px; }
+.grid img { width: 100%; height: 100%; object-fit: cover; transition: transform 0.3s ease; }
+.grid a:hover img { transform: scale(1.05); }
+.credit { font-size: 0.75rem; margin-top: 6px; text-align: right; color: #CD853F; }
+</style>
+</head>
+<body>
+<div class="grid">
+{{- range .}}
+<a href="{{.PageURL}}" target="_blank" rel="noopener" title="{{.Title}}"><img src="{{.Thumbnail}}" alt="{{.Title}}" loading="lazy"></a>
+{{- end}}
+</div>
+<div class="credit">Powered by AfroBase</div>
+<script>
+function postHeight() {
+  parent.postMessage({ afrobaseHeight: document.documentElement.scrollHeight }, "*");
+}
+window.addEventListener("load", postHeight);
+window.addEventListener("resize", postHeight);
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+// initErrorReporting sends panics and 5xx responses to Sentry, or any
+// Sentry-compatible service, when AFROBASE_SENTRY_DSN is set.
+// AFROBASE_SENTRY_ENVIRONMENT tags events with the deployment.
+func initErrorReporting(dsn string) error {
+	return sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: os.Getenv("AFROBASE_SENTRY_ENVIRONMENT"),
+	})
+}
+
+// reportErrors reports panics (before passing them on) and server errors
+// with the request attached. Sensitive headers such as Authorization are
+// stripped by the SDK.
+func reportErrors(c *fiber.Ctx) error {
+	hub := sentry.CurrentHub().Clone()
+	withRequest := func() {
+		if req, err := adaptor.ConvertRequest(c, true); err == nil {
+			hub.Scope().SetRequest(req)
+		}
+		hub.Scope().SetTag("route", c.Method()+" "+c.Route().Path)
+		if id, ok := c.Locals("requestid").(string); ok {
+			hub.Scope().SetTag("request_id", id)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			withRequest()
+			hub.Recover(r)
+			hub.Flush(2 * time.Second)
+			panic(r)
+		}
+	}()
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = 500
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+	if status < 500 {
+		return err
+	}
+
+	withRequest()
+	hub.Scope().SetTag("status", strconv.Itoa(status))
+	if err != nil {
+		hub.CaptureException(err)
+		return err
+	}
+	body := c.Response().Body()
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	hub.Scope().SetContext("response", sentry.Context{"body": string(body)})
+	hub.CaptureMessage(fmt.Sprintf("%s %s returned %d", c.Method(), c.Path(), status))
+	return nil
+}
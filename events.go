@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var errNotPending = errors.New("image is not awaiting moderation in this album")
+
+// eventLink finds the moderated upload link and album an event token
+// belongs to. Expired links still show their slideshow.
+func eventLink(token string) (uploadLink, album, bool) {
+	l, ok := metadata.uploadLink(secretKey(token))
+	if !ok || !l.Moderated {
+		return uploadLink{}, album{}, false
+	}
+	a, ok := metadata.album(l.Album)
+	return l, a, ok
+}
+
+// getKiosk serves the page guests at an event upload photos from, with no
+// account: GET /events/:token. What they send waits for the album owner's
+// approval before it shows in the slideshow.
+func getKiosk(c *fiber.Ctx) error {
+	l, a, ok := eventLink(c.Params("token"))
+	if !ok {
+		return c.Status(404).SendString("Event not found")
+	}
+
+	var page strings.Builder
+	err := kioskTemplate.Execute(&page, map[string]any{
+		"Name":   a.Name,
+		"Token":  c.Params("token"),
+		"Closed": !l.usable(serverClock.Now()),
+	})
+	if err != nil {
+		return err
+	}
+	c.Set("X-Robots-Tag", "noindex")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html")
+	return c.SendString(page.String())
+}
+
+// getEventSlideshow lists an event's approved photos in the order they
+// were added, as for an album's slideshow, for a display to poll:
+// GET /events/:token/slideshow
+func getEventSlideshow(c *fiber.Ctx) error {
+	_, a, ok := eventLink(c.Params("token"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Event not found",
+			"success": false,
+		})
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"name":       a.Name,
+		"items":      slideshowItems(c, a, "", defaultSlideSeconds),
+		"updated_at": a.Updated,
+	})
+}
+
+// listPending lists the uploads awaiting approval for an album, oldest
+// first: GET /api/albums/:id/pending
+func listPending(c *fiber.Ctx) error {
+	if err := checkAlbumOwner(c, c.Params("id")); err != nil {
+		return err
+	}
+	a, _ := metadata.album(c.Params("id"))
+	records := make([]map[string]interface{}, 0, len(a.Pending))
+	for _, name := range a.Pending {
+		object, err := uploadStore.Stat(c.Context(), name)
+		if err != nil {
+			if !isMissing(err) {
+				log.Printf("Error reading %s: %v", name, err)
+			}
+			continue
+		}
+		records = append(records, imageRecord(object.Info()))
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"images":  records,
+	})
+}
+
+// approvePending adds an upload awaiting approval to its album, making it
+// public: POST /api/albums/:id/pending/:image
+func approvePending(c *fiber.Ctx) error {
+	if a, ok := metadata.album(c.Params("id")); ok && a.Seal != nil {
+		return sendSealed(c, "moderation_approve", a.ID, "Album is sealed")
+	}
+	name, err := takePending(c)
+	if name == "" {
+		return err
+	}
+	if meta, ok := metadata.get(name); ok && meta.Pending {
+		_, err := metadata.update(name, meta, func(meta *imageMeta) error {
+			meta.Pending = false
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error approving %s: %v", name, err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to save metadata",
+				"success": false,
+			})
+		}
+		changes.record(changeUpdate, name)
+	}
+	_, err = metadata.updateAlbum(c.Params("id"), func(a *album) error {
+		if !a.contains(name) {
+			a.Images = append(a.Images, name)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error adding %s to album %s: %v", name, c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update album",
+			"success": false,
+		})
+	}
+	audit(c, "moderation_approve", c.Params("id")+"/"+name)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      imageID(name),
+	})
+}
+
+// rejectPending turns down an upload awaiting approval, deleting it unless
+// it was already stored before, as duplicates are:
+// DELETE /api/albums/:id/pending/:image
+func rejectPending(c *fiber.Ctx) error {
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+
+	name, err := takePending(c)
+	if name == "" {
+		return err
+	}
+	meta, _ := metadata.get(name)
+	deleted := meta.Pending && !imageHeld(name)
+	if deleted {
+		if err := removeUpload(name); err != nil {
+			log.Printf("Error deleting %s: %v", name, err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to delete image",
+				"success": false,
+			})
+		}
+	}
+	audit(c, "moderation_reject", c.Params("id")+"/"+name)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      imageID(name),
+		"deleted": deleted,
+	})
+}
+
+// takePending takes the image a moderation request is about out of its
+// album's queue, returning its stored name. When it can't, it answers the
+// request and returns "" with the response's error.
+func takePending(c *fiber.Ctx) (string, error) {
+	if err := checkAlbumOwner(c, c.Params("id")); err != nil {
+		return "", err
+	}
+	name, _ := findUpload(c.Params("image"))
+	_, err := metadata.updateAlbum(c.Params("id"), func(a *album) error {
+		if name == "" || !slices.Contains(a.Pending, name) {
+			return errNotPending
+		}
+		a.Pending = slices.DeleteFunc(a.Pending, func(image string) bool { return image == name })
+		return nil
+	})
+	switch {
+	case errors.Is(err, errNotPending):
+		return "", c.Status(404).JSON(fiber.Map{
+			"error":   "Image is not awaiting moderation in this album",
+			"success": false,
+		})
+	case err != nil:
+		log.Printf("Error updating album %s: %v", c.Params("id"), err)
+		return "", c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update album",
+			"success": false,
+		})
+	}
+	return name, nil
+}
+
+var kioskTemplate = template.Must(template.New("kiosk").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Name}} · AfroBase</title>
+<style>
+body { font-family: 'Ubuntu', Arial, sans-serif; background: #1a0e0a; color: #f4f1eb; max-width: 640px; margin: 0 auto; padding: 20px; text-align: center; }
+h1 { color: #DAA520; }
+label { display: block; padding: 40px 20px; border: 2px dashed #DAA520; border-radius: 12px; cursor: pointer; font-size: 1.2em; }
+input { display: none; }
+#status { min-height: 1.5em; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{if .Closed}}
+<p>This event is no longer taking photos.</p>
+{{else}}
+<label>Tap to share your photos<input id="photos" type="file" accept="image/*" multiple></label>
+<p id="status"></p>
+<script>
+(function () {
+  var token = {{.Token}};
+  var status = document.getElementById("status");
+  document.getElementById("photos").addEventListener("change", async function (event) {
+    var files = Array.from(event.target.files), sent = 0;
+    for (var i = 0; i < files.length; i++) {
+      status.textContent = "Sending " + (i + 1) + " of " + files.length + "…";
+      var form = new FormData();
+      form.set("image", files[i], files[i].name);
+      try {
+        var response = await fetch("/upload", { method: "POST", headers: { "X-Upload-Token": token }, body: form });
+        var body = await response.json();
+        if (!response.ok) throw new Error(body.error || "Upload failed");
+        sent++;
+      } catch (err) {
+        status.textContent = err.message;
+        return;
+      }
+    }
+    status.textContent = "Thanks! " + (sent === 1 ? "Your photo" : "Your " + sent + " photos") + " will show once approved.";
+    event.target.value = "";
+  });
+})();
+</script>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// eventSourcing keeps every change to image and album metadata as an
+// immutable event in the metadata database (AFROBASE_EVENT_SOURCING=on),
+// written in the same transaction as the change. The images and albums
+// buckets are then projections of the event log: replay-events rebuilds
+// them from it, and the admin API lists the history and replays it to any
+// point.
+var eventSourcing = os.Getenv("AFROBASE_EVENT_SOURCING") == "on"
+
+// eventsBucket holds the event log keyed by big-endian sequence number.
+// Nothing in it is ever changed or deleted.
+var eventsBucket = []byte("events")
+
+// projectedBuckets are the buckets the event log is projected into. The
+// other buckets are left out on purpose: users, usernames, identities,
+// invites and upload links hold credentials or their hashes, which an
+// immutable log would keep after they were revoked, and legal holds,
+// unlisted views and mirror deletions are operational state rather than
+// library history. replay-events leaves them as they are.
+var projectedBuckets = [][]byte{metadataBucket, albumsBucket}
+
+// metadataEvent is a record of one bucket being written or deleted
+type metadataEvent struct {
+	Seq uint64 `json:"seq"`
+	At  int64  `json:"at"`
+	// Bucket is "images" or "albums"
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// Value is the record as written, absent for deletions
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// putRecord writes a record to a projected bucket, logging it as an event
+// if event sourcing is on
+func putRecord(tx *bolt.Tx, bucket, key, value []byte) error {
+	if err := tx.Bucket(bucket).Put(key, value); err != nil {
+		return err
+	}
+	return appendEvent(tx, bucket, key, value)
+}
+
+// deleteRecord deletes a record from a projected bucket, logging it as an
+// event if event sourcing is on and there was one
+func deleteRecord(tx *bolt.Tx, bucket, key []byte) error {
+	b := tx.Bucket(bucket)
+	if b.Get(key) == nil {
+		return nil
+	}
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	return appendEvent(tx, bucket, key, nil)
+}
+
+func appendEvent(tx *bolt.Tx, bucket, key, value []byte) error {
+	if !eventSourcing {
+		return nil
+	}
+	events := tx.Bucket(eventsBucket)
+	seq, err := events.NextSequence()
+	if err != nil {
+		return err
+	}
+	event, err := json.Marshal(metadataEvent{
+		Seq:    seq,
+		At:     serverClock.Now().Unix(),
+		Bucket: string(bucket),
+		Key:    string(key),
+		Value:  value,
+	})
+	if err != nil {
+		return err
+	}
+	return events.Put(binary.BigEndian.AppendUint64(nil, seq), event)
+}
+
+// startEventSourcing brings the event log up to date with the projected
+// buckets when the server starts. Changes made while event sourcing was
+// off, or before it was first turned on, were never logged, so the log is
+// replayed and an event appended for every record it has wrong: written
+// for those it lacks or has an older value of, deleted for those gone
+// since. Replaying the log then gives the buckets as they are.
+func (s *metadataStore) startEventSourcing() error {
+	var snapshot int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		replayed := map[string]map[string][]byte{}
+		for _, bucket := range projectedBuckets {
+			replayed[string(bucket)] = map[string][]byte{}
+		}
+		err := replayEvents(tx, 0, func(event metadataEvent) error {
+			records, ok := replayed[event.Bucket]
+			switch {
+			case !ok:
+			case event.Value == nil:
+				delete(records, event.Key)
+			default:
+				records[event.Key] = event.Value
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, bucket := range projectedBuckets {
+			logged := replayed[string(bucket)]
+			changed := map[string][]byte{}
+			err := tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+				if old, ok := logged[string(k)]; !ok || !bytes.Equal(old, v) {
+					changed[string(k)] = bytes.Clone(v)
+				}
+				delete(logged, string(k))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// What is left was deleted while the log wasn't kept
+			for k := range logged {
+				changed[k] = nil
+			}
+			for _, k := range slices.Sorted(maps.Keys(changed)) {
+				if err := appendEvent(tx, bucket, []byte(k), changed[k]); err != nil {
+					return err
+				}
+			}
+			snapshot += len(changed)
+		}
+		return nil
+	})
+	if err == nil && snapshot > 0 {
+		log.Printf("Event log caught up with %d record(s) changed while it wasn't kept", snapshot)
+	}
+	return err
+}
+
+// replayEvents calls apply for each event logged, in order, up to and
+// including seq, or all of them if seq is 0
+func replayEvents(tx *bolt.Tx, seq uint64, apply func(event metadataEvent) error) error {
+	c := tx.Bucket(eventsBucket).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if seq != 0 && binary.BigEndian.Uint64(k) > seq {
+			break
+		}
+		var event metadataEvent
+		if err := json.Unmarshal(v, &event); err != nil {
+			return err
+		}
+		if err := apply(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildProjections empties the projected buckets and replays the whole
+// event log into them, returning the number of events replayed
+func rebuildProjections(tx *bolt.Tx) (int, error) {
+	for _, bucket := range projectedBuckets {
+		if err := tx.DeleteBucket(bucket); err != nil {
+			return 0, err
+		}
+		if _, err := tx.CreateBucket(bucket); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	err := replayEvents(tx, 0, func(event metadataEvent) error {
+		n++
+		b := tx.Bucket([]byte(event.Bucket))
+		if b == nil {
+			return nil
+		}
+		if event.Value == nil {
+			return b.Delete([]byte(event.Key))
+		}
+		return b.Put([]byte(event.Key), event.Value)
+	})
+	return n, err
+}
+
+// runReplayEvents implements `replay-events`, rebuilding image and album
+// metadata from the event log, as after restoring the log alone from a
+// backup. The server must be stopped, since it holds the database open.
+func runReplayEvents(args []string) {
+	flags := flag.NewFlagSet("replay-events", flag.ExitOnError)
+	flags.Parse(args)
+
+	store, err := openMetadataStore(false)
+	if err != nil {
+		log.Fatal("Failed to open metadata store:", err)
+	}
+	defer store.db.Close()
+	var n int
+	err = store.db.Update(func(tx *bolt.Tx) (err error) {
+		n, err = rebuildProjections(tx)
+		return err
+	})
+	if err != nil {
+		log.Fatal("Failed to replay events:", err)
+	}
+	log.Printf("Rebuilt image and album metadata from %d events", n)
+}
+
+// listMetadataEvents lists logged events after a sequence number, oldest
+// first: GET /api/admin/events?since=0&limit=1000. ?key= limits them to
+// one image's stored name or album's ID.
+func listMetadataEvents(c *fiber.Ctx) error {
+	since, err := strconv.ParseUint(c.Query("since", "0"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "since must be a sequence number",
+			"success": false,
+		})
+	}
+	limit := c.QueryInt("limit", 1000)
+	if limit < 1 || limit > 10000 {
+		limit = 1000
+	}
+	key := c.Query("key")
+
+	events := []metadataEvent{}
+	more := false
+	err = metadata.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(eventsBucket).Cursor()
+		for k, v := cur.Seek(binary.BigEndian.AppendUint64(nil, since+1)); k != nil; k, v = cur.Next() {
+			var event metadataEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if key != "" && event.Key != key {
+				continue
+			}
+			if len(events) == limit {
+				more = true
+				break
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading events: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read events",
+			"success": false,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"events":  events,
+		"more":    more,
+	})
+}
+
+// getMetadataStateAt replays the event log up to and including an event
+// and returns every image's metadata and album as they were then:
+// GET /api/admin/events/state?at=<seq>
+func getMetadataStateAt(c *fiber.Ctx) error {
+	at, err := strconv.ParseUint(c.Query("at"), 10, 64)
+	if err != nil || at == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "at must be a sequence number",
+			"success": false,
+		})
+	}
+	state := map[string]map[string]json.RawMessage{}
+	for _, bucket := range projectedBuckets {
+		state[string(bucket)] = map[string]json.RawMessage{}
+	}
+	err = metadata.db.View(func(tx *bolt.Tx) error {
+		return replayEvents(tx, at, func(event metadataEvent) error {
+			records, ok := state[event.Bucket]
+			switch {
+			case !ok:
+			case event.Value == nil:
+				delete(records, event.Key)
+			default:
+				records[event.Key] = event.Value
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error replaying events: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to replay events",
+			"success": false,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"at":      at,
+		"images":  state[string(metadataBucket)],
+		"albums":  state[string(albumsBucket)],
+	})
+}
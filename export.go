@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportedImage is what the static gallery template renders per image
+type exportedImage struct {
+	ID          string
+	Title       string
+	Description string
+	Original    string
+	Display     string
+	Thumbnail   string
+	UploadTime  time.Time
+	Size        int64
+}
+
+// runExportSite implements `export-site`, writing a self-contained static
+// HTML gallery of every public upload that can be hosted anywhere
+func runExportSite(args []string) {
+	flags := flag.NewFlagSet("export-site", flag.ExitOnError)
+	out := flags.String("out", "./dist", "directory to write the static site to")
+	title := flags.String("title", "AfroBase Gallery", "gallery title")
+	frozen := flags.String("frozen-clock", os.Getenv("AFROBASE_FROZEN_CLOCK"), frozenClockUsage)
+	flags.Parse(args)
+	freezeClock(*frozen)
+
+	imageProcessor = newLimitedProcessor(newProcessor(os.Getenv("AFROBASE_PROCESSOR")))
+
+	// Titles and descriptions come from the metadata store when it exists
+	if meta, err := openMetadataStore(true); err == nil {
+		metadata = meta
+		defer meta.db.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Exporting without stored metadata: %v", err)
+	}
+
+	store, err := openUploadStore()
+	if err != nil {
+		log.Fatal("Failed to open upload storage:", err)
+	}
+	uploadStore = store
+
+	for _, dir := range []string{"images", "thumbs"} {
+		if err := os.MkdirAll(filepath.Join(*out, dir), 0755); err != nil {
+			log.Fatal("Failed to create output directory:", err)
+		}
+	}
+
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Fatal("Failed to read uploads directory:", err)
+	}
+
+	var images []exportedImage
+	for _, file := range files {
+		if isPrivate(file.Name()) || isUnlisted(file.Name()) {
+			continue
+		}
+		image, err := exportImage(file.Name(), *out)
+		if err != nil {
+			log.Printf("Skipping %s: %v", file.Name(), err)
+			continue
+		}
+		images = append(images, image)
+	}
+
+	// Newest first, matching the order people expect from a gallery
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].UploadTime.After(images[j].UploadTime)
+	})
+
+	index, err := os.Create(filepath.Join(*out, "index.html"))
+	if err != nil {
+		log.Fatal("Failed to create index.html:", err)
+	}
+	defer index.Close()
+
+	err = galleryTemplate.Execute(index, map[string]interface{}{
+		"Title":    *title,
+		"Images":   images,
+		"Exported": serverClock.Now(),
+	})
+	if err != nil {
+		log.Fatal("Failed to render gallery:", err)
+	}
+
+	log.Printf("Exported %d image(s) to %s", len(images), *out)
+}
+
+// exportImage copies an upload and its thumbnail into the site directory
+func exportImage(name, out string) (exportedImage, error) {
+	info, err := uploadStore.Stat(context.Background(), name)
+	if err != nil {
+		return exportedImage{}, err
+	}
+	if err := copyUploadTo(name, filepath.Join(out, "images", name)); err != nil {
+		return exportedImage{}, err
+	}
+
+	thumbnail, err := exportThumbnail(name, out)
+	if err != nil {
+		return exportedImage{}, fmt.Errorf("thumbnail: %w", err)
+	}
+
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+
+	// Browsers can't show RAW originals, so the lightbox uses the preview
+	display := "images/" + name
+	if isRaw(name) {
+		display = "images/" + base + ".preview.jpg"
+		preview, err := readDisplaySource(name)
+		if err != nil {
+			return exportedImage{}, fmt.Errorf("preview: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(out, display), preview, 0644); err != nil {
+			return exportedImage{}, err
+		}
+	}
+
+	meta := imageInfo(name)
+	return exportedImage{
+		ID:          base,
+		Title:       meta.Title,
+		Description: meta.Description,
+		Original:    "images/" + name,
+		Display:     display,
+		Thumbnail:   "thumbs/" + thumbnail,
+		UploadTime:  time.Unix(meta.UploadTime, 0),
+		Size:        info.Size,
+	}, nil
+}
+
+// exportThumbnail copies the upload's small variant into the site, resizing
+// it now if the variant worker hasn't produced one, and returns its filename
+func exportThumbnail(name, out string) (string, error) {
+	if variants, _ := variantURLs(name); variants["200"] != "" {
+		thumbnail := filepath.Base(variants["200"])
+		return thumbnail, copyUploadTo(variantKey(variants["200"]), filepath.Join(out, "thumbs", thumbnail))
+	}
+
+	data, err := readDisplaySource(name)
+	if err != nil {
+		return "", err
+	}
+	if err := checkPixelBudget(data); err != nil {
+		return "", err
+	}
+	resized, ext, err := imageProcessor.Resize(data, TransformOptions{Width: 200, Height: 200})
+	if err != nil {
+		return "", err
+	}
+	thumbnail := strings.TrimSuffix(name, filepath.Ext(name)) + ext
+	return thumbnail, os.WriteFile(filepath.Join(out, "thumbs", thumbnail), resized, 0644)
+}
+
+// galleryTemplate uses a CSS :target lightbox so the export works without
+// JavaScript and from file:// URLs
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+* { margin: 0; padding: 0; box-sizing: border-box; }
+body { font-family: 'Ubuntu', Arial, sans-serif; background: #1a0e0a; color: #f4f1eb; padding: 20px; }
+h1 { text-align: center; color: #DAA520; margin: 30px 0; }
+.gallery { display: grid; grid-template-columns: repeat(auto-fill, minmax(220px, 1fr)); gap: 20px; max-width: 1200px; margin: 0 auto; }
+.card { background: rgba(255, 255, 255, 0.05); border: 2px solid rgba(218, 165, 32, 0.2); border-radius: 12px; overflow: hidden; }
+.card img { width: 100%; height: 200px; object-fit: cover; display: block; }
+.card .info { padding: 10px; }
+.card .meta { font-size: 0.8rem; color: #CD853F; }
+.card a { color: inherit; text-decoration: none; }
+.lightbox { display: none; position: fixed; inset: 0; background: rgba(0, 0, 0, 0.9); align-items: center; justify-content: center; flex-direction: column; }
+.lightbox:target { display: flex; }
+.lightbox img { max-width: 90vw; max-height: 80vh; }
+.lightbox p { margin-top: 10px; }
+.lightbox .close { position: absolute; top: 20px; right: 30px; color: #DAA520; font-size: 2rem; text-decoration: none; }
+footer { text-align: center; margin-top: 40px; font-size: 0.8rem; color: #CD853F; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="gallery">
+{{- range .Images}}
+<div class="card">
+<a href="#{{.ID}}"><img src="{{.Thumbnail}}" alt="{{.Title}}" loading="lazy"></a>
+<div class="info">
+<div>{{.Title}}</div>
+<div>{{.Description}}</div>
+<div class="meta">{{.UploadTime.Format "2 Jan 2006"}} · {{.Size}} bytes</div>
+</div>
+</div>
+{{- end}}
+</div>
+{{- range .Images}}
+<div class="lightbox" id="{{.ID}}">
+<a class="close" href="#">&times;</a>
+<img src="{{.Display}}" alt="{{.Title}}" loading="lazy">
+<p>{{.Title}} — <a href="{{.Original}}" download>Download original</a></p>
+</div>
+{{- end}}
+<footer>Exported from AfroBase on {{.Exported.Format "2 Jan 2006"}}</footer>
+</body>
+</html>
+`))
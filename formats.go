@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// formatExtensions maps the format names used in policies to the
+// extensions uploads of that format are stored with
+var formatExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"webp": ".webp",
+	"tiff": ".tif",
+	"avif": ".avif",
+	"dng":  ".dng",
+	"cr2":  ".cr2",
+	"nef":  ".nef",
+}
+
+// defaultFormats are accepted when AFROBASE_ALLOWED_FORMATS is unset
+var defaultFormats = []string{"jpeg", "png", "gif", "webp"}
+
+// rawFormats are added to the default policy when RAW uploads are enabled
+var rawFormats = []string{"dng", "cr2", "nef"}
+
+// allowedFormats is the global format policy, a comma-separated list of
+// format names in AFROBASE_ALLOWED_FORMATS (e.g. "jpeg,png,webp"),
+// defaulting to JPEG, PNG, GIF and WebP, and RAW formats with
+// AFROBASE_ACCEPT_RAW. Profiles can replace it with their own formats list.
+// Data in no recognised format is always refused, so the server can't be
+// used to host arbitrary files.
+var allowedFormats = loadAllowedFormats()
+
+func loadAllowedFormats() []string {
+	v := os.Getenv("AFROBASE_ALLOWED_FORMATS")
+	if v == "" {
+		if acceptRaw {
+			return slices.Concat(defaultFormats, rawFormats)
+		}
+		return defaultFormats
+	}
+	formats, err := parseFormats(strings.Split(v, ","))
+	if err != nil {
+		log.Fatal("Invalid AFROBASE_ALLOWED_FORMATS: ", err)
+	}
+	return formats
+}
+
+// parseFormats normalises and validates a list of format names
+func parseFormats(names []string) ([]string, error) {
+	formats := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "jpg" {
+			name = "jpeg"
+		}
+		if _, ok := formatExtensions[name]; !ok {
+			return nil, fmt.Errorf("unknown format %q", name)
+		}
+		formats = append(formats, name)
+	}
+	return formats, nil
+}
+
+// sniffExtension identifies image data from its first bytes, returning the
+// extension to store it with, or "" if it isn't a recognised format
+func sniffExtension(data []byte) string {
+	if ext := rawFormat(data); ext != "" {
+		return ext
+	}
+	if len(data) < 4 {
+		return ""
+	}
+	switch {
+	case data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return ".jpg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a")):
+		return ".gif"
+	// RIFF is also the container of WAV and AVI files
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return ".webp"
+	case bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")):
+		return ".tif"
+	case len(data) >= 12 && string(data[4:12]) == "ftypavif":
+		return ".avif"
+	}
+	return ""
+}
+
+// checkFormatPolicy rejects image data whose format the profile, or else
+// the global policy, doesn't accept, and data that isn't in a recognised
+// format at all. Every ingestion path checks what the client actually sent
+// with it, before any conversion.
+func checkFormatPolicy(data []byte, profile *uploadProfile) error {
+	allowed := allowedFormats
+	if profile != nil && profile.Formats != nil {
+		allowed = profile.Formats
+	}
+
+	ext := sniffExtension(data)
+	for _, name := range allowed {
+		if formatExtensions[name] == ext && ext != "" {
+			return nil
+		}
+	}
+	detected := "an unrecognised format"
+	for name, e := range formatExtensions {
+		if e == ext {
+			detected = name
+		}
+	}
+	sorted := slices.Clone(allowed)
+	slices.Sort(sorted)
+	return &ruleError{"formats", fmt.Sprintf("image is %s, accepted formats are %s", detected, strings.Join(sorted, ", "))}
+}
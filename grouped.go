@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones for ?tz= on hosts without a zoneinfo database
+	_ "time/tzdata"
+
+	"AfroBaseServer/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Layouts of group keys
+var groupLayouts = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+}
+
+// imageGroup is the images of one day or month on a page
+type imageGroup struct {
+	Key string `json:"key"`
+	// Start is the group's first moment, in the time zone
+	Start string `json:"start"`
+	// Count is how many images the group has on all pages
+	Count  int                      `json:"count"`
+	Images []map[string]interface{} `json:"images"`
+}
+
+// groupStart is the local midnight that starts the day or month t falls in
+func groupStart(t time.Time, by string) time.Time {
+	y, m, d := t.Date()
+	if by == "month" {
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// getGroupedImages lists images newest first, grouped by the calendar day
+// or month they were uploaded on in a time zone:
+// GET /api/images/grouped?by=day&tz=Africa/Nairobi. by is day or month and
+// tz an IANA time zone, UTC by default. Pages hold ?limit= images, so a
+// group can continue on the next page; each group's count covers all its
+// images. ?tag= and ?scope= filter as for GET /api/images.
+func getGroupedImages(c *fiber.Ctx) error {
+	by := c.Query("by", "day")
+	layout, ok := groupLayouts[by]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   `by must be "day" or "month"`,
+			"success": false,
+		})
+	}
+	loc, err := time.LoadLocation(c.Query("tz", "UTC"))
+	// "Local" would depend on where the server runs
+	if err != nil || c.Query("tz") == "Local" {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "tz must be a time zone such as Africa/Nairobi",
+			"success": false,
+		})
+	}
+	owner, err := listingOwner(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	objects, err := uploadStore.List(c.Context(), "")
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+	tag := strings.ToLower(strings.TrimSpace(c.Query("tag")))
+	uploaded := make(map[string]int64, len(objects))
+	objects = slices.DeleteFunc(objects, func(o storage.Object) bool {
+		meta := imageInfo(o.Key)
+		if (owner != "" && meta.Owner != owner) || (tag != "" && !meta.hasTag(tag)) || hiddenFrom(c, o.Key) {
+			return true
+		}
+		uploaded[o.Key] = meta.UploadTime
+		return false
+	})
+	slices.SortStableFunc(objects, func(a, b storage.Object) int {
+		return cmp.Or(cmp.Compare(uploaded[b.Key], uploaded[a.Key]), strings.Compare(b.Key, a.Key))
+	})
+
+	// Groups are counted in full, so headers are right on every page
+	keys := make(map[string]string, len(objects))
+	counts := map[string]int{}
+	for _, object := range objects {
+		key := time.Unix(uploaded[object.Key], 0).In(loc).Format(layout)
+		keys[object.Key] = key
+		counts[key]++
+	}
+
+	p := imagePage{page: c.QueryInt("page", 1), limit: c.QueryInt("limit", defaultPageSize), total: len(objects)}
+	if p.page < 1 || p.limit < 1 || p.limit > maxPageSize {
+		return sendPageError(c)
+	}
+	p.start = min((p.page-1)*p.limit, p.total)
+	p.end = min(p.start+p.limit, p.total)
+
+	groups := []imageGroup{}
+	for _, object := range objects[p.start:p.end] {
+		key := keys[object.Key]
+		if len(groups) == 0 || groups[len(groups)-1].Key != key {
+			groups = append(groups, imageGroup{
+				Key:   key,
+				Start: groupStart(time.Unix(uploaded[object.Key], 0).In(loc), by).Format(time.RFC3339),
+				Count: counts[key],
+			})
+		}
+		g := &groups[len(groups)-1]
+		g.Images = append(g.Images, imageRecord(object.Info()))
+	}
+
+	c.Set("X-Total-Count", strconv.Itoa(p.total))
+	return c.JSON(fiber.Map{
+		"success":     true,
+		"by":          by,
+		"tz":          loc.String(),
+		"groups":      groups,
+		"page":        p.page,
+		"limit":       p.limit,
+		"total":       p.total,
+		"total_pages": (p.total + p.limit - 1) / p.limit,
+	})
+}
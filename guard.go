@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"strconv"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// defaultMaxPixels bounds the decoded size of an image. A fully decoded
+// RGBA image costs 4 bytes per pixel, so 40 megapixels is ~160MB in memory.
+const defaultMaxPixels = 40_000_000
+
+// maxPixels is the pixel budget, overridable via AFROBASE_MAX_PIXELS
+var maxPixels = loadMaxPixels()
+
+func loadMaxPixels() int {
+	v := os.Getenv("AFROBASE_MAX_PIXELS")
+	if v == "" {
+		return defaultMaxPixels
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatal("Invalid AFROBASE_MAX_PIXELS: ", v)
+	}
+	return n
+}
+
+// checkPixelBudget reads only the image header and rejects images whose
+// decoded bitmap would exceed the pixel budget, so decompression bombs are
+// caught before anything decodes the full image. Data that isn't a
+// recognised image format is left to the caller to handle.
+func checkPixelBudget(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	if pixels := config.Width * config.Height; pixels > maxPixels {
+		return fmt.Errorf("image is %dx%d (%d pixels), limit is %d pixels",
+			config.Width, config.Height, pixels, maxPixels)
+	}
+	return nil
+}
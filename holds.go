@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// legalHold keeps an image from being deleted until it is released
+type legalHold struct {
+	Reason string `json:"reason,omitempty"`
+	Since  int64  `json:"since"`
+}
+
+// holdMu orders hold changes against deletions, so an image can't be
+// deleted between a hold being checked and the files going
+var holdMu sync.RWMutex
+
+// maxHoldReasonLength bounds the note kept with a hold
+const maxHoldReasonLength = 1000
+
+// putHold places a hold on an image, or replaces its reason
+func (s *metadataStore) putHold(name string, hold legalHold) error {
+	value, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(holdsBucket).Put([]byte(name), value)
+	})
+}
+
+// releaseHold lifts an image's hold
+func (s *metadataStore) releaseHold(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(holdsBucket).Delete([]byte(name))
+	})
+}
+
+// hold returns an image's legal hold, if it has one
+func (s *metadataStore) hold(name string) (legalHold, bool) {
+	var hold legalHold
+	found := false
+	if s == nil {
+		return hold, false
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(holdsBucket)
+		if bucket == nil {
+			return nil
+		}
+		if value := bucket.Get([]byte(name)); value != nil {
+			found = json.Unmarshal(value, &hold) == nil
+		}
+		return nil
+	})
+	return hold, found
+}
+
+// holds returns every legal hold keyed by stored filename
+func (s *metadataStore) holds() (map[string]legalHold, error) {
+	holds := map[string]legalHold{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(holdsBucket).ForEach(func(k, v []byte) error {
+			var hold legalHold
+			if err := json.Unmarshal(v, &hold); err != nil {
+				return err
+			}
+			holds[string(k)] = hold
+			return nil
+		})
+	})
+	return holds, err
+}
+
+// readHoldRequest reads {"legal_hold": true, "reason": "..."}
+func readHoldRequest(c *fiber.Ctx) (place bool, reason string, err error) {
+	var body struct {
+		LegalHold *bool  `json:"legal_hold"`
+		Reason    string `json:"reason"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.LegalHold == nil {
+		return false, "", errors.New("Body must be JSON with a legal_hold boolean")
+	}
+	reason = strings.TrimSpace(body.Reason)
+	if len(reason) > maxHoldReasonLength {
+		return false, "", errors.New("Reason is too long")
+	}
+	return *body.LegalHold, reason, nil
+}
+
+// setLegalHold places or releases a hold on an image:
+// PUT /api/admin/images/:id/legal-hold with {"legal_hold": true, "reason": "..."}.
+// Held images can't be deleted. Both changes are audited.
+func setLegalHold(c *fiber.Ctx) error {
+	place, reason, err := readHoldRequest(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	holdMu.Lock()
+	defer holdMu.Unlock()
+
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+
+	hold, held := metadata.hold(name)
+	var action, verb string
+	switch {
+	case place:
+		if !held {
+			hold.Since = serverClock.Now().Unix()
+		}
+		hold.Reason = reason
+		action, verb, err = "legal_hold", "placed on", metadata.putHold(name, hold)
+	case held:
+		action, verb, err = "legal_hold_release", "released from", metadata.releaseHold(name)
+	}
+	if err != nil {
+		log.Printf("Error updating legal hold on %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update legal hold",
+			"success": false,
+		})
+	}
+	if action != "" {
+		audit(c, action, name)
+		log.Printf("Legal hold %s %s", verb, name)
+	}
+
+	response := fiber.Map{
+		"success":    true,
+		"id":         imageID(name),
+		"legal_hold": place,
+	}
+	if place {
+		response["reason"] = hold.Reason
+		response["since"] = hold.Since
+	}
+	return c.JSON(response)
+}
+
+// setAlbumLegalHold places or releases a hold on an album:
+// PUT /api/admin/albums/:id/legal-hold, with the same body as for images.
+// A held album can't be deleted, and neither can its images be deleted or
+// taken out of it.
+func setAlbumLegalHold(c *fiber.Ctx) error {
+	place, reason, err := readHoldRequest(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	holdMu.Lock()
+	defer holdMu.Unlock()
+
+	var action, verb string
+	a, err := metadata.updateAlbum(c.Params("id"), func(a *album) error {
+		switch {
+		case place:
+			if a.LegalHold == nil {
+				a.LegalHold = &legalHold{Since: serverClock.Now().Unix()}
+			}
+			a.LegalHold.Reason = reason
+			action, verb = "album_legal_hold", "placed on"
+		case a.LegalHold != nil:
+			a.LegalHold = nil
+			action, verb = "album_legal_hold_release", "released from"
+		}
+		return nil
+	})
+	if errors.Is(err, errAlbumNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	}
+	if err != nil {
+		log.Printf("Error updating legal hold on album %s: %v", c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update legal hold",
+			"success": false,
+		})
+	}
+	if action != "" {
+		audit(c, action, a.ID)
+		log.Printf("Legal hold %s album %s", verb, a.ID)
+	}
+
+	response := fiber.Map{
+		"success":    true,
+		"id":         a.ID,
+		"legal_hold": place,
+	}
+	if place {
+		response["reason"] = a.LegalHold.Reason
+		response["since"] = a.LegalHold.Since
+	}
+	return c.JSON(response)
+}
+
+// imageHeld reports whether an image is under legal hold itself or through
+// an album it is in
+func imageHeld(name string) bool {
+	if _, held := metadata.hold(name); held {
+		return true
+	}
+	return slices.ContainsFunc(metadata.albumsOf(name), func(a album) bool { return a.LegalHold != nil })
+}
+
+// getLegalHolds lists the images and albums under legal hold:
+// GET /api/admin/legal-holds
+func getLegalHolds(c *fiber.Ctx) error {
+	holds, err := metadata.holds()
+	var albums []album
+	if err == nil {
+		albums, err = metadata.albums()
+	}
+	if err != nil {
+		log.Printf("Error reading legal holds: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read legal holds",
+			"success": false,
+		})
+	}
+
+	records := make([]fiber.Map, 0, len(holds))
+	for name, hold := range holds {
+		records = append(records, fiber.Map{
+			"id":     imageID(name),
+			"name":   name,
+			"reason": hold.Reason,
+			"since":  hold.Since,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i]["name"].(string) < records[j]["name"].(string)
+	})
+	heldAlbums := []fiber.Map{}
+	for _, a := range albums {
+		if a.LegalHold != nil {
+			heldAlbums = append(heldAlbums, fiber.Map{
+				"id":     a.ID,
+				"name":   a.Name,
+				"reason": a.LegalHold.Reason,
+				"since":  a.LegalHold.Since,
+			})
+		}
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"holds":   records,
+		"albums":  heldAlbums,
+	})
+}
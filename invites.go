@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// invite lets one person create an account while registration is closed
+type invite struct {
+	Note    string `json:"note,omitempty"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires"`
+}
+
+// invitesBucket holds invites keyed by the hex SHA-256 of their code, so
+// the codes themselves are never stored
+var invitesBucket = []byte("invites")
+
+// Lifetimes of invites
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 90 * 24 * time.Hour
+)
+
+// maxInviteNoteLength bounds the note kept with an invite
+const maxInviteNoteLength = 1000
+
+var errInvalidInvite = errors.New("invalid or expired invite")
+
+// secretKey is the key a secret code, such as an invite's, is stored under
+func secretKey(code string) []byte {
+	sum := sha256.Sum256([]byte(code))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// secretID names a stored secret by the start of its key, which is enough
+// to revoke it and useless for using it
+func secretID(key []byte) string {
+	return string(key[:16])
+}
+
+// revokeSecret deletes the secret with an ID from a bucket, reporting
+// whether there was one. keep, if not nil, can refuse the deletion by
+// looking at the value.
+func revokeSecret(tx *bolt.Tx, name []byte, id string, keep func(value []byte) error) (bool, error) {
+	bucket := tx.Bucket(name)
+	k, v := bucket.Cursor().Seek([]byte(id))
+	if len(id) != 16 || k == nil || !bytes.HasPrefix(k, []byte(id)) {
+		return false, nil
+	}
+	if keep != nil {
+		if err := keep(v); err != nil {
+			return false, err
+		}
+	}
+	return true, bucket.Delete(k)
+}
+
+// putInvite saves an invite under its code
+func (s *metadataStore) putInvite(code string, inv invite) error {
+	value, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(invitesBucket).Put(secretKey(code), value)
+	})
+}
+
+// redeemInviteTx uses up an invite, failing with errInvalidInvite if there
+// is no such invite or it has expired
+func redeemInviteTx(tx *bolt.Tx, code string) error {
+	bucket := tx.Bucket(invitesBucket)
+	key := secretKey(code)
+	value := bucket.Get(key)
+	if value == nil {
+		return errInvalidInvite
+	}
+	var inv invite
+	if err := json.Unmarshal(value, &inv); err != nil {
+		return err
+	}
+	if serverClock.Now().Unix() >= inv.Expires {
+		return errInvalidInvite
+	}
+	return bucket.Delete(key)
+}
+
+// invites lists the invites that have yet to expire, by ID
+func (s *metadataStore) invites() (map[string]invite, error) {
+	invites := map[string]invite{}
+	now := serverClock.Now().Unix()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(invitesBucket).ForEach(func(k, v []byte) error {
+			var inv invite
+			if err := json.Unmarshal(v, &inv); err != nil {
+				return err
+			}
+			if now < inv.Expires {
+				invites[secretID(k)] = inv
+			}
+			return nil
+		})
+	})
+	return invites, err
+}
+
+// revokeInvite deletes the invite with an ID, reporting whether there was one
+func (s *metadataStore) revokeInvite(id string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) (err error) {
+		found, err = revokeSecret(tx, invitesBucket, id, nil)
+		return err
+	})
+	return found, err
+}
+
+// createInvite makes a single-use invite: POST /api/admin/invites with an
+// optional {"expires_in": "72h", "note": "for Amani"}. The code is only
+// ever shown in this response, and is given as "invite" when registering.
+func createInvite(c *fiber.Ctx) error {
+	var req struct {
+		ExpiresIn string `json:"expires_in"`
+		Note      string `json:"note"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Body must be JSON",
+				"success": false,
+			})
+		}
+	}
+	ttl := defaultInviteTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxInviteTTL {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "expires_in must be a duration of up to " + maxInviteTTL.String(),
+				"success": false,
+			})
+		}
+		ttl = d
+	}
+	if len(req.Note) > maxInviteNoteLength {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "note is too long",
+			"success": false,
+		})
+	}
+
+	code, err := newID(16)
+	if err != nil {
+		log.Printf("Error generating invite code: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create invite",
+			"success": false,
+		})
+	}
+	now := serverClock.Now()
+	inv := invite{Note: req.Note, Created: now.Unix(), Expires: now.Add(ttl).Unix()}
+	if err := metadata.putInvite(code, inv); err != nil {
+		log.Printf("Error saving invite: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create invite",
+			"success": false,
+		})
+	}
+	id := secretID(secretKey(code))
+	audit(c, "invite_create", id)
+
+	return c.Status(201).JSON(fiber.Map{
+		"success":    true,
+		"id":         id,
+		"code":       code,
+		"expires_at": time.Unix(inv.Expires, 0).UTC().Format(time.RFC3339),
+	})
+}
+
+// listInvites lists the invites still to be used, soonest to expire
+// first: GET /api/admin/invites
+func listInvites(c *fiber.Ctx) error {
+	invites, err := metadata.invites()
+	if err != nil {
+		log.Printf("Error reading invites: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read invites",
+			"success": false,
+		})
+	}
+	list := make([]fiber.Map, 0, len(invites))
+	for id, inv := range invites {
+		list = append(list, fiber.Map{
+			"id":         id,
+			"note":       inv.Note,
+			"created_at": time.Unix(inv.Created, 0).UTC().Format(time.RFC3339),
+			"expires_at": time.Unix(inv.Expires, 0).UTC().Format(time.RFC3339),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["expires_at"].(string) < list[j]["expires_at"].(string)
+	})
+	return c.JSON(fiber.Map{
+		"success": true,
+		"invites": list,
+	})
+}
+
+// deleteInvite revokes an invite: DELETE /api/admin/invites/:id
+func deleteInvite(c *fiber.Ctx) error {
+	found, err := metadata.revokeInvite(c.Params("id"))
+	if err != nil {
+		log.Printf("Error revoking invite %s: %v", c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to revoke invite",
+			"success": false,
+		})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Invite not found",
+			"success": false,
+		})
+	}
+	audit(c, "invite_revoke", c.Params("id"))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      c.Params("id"),
+	})
+}
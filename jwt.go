@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Access tokens are JWTs signed with HMAC-SHA256 (RFC 7519, "HS256"),
+// carrying the user's ID as the subject and expiring after tokenTTL
+
+// tokenIssuer is the iss claim of every token
+const tokenIssuer = "afrobase"
+
+// tokenKey signs access tokens. It comes from AFROBASE_JWT_SECRET, or is
+// random, in which case everyone is logged out when the server restarts.
+var tokenKey []byte
+
+// tokenTTL is how long access tokens last (AFROBASE_JWT_TTL, default 24h)
+var tokenTTL = 24 * time.Hour
+
+// loadTokenKey sets tokenKey and tokenTTL from the environment
+func loadTokenKey() error {
+	if v := os.Getenv("AFROBASE_JWT_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return errors.New("invalid AFROBASE_JWT_TTL " + v)
+		}
+		tokenTTL = d
+	}
+	if v := os.Getenv("AFROBASE_JWT_SECRET"); v != "" {
+		if len(v) < 32 {
+			return errors.New("AFROBASE_JWT_SECRET is shorter than 32 characters")
+		}
+		tokenKey = []byte(v)
+		return nil
+	}
+	tokenKey = make([]byte, 32)
+	if _, err := rand.Read(tokenKey); err != nil {
+		return err
+	}
+	log.Printf("No AFROBASE_JWT_SECRET set, logins last until the server restarts")
+	return nil
+}
+
+// tokenClaims are the claims of an access token
+type tokenClaims struct {
+	Subject  string `json:"sub"`
+	Username string `json:"name"`
+	Issuer   string `json:"iss"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+// tokenHeader is the only header tokens are issued or accepted with, so a
+// token can't choose its own algorithm
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// issueToken returns an access token for u and when it expires
+func issueToken(u user) (string, time.Time, error) {
+	now := serverClock.Now()
+	exp := now.Add(tokenTTL)
+	claims, err := json.Marshal(tokenClaims{
+		Subject:  u.ID,
+		Username: u.Username,
+		Issuer:   tokenIssuer,
+		IssuedAt: now.Unix(),
+		Expires:  exp.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + signToken(signed), exp, nil
+}
+
+func signToken(signed string) string {
+	mac := hmac.New(sha256.New, tokenKey)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseToken checks an access token's signature and lifetime and returns
+// its claims
+func parseToken(token string) (tokenClaims, error) {
+	var claims tokenClaims
+	header, rest, ok := strings.Cut(token, ".")
+	if !ok || header != tokenHeader {
+		return claims, errInvalidToken
+	}
+	payload, sig, ok := strings.Cut(rest, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signToken(header+"."+payload))) {
+		return claims, errInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(data, &claims) != nil {
+		return claims, errInvalidToken
+	}
+	if claims.Issuer != tokenIssuer || claims.Subject == "" || serverClock.Now().Unix() >= claims.Expires {
+		return claims, errInvalidToken
+	}
+	return claims, nil
+}
+
+// isToken reports whether a bearer credential is one of our JWTs rather
+// than an API key, which may have dots of its own. Only tokens starting
+// with tokenHeader are ever issued.
+func isToken(credential string) bool {
+	return strings.HasPrefix(credential, tokenHeader+".") && strings.Count(credential, ".") == 2
+}
+
+// authenticateUser identifies the user a request is made by from an
+// "Authorization: Bearer <token>" header, refusing invalid or expired
+// tokens, and those of deleted accounts, with 401. Requests without a
+// token carry on anonymously, or with an API key.
+func authenticateUser(c *fiber.Ctx) error {
+	credential, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || !isToken(credential) {
+		return c.Next()
+	}
+	claims, err := parseToken(credential)
+	var u user
+	if err == nil {
+		if u, ok = metadata.user(claims.Subject); !ok {
+			err = errInvalidToken
+		}
+	}
+	if err != nil {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return c.Status(401).JSON(fiber.Map{
+			"error":   "Invalid or expired token",
+			"success": false,
+		})
+	}
+	c.Locals("user", u)
+	return c.Next()
+}
+
+// requestUser is the user a request was made by, if it carried a token
+func requestUser(c *fiber.Ctx) (user, bool) {
+	u, ok := c.Locals("user").(user)
+	return u, ok
+}
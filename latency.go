@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// slowRequestThreshold is how long a request may take before it is logged
+// with its step timings, set via AFROBASE_SLOW_REQUEST (a duration, "0"
+// disables it)
+var slowRequestThreshold = loadSlowRequestThreshold()
+
+func loadSlowRequestThreshold() time.Duration {
+	v := os.Getenv("AFROBASE_SLOW_REQUEST")
+	if v == "" {
+		return 2 * time.Second
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatal("Invalid AFROBASE_SLOW_REQUEST: ", v)
+	}
+	return d
+}
+
+// latencyBuckets are the histogram bucket upper bounds in milliseconds
+var latencyBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// latencyHistogram counts request durations per bucket; the final count is
+// for requests slower than the largest bucket. sum is in milliseconds.
+type latencyHistogram struct {
+	counts []uint64
+	total  uint64
+	sum    float64
+}
+
+// quantile estimates the q-th quantile as the upper bound of the bucket it
+// falls in
+func (h *latencyHistogram) quantile(q float64) float64 {
+	rank := uint64(q * float64(h.total))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen > rank && i < len(latencyBuckets) {
+			return latencyBuckets[i]
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+var (
+	latencyMu sync.Mutex
+	latencies = make(map[string]*latencyHistogram)
+)
+
+func observeLatency(route string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	bucket := sort.SearchFloat64s(latencyBuckets, ms)
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	h, ok := latencies[route]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		latencies[route] = h
+	}
+	h.counts[bucket]++
+	h.total++
+	h.sum += ms
+}
+
+// latencySnapshot reports p50/p95/p99 in milliseconds per route
+func latencySnapshot() map[string]fiber.Map {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	out := make(map[string]fiber.Map, len(latencies))
+	for route, h := range latencies {
+		out[route] = fiber.Map{
+			"count": h.total,
+			"p50":   h.quantile(0.50),
+			"p95":   h.quantile(0.95),
+			"p99":   h.quantile(0.99),
+		}
+	}
+	return out
+}
+
+// requestTimings collects how long each step of a request took
+type requestTimings struct {
+	start, last time.Time
+	steps       []string
+}
+
+// markStep records the time since the previous step (or the start of the
+// request) under name, for slow-request logs
+func markStep(c *fiber.Ctx, name string) {
+	t, ok := c.Locals("timings").(*requestTimings)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	t.steps = append(t.steps, fmt.Sprintf("%s=%v", name, now.Sub(t.last).Round(time.Microsecond)))
+	t.last = now
+}
+
+// trackLatency times every request into the per-route histograms and logs
+// those slower than slowRequestThreshold
+func trackLatency(c *fiber.Ctx) error {
+	now := time.Now()
+	timings := &requestTimings{start: now, last: now}
+	c.Locals("timings", timings)
+
+	err := c.Next()
+
+	elapsed := time.Since(timings.start)
+	route := c.Method() + " " + c.Route().Path
+	observeLatency(route, elapsed)
+	status := c.Response().StatusCode()
+	if err != nil {
+		// Not yet turned into a response by errorEnvelope
+		status = 500
+		var e *fiber.Error
+		if errors.As(err, &e) {
+			status = e.Code
+		}
+	}
+	if status >= 400 {
+		countRequestError(route, status)
+	}
+
+	if slowRequestThreshold > 0 && elapsed >= slowRequestThreshold {
+		steps := "none recorded"
+		if len(timings.steps) > 0 {
+			steps = strings.Join(timings.steps, " ")
+		}
+		log.Printf("Slow request: %s %s took %v (route %s, %d bytes in, %d bytes out), steps: %s",
+			c.Method(), c.OriginalURL(), elapsed.Round(time.Millisecond), route,
+			requestBodySize(c), len(c.Response().Body()), steps)
+	}
+	return err
+}
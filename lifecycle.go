@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// runCheckLifecycle implements `check-lifecycle`, taking one image through
+// its whole life on a running server: upload, variant generation, reading
+// it and its variants back, looking up its record and deleting it, then
+// checking that nothing of it is left. It exits with an error at the first
+// step that fails, so storage changes can be gated on it against real
+// backends, for example MinIO (with its bucket created) started for the
+// run:
+//
+//	docker run -d -p 9000:9000 -e MINIO_ROOT_USER=afrobase -e MINIO_ROOT_PASSWORD=afrobase123 minio/minio server /data
+//	AFROBASE_STORAGE=s3 AFROBASE_S3_ENDPOINT=http://127.0.0.1:9000 AFROBASE_S3_BUCKET=afrobase \
+//	  AFROBASE_S3_ACCESS_KEY=afrobase AFROBASE_S3_SECRET_KEY=afrobase123 afrobase &
+//	afrobase check-lifecycle
+//
+// Metadata is kept in the embedded database, so there is no database
+// server to start alongside. go test runs the same check against memory
+// storage.
+func runCheckLifecycle(args []string) {
+	flags := flag.NewFlagSet("check-lifecycle", flag.ExitOnError)
+	target := flags.String("url", "http://127.0.0.1:5174", "server to check")
+	key := flags.String("key", "", "API key for uploads and deletes, if the server requires one")
+	timeout := flags.Duration("timeout", 2*time.Minute, "how long to wait for variants")
+	flags.Parse(args)
+
+	s := &soaker{
+		client: &http.Client{Timeout: time.Minute},
+		target: strings.TrimSuffix(*target, "/"),
+		key:    *key,
+	}
+	if err := s.lifecycle(*timeout); err != nil {
+		log.Fatal("Lifecycle check failed: ", err)
+	}
+	log.Printf("Lifecycle check passed against %s", s.target)
+}
+
+// lifecycle runs the steps of check-lifecycle
+func (s *soaker) lifecycle(timeout time.Duration) error {
+	title := fmt.Sprintf("Lifecycle %d", time.Now().UnixNano())
+	data, _, err := encodeImage(gradientImage(640, 480, color.RGBA{200, 80, 20, 255}, color.RGBA{20, 80, 200, 255}, title), false, 0)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ImagePayload{Title: title, Image: base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return err
+	}
+	var uploaded struct {
+		URL string `json:"url"`
+	}
+	if err := s.do("POST", "/upload", body, &uploaded); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	name := path.Base(uploaded.URL)
+	id := strings.TrimSuffix(name, path.Ext(name))
+	log.Printf("Uploaded %s", id)
+
+	// Variants are generated in the background
+	var record struct {
+		Image struct {
+			URL           string            `json:"url"`
+			Variants      map[string]string `json:"variants"`
+			VariantsReady bool              `json:"variants_ready"`
+		} `json:"image"`
+	}
+	for deadline := time.Now().Add(timeout); ; time.Sleep(time.Second) {
+		if err := s.do("GET", "/api/images/"+id, nil, &record); err != nil {
+			return fmt.Errorf("reading record: %w", err)
+		}
+		if record.Image.VariantsReady {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("variants of %s not ready after %v", id, timeout)
+		}
+	}
+	files := []string{record.Image.URL}
+	for _, u := range record.Image.Variants {
+		files = append(files, u)
+	}
+	for i, u := range files {
+		p, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		files[i] = p.RequestURI()
+		if err := s.do("GET", files[i], nil, nil); err != nil {
+			return fmt.Errorf("reading file: %w", err)
+		}
+	}
+	log.Printf("Read %s and %d variants", id, len(record.Image.Variants))
+
+	// Looked up by ID, since listings are oldest first and a busy server's
+	// first page won't reach a new upload
+	var listed struct {
+		Image struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"image"`
+	}
+	if err := s.do("GET", "/api/images/"+id, nil, &listed); err != nil {
+		return fmt.Errorf("reading record: %w", err)
+	}
+	if listed.Image.ID != id || listed.Image.Title != title {
+		return fmt.Errorf("record of %s is %q titled %q", id, listed.Image.ID, listed.Image.Title)
+	}
+
+	if err := s.do("DELETE", "/api/images/"+id, nil, nil); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	for _, p := range append([]string{"/api/images/" + id}, files...) {
+		status, err := s.status(p)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusNotFound {
+			return fmt.Errorf("GET %s returned %d after delete, not 404", p, status)
+		}
+	}
+	log.Printf("Deleted %s and its files", id)
+	return nil
+}
+
+// status makes a GET request and returns only its status
+func (s *soaker) status(p string) (int, error) {
+	req, err := http.NewRequest("GET", s.target+p, nil)
+	if err != nil {
+		return 0, err
+	}
+	if s.key != "" {
+		req.Header.Set(apiKeyHeader, s.key)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
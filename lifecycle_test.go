@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"AfroBaseServer/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestLifecycle runs check-lifecycle against a server kept in memory
+func TestLifecycle(t *testing.T) {
+	sandbox = true
+	uploadStore = storage.NewMemory()
+	var err error
+	if metadata, err = openMemoryMetadataStore(); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err = openChangeJournal(); err != nil {
+		t.Fatal(err)
+	}
+	imageProcessor = newLimitedProcessor(newProcessor(""))
+	if err := startVariantWorker(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{
+		BodyLimit:                    uploadBodyLimit,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
+	app.Use(bufferBody)
+	app.Post("/upload", handleImageUpload)
+	app.Get("/api/images/:id", getImage)
+	app.Delete("/api/images/:id", deleteImage)
+	app.Get("/uploads/*", serveUpload)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	s := &soaker{
+		client: &http.Client{Timeout: time.Minute},
+		target: "http://" + ln.Addr().String(),
+	}
+	if err := s.lifecycle(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Listing representations, picked by the Accept header
+const (
+	mimeCSV    = "text/csv"
+	mimeNDJSON = "application/x-ndjson"
+)
+
+// sendListing writes records as CSV with the given columns or as NDJSON
+// when the client asks for them, and otherwise calls asJSON
+func sendListing(c *fiber.Ctx, records []map[string]interface{}, columns []string, asJSON func() error) error {
+	c.Vary(fiber.HeaderAccept)
+	switch listingFormat(c) {
+	case mimeCSV:
+		return sendCSV(c, records, columns)
+	case mimeNDJSON:
+		c.Set(fiber.HeaderContentType, mimeNDJSON)
+		w := bufio.NewWriter(c)
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+	return asJSON()
+}
+
+// listingFormat is the representation of a listing the client accepts
+func listingFormat(c *fiber.Ctx) string {
+	return c.Accepts(fiber.MIMEApplicationJSON, mimeCSV, mimeNDJSON)
+}
+
+// sendCSV writes records as CSV with a header row of columns
+func sendCSV(c *fiber.Ctx, records []map[string]interface{}, columns []string) error {
+	c.Set(fiber.HeaderContentType, mimeCSV+"; charset=utf-8")
+	w := csv.NewWriter(c)
+	w.Write(columns)
+	row := make([]string, len(columns))
+	for _, record := range records {
+		for i, column := range columns {
+			row[i] = csvCell(record[column])
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvCell formats a value for a CSV cell. Text that a spreadsheet would
+// run as a formula is prefixed with a quote, since titles and user agents
+// come from clients.
+func csvCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case []string:
+		return csvCell(strings.Join(v, ","))
+	}
+	return fmt.Sprint(v)
+}
+
+// csvText reverses csvCell's formula quoting for text read back in
+func csvText(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// openLogFile opens one of the server's log files with rotation. Each log
+// (ACCESS, APP or AUDIT) is configured on its own:
+//
+//	AFROBASE_<LOG>_LOG               file path; unset keeps the log off (or on stderr for APP)
+//	AFROBASE_<LOG>_LOG_MAX_SIZE_MB   rotate when the file reaches this size (default 100)
+//	AFROBASE_<LOG>_LOG_ROTATE        also rotate "hourly" or "daily"
+//	AFROBASE_<LOG>_LOG_MAX_BACKUPS   rotated files to keep (default 10, 0 keeps all)
+//	AFROBASE_<LOG>_LOG_MAX_AGE_DAYS  delete rotated files older than this (default 0, never)
+//	AFROBASE_<LOG>_LOG_COMPRESS      gzip rotated files (default on)
+//
+// It returns nil if the log has no path, and always in the sandbox, which
+// writes no files.
+func openLogFile(name string) (io.Writer, error) {
+	prefix := "AFROBASE_" + name + "_LOG"
+	path := os.Getenv(prefix)
+	if path == "" || sandbox {
+		return nil, nil
+	}
+
+	out := &lumberjack.Logger{
+		Filename: path,
+		Compress: os.Getenv(prefix+"_COMPRESS") != "off",
+	}
+	var err error
+	if out.MaxSize, err = logSetting(prefix+"_MAX_SIZE_MB", 100); err != nil {
+		return nil, err
+	}
+	if out.MaxBackups, err = logSetting(prefix+"_MAX_BACKUPS", 10); err != nil {
+		return nil, err
+	}
+	if out.MaxAge, err = logSetting(prefix+"_MAX_AGE_DAYS", 0); err != nil {
+		return nil, err
+	}
+
+	switch every := os.Getenv(prefix + "_ROTATE"); every {
+	case "":
+	case "hourly":
+		go rotateEvery(out, time.Hour)
+	case "daily":
+		go rotateEvery(out, 24*time.Hour)
+	default:
+		return nil, fmt.Errorf("%s_ROTATE must be hourly or daily, not %q", prefix, every)
+	}
+	return out, nil
+}
+
+func logSetting(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, v)
+	}
+	return n, nil
+}
+
+// rotateEvery rotates a log at each local hour or midnight boundary
+func rotateEvery(out *lumberjack.Logger, period time.Duration) {
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Hour).Add(time.Hour)
+		if period == 24*time.Hour {
+			next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		}
+		time.Sleep(time.Until(next))
+		if err := out.Rotate(); err != nil {
+			log.Printf("Error rotating %s: %v", out.Filename, err)
+		}
+	}
+}
+
+// auditLog records who changed the library, as one JSON object per line
+var auditLog = log.New(io.Discard, "", 0)
+
+// auditLogPath is the audit log's file, if it is written to one
+var auditLogPath string
+
+// auditColumns are the fields of an audit entry
+var auditColumns = []string{"at", "action", "name", "ip", "agent"}
+
+// audit appends an entry to the audit log for a request that changed an
+// upload
+func audit(c *fiber.Ctx, action, name string) {
+	entry := fiber.Map{
+		"at":     serverClock.Now().UTC().Format(time.RFC3339),
+		"action": action,
+		"name":   name,
+		"ip":     c.IP(),
+		"agent":  c.Get("User-Agent"),
+	}
+	if key := apiKeyName(c); key != "" {
+		entry["key"] = key
+	}
+	if u, ok := requestUser(c); ok {
+		entry["user"] = u.Username
+	}
+	line, _ := json.Marshal(entry)
+	auditLog.Print(string(line))
+}
+
+// getAuditLog lists the most recent audit entries, oldest first, from the
+// current audit log file (rotated files are not read):
+// GET /api/admin/audit?limit=1000
+func getAuditLog(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 1000)
+	if limit < 1 {
+		limit = 1000
+	}
+
+	entries := []map[string]interface{}{}
+	if auditLogPath != "" {
+		f, err := os.Open(auditLogPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error reading audit log: %v", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to read audit log",
+				"success": false,
+			})
+		}
+		if err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var entry map[string]interface{}
+				if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	return sendListing(c, entries, auditColumns, func() error {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"count":   len(entries),
+			"entries": entries,
+		})
+	})
+}
@@ -1,71 +1,503 @@
 package main
 
 import (
-	"encoding/base64"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"image"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"AfroBaseServer/storage"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"golang.org/x/text/unicode/norm"
 )
 
 type ImagePayload struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Image       string `json:"image"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Filename    string   `json:"filename"`
+	Image       string   `json:"image"`
+	Mode        string   `json:"mode"`
+	Tags        []string `json:"tags"`
 }
 
 func main() {
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "export-site" {
+		runExportSite(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		runSeed(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check-storage" {
+		runCheckStorage(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		runSoak(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check-lifecycle" {
+		runCheckLifecycle(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay-events" {
+		runReplayEvents(os.Args[2:])
+		return
+	}
+
+	// Where to listen, and the URL clients see
+	listen, err := parseListenConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid server configuration: ", err)
+	}
+	publicBaseURL = listen.BaseURL
+	sandbox = listen.Sandbox
+	if !listen.FrozenClock.IsZero() {
+		serverClock = frozenClock(listen.FrozenClock)
+		log.Printf("Clock frozen at %s", listen.FrozenClock.Format(time.RFC3339))
+	}
+	if sandbox {
+		ids = &sequentialIDs{prefix: "afrobase-sandbox"}
+		stagedData = &stagingMemory{}
+		log.Printf("Sandbox mode: everything is kept in memory and lost on exit")
+	}
+
+	// Send logs to rotated files if configured
+	appLog, err := openLogFile("APP")
+	if err != nil {
+		log.Fatal("Invalid app log configuration:", err)
+	}
+	if appLog != nil {
+		log.SetOutput(io.MultiWriter(os.Stderr, appLog))
+	}
+	auditOut, err := openLogFile("AUDIT")
+	if err != nil {
+		log.Fatal("Invalid audit log configuration:", err)
+	}
+	if auditOut != nil {
+		auditLog.SetOutput(auditOut)
+		auditLogPath = os.Getenv("AFROBASE_AUDIT_LOG")
+	}
+	accessOut, err := openLogFile("ACCESS")
+	if err != nil {
+		log.Fatal("Invalid access log configuration:", err)
+	}
+
 	// Create Fiber instance
 	app := fiber.New(fiber.Config{
-		BodyLimit: 50 * 1024 * 1024, // 50MB limit for large images
+		BodyLimit:    uploadBodyLimit,
+		ErrorHandler: errorEnvelope,
+		// Bodies are read by bufferBody or readBody, or streamed by the
+		// upload handler
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
+	app.Server().HeaderReceived = bodyLimitFor
 
-	// Middleware
+	// Middleware. Panics are recovered inside the access log, so they are
+	// logged as the 500 the client saw, and outside error reporting, which
+	// sees them first.
 	app.Use(logger.New())
+	app.Use(requestid.New())
+	if accessOut != nil {
+		accessLog, err := newAccessLog(accessOut, os.Getenv("AFROBASE_ACCESS_LOG_FORMAT"))
+		if err != nil {
+			log.Fatal("Invalid access log configuration:", err)
+		}
+		app.Use(accessLog)
+	}
+	app.Use(recoverPanics)
+	if dsn := os.Getenv("AFROBASE_SENTRY_DSN"); dsn != "" {
+		if err := initErrorReporting(dsn); err != nil {
+			log.Fatal("Failed to set up error reporting:", err)
+		}
+		app.Use(reportErrors)
+	}
+	app.Use(trackLatency)
+	app.Use(bufferBody)
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
+		AllowOrigins:  "*",
+		AllowMethods:  "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
+		AllowHeaders:  "Origin,Content-Type,Accept,Authorization," + apiKeyHeader + "," + uploadDeadlineHeader + "," + tusRequestHeaders,
+		ExposeHeaders: tusResponseHeaders,
 	}))
 
-	// Create uploads directory if it doesn't exist
-	uploadsDir := "./uploads"
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		log.Fatal("Failed to create uploads directory:", err)
+	// Inject faults for resilience testing, never in production
+	if v := os.Getenv("AFROBASE_CHAOS"); v != "" {
+		chaos, err = parseChaos(v)
+		if err != nil {
+			log.Fatal("Invalid AFROBASE_CHAOS: ", err)
+		}
+		app.Use(injectChaos)
+		log.Printf("Chaos mode: %s", chaos)
+	}
+
+	// Sign share links to private images
+	if err := loadSigningKey(); err != nil {
+		log.Fatal("Failed to generate signing key:", err)
+	}
+
+	// Identify users by their access tokens
+	if err := loadTokenKey(); err != nil {
+		log.Fatal("Invalid token configuration: ", err)
+	}
+	app.Use(authenticateUser)
+	app.Use(acceptUploadLinks)
+	if err := loadOAuthProviders(); err != nil {
+		log.Fatal("Invalid OAuth configuration: ", err)
+	}
+
+	// Writes need an API key once any are configured
+	keys, err := loadAPIKeys()
+	if err != nil {
+		log.Fatal("Invalid API key configuration: ", err)
+	}
+	if keys == nil && requireReadKey {
+		log.Fatal("AFROBASE_REQUIRE_READ_KEY requires API keys")
+	}
+	if keys != nil {
+		app.Use(requireAPIKey(keys))
+		log.Printf("Loaded %d API key(s)", len(keys))
+	} else {
+		log.Printf("No API keys configured, uploads are open to anyone")
+	}
+	if err := setRegistration(keys != nil); err != nil {
+		log.Fatal("Invalid AFROBASE_REGISTRATION: ", err)
+	}
+	if !registrationOpen {
+		log.Printf("Registration is closed")
+	}
+
+	// Open the upload storage backend
+	store, err := openUploadStore()
+	if err != nil {
+		log.Fatal("Failed to open upload storage:", err)
+	}
+	uploadStore = store
+	log.Printf("Storing uploads in %s", uploadStore.Name())
+	if local, ok := uploadStore.(*storage.Local); ok {
+		checkCaseSensitivity(local.Root())
+	}
+	if _, ok := uploadStore.(*storage.Memory); ok && !sandbox {
+		log.Printf("Uploads are kept in memory and lost when the server stops")
+	}
+
+	// Keep titles, descriptions and original filenames
+	if sandbox {
+		metadata, err = openMemoryMetadataStore()
+	} else {
+		metadata, err = openMetadataStore(false)
+	}
+	if err != nil {
+		log.Fatal("Failed to open metadata store:", err)
+	}
+	if eventSourcing {
+		if err := metadata.startEventSourcing(); err != nil {
+			log.Fatal("Failed to start event log:", err)
+		}
+	}
+	if sandbox {
+		if err := seedSandbox(); err != nil {
+			log.Fatal("Failed to seed sandbox:", err)
+		}
+	}
+
+	// Journal changes for incremental sync clients
+	journal, err := openChangeJournal()
+	if err != nil {
+		log.Fatal("Failed to open change journal:", err)
+	}
+	changes = journal
+
+	// Keep rejected uploads across restarts if asked to
+	if os.Getenv("AFROBASE_PERSIST_UPLOAD_FAILURES") == "on" && !sandbox {
+		failures, err := openFailureLog()
+		if err != nil {
+			log.Fatal("Failed to open upload failure log:", err)
+		}
+		uploadFailures = failures
+	}
+
+	// Pick the image processing backend
+	imageProcessor = newLimitedProcessor(newProcessor(os.Getenv("AFROBASE_PROCESSOR")))
+	log.Printf("Using %s image processor", imageProcessor.Name())
+	loadRenditionFormats()
+
+	// Load named upload profiles
+	if path := os.Getenv("AFROBASE_PROFILES"); path != "" {
+		profiles, err := loadUploadProfiles(path)
+		if err != nil {
+			log.Fatal("Failed to load upload profiles:", err)
+		}
+		uploadProfiles = profiles
+		log.Printf("Loaded %d upload profile(s)", len(profiles))
+	}
+
+	// Hold heavy processing for off-peak hours if configured
+	if v := os.Getenv("AFROBASE_PROCESSING_WINDOW"); v != "" {
+		w, err := parseTimeWindow(v)
+		if err != nil {
+			log.Fatal("Invalid AFROBASE_PROCESSING_WINDOW:", err)
+		}
+		processingWindow = w
+	}
+
+	// Two-phase uploads wait this long for their bytes and commit
+	if v := os.Getenv("AFROBASE_UPLOAD_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("Invalid AFROBASE_UPLOAD_TTL: ", v)
+		}
+		uploadTTL = d
+	}
+	if err := startStaging(); err != nil {
+		log.Fatal("Failed to prepare upload staging directory:", err)
+	}
+
+	// Thumbnail sizes generated for uploads made without a profile
+	if v := os.Getenv("AFROBASE_THUMBNAIL_SIZES"); v != "" {
+		sizes, err := parseVariantSizes(v)
+		if err != nil {
+			log.Fatal("Invalid AFROBASE_THUMBNAIL_SIZES: ", err)
+		}
+		variantSizes = sizes
+	}
+
+	// Cache on-demand resizes up to a size limit
+	if v := os.Getenv("AFROBASE_RESIZE_CACHE_MB"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 1 {
+			log.Fatal("Invalid AFROBASE_RESIZE_CACHE_MB: ", v)
+		}
+		resizeCacheLimit = int64(mb) << 20
+	}
+	// Per-IP upload limits, enforced with 429 and Retry-After
+	var uploadsPerMinute, uploadMBPerHour int
+	if v := os.Getenv("AFROBASE_UPLOAD_RATE_PER_MINUTE"); v != "" {
+		uploadsPerMinute, err = strconv.Atoi(v)
+		if err != nil || uploadsPerMinute < 1 {
+			log.Fatal("Invalid AFROBASE_UPLOAD_RATE_PER_MINUTE: ", v)
+		}
+	}
+	if v := os.Getenv("AFROBASE_UPLOAD_MB_PER_HOUR"); v != "" {
+		uploadMBPerHour, err = strconv.Atoi(v)
+		if err != nil || uploadMBPerHour < 1 {
+			log.Fatal("Invalid AFROBASE_UPLOAD_MB_PER_HOUR: ", v)
+		}
+	}
+	if uploadsPerMinute > 0 || uploadMBPerHour > 0 {
+		uploadLimits = newUploadLimiter(float64(uploadsPerMinute), float64(int64(uploadMBPerHour)<<20))
+	}
+	// Per-IP login and registration attempts
+	authPerMinute := defaultAuthRatePerMinute
+	if v := os.Getenv("AFROBASE_AUTH_RATE_PER_MINUTE"); v != "" {
+		authPerMinute, err = strconv.Atoi(v)
+		if err != nil || authPerMinute < 1 {
+			log.Fatal("Invalid AFROBASE_AUTH_RATE_PER_MINUTE: ", v)
+		}
+	}
+	authLimits = newUploadLimiter(float64(authPerMinute), 0)
+
+	if !sandbox {
+		resized, err = openResizeCache(resizeCacheLimit)
+		if err != nil {
+			log.Fatal("Failed to open resize cache:", err)
+		}
+	}
+
+	// Mirror uploads to a secondary directory if configured. Deletions are
+	// recorded in the metadata store, and reconciliation covers every
+	// profile's variants, so this waits for both.
+	if mirrorDir := os.Getenv("AFROBASE_MIRROR_DIR"); mirrorDir != "" {
+		interval := 5 * time.Minute
+		if v := os.Getenv("AFROBASE_MIRROR_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				log.Fatal("Invalid AFROBASE_MIRROR_INTERVAL:", err)
+			}
+			interval = d
+		}
+		secondary, err := storage.NewLocal(mirrorDir)
+		if err != nil {
+			log.Fatal("Failed to create mirror directory:", err)
+		}
+		checkCaseSensitivity(mirrorDir)
+		uploadMirror = newMirror(uploadStore, secondary)
+		uploadMirror.start(interval)
+	}
+
+	// Generate resized variants of uploads in the background
+	if err := startVariantWorker(); err != nil {
+		log.Fatal("Failed to start variant worker:", err)
+	}
+
+	// Injected storage failures start once startup has read the store
+	if chaos.Storage > 0 {
+		uploadStore = chaosStorage{Storage: uploadStore, rate: chaos.Storage}
+	}
+
+	// Debug endpoints on a separate, token-protected listener
+	if addr := os.Getenv("AFROBASE_ADMIN_ADDR"); addr != "" {
+		token := os.Getenv("AFROBASE_ADMIN_TOKEN")
+		if token == "" {
+			log.Fatal("AFROBASE_ADMIN_ADDR requires AFROBASE_ADMIN_TOKEN")
+		}
+		startAdminListener(addr, token)
 	}
 
-	// Upload endpoint
-	app.Post("/upload", handleImageUpload)
+	// Upload endpoints, the second fetching the image from another host
+	app.Post("/upload", limitUploads, handleImageUpload)
+	app.Post("/upload/url", limitUploads, handleURLUpload)
+
+	// Two-phase uploads: reserve, PUT the bytes, then commit with metadata
+	app.Post("/api/uploads", limitUploads, reserveUpload)
+	app.Put("/api/uploads/:id", limitUploads, readBody, putUploadBytes)
+	app.Post("/api/uploads/:id/commit", commitUpload)
+
+	// Resumable uploads over the tus protocol
+	app.Options("/api/tus", getTusOptions)
+	app.Post("/api/tus", limitUploads, createTusUpload)
+	app.Head("/api/tus/:id", headTusUpload)
+	app.Patch("/api/tus/:id", limitUploads, readBody, patchTusUpload)
+	app.Delete("/api/tus/:id", deleteTusUpload)
+
+	// Visual diff of two uploads
+	app.Post("/api/compare", handleCompare)
 
 	// Health check endpoint
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"message": "Fiber Image Server is running",
-			"port":    "5175",
+			"port":    strconv.Itoa(listen.Port),
 		})
 	})
 
+	// Shareable landing page for a single image
+	app.Get("/i/:id/page", getImagePage)
+
+	// Event kiosk upload page and the slideshow of its approved photos
+	app.Get("/events/:token", getKiosk)
+	app.Get("/events/:token/slideshow", getEventSlideshow)
+
+	// Embeddable gallery widget
+	app.Get("/embed.js", getEmbedScript)
+	app.Get("/embed/gallery", getEmbedGallery)
+	app.Get("/widget/upload.js", getUploadWidget)
+
+	// Generated placeholder images
+	app.Get("/placeholder/:size", getPlaceholder)
+
+	// Search engine hints
+	app.Get("/robots.txt", getRobots)
+	app.Get("/sitemap.xml", getSitemap)
+
+	// User accounts
+	app.Post("/api/auth/register", limitAuth, register)
+	app.Post("/api/auth/login", limitAuth, login)
+	app.Get("/api/auth/me", getCurrentUser)
+	app.Patch("/api/auth/me", updateCurrentUser)
+	app.Get("/api/auth/providers", listOAuthProviders)
+	app.Get("/api/auth/oauth/:provider", startOAuth)
+	app.Get("/api/auth/oauth/:provider/callback", finishOAuth)
+
 	// API endpoint to get image list
 	app.Get("/api/images", getImageList)
+	app.Get("/api/client.ts", getTypeScriptClient)
+	app.Get("/api/images/random", getRandomImage)
+	app.Get("/api/images/search", searchImages)
+	app.Get("/api/images/grouped", getGroupedImages)
+	app.Get("/api/tags", getTags)
+	app.Get("/api/contact-sheet", getContactSheet)
+	app.Get("/api/manifest", getManifest)
+	app.Get("/api/changes", getChanges)
+	app.Get("/api/uploads/failures", getUploadFailures)
+	app.Get("/api/uploads/:id", getUploadSession)
+	app.Get("/api/images/:id", getImage)
+	app.Get("/api/images/:id/snippets", getImageSnippets)
+	app.Get("/api/images/:id/qr", getImageQR)
+	app.Patch("/api/images/:id", updateImage)
+	app.Post("/api/images/:id/move", moveImage)
+	app.Post("/api/images/:id/share", shareImage)
+	app.Delete("/api/images/:id", deleteImage)
+
+	// Albums group images; deleting one leaves its images alone
+	app.Get("/api/albums", listAlbums)
+	app.Get("/api/tree", getTree)
+	app.Post("/api/albums", createAlbum)
+	app.Get("/api/albums/:id", getAlbum)
+	app.Patch("/api/albums/:id", updateAlbum)
+	app.Delete("/api/albums/:id", deleteAlbumHandler)
+	app.Post("/api/albums/:id/images", addAlbumImages)
+	app.Delete("/api/albums/:id/images/:image", removeAlbumImage)
+	app.Post("/api/albums/:id/publish", publishAlbum)
+	app.Post("/api/albums/:id/seal/break", breakAlbumSeal)
+	app.Post("/api/albums/:id/upload-links", createUploadLink)
+	app.Get("/api/albums/:id/upload-links", listUploadLinks)
+	app.Delete("/api/albums/:id/upload-links/:link", deleteUploadLink)
+	app.Get("/api/albums/:id/slideshow", getAlbumSlideshow)
+	app.Get("/api/albums/:id/pending", listPending)
+	app.Post("/api/albums/:id/pending/:image", approvePending)
+	app.Delete("/api/albums/:id/pending/:image", rejectPending)
+
+	// Serve uploads and their variants from storage
+	app.Get("/uploads/*", serveUpload)
+
+	// Resize uploads on demand
+	app.Get("/img/:name", getResizedImage)
 
-	// Serve static files from uploads directory
-	app.Static("/uploads", "./uploads")
+	// Serve originals by content hash, cacheable forever
+	app.Get("/blob/:hash", serveBlob)
 
 	// Start server
-	log.Println("Server starting on port 5175...")
-	log.Fatal(app.Listen(":5174"))
+	log.Printf("Server starting on %s (public URL %s)...", listen.Addr(), publicBaseURL)
+	serveUntilSignalled(app, listen.Addr())
 }
 
+// getImageList lists uploads oldest first, a page at a time:
+// GET /api/images?page=1&limit=50. CSV and NDJSON listings include every
+// image unless a page or limit is given. With ?album=<id> it lists only
+// that album's images, and with ?tag=<tag> only images with that tag.
+// ?sort=title orders them by title for the collation locale, or ?locale=.
+// Unlisted images are left out, except for those who may see private ones.
+// With an access token only the user's own images are listed, unless
+// ?scope=all. With ?as_of=<time> it lists the library as it was then
+// instead.
 func getImageList(c *fiber.Ctx) error {
-	// read all files in the uploads directory
-	files, err := ioutil.ReadDir("./uploads")
+	owner, err := listingOwner(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+	if v := c.Query("as_of"); v != "" {
+		if c.Query("album") != "" || c.Query("tag") != "" || c.Query("sort") != "" {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "album, tag and sort can't be combined with as_of",
+				"success": false,
+			})
+		}
+		return getImageListAsOf(c, v, owner)
+	}
+
+	// Only the listing is read up front; files are inspected for the page alone
+	objects, err := uploadStore.List(c.Context(), "")
 	if err != nil {
 		log.Printf("Error reading uploads directory: %v", err)
 		return c.Status(500).JSON(fiber.Map{
@@ -73,114 +505,524 @@ func getImageList(c *fiber.Ctx) error {
 			"success": false,
 		})
 	}
+	if id := c.Query("album"); id != "" {
+		a, ok := metadata.album(id)
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{
+				"error":   "Album not found",
+				"success": false,
+			})
+		}
+		objects = slices.DeleteFunc(objects, func(o storage.Object) bool { return !a.contains(o.Key) })
+	}
+	if owner != "" {
+		objects = ownedBy(objects, owner)
+	}
+	objects = slices.DeleteFunc(objects, func(o storage.Object) bool { return hiddenFrom(c, o.Key) })
+	if tag := strings.ToLower(strings.TrimSpace(c.Query("tag"))); tag != "" {
+		objects = slices.DeleteFunc(objects, func(o storage.Object) bool {
+			meta, _ := metadata.get(o.Key)
+			return !meta.hasTag(tag)
+		})
+	}
+	if err := sortByTitle(c, objects); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
 
-	// send images in uploads directory as JSON
-	var images []map[string]interface{} = make([]map[string]interface{}, 0, len(files))
-	for _, file := range files {
-		if !file.IsDir() {
-			// Get file info
-			fileInfo, err := os.Stat(filepath.Join("./uploads", file.Name()))
-			if err != nil {
-				log.Printf("Error getting file info: %v", err)
-				continue
-			}
+	p, ok := listingPage(c, len(objects))
+	if !ok {
+		return sendPageError(c)
+	}
+	objects = objects[p.start:p.end]
 
-			// Create image object
-			image := map[string]interface{}{
-				"name":        file.Name(),
-				"size":        fileInfo.Size(),
-				"upload_time": fileInfo.ModTime().Unix(),
-				"title":       strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())),
-				"description": "Uploaded image",
-				"url":         "http://localhost:5174/uploads/" + file.Name(),
-			}
-			images = append(images, image)
+	images := make([]map[string]interface{}, 0, len(objects))
+	for _, object := range objects {
+		images = append(images, imageRecord(object.Info()))
+	}
+	return sendImagePage(c, images, imageListColumns, p, nil)
+}
+
+// imagePage is the slice of a listing a request asked for
+type imagePage struct {
+	page, limit, total int
+	start, end         int
+}
+
+// listingPage works out which of total listing entries to return. CSV and
+// NDJSON get them all unless a page or limit is given.
+func listingPage(c *fiber.Ctx, total int) (imagePage, bool) {
+	p := imagePage{page: c.QueryInt("page", 1), limit: c.QueryInt("limit", defaultPageSize), total: total}
+	if p.page < 1 || p.limit < 1 || p.limit > maxPageSize {
+		return p, false
+	}
+	format := listingFormat(c)
+	p.end = total
+	if (format != mimeCSV && format != mimeNDJSON) || c.Query("page") != "" || c.Query("limit") != "" {
+		p.start = min((p.page-1)*p.limit, total)
+		p.end = min(p.start+p.limit, total)
+	}
+	return p, true
+}
+
+func sendPageError(c *fiber.Ctx) error {
+	return c.Status(400).JSON(fiber.Map{
+		"error":   fmt.Sprintf("page must be at least 1 and limit between 1 and %d", maxPageSize),
+		"success": false,
+	})
+}
+
+// sendImagePage returns a page of image records as JSON, or CSV or NDJSON
+// if asked for. extra adds fields to the JSON envelope.
+func sendImagePage(c *fiber.Ctx, images []map[string]interface{}, columns []string, p imagePage, extra fiber.Map) error {
+	c.Set("X-Total-Count", strconv.Itoa(p.total))
+	return sendListing(c, images, columns, func() error {
+		response := fiber.Map{
+			"success":     true,
+			"images":      images,
+			"page":        p.page,
+			"limit":       p.limit,
+			"total":       p.total,
+			"total_pages": (p.total + p.limit - 1) / p.limit,
 		}
+		for k, v := range extra {
+			response[k] = v
+		}
+		return c.JSON(response)
+	})
+}
+
+// Page sizes of the image listing
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// imageListColumns are the image fields included in CSV listings
+var imageListColumns = []string{
+	"id", "name", "size", "upload_time", "title", "slug", "description", "tags", "url", "thumbnail_url", "blob_url", "page_url",
+	"width", "height", "color_space", "bit_depth", "raw", "original_url",
+}
+
+// imageRecord builds the API representation of an uploaded file
+func imageRecord(fileInfo os.FileInfo) map[string]interface{} {
+	name := fileInfo.Name()
+
+	// Variants are generated asynchronously after upload
+	variants, variantsReady := variantURLs(name)
+	// The grid thumbnail is the smallest variant, or the image itself until
+	// one is ready
+	thumbnail := displayPath(name)
+	if path := thumbnailPath(name, variants); path != "" {
+		thumbnail = path
+	}
+	for size, path := range variants {
+		variants[size] = publicBaseURL + path
+	}
+
+	meta := imageInfo(name)
+	record := map[string]interface{}{
+		"id":             imageID(name),
+		"name":           name,
+		"size":           fileInfo.Size(),
+		"upload_time":    meta.UploadTime,
+		"title":          meta.Title,
+		"slug":           meta.Slug,
+		"description":    meta.Description,
+		"tags":           tagList(meta.Tags),
+		"url":            publicBaseURL + displayPath(name),
+		"thumbnail_url":  publicBaseURL + thumbnail,
+		"blob_url":       blobURL(fileInfo),
+		"page_url":       imagePageURL(name),
+		"variants":       variants,
+		"variants_ready": variantsReady,
+	}
+
+	if meta.Private {
+		record["private"] = true
+	}
+	if meta.Unlisted {
+		record["unlisted"] = true
+		record["views"] = metadata.views(name)
+	}
+	if meta.Pending {
+		record["pending"] = true
+	}
+	if meta.FocalPoint != nil {
+		record["focal_point"] = meta.FocalPoint
+	}
+	if meta.Owner != "" {
+		record["owner"] = meta.Owner
+	}
+
+	// RAW uploads are displayed from their preview but stay downloadable,
+	// as do originals in cold storage, displayed from a variant
+	if isRaw(name) {
+		record["raw"] = true
+		record["original_url"] = publicBaseURL + "/uploads/" + name
+	} else if originalsTiered {
+		record["original_url"] = publicBaseURL + "/uploads/" + name
 	}
 
-	// Return images as JSON
-	if len(images) == 0 {
-		return c.JSON([]map[string]interface{}{})
+	// Dimensions come from the image header; unreadable files just omit them
+	if header, err := imageHeader(name); err == nil {
+		record["width"] = header.Width
+		record["height"] = header.Height
+		record["color_space"] = header.ColorSpace
+		record["bit_depth"] = header.BitDepth
+		record["print_sizes"] = printSizes(header.Width, header.Height)
 	}
-	return c.JSON(images)
+	return record
 }
 
 func handleImageUpload(c *fiber.Ctx) error {
 	var payload ImagePayload
+	attempt := &uploadAttempt{}
 
-	// Parse JSON body
-	if err := c.BodyParser(&payload); err != nil {
-		log.Printf("Error parsing request body: %v", err)
-		return c.Status(400).JSON(fiber.Map{
-			"error":   "Invalid request body",
+	// Resolve the upload profile, if any
+	profileName := c.Query("profile")
+	attempt.profile = profileName
+	var profile *uploadProfile
+	if profileName != "" {
+		var ok bool
+		if profile, ok = uploadProfiles[profileName]; !ok {
+			return attempt.reject(c, 400, "unknown_profile", "Unknown upload profile: "+profileName)
+		}
+	}
+
+	// Stop work promptly if the client's deadline passes or the server shuts down
+	ctx, cancel, err := uploadContext(c)
+	if err != nil {
+		return attempt.reject(c, 400, "deadline_header", "Invalid "+uploadDeadlineHeader+" header")
+	}
+	defer cancel()
+
+	// Decode the image into a spool file as the body arrives
+	body, finish := uploadBodyStream(c)
+	defer finish()
+	spool, err := newSpoolFile()
+	if err != nil {
+		log.Printf("Error creating spool file: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save image",
 			"success": false,
 		})
 	}
+	defer spool.close()
+	if isMultipartUpload(c) {
+		attempt.declaredType, err = readMultipartUpload(ctx, c, body, spool, &payload)
+	} else {
+		attempt.declaredType, err = readJSONUpload(ctx, body, spool, &payload)
+	}
+	if err != nil {
+		return rejectUploadBody(c, ctx, attempt, err)
+	}
+
+	// Chunked bodies have no length to charge up front
+	if uploadLimits != nil && c.Request().Header.ContentLength() < 0 {
+		if wait := uploadLimits.allow(c.IP(), 0, float64(body.read), serverClock.Now()); wait != 0 {
+			return rateLimited(c, wait)
+		}
+	}
 
 	// Validate payload
-	if payload.Image == "" {
-		return c.Status(400).JSON(fiber.Map{
-			"error":   "Image data is required",
+	imageData, err := spool.data()
+	if err != nil {
+		log.Printf("Error mapping spool file: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save image",
 			"success": false,
 		})
 	}
+	if len(imageData) == 0 {
+		return attempt.reject(c, 400, "missing_image", "Image data is required")
+	}
+	attempt.data = imageData
+
+	markStep(c, "decode")
+
+	return storeUpload(c, ctx, attempt, payload, profile, imageData)
+}
 
-	// Decode base64 image
-	imageData, err := base64.StdEncoding.DecodeString(payload.Image)
+// storeUpload validates decoded image bytes against the format policy and
+// profile, then saves them with their metadata and responds
+func storeUpload(c *fiber.Ctx, ctx context.Context, attempt *uploadAttempt, payload ImagePayload, profile *uploadProfile, imageData []byte) error {
+	profileName := attempt.profile
+	declaredType := attempt.declaredType
+	var err error
+
+	tags, err := normalizeTags(payload.Tags)
 	if err != nil {
-		log.Printf("Error decoding base64 image: %v", err)
-		return c.Status(400).JSON(fiber.Map{
-			"error":   "Invalid base64 image data",
-			"success": false,
-		})
+		return attempt.reject(c, 400, "tags", "Invalid tags: "+err.Error())
 	}
 
-	// Detect image format from first few bytes
-	var fileExt string
-	if len(imageData) >= 4 {
-		switch {
-		case imageData[0] == 0xFF && imageData[1] == 0xD8:
-			fileExt = ".jpg"
-		case imageData[0] == 0x89 && imageData[1] == 0x50 && imageData[2] == 0x4E && imageData[3] == 0x47:
-			fileExt = ".png"
-		case imageData[0] == 0x47 && imageData[1] == 0x49 && imageData[2] == 0x46:
-			fileExt = ".gif"
-		case imageData[0] == 0x52 && imageData[1] == 0x49 && imageData[2] == 0x46 && imageData[3] == 0x46:
-			fileExt = ".webp"
-		default:
-			fileExt = ".jpg" // Default fallback
+	// RAW files are stored as uploaded; everything that looks at pixels
+	// uses the JPEG preview embedded in them instead
+	rawExt := rawFormat(imageData)
+	display := imageData
+	if rawExt != "" {
+		if !acceptRaw {
+			return attempt.reject(c, 415, "raw_disabled", "RAW uploads are not enabled")
 		}
-	} else {
-		fileExt = ".jpg"
+		if display, err = extractRawPreview(imageData); err != nil {
+			log.Printf("Error extracting RAW preview: %v", err)
+			return attempt.reject(c, 422, "raw_preview", "RAW file has no usable embedded preview")
+		}
+	}
+
+	// Only accept formats the policy allows
+	if err := checkFormatPolicy(imageData, profile); err != nil {
+		return attempt.rejectRule(c, 415, "formats", "", err)
+	}
+
+	// Reject images too large to decode safely
+	if err := checkPixelBudget(display); err != nil {
+		log.Printf("Rejected oversized image: %v", err)
+		return attempt.reject(c, 413, "pixel_budget", "Image dimensions exceed the allowed pixel budget")
+	}
+
+	// Screenshot mode trims letterboxing and window padding before storing
+	if payload.Mode == "" {
+		payload.Mode = c.Query("mode")
+	}
+	screenshot := payload.Mode == "screenshot"
+	var trimmed image.Rectangle
+	if screenshot && rawExt != "" {
+		return attempt.reject(c, 400, "screenshot", "Screenshot mode does not apply to RAW files")
+	}
+	if screenshot {
+		imageData, trimmed, err = trimUniformBorders(imageData)
+		if err != nil {
+			log.Printf("Error trimming screenshot: %v", err)
+			return attempt.reject(c, 400, "screenshot", "Screenshot mode requires a decodable image")
+		}
+		display = imageData
+	}
+
+	// Enforce the profile's rules
+	if profile != nil {
+		config, _, err := image.DecodeConfig(bytes.NewReader(display))
+		if err != nil {
+			return attempt.reject(c, 400, "profile_decodable", "Upload profiles require a decodable image")
+		}
+		status, err := profile.check(imageData, config)
+		if err != nil {
+			return attempt.rejectRule(c, status, "profile", "Image does not match profile "+profileName+": ", err)
+		}
+		// A RAW original can't be rewritten, so only its preview is reshaped
+		if display, status, err = profile.enforceAspect(display, config.Width, config.Height); err != nil {
+			return attempt.rejectRule(c, status, "profile", "Image does not match profile "+profileName+": ", err)
+		}
+		if rawExt == "" {
+			imageData = display
+		}
+	}
+
+	markStep(c, "validate")
+	if ctx.Err() != nil {
+		return abortedUpload(c, ctx.Err())
+	}
+
+	// Remove location and device metadata before anything is stored. A RAW
+	// original is kept as uploaded, so only its preview is cleaned.
+	var metadataStripped bool
+	if !keepsMetadata(profile) {
+		cleaned, removed, err := stripImageMetadata(display)
+		if err != nil {
+			log.Printf("Error stripping metadata: %v", err)
+			return attempt.reject(c, 422, "metadata", "Image metadata could not be removed")
+		}
+		if rawExt == "" {
+			imageData = cleaned
+		}
+		display, metadataStripped = cleaned, removed
+	}
+	if ctx.Err() != nil {
+		return abortedUpload(c, ctx.Err())
+	}
+
+	// Re-uploading an image gets the copy already stored
+	variants := defaultVariantSpecs()
+	if profile != nil {
+		variants = profile.variantSpecs(profileName)
+	}
+	linkKey, viaLink := requestUploadLink(c)
+	// Uploads made with an upload link count against its limit, duplicates
+	// included, given back if the upload fails
+	if viaLink {
+		if err := metadata.claimUploadLink(linkKey); err != nil {
+			if errors.Is(err, errUploadLinkUsedUp) {
+				return c.Status(403).JSON(fiber.Map{
+					"error":   "Invalid, expired or used up upload link",
+					"success": false,
+				})
+			}
+			log.Printf("Error claiming upload link: %v", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to save image",
+				"success": false,
+			})
+		}
+		defer func() {
+			if attempt.stored == "" {
+				metadata.releaseUploadLink(linkKey)
+			}
+		}()
+	}
+	// Duplicates are only looked for among the caller's own uploads, or
+	// the link's album, so one is never added anywhere it wasn't already
+	if existing, ok := findDuplicate(c, imageData, variants); ok {
+		attempt.stored = existing
+		audit(c, "upload_duplicate", existing)
+		log.Printf("Upload is a duplicate of %s", existing)
+		_, ready := variantURLs(existing)
+		response := fiber.Map{
+			"success":        true,
+			"duplicate":      true,
+			"id":             imageID(existing),
+			"url":            displayPath(existing),
+			"variants_ready": ready,
+		}
+		if isRaw(existing) {
+			response["raw"] = true
+			response["original_url"] = "/uploads/" + existing
+		}
+		return c.JSON(response)
+	}
+
+	// Name the file after the format sniffed from its first few bytes; the
+	// format policy has already refused anything unrecognised
+	fileExt := sniffExtension(imageData)
+	if ext, ok := imageTypeExtensions[declaredType]; ok && ext != fileExt {
+		log.Printf("Upload declared %s but its content sniffs as %s", declaredType, fileExt)
 	}
 
 	// Generate unique filename
-	timestamp := time.Now().Unix()
-	sanitizedTitle := sanitizeFilename(payload.Title)
+	timestamp := serverClock.Now().Unix()
+	// Titles are named in NFC, like they are stored, so the same title
+	// always gives the same name
+	sanitizedTitle := sanitizeFilename(norm.NFC.String(payload.Title))
 	if sanitizedTitle == "" {
 		sanitizedTitle = "image"
 	}
-	filename := fmt.Sprintf("%d_%s%s", timestamp, sanitizedTitle, fileExt)
-	filepath := filepath.Join("./uploads", filename)
+	filename, release, err := reserveUploadName(ctx, timestamp, sanitizedTitle, fileExt)
+	if err != nil {
+		if ctx.Err() != nil {
+			return abortedUpload(c, ctx.Err())
+		}
+		log.Printf("Error choosing a name for upload: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save image",
+			"success": false,
+		})
+	}
+	defer release()
 
-	// Save file
-	if err := ioutil.WriteFile(filepath, imageData, 0644); err != nil {
+	// Save file, removing any partial write if the upload is abandoned
+	if err := writeUpload(ctx, filename, imageData); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Upload of %s abandoned: %v", filename, ctx.Err())
+			return abortedUpload(c, ctx.Err())
+		}
 		log.Printf("Error saving file: %v", err)
 		return c.Status(500).JSON(fiber.Map{
 			"error":   "Failed to save image",
 			"success": false,
 		})
 	}
+	if rawExt != "" {
+		if err := writeRawPreview(filename, display); err != nil {
+			log.Printf("Error saving RAW preview: %v", err)
+			if err := uploadStore.Delete(context.Background(), filename); err != nil {
+				log.Printf("Error removing %s: %v", filename, err)
+			}
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to save image",
+				"success": false,
+			})
+		}
+	}
+	meta := imageMeta{
+		Title:            payload.Title,
+		Description:      payload.Description,
+		OriginalFilename: payload.Filename,
+		UploadTime:       timestamp,
+		Tags:             tags,
+		Content:          describeContent(imageData, display),
+	}
+	if u, ok := requestUser(c); ok {
+		meta.Owner = u.ID
+	}
+	if viaLink {
+		// Guests' uploads belong to whoever owns the album they went into,
+		// and wait for them to approve it if the link is moderated
+		l, _ := metadata.uploadLink(linkKey)
+		if meta.Owner == "" {
+			meta.Owner = linkAlbumOwner(linkKey)
+		}
+		meta.Pending = l.Moderated
+	}
+	err = metadata.put(filename, meta)
+	if err != nil {
+		log.Printf("Error saving metadata for %s: %v", filename, err)
+	}
+	if uploadMirror != nil {
+		uploadMirror.enqueue(filename)
+	}
+	enqueueVariants(filename, variants)
+	changes.record(changeCreate, filename)
+	countUpload(len(imageData))
+	audit(c, "upload", filename)
+	if viaLink {
+		addToLinkAlbum(c, linkKey, filename)
+	}
+	attempt.stored = filename
+	markStep(c, "store")
 
-	// Log successful upload
-	log.Printf("Image uploaded successfully: %s (Title: %s, Description: %s)", 
-		filename, payload.Title, payload.Description)
+	// Log successful upload, and the key it was made with
+	var by string
+	if key := apiKeyName(c); key != "" {
+		by = " with key " + key
+	}
+	log.Printf("Image uploaded successfully%s: %s (Title: %s, Description: %s)", 
+		by, filename, payload.Title, payload.Description)
 
 	// Return success response
-	return c.JSON(fiber.Map{
-		"success": true,
-		"url":     "/uploads/" + filename,
-	})
+	response := fiber.Map{
+		"success":        true,
+		"url":            displayPath(filename),
+		"variants_ready": false,
+	}
+	if !processingAllowed() {
+		response["variants_deferred_until"] = processingWindow.nextOpen(serverClock.Now()).Format(time.RFC3339)
+	}
+	if rawExt != "" {
+		response["raw"] = true
+		response["original_url"] = "/uploads/" + filename
+	}
+	if profile != nil {
+		response["profile"] = profileName
+	}
+	if metadataStripped {
+		response["metadata_stripped"] = true
+	}
+	if screenshot {
+		response["screenshot"] = true
+		response["trimmed_to"] = fiber.Map{
+			"x":      trimmed.Min.X,
+			"y":      trimmed.Min.Y,
+			"width":  trimmed.Dx(),
+			"height": trimmed.Dy(),
+		}
+	}
+	return c.JSON(response)
+}
+
+// imageID derives an image's ID from its stored filename
+func imageID(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
 }
 
 // sanitizeFilename removes or replaces invalid characters for filenames
@@ -196,10 +1038,26 @@ func sanitizeFilename(filename string) string {
 	filename = strings.ReplaceAll(filename, "<", "-")
 	filename = strings.ReplaceAll(filename, ">", "-")
 	filename = strings.ReplaceAll(filename, "|", "-")
+	filename = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, filename)
 	
-	// Limit length
+	// Limit length, without cutting a character in half
 	if len(filename) > 50 {
 		filename = filename[:50]
+		for !utf8.ValidString(filename) {
+			filename = filename[:len(filename)-1]
+		}
+	}
+
+	// Windows drops trailing dots and opens device names like CON instead
+	// of files, so names stay the same wherever the uploads are copied
+	filename = strings.TrimRight(filename, ".")
+	if filename != "" && !storage.PortableName(filename) {
+		filename = "_" + filename
 	}
 	
 	return filename
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hashCacheEntry remembers a file's hash together with the size and
+// modification time it was computed for
+type hashCacheEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+var (
+	hashCacheMu sync.Mutex
+	hashCache   = make(map[string]hashCacheEntry)
+)
+
+// uploadHash returns the hex SHA-256 of an upload's content, recomputing it
+// only when the file's size or modification time changed
+func uploadHash(info os.FileInfo) (string, error) {
+	name := info.Name()
+	hashCacheMu.Lock()
+	entry, ok := hashCache[name]
+	hashCacheMu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.hash, nil
+	}
+	// Originals are never rewritten, so the hash recorded at upload holds
+	// while the size does
+	if meta, _ := metadata.get(name); meta.Content != nil && meta.Content.Hash != "" && meta.Content.Size == info.Size() {
+		hash := meta.Content.Hash
+		hashCacheMu.Lock()
+		hashCache[name] = hashCacheEntry{size: info.Size(), modTime: info.ModTime(), hash: hash}
+		hashCacheMu.Unlock()
+		indexBlob(hash, name)
+		return hash, nil
+	}
+
+	f, _, err := uploadStore.Get(context.Background(), name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	hashCacheMu.Lock()
+	hashCache[name] = hashCacheEntry{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	hashCacheMu.Unlock()
+	indexBlob(hash, name)
+	return hash, nil
+}
+
+// getManifest returns id -> content hash -> updated_at for every image the
+// caller can list, so caches can be diffed without fetching full metadata.
+// The response carries an ETag derived from the manifest itself, so
+// unchanged libraries cost a 304.
+func getManifest(c *fiber.Ctx) error {
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	manifest := make(map[string]fiber.Map, len(files))
+	etag := sha256.New()
+	for _, file := range files {
+		if hiddenFrom(c, file.Name()) {
+			continue
+		}
+		hash, err := uploadHash(file)
+		if err != nil {
+			log.Printf("Error hashing %s: %v", file.Name(), err)
+			continue
+		}
+		id := imageID(file.Name())
+		manifest[id] = fiber.Map{
+			"hash":       hash,
+			"updated_at": file.ModTime().Unix(),
+		}
+		io.WriteString(etag, id+":"+hash+"\n")
+	}
+
+	tag := `"` + hex.EncodeToString(etag.Sum(nil))[:32] + `"`
+	c.Set("ETag", tag)
+	if c.Get("If-None-Match") == tag {
+		return c.SendStatus(304)
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"count":   len(manifest),
+		"images":  manifest,
+	})
+}
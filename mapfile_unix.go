@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapFile maps the first size bytes of f into memory. The pages are read
+// from the file as they are touched and can be dropped again under memory
+// pressure, so a large upload doesn't take up its size in the heap. Writes
+// to the mapping are private and never reach the file. unmap must be
+// called once nothing refers to the data.
+func mapFile(f *os.File, size int) (data []byte, unmap func(), err error) {
+	data, err = unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() { unix.Munmap(data) }, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// memoryFile stands in for Linux's memfd_create with a temporary file that
+// is unlinked straight away, where the OS allows it, so nothing is left
+// behind once it is closed
+func memoryFile(name string) (*os.File, error) {
+	f, err := os.CreateTemp("", name+"-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	return f, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// imageMeta is what the uploader said about an image, which the stored
+// filename can't carry
+type imageMeta struct {
+	Title            string   `json:"title"`
+	Description      string   `json:"description"`
+	OriginalFilename string   `json:"original_filename,omitempty"`
+	UploadTime       int64    `json:"upload_time"`
+	Tags             []string `json:"tags,omitempty"`
+	Private          bool     `json:"private,omitempty"`
+	// Unlisted images are left out of listings and search
+	Unlisted bool `json:"unlisted,omitempty"`
+	// Pending images came in through a moderated upload link and are kept
+	// private and unlisted until approved
+	Pending bool `json:"pending,omitempty"`
+	// FocalPoint is the subject crops keep in frame, the centre if unset
+	FocalPoint *focalPoint `json:"focal_point,omitempty"`
+	// Slug is derived from the title when the metadata is saved
+	Slug string `json:"slug,omitempty"`
+	// Owner is the ID of the user who uploaded the image with an access
+	// token, empty for images uploaded anonymously or with an API key
+	Owner string `json:"owner,omitempty"`
+	// Content is absent for images stored before it was recorded
+	Content *contentInfo `json:"content,omitempty"`
+}
+
+var (
+	metadataBucket = []byte("images")
+	holdsBucket    = []byte("legal_holds")
+)
+
+// metadataStore keeps image metadata in an embedded bbolt database at
+// ./data/metadata.db, keyed by stored filename
+type metadataStore struct {
+	db *bolt.DB
+}
+
+var metadata *metadataStore
+
+// openMetadataStore opens the metadata database. A read-only store shares
+// the file with a running server, so export-site can use it.
+func openMetadataStore(readOnly bool) (*metadataStore, error) {
+	if !readOnly {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(filepath.Join(dataDir, "metadata.db"), 0644, &bolt.Options{
+		Timeout:  time.Second,
+		ReadOnly: readOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !readOnly {
+		if err := createMetadataBuckets(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &metadataStore{db: db}, nil
+}
+
+// openMemoryMetadataStore opens an empty metadata database kept in memory,
+// for the sandbox
+func openMemoryMetadataStore() (*metadataStore, error) {
+	f, err := memoryFile("afrobase-metadata")
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(f.Name(), 0644, &bolt.Options{
+		OpenFile: func(string, int, os.FileMode) (*os.File, error) { return f, nil },
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := createMetadataBuckets(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &metadataStore{db: db}, nil
+}
+
+func createMetadataBuckets(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metadataBucket, holdsBucket, albumsBucket, usersBucket, usernamesBucket, identitiesBucket, viewsBucket, invitesBucket, uploadLinksBucket, eventsBucket, mirrorDeletesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// put saves an image's metadata
+func (s *metadataStore) put(name string, meta imageMeta) error {
+	meta.normalize()
+	value, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx, metadataBucket, []byte(name), value)
+	})
+}
+
+// putAll saves the metadata of several images in one transaction
+func (s *metadataStore) putAll(metas map[string]imageMeta) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for name, meta := range metas {
+			meta.normalize()
+			value, err := json.Marshal(meta)
+			if err != nil {
+				return err
+			}
+			if err := putRecord(tx, metadataBucket, []byte(name), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// get loads an image's metadata, reporting whether any was stored
+func (s *metadataStore) get(name string) (imageMeta, bool) {
+	var meta imageMeta
+	found := false
+	if s == nil {
+		return meta, false
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metadataBucket)
+		if bucket == nil {
+			return nil
+		}
+		if value := bucket.Get([]byte(name)); value != nil {
+			found = json.Unmarshal(value, &meta) == nil
+		}
+		return nil
+	})
+	return meta, found
+}
+
+// update applies change to an image's metadata in one transaction, starting
+// from base if none is stored
+func (s *metadataStore) update(name string, base imageMeta, change func(meta *imageMeta) error) (imageMeta, error) {
+	var meta imageMeta
+	err := s.transact(func(t *metadataTx) (err error) {
+		meta, err = t.update(name, base, change)
+		return err
+	})
+	return meta, err
+}
+
+// metadataTx is a transaction over image and album metadata. Work that
+// should only happen once its changes are saved, such as journaling them
+// and queueing jobs, is put off with afterCommit.
+type metadataTx struct {
+	tx    *bolt.Tx
+	after []func()
+}
+
+// transact runs fn in one transaction: if fn fails, none of its changes
+// are saved and nothing it put off with afterCommit runs
+func (s *metadataStore) transact(fn func(t *metadataTx) error) error {
+	t := &metadataTx{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		t.tx, t.after = tx, nil
+		return fn(t)
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range t.after {
+		f()
+	}
+	return nil
+}
+
+// afterCommit puts work off until the transaction's changes are saved
+func (t *metadataTx) afterCommit(f func()) {
+	t.after = append(t.after, f)
+}
+
+// update applies change to an image's metadata, starting from base if none
+// is stored
+func (t *metadataTx) update(name string, base imageMeta, change func(meta *imageMeta) error) (imageMeta, error) {
+	meta := base
+	bucket := t.tx.Bucket(metadataBucket)
+	if value := bucket.Get([]byte(name)); value != nil {
+		meta = imageMeta{}
+		if err := json.Unmarshal(value, &meta); err != nil {
+			return meta, err
+		}
+	}
+	if err := change(&meta); err != nil {
+		return meta, err
+	}
+	meta.normalize()
+	value, err := json.Marshal(meta)
+	if err != nil {
+		return meta, err
+	}
+	return meta, putRecord(t.tx, metadataBucket, []byte(name), value)
+}
+
+// delete forgets an image's metadata
+func (s *metadataStore) delete(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(viewsBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		return deleteRecord(tx, metadataBucket, []byte(name))
+	})
+}
+
+// imageInfo returns an image's metadata. Files uploaded before metadata
+// was kept fall back to a title derived from the filename and the file's
+// modification time.
+func imageInfo(name string) imageMeta {
+	if meta, ok := metadata.get(name); ok {
+		if meta.Title == "" {
+			meta.Title = imageID(name)
+		}
+		// Metadata saved before slugs were kept gets one on the fly
+		if meta.Slug == "" {
+			meta.Slug = slugify(meta.Title)
+		}
+		return meta
+	}
+	meta := imageMeta{Title: imageID(name), Slug: slugify(imageID(name))}
+	if object, err := uploadStore.Stat(context.Background(), name); err == nil {
+		meta.UploadTime = object.ModTime.Unix()
+	}
+	return meta
+}
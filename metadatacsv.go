@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// metadataColumns are the columns of a metadata export. Only the editable
+// ones are read back on import; the others are there for reference.
+var metadataColumns = []string{"id", "name", "title", "description", "original_filename", "upload_time", "tags"}
+
+var editableMetadataColumns = []string{"title", "description", "original_filename", "tags"}
+
+// Limits on imported metadata
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 5000
+)
+
+// rowError is a problem with one row of an import. Row 1 is the header.
+type rowError struct {
+	Row   int    `json:"row"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// exportMetadata downloads the metadata of every upload as CSV:
+// GET /api/admin/metadata
+func exportMetadata(c *fiber.Ctx) error {
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+
+	records := make([]map[string]interface{}, 0, len(files))
+	for _, file := range files {
+		meta := imageInfo(file.Name())
+		records = append(records, map[string]interface{}{
+			"id":                imageID(file.Name()),
+			"name":              file.Name(),
+			"title":             meta.Title,
+			"description":       meta.Description,
+			"original_filename": meta.OriginalFilename,
+			"upload_time":       meta.UploadTime,
+			"tags":              meta.Tags,
+		})
+	}
+	c.Attachment("metadata.csv")
+	return sendCSV(c, records, metadataColumns)
+}
+
+// importMetadata applies edits from a CSV in the shape exportMetadata
+// writes: POST /api/admin/metadata. Rows are matched by id and need only
+// the columns being changed. Rows with errors are reported and skipped;
+// the rest are applied together, unless ?dry_run=true.
+func importMetadata(c *fiber.Ctx) error {
+	reader := csv.NewReader(bytes.NewReader(c.Body()))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid CSV header",
+			"success": false,
+		})
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "CSV needs an id column",
+			"success": false,
+		})
+	}
+
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+	names := make(map[string]string, len(files))
+	for _, file := range files {
+		names[imageID(file.Name())] = file.Name()
+	}
+
+	updates := map[string]imageMeta{}
+	rowErrors := []rowError{}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				// A malformed row can't be resynchronised reliably
+				rowErrors = append(rowErrors, rowError{Row: row, Error: parseErr.Err.Error()})
+				break
+			}
+			return err
+		}
+
+		id := field(record, columns, "id")
+		name, ok := names[id]
+		if !ok {
+			rowErrors = append(rowErrors, rowError{Row: row, ID: id, Error: "no image with this id"})
+			continue
+		}
+		if _, seen := updates[name]; seen {
+			rowErrors = append(rowErrors, rowError{Row: row, ID: id, Error: "duplicate id"})
+			continue
+		}
+		if imageSealed(name) {
+			rowErrors = append(rowErrors, rowError{Row: row, ID: id, Error: "image is in a sealed album"})
+			continue
+		}
+
+		// Files without stored metadata start from what can be derived,
+		// less the title, which is only derived for display
+		meta, ok := metadata.get(name)
+		if !ok {
+			meta = imageInfo(name)
+			meta.Title = ""
+		}
+		if err := applyMetadataRow(&meta, record, columns); err != nil {
+			rowErrors = append(rowErrors, rowError{Row: row, ID: id, Error: err.Error()})
+			continue
+		}
+		updates[name] = meta
+	}
+
+	dryRun := c.QueryBool("dry_run", false)
+	if !dryRun && len(updates) > 0 {
+		if err := metadata.putAll(updates); err != nil {
+			log.Printf("Error importing metadata: %v", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to save metadata",
+				"success": false,
+			})
+		}
+		for name := range updates {
+			changes.record(changeUpdate, name)
+			audit(c, "metadata", name)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": len(rowErrors) == 0,
+		"dry_run": dryRun,
+		"updated": len(updates),
+		"errors":  rowErrors,
+	})
+}
+
+// applyMetadataRow copies the editable columns present in a row onto meta
+func applyMetadataRow(meta *imageMeta, record []string, columns map[string]int) error {
+	for _, column := range editableMetadataColumns {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			continue
+		}
+		value := csvText(record[i])
+		if !utf8.ValidString(value) {
+			return fmt.Errorf("%s is not valid UTF-8", column)
+		}
+		switch column {
+		case "title":
+			if err := checkTitle(value); err != nil {
+				return err
+			}
+			meta.Title = value
+		case "description":
+			if err := checkDescription(value); err != nil {
+				return err
+			}
+			meta.Description = value
+		case "original_filename":
+			if strings.ContainsAny(value, "/\\\x00") {
+				return errors.New("original_filename must not contain path separators")
+			}
+			meta.OriginalFilename = value
+		case "tags":
+			tags, err := normalizeTags(splitTags(value))
+			if err != nil {
+				return err
+			}
+			meta.Tags = tags
+		}
+	}
+	return nil
+}
+
+// checkTitle enforces the limits on an image title
+func checkTitle(title string) error {
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("title is longer than %d characters", maxTitleLength)
+	}
+	if strings.IndexFunc(title, unicode.IsControl) >= 0 {
+		return errors.New("title contains control characters")
+	}
+	return nil
+}
+
+// checkDescription enforces the limits on an image description
+func checkDescription(description string) error {
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return fmt.Errorf("description is longer than %d characters", maxDescriptionLength)
+	}
+	return nil
+}
+
+// field returns a row's value for a column, or "" if it has none
+func field(record []string, columns map[string]int, column string) string {
+	if i, ok := columns[column]; ok && i < len(record) {
+		return strings.TrimSpace(csvText(record[i]))
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"maps"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"AfroBaseServer/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Counters exported at /metrics on the admin listener
+var (
+	uploadsStored     atomic.Uint64
+	uploadBytesStored atomic.Uint64
+
+	metricsMu        sync.Mutex
+	uploadRejections = map[string]uint64{}         // by rule
+	requestErrors    = map[string]map[int]uint64{} // by route, then status
+)
+
+// countUpload records an upload that was stored
+func countUpload(size int) {
+	uploadsStored.Add(1)
+	uploadBytesStored.Add(uint64(size))
+}
+
+// countRejection records an upload refused by a validation rule
+func countRejection(rule string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	uploadRejections[rule]++
+}
+
+// countRequestError records a 4xx or 5xx response
+func countRequestError(route string, status int) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	byStatus, ok := requestErrors[route]
+	if !ok {
+		byStatus = map[int]uint64{}
+		requestErrors[route] = byStatus
+	}
+	byStatus[status]++
+}
+
+// metricsWriter writes the Prometheus text exposition format
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+func (w *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one value; labels alternate names and values
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// splitRoute splits a "METHOD /path" latency key into its parts
+func splitRoute(route string) (method, path string) {
+	method, path, _ = strings.Cut(route, " ")
+	return method, path
+}
+
+// getMetrics reports upload, request and storage metrics for Prometheus:
+// GET /metrics. Storage usage is counted by listing uploads on each scrape.
+func getMetrics(c *fiber.Ctx) error {
+	var w metricsWriter
+
+	w.family("afrobase_uploads_total", "counter", "Uploads stored, not counting duplicates.")
+	w.sample("afrobase_uploads_total", float64(uploadsStored.Load()))
+	w.family("afrobase_upload_bytes_total", "counter", "Bytes of uploads stored.")
+	w.sample("afrobase_upload_bytes_total", float64(uploadBytesStored.Load()))
+
+	metricsMu.Lock()
+	w.family("afrobase_upload_rejections_total", "counter", "Uploads refused, by the rule they broke.")
+	for _, rule := range slices.Sorted(maps.Keys(uploadRejections)) {
+		w.sample("afrobase_upload_rejections_total", float64(uploadRejections[rule]), "rule", rule)
+	}
+	w.family("afrobase_http_errors_total", "counter", "Responses with a 4xx or 5xx status, by route.")
+	for _, route := range slices.Sorted(maps.Keys(requestErrors)) {
+		method, path := splitRoute(route)
+		byStatus := requestErrors[route]
+		for _, status := range slices.Sorted(maps.Keys(byStatus)) {
+			w.sample("afrobase_http_errors_total", float64(byStatus[status]), "method", method, "route", path, "status", strconv.Itoa(status))
+		}
+	}
+	metricsMu.Unlock()
+
+	latencyMu.Lock()
+	w.family("afrobase_http_request_duration_seconds", "histogram", "Time taken to answer requests, by route.")
+	for _, route := range slices.Sorted(maps.Keys(latencies)) {
+		method, path := splitRoute(route)
+		h := latencies[route]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			w.sample("afrobase_http_request_duration_seconds_bucket", float64(cumulative), "method", method, "route", path, "le", strconv.FormatFloat(bound/1000, 'g', -1, 64))
+		}
+		w.sample("afrobase_http_request_duration_seconds_bucket", float64(h.total), "method", method, "route", path, "le", "+Inf")
+		w.sample("afrobase_http_request_duration_seconds_sum", h.sum/1000, "method", method, "route", path)
+		w.sample("afrobase_http_request_duration_seconds_count", float64(h.total), "method", method, "route", path)
+	}
+	latencyMu.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.family("go_goroutines", "gauge", "Goroutines that currently exist.")
+	w.sample("go_goroutines", float64(runtime.NumGoroutine()))
+	w.family("go_memstats_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.")
+	w.sample("go_memstats_heap_inuse_bytes", float64(mem.HeapInuse))
+
+	w.family("afrobase_variant_queue_length", "gauge", "Uploads waiting for variants to be generated.")
+	w.sample("afrobase_variant_queue_length", float64(len(variantQueue)))
+
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error listing uploads for metrics: %v", err)
+	} else {
+		var total usage
+		for _, file := range files {
+			total.add(file.Size())
+		}
+		w.family("afrobase_stored_uploads", "gauge", "Originals in upload storage.")
+		w.sample("afrobase_stored_uploads", float64(total.Count))
+		w.family("afrobase_stored_upload_bytes", "gauge", "Bytes taken up by originals in upload storage.")
+		w.sample("afrobase_stored_upload_bytes", float64(total.Bytes))
+	}
+
+	// Space left on the filesystem holding local storage
+	if local, ok := uploadStore.(*storage.Local); ok {
+		size, free, err := diskSpace(local.Root())
+		if err != nil {
+			log.Printf("Error reading disk space for metrics: %v", err)
+		} else {
+			w.family("afrobase_disk_size_bytes", "gauge", "Size of the filesystem holding uploads.")
+			w.sample("afrobase_disk_size_bytes", float64(size))
+			w.family("afrobase_disk_free_bytes", "gauge", "Space available on the filesystem holding uploads.")
+			w.sample("afrobase_disk_free_bytes", float64(free))
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.Send(w.Bytes())
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"time"
+
+	"AfroBaseServer/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// mirror replicates objects written to the upload store into a secondary
+// store (a different volume, region or bucket). Writes are copied
+// asynchronously so uploads never wait on the secondary, and a periodic
+// reconciliation pass repairs anything the queue missed in either
+// direction. Originals, their variants and renditions under thumbs/ and
+// RAW previews under previews/ are mirrored.
+type mirror struct {
+	primary   storage.Storage
+	secondary storage.Storage
+	queue     chan string
+}
+
+// uploadMirror is nil unless AFROBASE_MIRROR_DIR is set
+var uploadMirror *mirror
+
+// mirrorDeletesBucket records keys deleted from the upload store, by the
+// time of deletion, until neither store has them. Reconciliation would
+// otherwise restore a file whose deletion only reached one side.
+var mirrorDeletesBucket = []byte("mirror_deletes")
+
+func newMirror(primary, secondary storage.Storage) *mirror {
+	return &mirror{
+		primary:   primary,
+		secondary: secondary,
+		queue:     make(chan string, 256),
+	}
+}
+
+// start runs the copy worker and the reconciliation loop in the background
+func (m *mirror) start(interval time.Duration) {
+	go func() {
+		for key := range m.queue {
+			if err := copyObject(context.Background(), m.primary, m.secondary, key); err != nil {
+				// Reconciliation will pick it up on the next pass
+				log.Printf("Error mirroring %s: %v", key, err)
+			}
+		}
+	}()
+
+	go func() {
+		m.reconcile()
+		for range time.Tick(interval) {
+			m.reconcile()
+		}
+	}()
+}
+
+// enqueue schedules a stored file, by its key, for mirroring. A key
+// written again after being deleted is no longer a deletion.
+func (m *mirror) enqueue(key string) {
+	if err := metadata.forgetMirrorDelete(key); err != nil {
+		log.Printf("Error clearing mirror deletion of %s: %v", key, err)
+	}
+	select {
+	case m.queue <- key:
+	default:
+		log.Printf("Mirror queue full, deferring %s to reconciliation", key)
+	}
+}
+
+// remove deletes the mirror copy of a stored file, by its key. The
+// deletion is recorded first, so reconciliation can't restore the file
+// from whichever side still has it.
+func (m *mirror) remove(key string) {
+	if err := metadata.recordMirrorDelete(key); err != nil {
+		log.Printf("Error recording mirror deletion of %s: %v", key, err)
+	}
+	if err := m.secondary.Delete(context.Background(), key); err != nil {
+		log.Printf("Error deleting mirror copy of %s: %v", key, err)
+	}
+}
+
+// reconcile brings the stores back in step, so the secondary catches up
+// after an outage and the primary is refilled after failing back. Objects
+// only on one side are copied to the other, or deleted if they were
+// deleted. Where both have an object the primary's wins if the sizes
+// differ or it was written after the mirror copy.
+func (m *mirror) reconcile() {
+	ctx := context.Background()
+	primaryObjects, err := mirroredObjects(ctx, m.primary)
+	if err != nil {
+		log.Printf("Error listing upload store: %v", err)
+		return
+	}
+	secondaryObjects, err := mirroredObjects(ctx, m.secondary)
+	if err != nil {
+		log.Printf("Error listing mirror: %v", err)
+		return
+	}
+	deleted, err := metadata.mirrorDeletes()
+	if err != nil {
+		log.Printf("Error reading mirror deletions: %v", err)
+		return
+	}
+
+	copied, removed := 0, 0
+	for key, p := range primaryObjects {
+		if deleted[key] {
+			// Being deleted, or its deletion failed and is retried
+			continue
+		}
+		s, ok := secondaryObjects[key]
+		if ok && s.Size == p.Size && !p.ModTime.After(s.ModTime) {
+			continue
+		}
+		if err := copyObject(ctx, m.primary, m.secondary, key); err != nil {
+			log.Printf("Error mirroring %s: %v", key, err)
+			continue
+		}
+		copied++
+	}
+	for key := range secondaryObjects {
+		if _, ok := primaryObjects[key]; ok {
+			continue
+		}
+		if deleted[key] {
+			if err := m.secondary.Delete(ctx, key); err != nil {
+				log.Printf("Error deleting mirror copy of %s: %v", key, err)
+				continue
+			}
+			removed++
+			continue
+		}
+		if err := copyObject(ctx, m.secondary, m.primary, key); err != nil {
+			log.Printf("Error restoring %s from mirror: %v", key, err)
+			continue
+		}
+		copied++
+	}
+	for key := range deleted {
+		_, p := primaryObjects[key]
+		_, s := secondaryObjects[key]
+		if !p && !s {
+			if err := metadata.forgetMirrorDelete(key); err != nil {
+				log.Printf("Error clearing mirror deletion of %s: %v", key, err)
+			}
+		}
+	}
+
+	if copied > 0 || removed > 0 {
+		log.Printf("Mirror reconciliation copied %d file(s) and deleted %d", copied, removed)
+	}
+}
+
+// mirroredObjects returns the mirrored objects in a store by key: the
+// originals at the top level, the variants in each variant directory and
+// the RAW previews
+func mirroredObjects(ctx context.Context, s storage.Storage) (map[string]storage.Object, error) {
+	prefixes := []string{"", "previews/"}
+	dirs := map[string]bool{}
+	for _, specs := range variantSets() {
+		for _, spec := range specs {
+			if !dirs[spec.Dir] {
+				dirs[spec.Dir] = true
+				prefixes = append(prefixes, "thumbs/"+spec.Dir+"/")
+			}
+		}
+	}
+	objects := map[string]storage.Object{}
+	for _, prefix := range prefixes {
+		listed, err := s.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range listed {
+			objects[object.Key] = object
+		}
+	}
+	return objects, nil
+}
+
+// copyObject copies an object from one store to another. Stores never
+// expose a partly written object, so readers see the old copy or the new.
+func copyObject(ctx context.Context, from, to storage.Storage, key string) error {
+	r, object, err := from.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return to.Put(ctx, key, r, object.Size)
+}
+
+// recordMirrorDelete records that a key is being deleted
+func (s *metadataStore) recordMirrorDelete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		at := binary.BigEndian.AppendUint64(nil, uint64(serverClock.Now().Unix()))
+		return tx.Bucket(mirrorDeletesBucket).Put([]byte(key), at)
+	})
+}
+
+// forgetMirrorDelete drops the record of a key's deletion, if there is one
+func (s *metadataStore) forgetMirrorDelete(key string) error {
+	var recorded bool
+	s.db.View(func(tx *bolt.Tx) error {
+		recorded = tx.Bucket(mirrorDeletesBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	if !recorded {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(mirrorDeletesBucket).Delete([]byte(key))
+	})
+}
+
+// mirrorDeletes returns the keys whose deletion is recorded
+func (s *metadataStore) mirrorDeletes() (map[string]bool, error) {
+	deleted := map[string]bool{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(mirrorDeletesBucket).ForEach(func(k, _ []byte) error {
+			deleted[string(k)] = true
+			return nil
+		})
+	})
+	return deleted, err
+}
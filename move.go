@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// imageMove is the body of POST /api/images/:id/move: the albums to take
+// the image out of and put it in, either of which may be left out, and
+// any edit PATCH /api/images/:id takes
+type imageMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	imageChanges
+}
+
+// moveImage moves an image between albums and edits it as one change:
+// POST /api/images/:id/move with {"from": "<album id>", "to": "<album
+// id>", "tags": ["..."], "private": true}. Either everything is saved or,
+// if any part is refused or fails, nothing is, and nothing is journaled.
+// If-Match, if sent, is checked as for PATCH /api/images/:id.
+func moveImage(c *fiber.Ctx) error {
+	var req imageMove
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Body must be JSON",
+			"success": false,
+		})
+	}
+	err := req.check()
+	switch {
+	case req.From == "" && req.To == "" && req.empty():
+		err = errors.New("Nothing to do: give from, to or changes to make")
+	case req.From != "" && req.From == req.To:
+		err = errors.New("from and to are the same album")
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	object, err := uploadStore.Stat(c.Context(), name)
+	if err != nil {
+		log.Printf("Error reading %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read image",
+			"success": false,
+		})
+	}
+	if !mayChange(c, name) {
+		return sendNotOwner(c, "Image belongs to another user")
+	}
+
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+	if !req.empty() && imageSealed(name) {
+		return sendSealed(c, "metadata", name, "Image is in a sealed album")
+	}
+	imageEditMu.Lock()
+	defer imageEditMu.Unlock()
+	if c.Get(fiber.HeaderIfMatch) != "" {
+		current, _ := changes.latest(name)
+		if err := checkVersion(c, current.Seq); err != nil {
+			return c.Status(412).JSON(fiber.Map{
+				"error":   "Image has changed since the version in If-Match",
+				"success": false,
+			})
+		}
+	}
+
+	// Files without stored metadata start from what can be derived, less
+	// the title, which is only derived for display
+	base := imageInfo(name)
+	base.Title = ""
+	errHeld := errors.New("album is under legal hold")
+	errNotInAlbum := errors.New("image is not in the album")
+	var from, to album
+	var failed string
+	err = metadata.transact(func(t *metadataTx) (err error) {
+		if req.From != "" {
+			failed = req.From
+			from, err = t.updateAlbum(req.From, func(a *album) error {
+				if !ownedByCaller(c, a.Owner) {
+					return errNotOwner
+				}
+				if !a.contains(name) {
+					return errNotInAlbum
+				}
+				if a.LegalHold != nil {
+					return errHeld
+				}
+				if a.Seal != nil {
+					return errAlbumSealed
+				}
+				a.Images = slices.DeleteFunc(a.Images, func(image string) bool { return image == name })
+				if a.Cover == name {
+					a.Cover = ""
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			t.afterCommit(func() { audit(c, "album_remove", from.ID+"/"+name) })
+		}
+		if req.To != "" {
+			failed = req.To
+			to, err = t.updateAlbum(req.To, func(a *album) error {
+				if !ownedByCaller(c, a.Owner) {
+					return errNotOwner
+				}
+				if a.Seal != nil {
+					return errAlbumSealed
+				}
+				if !a.contains(name) {
+					a.Images = append(a.Images, name)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			t.afterCommit(func() { audit(c, "album_add", to.ID+"/"+name) })
+		}
+		if !req.empty() {
+			_, err = t.update(name, base, func(meta *imageMeta) error {
+				req.apply(meta)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			t.afterCommit(func() {
+				changes.record(changeUpdate, name)
+				audit(c, "metadata", name)
+				if req.FocalPoint != nil {
+					recropVariants(name)
+				}
+			})
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found: " + failed,
+			"success": false,
+		})
+	case errors.Is(err, errNotOwner):
+		return sendNotOwner(c, "Album belongs to another user: "+failed)
+	case errors.Is(err, errNotInAlbum):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image is not in the album: " + failed,
+			"success": false,
+		})
+	case errors.Is(err, errAlbumSealed):
+		return sendSealed(c, "move", failed+"/"+name, "Album is sealed: "+failed)
+	case errors.Is(err, errHeld):
+		audit(c, "album_remove_refused", failed+"/"+name)
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Album is under legal hold: " + failed,
+			"success": false,
+		})
+	case err != nil:
+		log.Printf("Error moving %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to move image",
+			"success": false,
+		})
+	}
+
+	info := imageRecord(object.Info())
+	if event, ok := changes.latest(name); ok {
+		info["version"] = event.Seq
+	}
+	response := fiber.Map{
+		"success": true,
+		"image":   info,
+	}
+	if req.From != "" {
+		response["from"] = from.view()
+	}
+	if req.To != "" {
+		response["to"] = to.view()
+	}
+	return c.JSON(response)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isMultipartUpload reports whether an upload was sent as a form
+func isMultipartUpload(c *fiber.Ctx) bool {
+	return strings.HasPrefix(strings.ToLower(c.Get(fiber.HeaderContentType)), fiber.MIMEMultipartForm)
+}
+
+// readMultipartUpload reads /upload sent as multipart/form-data, with the
+// file in an "image" field and title, description, filename, mode and
+// comma-separated tags as form values. The file arrives as raw bytes, which
+// avoids base64's size overhead and the decoding pass, and is copied into
+// dst as it arrives. It returns the file's declared image type, if any.
+func readMultipartUpload(ctx context.Context, c *fiber.Ctx, body io.Reader, dst io.Writer, payload *ImagePayload) (string, error) {
+	_, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	if err != nil || params["boundary"] == "" {
+		return "", errInvalidBody
+	}
+	form := multipart.NewReader(body, params["boundary"])
+
+	var declaredType, fileName string
+	var seenImage bool
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		name := part.FormName()
+		if name == "image" {
+			if seenImage {
+				return "", errInvalidBody
+			}
+			seenImage = true
+			fileName = part.FileName()
+			if contentType := strings.ToLower(part.Header.Get(fiber.HeaderContentType)); strings.HasPrefix(contentType, "image/") {
+				declaredType, _, _ = strings.Cut(contentType, ";")
+			}
+			if _, err := copyContext(ctx, dst, part); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxUploadField+1))
+		if err != nil {
+			return "", err
+		}
+		if len(value) > maxUploadField {
+			return "", &uploadBodyError{400, "request_body", "Request field too large"}
+		}
+		switch name {
+		case "title":
+			payload.Title = string(value)
+		case "description":
+			payload.Description = string(value)
+		case "filename":
+			payload.Filename = string(value)
+		case "mode":
+			payload.Mode = string(value)
+		case "tags":
+			payload.Tags = splitTags(string(value))
+		}
+	}
+	// Read to the end of the body, so the connection can be reused
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return "", err
+	}
+	if payload.Filename == "" {
+		payload.Filename = fileName
+	}
+	return declaredType, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"AfroBaseServer/storage"
+)
+
+// foldUploadNames is set at startup when upload storage, or its mirror, is
+// on a filesystem that doesn't tell names apart by case. Upload IDs are
+// then kept unique ignoring case, so "Photo" and "photo" uploaded in the
+// same second don't overwrite each other.
+var foldUploadNames bool
+
+// Upload IDs handed out but not yet written, so two uploads being saved at
+// once can't pick the same one
+var (
+	uploadNamesMu       sync.Mutex
+	uploadNamesInFlight = map[string]bool{}
+)
+
+// checkCaseSensitivity turns on case-folded upload names if dir is on a
+// case-insensitive filesystem
+func checkCaseSensitivity(dir string) {
+	sensitive, err := storage.CaseSensitive(dir)
+	if err != nil {
+		// Erring towards folding only costs the odd needless suffix
+		log.Printf("Could not tell whether %s is case-sensitive, assuming not: %v", dir, err)
+		sensitive = false
+	}
+	if !sensitive {
+		if !foldUploadNames {
+			log.Printf("%s is case-insensitive, keeping upload names unique ignoring case", dir)
+		}
+		foldUploadNames = true
+	}
+}
+
+// uploadNameKey is the form of an upload ID that two IDs naming the same
+// file share
+func uploadNameKey(id string) string {
+	if foldUploadNames {
+		return strings.ToLower(id)
+	}
+	return id
+}
+
+// reserveUploadName picks the name a new upload is stored under,
+// <timestamp>_<title><ext>, adding _2, _3 and so on to the title if an
+// upload with that ID already exists, in whichever format, or is being
+// saved. The release function frees the reservation once the upload is
+// written or has failed.
+func reserveUploadName(ctx context.Context, timestamp int64, title, ext string) (string, func(), error) {
+	uploadNamesMu.Lock()
+	defer uploadNamesMu.Unlock()
+
+	prefix := fmt.Sprintf("%d_", timestamp)
+	objects, err := uploadStore.List(ctx, prefix)
+	if err != nil {
+		return "", nil, err
+	}
+	taken := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		taken[uploadNameKey(imageID(object.Key))] = true
+	}
+
+	id := prefix + title
+	for n := 2; taken[uploadNameKey(id)] || uploadNamesInFlight[uploadNameKey(id)]; n++ {
+		id = fmt.Sprintf("%s%s_%d", prefix, title, n)
+	}
+	key := uploadNameKey(id)
+	uploadNamesInFlight[key] = true
+	release := func() {
+		uploadNamesMu.Lock()
+		defer uploadNamesMu.Unlock()
+		delete(uploadNamesInFlight, key)
+	}
+	return id + ext, release, nil
+}
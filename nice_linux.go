@@ -0,0 +1,17 @@
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// lowerWorkerPriority pins the calling goroutine to its OS thread and
+// renices that thread, so a background worker yields CPU to request
+// handlers. Linux applies nice levels per thread.
+func lowerWorkerPriority(nice int) error {
+	if nice == 0 {
+		return nil
+	}
+	runtime.LockOSThread()
+	return syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), nice)
+}
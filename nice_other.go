@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// lowerWorkerPriority is only supported on Linux, where nice levels can be
+// set per thread
+func lowerWorkerPriority(nice int) error {
+	if nice == 0 {
+		return nil
+	}
+	return errors.New("worker nice levels are only supported on Linux")
+}
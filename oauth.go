@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Users can log in with Google or GitHub instead of a password, through
+// OAuth 2.0's authorization code flow with PKCE. GET
+// /api/auth/oauth/<provider> sends the browser to the provider, which
+// sends it back to /api/auth/oauth/<provider>/callback. The first login
+// creates an account, and a login started with an access token links the
+// provider to that account instead. Either way the user ends up with the
+// same access token a password login gives.
+
+// oauthProvider is a service users can log in with. It is enabled by
+// setting AFROBASE_<NAME>_CLIENT_ID and AFROBASE_<NAME>_CLIENT_SECRET to
+// the credentials of an app registered with it, whose redirect URI is
+// logged at startup.
+type oauthProvider struct {
+	name         string
+	authURL      string
+	tokenURL     string
+	scope        string
+	clientID     string
+	clientSecret string
+	// identify looks up the account an access token belongs to
+	identify func(ctx context.Context, token string) (oauthIdentity, error)
+}
+
+// oauthIdentity is an account with a provider
+type oauthIdentity struct {
+	// ID is the provider's permanent ID for the account
+	ID string
+	// Username suggests a name for an account created from this one
+	Username string
+}
+
+// oauthProviders are the enabled providers, by name
+var oauthProviders = map[string]*oauthProvider{}
+
+// oauthFlowTTL is how long a user has to log in with a provider
+const oauthFlowTTL = 10 * time.Minute
+
+// oauthCookie carries an oauthFlow from the redirect to the callback
+const oauthCookie = "afrobase_oauth"
+
+// oauthClient calls providers' token and profile endpoints
+var oauthClient = &http.Client{Timeout: 15 * time.Second}
+
+var errIdentityLinked = errors.New("identity is linked to another user")
+
+// loadOAuthProviders enables the providers configured in the environment
+func loadOAuthProviders() error {
+	for _, p := range []*oauthProvider{
+		{
+			name:     "google",
+			authURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+			tokenURL: "https://oauth2.googleapis.com/token",
+			scope:    "openid email profile",
+			identify: identifyGoogle,
+		},
+		{
+			name:     "github",
+			authURL:  "https://github.com/login/oauth/authorize",
+			tokenURL: "https://github.com/login/oauth/access_token",
+			scope:    "read:user",
+			identify: identifyGitHub,
+		},
+	} {
+		env := "AFROBASE_" + strings.ToUpper(p.name)
+		p.clientID, p.clientSecret = os.Getenv(env+"_CLIENT_ID"), os.Getenv(env+"_CLIENT_SECRET")
+		if p.clientID == "" && p.clientSecret == "" {
+			continue
+		}
+		if p.clientID == "" || p.clientSecret == "" {
+			return fmt.Errorf("%s_CLIENT_ID and %s_CLIENT_SECRET must be set together", env, env)
+		}
+		oauthProviders[p.name] = p
+		log.Printf("Login with %s enabled, redirect URI %s", p.name, p.redirectURI())
+	}
+	return nil
+}
+
+// redirectURI is where the provider sends users back to
+func (p *oauthProvider) redirectURI() string {
+	return publicBaseURL + "/api/auth/oauth/" + p.name + "/callback"
+}
+
+// oauthFlow is a login in progress, kept in a signed cookie between the
+// redirect to the provider and the callback
+type oauthFlow struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	// Link is the user to link the provider's account to, if any
+	Link string `json:"link,omitempty"`
+	// Next is the page to send the user to afterwards, if any
+	Next string `json:"next,omitempty"`
+	// Invite is the invite code a new account is made with, if any
+	Invite  string `json:"invite,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+// signOAuth signs a value with the token key, kept apart from tokens by a
+// prefix
+func signOAuth(purpose, value string) string {
+	mac := hmac.New(sha256.New, tokenKey)
+	mac.Write([]byte("oauth " + purpose + "\n" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (f oauthFlow) encode() string {
+	data, _ := json.Marshal(f)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signOAuth("flow", payload)
+}
+
+// decodeOAuthFlow checks and decodes an oauthCookie
+func decodeOAuthFlow(value string) (oauthFlow, bool) {
+	var f oauthFlow
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signOAuth("flow", payload))) {
+		return f, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(data, &f) != nil {
+		return f, false
+	}
+	return f, serverClock.Now().Unix() < f.Expires
+}
+
+// codeVerifier is the PKCE secret for a login. It is derived from the
+// state, so nothing needs storing, but can't be worked out without the
+// token key.
+func codeVerifier(state string) string {
+	return signOAuth("pkce", state)
+}
+
+// setOAuthCookie sets or, with maxAge -1, clears the oauthCookie
+func setOAuthCookie(c *fiber.Ctx, value string, maxAge int) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oauthCookie,
+		Value:    value,
+		Path:     "/api/auth/oauth/",
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(publicBaseURL, "https://"),
+		HTTPOnly: true,
+		// Lax, so the cookie comes back with the provider's redirect
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// isLocalPath reports whether a ?next= page is a path on this server, so
+// logins can't be used to send users, or their tokens, elsewhere
+func isLocalPath(next string) bool {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\\#") {
+		return false
+	}
+	u, err := url.Parse(next)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// listOAuthProviders lists the providers users can log in with:
+// GET /api/auth/providers
+func listOAuthProviders(c *fiber.Ctx) error {
+	providers := []fiber.Map{}
+	for name, p := range oauthProviders {
+		providers = append(providers, fiber.Map{
+			"name": name,
+			"url":  publicBaseURL + "/api/auth/oauth/" + p.name,
+		})
+	}
+	slices.SortFunc(providers, func(a, b fiber.Map) int {
+		return strings.Compare(a["name"].(string), b["name"].(string))
+	})
+	return c.JSON(fiber.Map{
+		"success":   true,
+		"providers": providers,
+	})
+}
+
+// startOAuth sends the browser to a provider to log in:
+// GET /api/auth/oauth/github?next=/gallery. Afterwards the browser goes to
+// ?next= with the access token in the fragment, or is shown it as JSON
+// without one. Requests that accept JSON get {"url": …} to go to instead
+// of a redirect, which lets a page send its access token with fetch to
+// link the provider to the account.
+func startOAuth(c *fiber.Ctx) error {
+	p, ok := oauthProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Unknown login provider",
+			"success": false,
+		})
+	}
+	next := c.Query("next")
+	if next != "" && !isLocalPath(next) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "next must be a path on this server",
+			"success": false,
+		})
+	}
+	state, err := newID(16)
+	if err != nil {
+		log.Printf("Error generating OAuth state: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to start login",
+			"success": false,
+		})
+	}
+
+	flow := oauthFlow{
+		Provider: p.name,
+		State:    state,
+		Next:     next,
+		Invite:   c.Query("invite"),
+		Expires:  serverClock.Now().Add(oauthFlowTTL).Unix(),
+	}
+	if u, ok := requestUser(c); ok {
+		flow.Link = u.ID
+	}
+	setOAuthCookie(c, flow.encode(), int(oauthFlowTTL.Seconds()))
+
+	challenge := sha256.Sum256([]byte(codeVerifier(state)))
+	target := p.authURL + "?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURI()},
+		"scope":                 {p.scope},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	if strings.Contains(c.Get(fiber.HeaderAccept), fiber.MIMEApplicationJSON) {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"url":     target,
+		})
+	}
+	return c.Redirect(target, 302)
+}
+
+// finishOAuth completes a login when the provider sends the browser back:
+// GET /api/auth/oauth/<provider>/callback?code=…&state=…
+func finishOAuth(c *fiber.Ctx) error {
+	p, ok := oauthProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Unknown login provider",
+			"success": false,
+		})
+	}
+	flow, ok := decodeOAuthFlow(c.Cookies(oauthCookie))
+	setOAuthCookie(c, "", -1)
+	if !ok || flow.Provider != p.name || c.Query("state") != flow.State {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Login expired or was started in another browser, please try again",
+			"success": false,
+		})
+	}
+	if e := c.Query("error"); e != "" {
+		if e == "access_denied" {
+			return oauthFailed(c, flow, 401, "Login was cancelled")
+		}
+		return oauthFailed(c, flow, 401, "Login failed: "+e)
+	}
+	code := c.Query("code")
+	if code == "" {
+		return oauthFailed(c, flow, 400, "Missing authorization code")
+	}
+
+	token, err := p.exchange(c.Context(), code, codeVerifier(flow.State))
+	var identity oauthIdentity
+	if err == nil {
+		identity, err = p.identify(c.Context(), token)
+	}
+	if err != nil {
+		log.Printf("Error completing %s login: %v", p.name, err)
+		return oauthFailed(c, flow, 502, "Login with "+p.name+" failed")
+	}
+
+	u, err := oauthUser(p, identity, flow.Link, flow.Invite)
+	if errors.Is(err, errIdentityLinked) {
+		return oauthFailed(c, flow, 409, "This "+p.name+" account is linked to another user")
+	}
+	if errors.Is(err, errInvalidToken) {
+		return oauthFailed(c, flow, 401, "The account to link no longer exists")
+	}
+	if errors.Is(err, errRegistrationClosed) {
+		return oauthFailed(c, flow, 403, "Registration is closed")
+	}
+	if errors.Is(err, errInvalidInvite) {
+		return oauthFailed(c, flow, 403, "Invalid or expired invite")
+	}
+	if err != nil {
+		log.Printf("Error saving %s login: %v", p.name, err)
+		return oauthFailed(c, flow, 500, "Failed to save login")
+	}
+	c.Locals("user", u)
+	if flow.Link != "" {
+		audit(c, "link_"+p.name, u.Username)
+	} else {
+		audit(c, "login_"+p.name, u.Username)
+	}
+
+	if flow.Next == "" {
+		return sendToken(c, 200, u)
+	}
+	access, exp, err := issueToken(u)
+	if err != nil {
+		log.Printf("Error issuing token for %s: %v", u.Username, err)
+		return oauthFailed(c, flow, 500, "Failed to issue token")
+	}
+	return c.Redirect(flow.Next+"#"+url.Values{
+		"access_token": {access},
+		"token_type":   {"Bearer"},
+		"expires_at":   {exp.UTC().Format(time.RFC3339)},
+	}.Encode(), 303)
+}
+
+// oauthFailed reports a failed login on the ?next= page, in the fragment
+// as error=…, or as JSON without one
+func oauthFailed(c *fiber.Ctx, flow oauthFlow, status int, message string) error {
+	if flow.Next != "" {
+		return c.Redirect(flow.Next+"#"+url.Values{"error": {message}}.Encode(), 303)
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error":   message,
+		"success": false,
+	})
+}
+
+// oauthUser finds or makes the user for a provider's account: the one to
+// link it to, the one it was linked to before, or a new one while
+// registration is open or with an invite
+func oauthUser(p *oauthProvider, identity oauthIdentity, link, invite string) (user, error) {
+	key := p.name + ":" + identity.ID
+	if link != "" {
+		u, ok := metadata.user(link)
+		if !ok {
+			return user{}, errInvalidToken
+		}
+		return u, metadata.linkIdentity(key, u.ID)
+	}
+	if u, ok := metadata.identityUser(key); ok {
+		return u, nil
+	}
+	if registrationOpen {
+		invite = ""
+	} else if invite == "" {
+		return user{}, errRegistrationClosed
+	}
+
+	id, err := newID(8)
+	if err != nil {
+		return user{}, err
+	}
+	// The account has no password, so it can only log in through providers
+	u := user{ID: id, Created: serverClock.Now().Unix()}
+	base := oauthUsername(identity.Username)
+	for n := 1; ; n++ {
+		u.Username = base
+		if n > 1 {
+			suffix := "-" + strconv.Itoa(n)
+			u.Username = base[:min(len(base), maxUsernameLength-len(suffix))] + suffix
+		}
+		err := metadata.createUser(u, invite, key)
+		if errors.Is(err, errIdentityLinked) {
+			// A login in another tab got there first
+			if u, ok := metadata.identityUser(key); ok {
+				return u, nil
+			}
+		}
+		if !errors.Is(err, errUsernameTaken) || n == 100 {
+			return u, err
+		}
+	}
+}
+
+// oauthUsername makes a valid username from a provider's: an email's
+// local part, with characters usernames can't have dropped
+func oauthUsername(name string) string {
+	name, _, _ = strings.Cut(name, "@")
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case (r == '.' || r == '-' || r == '_' || r == ' ') && b.Len() > 0:
+			if r == ' ' {
+				r = '.'
+			}
+			b.WriteRune(r)
+		}
+		if b.Len() == maxUsernameLength {
+			break
+		}
+	}
+	if b.Len() < minUsernameLength {
+		return "user"
+	}
+	return b.String()
+}
+
+// identityUser loads the user an identity is linked to
+func (s *metadataStore) identityUser(identity string) (user, bool) {
+	var id []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		id = append(id, tx.Bucket(identitiesBucket).Get([]byte(identity))...)
+		return nil
+	})
+	if id == nil {
+		return user{}, false
+	}
+	return s.user(string(id))
+}
+
+// linkIdentity links an identity to a user, failing with
+// errIdentityLinked if it is linked to someone else
+func (s *metadataStore) linkIdentity(identity, userID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return linkIdentityTx(tx, identity, userID)
+	})
+}
+
+func linkIdentityTx(tx *bolt.Tx, identity, userID string) error {
+	bucket := tx.Bucket(identitiesBucket)
+	if linked := bucket.Get([]byte(identity)); linked != nil {
+		if string(linked) == userID {
+			return nil
+		}
+		return errIdentityLinked
+	}
+	return bucket.Put([]byte(identity), []byte(userID))
+}
+
+// exchange trades an authorization code for an access token with the
+// provider
+func (p *oauthProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURI()},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	// Errors come with 400 from some providers and 200 from others
+	status, err := fetchOAuthJSON(req, &res)
+	switch {
+	case err != nil:
+		return "", err
+	case res.Error != "":
+		return "", fmt.Errorf("token endpoint: %s: %s", res.Error, res.Description)
+	case status != http.StatusOK || res.AccessToken == "":
+		return "", fmt.Errorf("token endpoint returned %d without a token", status)
+	}
+	return res.AccessToken, nil
+}
+
+// fetchOAuthJSON makes a request to a provider and decodes its JSON
+// response, returning the status code
+func fetchOAuthJSON(req *http.Request, dst any) (int, error) {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst); err != nil {
+		return resp.StatusCode, fmt.Errorf("%s returned %d: %w", req.URL.Host, resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// getOAuthProfile fetches a provider's profile of the account a token
+// belongs to
+func getOAuthProfile(ctx context.Context, endpoint, token string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	status, err := fetchOAuthJSON(req, dst)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("%s returned %d", req.URL.Host, status)
+	}
+	return err
+}
+
+// identifyGoogle reads the OpenID Connect profile of a Google account
+func identifyGoogle(ctx context.Context, token string) (oauthIdentity, error) {
+	var profile struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+		Name    string `json:"name"`
+	}
+	if err := getOAuthProfile(ctx, "https://openidconnect.googleapis.com/v1/userinfo", token, &profile); err != nil {
+		return oauthIdentity{}, err
+	}
+	if profile.Subject == "" {
+		return oauthIdentity{}, errors.New("google profile has no subject")
+	}
+	name := profile.Email
+	if name == "" {
+		name = profile.Name
+	}
+	return oauthIdentity{ID: profile.Subject, Username: name}, nil
+}
+
+// identifyGitHub reads the profile of a GitHub account. Its numeric ID is
+// kept rather than the login, which can be changed.
+func identifyGitHub(ctx context.Context, token string) (oauthIdentity, error) {
+	var profile struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := getOAuthProfile(ctx, "https://api.github.com/user", token, &profile); err != nil {
+		return oauthIdentity{}, err
+	}
+	if profile.ID == 0 {
+		return oauthIdentity{}, errors.New("github profile has no ID")
+	}
+	return oauthIdentity{ID: strconv.FormatInt(profile.ID, 10), Username: profile.Login}, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily window in local time, given as minutes after
+// midnight. A window whose end is before its start runs past midnight.
+type timeWindow struct {
+	start, end int
+}
+
+// processingWindow restricts variant generation to off-peak hours, set via
+// AFROBASE_PROCESSING_WINDOW (e.g. "01:00-06:00"). Uploads are stored
+// immediately either way; outside the window their variants wait. Nil
+// means process at any time.
+var processingWindow *timeWindow
+
+// parseTimeWindow parses "HH:MM-HH:MM"
+func parseTimeWindow(s string) (*timeWindow, error) {
+	from, to, found := strings.Cut(s, "-")
+	if !found {
+		return nil, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return nil, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return nil, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+	}
+	w := &timeWindow{start: start.Hour()*60 + start.Minute(), end: end.Hour()*60 + end.Minute()}
+	if w.start == w.end {
+		return nil, fmt.Errorf("window %q is empty", s)
+	}
+	return w, nil
+}
+
+// contains reports whether t falls inside the window
+func (w *timeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// nextOpen returns the next time at or after t that the window opens
+func (w *timeWindow) nextOpen(t time.Time) time.Time {
+	open := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+	if open.Before(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
+
+// processingAllowed reports whether heavy processing may run now
+func processingAllowed() bool {
+	return processingWindow == nil || processingWindow.contains(serverClock.Now())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// imagePageURL is the shareable landing page of an image
+func imagePageURL(name string) string {
+	return publicBaseURL + "/i/" + imageID(name) + "/page"
+}
+
+// getImagePage renders a minimal landing page for an image, with the Open
+// Graph and Twitter card tags link previews need. A private image's page
+// needs a signed link, like the image itself. Opening an unlisted image's
+// page counts as a view of it.
+func getImagePage(c *fiber.Ctx) error {
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).SendString("Image not found")
+	}
+	if denied, err := privateDenied(c, name); denied {
+		return err
+	}
+	// A private image's page is opened with its signature, which its
+	// image URLs carry on
+	sign := func(p string) string { return p }
+	if isPrivate(name) {
+		exp, _ := strconv.ParseInt(c.Query("exp"), 10, 64)
+		sign = func(p string) string { return signedPath(p, imageID(name), exp) }
+		c.Set("X-Robots-Tag", "noindex")
+	}
+	if isUnlisted(name) {
+		metadata.countView(name)
+		c.Set("X-Robots-Tag", "noindex")
+	}
+
+	imageURL := publicBaseURL + sign(displayPath(name))
+	previewURL := imageURL
+	if variants, _ := variantURLs(name); variants["800"] != "" {
+		previewURL = publicBaseURL + sign(variants["800"])
+	}
+	meta := imageInfo(name)
+	description := meta.Description
+	if description == "" {
+		description = meta.Title
+	}
+	pageURL := imagePageURL(name)
+
+	var page strings.Builder
+	err := imagePageTemplate.Execute(&page, map[string]string{
+		"Title":       meta.Title,
+		"Description": description,
+		"ImageURL":    imageURL,
+		"PreviewURL":  previewURL,
+		"OriginalURL": publicBaseURL + sign("/uploads/"+name),
+		"PageURL":     pageURL,
+		"Embed":       embedHTML(pageURL, previewURL, meta.Title),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Type("html")
+	return c.SendString(page.String())
+}
+
+var imagePageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · AfroBase</title>
+<meta name="description" content="{{.Description}}">
+<link rel="canonical" href="{{.PageURL}}">
+<meta property="og:type" content="article">
+<meta property="og:site_name" content="AfroBase">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.PageURL}}">
+<meta property="og:image" content="{{.PreviewURL}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<meta name="twitter:image" content="{{.PreviewURL}}">
+<style>
+body { font-family: 'Ubuntu', Arial, sans-serif; background: #1a0e0a; color: #f4f1eb; max-width: 960px; margin: 0 auto; padding: 20px; }
+h1 { color: #DAA520; }
+img { max-width: 100%; border-radius: 12px; }
+a { color: #DAA520; }
+textarea { width: 100%; height: 4em; background: #2d1810; color: #f4f1eb; border: 1px solid #DAA520; border-radius: 6px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<a href="{{.ImageURL}}"><img src="{{.PreviewURL}}" alt="{{.Title}}"></a>
+<p>{{.Description}}</p>
+<p><a href="{{.OriginalURL}}" download>Download original</a></p>
+<h2>Embed</h2>
+<textarea readonly onclick="this.select()">{{.Embed}}</textarea>
+</body>
+</html>
+`))
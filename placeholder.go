@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// maxPlaceholderSide keeps placeholder generation cheap
+const maxPlaceholderSide = 4000
+
+// getPlaceholder generates a solid PNG for /placeholder/WIDTHxHEIGHT with
+// optional ?bg= and ?fg= hex colours and ?text= (defaults to the size)
+func getPlaceholder(c *fiber.Ctx) error {
+	width, height, ok := parseSize(c.Params("size"))
+	if !ok || width > maxPlaceholderSide || height > maxPlaceholderSide {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   fmt.Sprintf("Size must be WIDTHxHEIGHT, up to %dx%d", maxPlaceholderSide, maxPlaceholderSide),
+			"success": false,
+		})
+	}
+
+	bg, err := parseHexColor(c.Query("bg", "cccccc"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid bg colour",
+			"success": false,
+		})
+	}
+	fg, err := parseHexColor(c.Query("fg", "555555"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid fg colour",
+			"success": false,
+		})
+	}
+	text := c.Query("text", fmt.Sprintf("%dx%d", width, height))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	drawCenteredText(img, text, fg)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	// The output depends only on the URL, so it can be cached forever
+	c.Set("Cache-Control", "public, max-age=31536000, immutable")
+	c.Type("png")
+	return c.Send(buf.Bytes())
+}
+
+// parseSize parses "400x300"
+func parseSize(s string) (int, int, bool) {
+	w, h, found := strings.Cut(strings.ToLower(s), "x")
+	if !found {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width < 1 {
+		return 0, 0, false
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height < 1 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// parseHexColor parses "rgb" or "rrggbb", with or without a leading #
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// drawCenteredText renders text with the built-in bitmap font and scales
+// it up to about half the image width, so it stays legible at any size
+func drawCenteredText(dst *image.RGBA, text string, fg color.Color) {
+	face := basicfont.Face7x13
+	textWidth := font.MeasureString(face, text).Ceil()
+	if textWidth == 0 {
+		return
+	}
+	textHeight := face.Metrics().Height.Ceil()
+
+	src := image.NewRGBA(image.Rect(0, 0, textWidth, textHeight))
+	drawer := &font.Drawer{
+		Dst:  src,
+		Src:  image.NewUniform(fg),
+		Face: face,
+		Dot:  fixed.P(0, face.Metrics().Ascent.Ceil()),
+	}
+	drawer.DrawString(text)
+
+	bounds := dst.Bounds()
+	scale := max(1, min(bounds.Dx()/2/textWidth, bounds.Dy()/2/textHeight))
+	w, h := textWidth*scale, textHeight*scale
+	x, y := (bounds.Dx()-w)/2, (bounds.Dy()-h)/2
+	draw.NearestNeighbor.Scale(dst, image.Rect(x, y, x+w, y+h), src, src.Bounds(), draw.Over, nil)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// Processing pool limits keep transforms from starving request handling on
+// a shared host:
+//
+//	AFROBASE_MAX_TRANSFORMS   transforms running at once (default: half the CPUs)
+//	AFROBASE_TRANSFORM_MEMORY estimated bytes one transform may use (default: unlimited)
+//	AFROBASE_WORKER_NICE      nice level of background variant workers (Linux only)
+var (
+	maxTransforms   = max(1, loadPoolLimit("AFROBASE_MAX_TRANSFORMS", runtime.NumCPU()/2))
+	transformMemory = loadPoolLimit("AFROBASE_TRANSFORM_MEMORY", 0)
+	workerNice      = loadPoolLimit("AFROBASE_WORKER_NICE", 0)
+)
+
+func loadPoolLimit(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatal("Invalid "+key+": ", v)
+	}
+	return n
+}
+
+// limitedProcessor runs at most maxTransforms resizes at a time, across the
+// variant workers and request handlers, and refuses transforms estimated to
+// need more than transformMemory
+type limitedProcessor struct {
+	Processor
+	slots chan struct{}
+}
+
+func newLimitedProcessor(p Processor) Processor {
+	return limitedProcessor{Processor: p, slots: make(chan struct{}, maxTransforms)}
+}
+
+func (p limitedProcessor) Resize(data []byte, opts TransformOptions) ([]byte, string, error) {
+	if transformMemory > 0 {
+		if need, err := estimateTransformMemory(data, opts); err == nil && need > transformMemory {
+			return nil, "", fmt.Errorf("transform needs about %d bytes, limit is %d", need, transformMemory)
+		}
+	}
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+	return p.Processor.Resize(data, opts)
+}
+
+// estimateTransformMemory approximates the peak memory of a resize from the
+// image header: the decoded source plus the RGBA output
+func estimateTransformMemory(data []byte, opts TransformOptions) (int, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	bytesPerPixel := 4
+	if _, depth := colorInfo(config.ColorModel); depth == 16 {
+		bytesPerPixel = 8
+	}
+	width, height := fitWithin(config.Width, config.Height, opts.Width, opts.Height)
+	return config.Width*config.Height*bytesPerPixel + width*height*4, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/gofiber/fiber/v2"
+)
+
+// printDPIs are the resolutions print sizes are reported at: screen,
+// newspaper/draft and photo quality
+var printDPIs = []int{72, 150, 300}
+
+// printSizes reports how large an image prints at each of printDPIs
+func printSizes(width, height int) map[string]map[string]float64 {
+	sizes := make(map[string]map[string]float64, len(printDPIs))
+	for _, dpi := range printDPIs {
+		widthIn := float64(width) / float64(dpi)
+		heightIn := float64(height) / float64(dpi)
+		sizes[fmt.Sprint(dpi)] = map[string]float64{
+			"width_in":  round2(widthIn),
+			"height_in": round2(heightIn),
+			"width_cm":  round2(widthIn * 2.54),
+			"height_cm": round2(heightIn * 2.54),
+		}
+	}
+	return sizes
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Contact sheet layout on A4 portrait, in millimetres
+const (
+	sheetMargin  = 10.0
+	sheetColumns = 4
+	sheetCellH   = 55.0
+	sheetThumb   = 40.0
+)
+
+// getContactSheet exports images as a PDF grid of thumbnails with
+// captions for review. ?album=<id> makes it a sheet of that album, in the
+// album's order, and ?ids= (comma-separated) limits it to specific images;
+// otherwise the whole gallery is included. ?scope= limits it as for GET
+// /api/images. Private images are left out unless the request may see them,
+// and unlisted ones too unless picked by ?ids=.
+func getContactSheet(c *fiber.Ctx) error {
+	owner, err := listingOwner(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+
+	heading := "AfroBase contact sheet"
+	if id := c.Query("album"); id != "" {
+		a, ok := metadata.album(id)
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{
+				"error":   "Album not found",
+				"success": false,
+			})
+		}
+		stored := make(map[string]os.FileInfo, len(files))
+		for _, file := range files {
+			stored[file.Name()] = file
+		}
+		files = files[:0]
+		for _, name := range a.Images {
+			if file, ok := stored[name]; ok {
+				files = append(files, file)
+			}
+		}
+		heading = a.Name
+	}
+
+	if ids := c.Query("ids"); ids != "" {
+		wanted := make(map[string]bool)
+		for _, id := range strings.Split(ids, ",") {
+			wanted[strings.TrimSpace(id)] = true
+		}
+		selected := files[:0]
+		for _, file := range files {
+			if wanted[imageID(file.Name())] {
+				selected = append(selected, file)
+			}
+		}
+		files = selected
+	}
+	visible := files[:0]
+	for _, file := range files {
+		if owner != "" && imageInfo(file.Name()).Owner != owner {
+			continue
+		}
+		// Sheets of chosen images may include unlisted ones
+		if c.Query("ids") == "" && hiddenFrom(c, file.Name()) {
+			continue
+		}
+		if !isPrivate(file.Name()) || seesPrivate(c, file.Name()) {
+			visible = append(visible, file)
+		}
+	}
+	files = visible
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetAutoPageBreak(false, sheetMargin)
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, pageHeight := pdf.GetPageSize()
+	cellW := (pageWidth - 2*sheetMargin) / sheetColumns
+	top := sheetMargin + 12
+	y := pageHeight
+
+	for i, file := range files {
+		col := i % sheetColumns
+		if col == 0 {
+			y += sheetCellH
+		}
+		if y+sheetCellH > pageHeight-sheetMargin {
+			pdf.AddPage()
+			pdf.SetFont("Helvetica", "B", 12)
+			pdf.SetXY(sheetMargin, sheetMargin)
+			pdf.CellFormat(0, 8, translate(heading)+" - "+serverClock.Now().Format("2 Jan 2006"), "", 0, "L", false, 0, "")
+			y = top
+		}
+		x := sheetMargin + float64(col)*cellW
+
+		if err := placeThumbnail(pdf, file.Name(), x+(cellW-sheetThumb)/2, y); err != nil {
+			log.Printf("Contact sheet: skipping thumbnail for %s: %v", file.Name(), err)
+			pdf.SetDrawColor(200, 200, 200)
+			pdf.Rect(x+(cellW-sheetThumb)/2, y, sheetThumb, sheetThumb, "D")
+		}
+
+		caption := imageInfo(file.Name()).Title
+		if width, height, err := imageDimensions(file.Name()); err == nil {
+			caption += fmt.Sprintf("\n%dx%d px", width, height)
+		}
+		pdf.SetFont("Helvetica", "", 7)
+		pdf.SetXY(x, y+sheetThumb+1)
+		pdf.MultiCell(cellW, 3.5, translate(caption), "", "C", false)
+	}
+
+	if len(files) == 0 {
+		pdf.AddPage()
+		pdf.SetFont("Helvetica", "", 12)
+		pdf.CellFormat(0, 10, "No images", "", 0, "C", false, 0, "")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		log.Printf("Error rendering contact sheet: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to render contact sheet",
+			"success": false,
+		})
+	}
+
+	c.Type("pdf")
+	c.Set("Content-Disposition", `inline; filename="contact-sheet.pdf"`)
+	return c.Send(buf.Bytes())
+}
+
+// placeThumbnail draws an upload's small variant centred in the thumbnail
+// box at x, y, resizing it now if no variant has been generated yet
+func placeThumbnail(pdf *fpdf.Fpdf, name string, x, y float64) error {
+	var data []byte
+	var ext string
+	if variants, _ := variantURLs(name); variants["200"] != "" {
+		var err error
+		ext = filepath.Ext(variants["200"])
+		data, err = readUpload(variantKey(variants["200"]))
+		if err != nil {
+			return err
+		}
+	} else {
+		original, err := readDisplaySource(name)
+		if err != nil {
+			return err
+		}
+		if err := checkPixelBudget(original); err != nil {
+			return err
+		}
+		data, ext, err = imageProcessor.Resize(original, TransformOptions{Width: 200, Height: 200})
+		if err != nil {
+			return err
+		}
+	}
+
+	imageType := "JPG"
+	if ext == ".png" {
+		imageType = "PNG"
+	}
+	info := pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: imageType}, bytes.NewReader(data))
+	if !pdf.Ok() {
+		// fpdf errors stick until cleared, and would fail the whole sheet
+		err := pdf.Error()
+		pdf.ClearError()
+		return err
+	}
+
+	w, h := info.Extent()
+	scale := math.Min(sheetThumb/w, sheetThumb/h)
+	w, h = w*scale, h*scale
+	pdf.ImageOptions(name, x+(sheetThumb-w)/2, y+(sheetThumb-h)/2, w, h, false, fpdf.ImageOptions{ImageType: imageType}, 0, "")
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+
+	"golang.org/x/image/draw"
+)
+
+// defaultJPEGQuality is used when TransformOptions.Quality is unset
+const defaultJPEGQuality = 85
+
+// TransformOptions describe a resize. Width and Height bound the output;
+// a zero on either side means that side follows the aspect ratio. With Crop
+// set (and both sides given) the output takes the box's aspect ratio
+// instead, cropping the overflow around Focus, or the centre if it is nil.
+// Format asks for "webp" or "avif" output instead of the default of JPEG,
+// or PNG for images with transparency.
+type TransformOptions struct {
+	Width   int
+	Height  int
+	Quality int
+	Crop    bool
+	Focus   *focalPoint
+	Format  string
+}
+
+// focalPoint is a point in an image as fractions of its width and height
+// from the top left, so {0.5, 0.5} is the centre
+type focalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Processor transforms encoded images. Implementations must be safe for
+// concurrent use.
+type Processor interface {
+	// Name identifies the backend in logs
+	Name() string
+	// Resize scales the image to fit (or, with Crop, fill) the requested
+	// box, never upscaling, and returns the re-encoded bytes with their
+	// file extension
+	Resize(data []byte, opts TransformOptions) ([]byte, string, error)
+	// Encodes reports whether Resize can produce a TransformOptions.Format
+	Encodes(format string) bool
+}
+
+// processorBackends maps AFROBASE_PROCESSOR values to constructors.
+// Optional backends register themselves from build-tagged files.
+var processorBackends = map[string]func() (Processor, error){
+	"go": func() (Processor, error) { return goProcessor{}, nil },
+}
+
+// imageProcessor is the backend selected at startup
+var imageProcessor Processor = goProcessor{}
+
+// newProcessor returns the named backend, or the fastest one compiled in
+// when name is empty. Anything other than the pure Go backend is wrapped so
+// that failures fall back to pure Go rather than failing the request.
+func newProcessor(name string) Processor {
+	if name == "" {
+		name = "go"
+		if _, ok := processorBackends["vips"]; ok {
+			name = "vips"
+		}
+	}
+	if name == "go" {
+		return goProcessor{}
+	}
+
+	constructor, ok := processorBackends[name]
+	if !ok {
+		log.Printf("Image processor %q is not available in this build, using pure Go", name)
+		return goProcessor{}
+	}
+	p, err := constructor()
+	if err != nil {
+		log.Printf("Failed to start %s image processor, using pure Go: %v", name, err)
+		return goProcessor{}
+	}
+	return fallbackProcessor{primary: p, fallback: goProcessor{}}
+}
+
+// fallbackProcessor retries failed transforms on a second backend
+type fallbackProcessor struct {
+	primary  Processor
+	fallback Processor
+}
+
+func (p fallbackProcessor) Name() string {
+	return p.primary.Name()
+}
+
+func (p fallbackProcessor) Encodes(format string) bool {
+	return p.primary.Encodes(format)
+}
+
+// Resize falls back to the default format if the fallback can't encode the
+// one asked for, so callers go by the extension returned
+func (p fallbackProcessor) Resize(data []byte, opts TransformOptions) ([]byte, string, error) {
+	out, ext, err := p.primary.Resize(data, opts)
+	if err != nil {
+		log.Printf("%s resize failed, falling back to %s: %v", p.primary.Name(), p.fallback.Name(), err)
+		if !p.fallback.Encodes(opts.Format) {
+			opts.Format = ""
+		}
+		return p.fallback.Resize(data, opts)
+	}
+	return out, ext, nil
+}
+
+// goProcessor is the pure Go backend built on golang.org/x/image
+type goProcessor struct{}
+
+func (goProcessor) Name() string {
+	return "go"
+}
+
+// Encodes is true only of the default formats; the Go standard library and
+// golang.org/x/image can decode WebP but encode neither WebP nor AVIF
+func (goProcessor) Encodes(format string) bool {
+	return format == ""
+}
+
+func (goProcessor) Resize(data []byte, opts TransformOptions) ([]byte, string, error) {
+	if opts.Format != "" {
+		return nil, "", fmt.Errorf("the go image processor can't encode %s", opts.Format)
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	bounds := src.Bounds()
+	if opts.Crop && opts.Width > 0 && opts.Height > 0 {
+		bounds = coverCrop(bounds, opts.Width, opts.Height, opts.Focus)
+	}
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), opts.Width, opts.Height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	return encodeImage(dst, keepsAlpha(src, format), opts.Quality)
+}
+
+// imageDimensions reads just enough of a stored upload to report its size
+func imageDimensions(name string) (int, int, error) {
+	header, err := imageHeader(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return header.Width, header.Height, nil
+}
+
+// fitWithin scales width x height to fit inside maxWidth x maxHeight while
+// keeping the aspect ratio. Zero bounds are unconstrained and images are
+// never enlarged.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && float64(height)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(height)
+	}
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
+
+// coverCrop returns the largest region of bounds with the aspect ratio of
+// a width x height box, centred on focus as nearly as the edges allow, or
+// on the centre of bounds if focus is nil
+func coverCrop(bounds image.Rectangle, width, height int, focus *focalPoint) image.Rectangle {
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	cropW, cropH := srcW, srcW*height/width
+	if cropH > srcH {
+		cropW, cropH = srcH*width/height, srcH
+	}
+	x, y := (srcW-cropW)/2, (srcH-cropH)/2
+	if focus != nil {
+		x = min(max(0, int(focus.X*float64(srcW)+0.5)-cropW/2), srcW-cropW)
+		y = min(max(0, int(focus.Y*float64(srcH)+0.5)-cropH/2), srcH-cropH)
+	}
+	x += bounds.Min.X
+	y += bounds.Min.Y
+	return image.Rect(x, y, x+max(1, cropW), y+max(1, cropH))
+}
+
+// keepsAlpha reports whether an output derived from img needs a format that
+// supports transparency
+func keepsAlpha(img image.Image, format string) bool {
+	if format == "jpeg" {
+		return false
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return true
+}
+
+// encodeImage writes img as PNG when alpha must be preserved and as JPEG
+// otherwise
+func encodeImage(img image.Image, alpha bool, quality int) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if alpha {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), ".png", nil
+	}
+
+	if quality <= 0 {
+		quality = defaultJPEGQuality
+	}
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), ".jpg", nil
+}
@@ -0,0 +1,137 @@
+//go:build vips
+
+package main
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// The libvips backend is compiled in with `go build -tags vips` and needs
+// libvips (8.10+) and its headers installed on the build host.
+func init() {
+	processorBackends["vips"] = newVipsProcessor
+}
+
+// vipsProcessor uses libvips, which shrinks on load and runs several times
+// faster than the pure Go backend on large images
+type vipsProcessor struct{}
+
+func newVipsProcessor() (Processor, error) {
+	vips.LoggingSettings(nil, vips.LogLevelWarning)
+	vips.Startup(nil)
+	return vipsProcessor{}, nil
+}
+
+func (vipsProcessor) Name() string {
+	return "vips"
+}
+
+// Encodes covers WebP and AVIF, provided libvips was built with libwebp and
+// libheif
+func (vipsProcessor) Encodes(format string) bool {
+	switch format {
+	case "":
+		return true
+	case "webp":
+		return vips.IsTypeSupported(vips.ImageTypeWEBP)
+	case "avif":
+		return vips.IsTypeSupported(vips.ImageTypeAVIF)
+	}
+	return false
+}
+
+func (vipsProcessor) Resize(data []byte, opts TransformOptions) ([]byte, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	crop := vips.InterestingNone
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+	if opts.Crop && opts.Width > 0 && opts.Height > 0 {
+		crop = vips.InterestingCentre
+		bounds = coverCrop(bounds, opts.Width, opts.Height, nil)
+	}
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), opts.Width, opts.Height)
+
+	var img *vips.ImageRef
+	if crop == vips.InterestingCentre && opts.Focus != nil {
+		img, err = focusedThumbnail(data, opts)
+	} else {
+		img, err = vips.NewThumbnailWithSizeFromBuffer(data, width, height, crop, vips.SizeDown)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer img.Close()
+
+	switch opts.Format {
+	case "webp":
+		params := vips.NewWebpExportParams()
+		if opts.Quality > 0 {
+			params.Quality = opts.Quality
+		}
+		out, _, err := img.ExportWebp(params)
+		if err != nil {
+			return nil, "", err
+		}
+		return out, ".webp", nil
+	case "avif":
+		params := vips.NewAvifExportParams()
+		if opts.Quality > 0 {
+			params.Quality = opts.Quality
+		}
+		out, _, err := img.ExportAvif(params)
+		if err != nil {
+			return nil, "", err
+		}
+		return out, ".avif", nil
+	}
+
+	if img.HasAlpha() {
+		out, _, err := img.ExportPng(vips.NewPngExportParams())
+		if err != nil {
+			return nil, "", err
+		}
+		return out, ".png", nil
+	}
+
+	params := vips.NewJpegExportParams()
+	params.Quality = defaultJPEGQuality
+	if opts.Quality > 0 {
+		params.Quality = opts.Quality
+	}
+	out, _, err := img.ExportJpeg(params)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, ".jpg", nil
+}
+
+// focusedThumbnail crops around opts.Focus before shrinking, since libvips
+// can only crop a thumbnail around the centre or what it finds interesting.
+// The crop is taken after autorotation, as focal points are set on the
+// image as displayed.
+func focusedThumbnail(data []byte, opts TransformOptions) (*vips.ImageRef, error) {
+	img, err := vips.NewImageFromBuffer(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.AutoRotate(); err != nil {
+		img.Close()
+		return nil, err
+	}
+	bounds := coverCrop(image.Rect(0, 0, img.Width(), img.Height()), opts.Width, opts.Height, opts.Focus)
+	if err := img.ExtractArea(bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy()); err != nil {
+		img.Close()
+		return nil, err
+	}
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), opts.Width, opts.Height)
+	if err := img.Thumbnail(width, height, vips.InterestingNone); err != nil {
+		img.Close()
+		return nil, err
+	}
+	return img, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// uploadProfile constrains uploads made with ?profile=<name> and decides
+// which variants are generated for them. Profiles are loaded from the JSON
+// file named by AFROBASE_PROFILES, for example:
+//
+//	{
+//	  "avatar": {"max_bytes": 2097152, "aspect_ratio": "1:1", "crop": true, "sizes": [64, 128, 256]},
+//	  "banner": {"max_bytes": 10485760, "aspect_ratio": "16:9", "min_width": 1280, "sizes": [640, 1280, 1920]}
+//	}
+//
+// Sizes are variant widths; with an aspect ratio the variant boxes take
+// that shape, and crop fills them instead of fitting inside them.
+//
+// aspect_strategy additionally enforces the aspect ratio on the stored
+// original: "reject" refuses off-spec images, "crop" trims them around the
+// centre and "letterbox" pads them with letterbox_color (hex, default
+// black). Images within aspect_tolerance (a fraction, default 0.01) of the
+// ratio are accepted as they are.
+//
+// For print workflows color_spaces and bit_depths list what a profile
+// accepts, e.g. {"color_spaces": ["cmyk"], "bit_depths": [16]}, and
+// forbid_color_spaces lists what it refuses. Colour spaces are rgb, cmyk,
+// gray and indexed.
+//
+// formats replaces the global AFROBASE_ALLOWED_FORMATS policy for the
+// profile, e.g. {"formats": ["tiff", "dng"]} for an archive profile.
+//
+// keep_metadata stores uploads with their EXIF, IPTC and XMP metadata,
+// which is otherwise stripped.
+type uploadProfile struct {
+	MaxBytes        int64   `json:"max_bytes"`
+	MinWidth        int     `json:"min_width"`
+	MinHeight       int     `json:"min_height"`
+	AspectRatio     string  `json:"aspect_ratio"`
+	AspectStrategy  string  `json:"aspect_strategy"`
+	AspectTolerance float64 `json:"aspect_tolerance"`
+	LetterboxColor  string  `json:"letterbox_color"`
+	Crop            bool    `json:"crop"`
+	Sizes           []int   `json:"sizes"`
+
+	ColorSpaces       []string `json:"color_spaces"`
+	ForbidColorSpaces []string `json:"forbid_color_spaces"`
+	BitDepths         []int    `json:"bit_depths"`
+	Formats           []string `json:"formats"`
+	KeepMetadata      bool     `json:"keep_metadata"`
+
+	ratioW, ratioH int
+	letterbox      color.RGBA
+}
+
+// Aspect ratio strategies
+const (
+	aspectReject    = "reject"
+	aspectCrop      = "crop"
+	aspectLetterbox = "letterbox"
+)
+
+// uploadProfiles are the configured profiles by name
+var uploadProfiles = map[string]*uploadProfile{}
+
+// loadUploadProfiles reads and validates a profiles file
+func loadUploadProfiles(path string) (map[string]*uploadProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]*uploadProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+
+	for name, p := range profiles {
+		if name == "" || strings.ContainsAny(name, `/\.`) {
+			return nil, fmt.Errorf("invalid profile name %q", name)
+		}
+		if p.AspectRatio != "" {
+			w, h, ok := parseAspectRatio(p.AspectRatio)
+			if !ok {
+				return nil, fmt.Errorf("profile %s: invalid aspect_ratio %q", name, p.AspectRatio)
+			}
+			p.ratioW, p.ratioH = w, h
+		}
+		if p.Crop && p.AspectRatio == "" {
+			return nil, fmt.Errorf("profile %s: crop requires aspect_ratio", name)
+		}
+		switch p.AspectStrategy {
+		case "":
+		case aspectReject, aspectCrop, aspectLetterbox:
+			if p.AspectRatio == "" {
+				return nil, fmt.Errorf("profile %s: aspect_strategy requires aspect_ratio", name)
+			}
+		default:
+			return nil, fmt.Errorf("profile %s: unknown aspect_strategy %q", name, p.AspectStrategy)
+		}
+		if p.AspectTolerance <= 0 {
+			p.AspectTolerance = 0.01
+		}
+		if p.LetterboxColor == "" {
+			p.LetterboxColor = "000000"
+		}
+		if p.letterbox, err = parseHexColor(p.LetterboxColor); err != nil {
+			return nil, fmt.Errorf("profile %s: invalid letterbox_color %q", name, p.LetterboxColor)
+		}
+		if len(p.Sizes) == 0 {
+			p.Sizes = variantSizes
+		}
+		for _, size := range p.Sizes {
+			if size <= 0 {
+				return nil, fmt.Errorf("profile %s: invalid size %d", name, size)
+			}
+		}
+		for _, space := range append(p.ColorSpaces, p.ForbidColorSpaces...) {
+			if !validColorSpace(space) {
+				return nil, fmt.Errorf("profile %s: unknown color space %q", name, space)
+			}
+		}
+		if p.Formats != nil {
+			if p.Formats, err = parseFormats(p.Formats); err != nil {
+				return nil, fmt.Errorf("profile %s: %v", name, err)
+			}
+		}
+		for _, depth := range p.BitDepths {
+			if depth != 8 && depth != 16 {
+				return nil, fmt.Errorf("profile %s: invalid bit depth %d", name, depth)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+// parseAspectRatio parses "16:9"
+func parseAspectRatio(s string) (int, int, bool) {
+	w, h, found := strings.Cut(s, ":")
+	if !found {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return 0, 0, false
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// profileNames returns the configured profile names in a stable order
+func profileNames() []string {
+	names := make([]string, 0, len(uploadProfiles))
+	for name := range uploadProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// check validates decoded image data against the profile, returning the
+// HTTP status to reject it with
+func (p *uploadProfile) check(data []byte, config image.Config) (int, error) {
+	if p.MaxBytes > 0 && int64(len(data)) > p.MaxBytes {
+		return 413, &ruleError{"max_bytes", fmt.Sprintf("image is %d bytes, profile allows %d", len(data), p.MaxBytes)}
+	}
+	if config.Width < p.MinWidth {
+		return 422, &ruleError{"min_width", fmt.Sprintf("image is %dpx wide, profile requires at least %dpx", config.Width, p.MinWidth)}
+	}
+	if config.Height < p.MinHeight {
+		return 422, &ruleError{"min_height", fmt.Sprintf("image is %dpx high, profile requires at least %dpx", config.Height, p.MinHeight)}
+	}
+
+	colorSpace, bitDepth := colorInfo(config.ColorModel)
+	if len(p.ColorSpaces) > 0 && !slices.Contains(p.ColorSpaces, colorSpace) {
+		return 422, &ruleError{"color_spaces", fmt.Sprintf("image is %s, profile requires %s", colorSpace, strings.Join(p.ColorSpaces, " or "))}
+	}
+	if slices.Contains(p.ForbidColorSpaces, colorSpace) {
+		return 422, &ruleError{"forbid_color_spaces", fmt.Sprintf("image is %s, which the profile does not accept", colorSpace)}
+	}
+	if len(p.BitDepths) > 0 && !slices.Contains(p.BitDepths, bitDepth) {
+		return 422, &ruleError{"bit_depths", fmt.Sprintf("image is %d-bit, profile accepts bit depths %v", bitDepth, p.BitDepths)}
+	}
+	return 0, nil
+}
+
+// enforceAspect applies the profile's aspect strategy to image data,
+// returning the data to store (re-encoded if it was cropped or padded), or
+// the HTTP status to reject it with
+func (p *uploadProfile) enforceAspect(data []byte, width, height int) ([]byte, int, error) {
+	if p.AspectStrategy == "" {
+		return data, 0, nil
+	}
+	want := float64(p.ratioW) / float64(p.ratioH)
+	got := float64(width) / float64(height)
+	if math.Abs(got-want)/want <= p.AspectTolerance {
+		return data, 0, nil
+	}
+	if p.AspectStrategy == aspectReject {
+		return nil, 422, &ruleError{"aspect_ratio", fmt.Sprintf("image aspect ratio is %.3f, profile requires %s", got, p.AspectRatio)}
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 400, err
+	}
+
+	var out image.Image
+	if p.AspectStrategy == aspectCrop {
+		bounds := coverCrop(src.Bounds(), p.ratioW, p.ratioH, nil)
+		dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+		out = dst
+	} else {
+		// Pad the short side so the canvas has the profile's ratio
+		canvasW, canvasH := width, width*p.ratioH/p.ratioW
+		if canvasH < height {
+			canvasW, canvasH = height*p.ratioW/p.ratioH, height
+		}
+		if canvasW*canvasH > maxPixels {
+			return nil, 413, &ruleError{"pixel_budget", fmt.Sprintf("letterboxed image would be %dx%d, over the pixel budget", canvasW, canvasH)}
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, canvasW, canvasH))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(p.letterbox), image.Point{}, draw.Src)
+		offset := image.Pt((canvasW-width)/2, (canvasH-height)/2)
+		draw.Draw(dst, src.Bounds().Sub(src.Bounds().Min).Add(offset), src, src.Bounds().Min, draw.Over)
+		out = dst
+	}
+
+	// Keep JPEGs as JPEG and store everything else losslessly
+	encoded, _, err := encodeImage(out, format != "jpeg", 0)
+	if err != nil {
+		return nil, 500, err
+	}
+	return encoded, 0, nil
+}
+
+// variantSpecs are the variants generated for uploads using the profile
+func (p *uploadProfile) variantSpecs(name string) []variantSpec {
+	specs := make([]variantSpec, 0, len(p.Sizes))
+	for _, size := range p.Sizes {
+		height := size
+		if p.ratioW > 0 {
+			height = max(1, size*p.ratioH/p.ratioW)
+		}
+		key := strconv.Itoa(size)
+		specs = append(specs, variantSpec{
+			Key:    key,
+			Dir:    name + "-" + key,
+			Width:  size,
+			Height: height,
+			Crop:   p.Crop,
+		})
+	}
+	return specs
+}
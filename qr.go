@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+)
+
+// getImageQR returns a QR code of an image's landing page, for printed
+// posters. ?size= is the side in pixels and ?format= is png or svg.
+func getImageQR(c *fiber.Ctx) error {
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+
+	size := c.QueryInt("size", 256)
+	if size < 64 || size > 2048 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "size must be between 64 and 2048",
+			"success": false,
+		})
+	}
+
+	code, err := qrcode.New(imagePageURL(name), qrcode.Medium)
+	if err != nil {
+		return err
+	}
+
+	switch c.Query("format", "png") {
+	case "png":
+		png, err := code.PNG(size)
+		if err != nil {
+			return err
+		}
+		c.Type("png")
+		return c.Send(png)
+	case "svg":
+		c.Type("svg")
+		return c.SendString(qrSVG(code.Bitmap(), size))
+	default:
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "format must be png or svg",
+			"success": false,
+		})
+	}
+}
+
+// qrSVG renders a QR bitmap (which already includes the quiet zone) as an
+// SVG path of unit squares scaled to size
+func qrSVG(bitmap [][]bool, size int) string {
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	n := len(bitmap)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		size, size, n, n, path.String())
+}
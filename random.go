@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getRandomImage returns one random image, optionally restricted with
+// ?orientation=landscape|portrait|square. With ?redirect=true it answers
+// with a 302 to the image itself, so it can be used directly as an <img> src
+// for banner rotators.
+func getRandomImage(c *fiber.Ctx) error {
+	orientation := c.Query("orientation")
+	switch orientation {
+	case "", "landscape", "portrait", "square":
+	default:
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "orientation must be landscape, portrait or square",
+			"success": false,
+		})
+	}
+
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+
+	// Shuffle and take the first match, so only as many headers as needed
+	// are read
+	rand.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+	for _, file := range files {
+		if hiddenFrom(c, file.Name()) {
+			continue
+		}
+		if orientation != "" {
+			width, height, err := imageDimensions(file.Name())
+			if err != nil || imageOrientation(width, height) != orientation {
+				continue
+			}
+		}
+
+		if c.QueryBool("redirect") {
+			c.Set("Cache-Control", "no-store")
+			return c.Redirect(displayPath(file.Name()), 302)
+		}
+		return c.JSON(imageRecord(file))
+	}
+
+	return c.Status(404).JSON(fiber.Map{
+		"error":   "No matching image",
+		"success": false,
+	})
+}
+
+// imageOrientation classifies dimensions as landscape, portrait or square
+func imageOrientation(width, height int) string {
+	switch {
+	case width > height:
+		return "landscape"
+	case height > width:
+		return "portrait"
+	default:
+		return "square"
+	}
+}
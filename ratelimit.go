@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadLimits caps how much each client IP can upload. It is nil, and
+// uploads are unlimited, unless AFROBASE_UPLOAD_RATE_PER_MINUTE or
+// AFROBASE_UPLOAD_MB_PER_HOUR is set.
+var uploadLimits *uploadLimiter
+
+// authLimits caps how often each client IP can log in or register, to slow
+// down password guessing: defaultAuthRatePerMinute attempts unless
+// AFROBASE_AUTH_RATE_PER_MINUTE is set
+var authLimits *uploadLimiter
+
+const defaultAuthRatePerMinute = 10
+
+// neverAllowed is the wait allow returns for a body bigger than the whole
+// hourly allowance, which no amount of waiting would admit
+const neverAllowed time.Duration = -1
+
+// uploadLimiter keeps a bucket of requests and one of bytes per client.
+// Buckets start full and refill steadily, so a client can burst up to the
+// limit and then carries on at the limit's rate.
+type uploadLimiter struct {
+	mu                sync.Mutex
+	requestsPerMinute float64
+	bytesPerHour      float64
+	clients           map[string]*uploadAllowance
+}
+
+type uploadAllowance struct {
+	requests, bytes float64
+	updated         time.Time
+}
+
+// newUploadLimiter limits clients to requestsPerMinute uploads and
+// bytesPerHour bytes; zero leaves that dimension unlimited
+func newUploadLimiter(requestsPerMinute, bytesPerHour float64) *uploadLimiter {
+	l := &uploadLimiter{
+		requestsPerMinute: requestsPerMinute,
+		bytesPerHour:      bytesPerHour,
+		clients:           map[string]*uploadAllowance{},
+	}
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			l.forgetIdle(serverClock.Now())
+		}
+	}()
+	return l
+}
+
+// allow charges a client for requests and bytes if it has the allowance
+// for both, or returns how long it has to wait until it would, or
+// neverAllowed
+func (l *uploadLimiter) allow(ip string, requests, bytes float64, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.clients[ip]
+	if !ok {
+		a = &uploadAllowance{requests: l.requestsPerMinute, bytes: l.bytesPerHour, updated: now}
+		l.clients[ip] = a
+	}
+	l.refill(a, now)
+
+	if l.bytesPerHour > 0 && bytes > l.bytesPerHour {
+		return neverAllowed
+	}
+	var wait time.Duration
+	if l.requestsPerMinute > 0 && requests > a.requests {
+		wait = max(wait, time.Duration((requests-a.requests)/l.requestsPerMinute*float64(time.Minute)))
+	}
+	if l.bytesPerHour > 0 && bytes > a.bytes {
+		wait = max(wait, time.Duration((bytes-a.bytes)/l.bytesPerHour*float64(time.Hour)))
+	}
+	if wait > 0 {
+		return wait
+	}
+	a.requests -= requests
+	a.bytes -= bytes
+	return 0
+}
+
+func (l *uploadLimiter) refill(a *uploadAllowance, now time.Time) {
+	elapsed := now.Sub(a.updated)
+	if elapsed <= 0 {
+		return
+	}
+	a.requests = min(l.requestsPerMinute, a.requests+elapsed.Minutes()*l.requestsPerMinute)
+	a.bytes = min(l.bytesPerHour, a.bytes+elapsed.Hours()*l.bytesPerHour)
+	a.updated = now
+}
+
+// forgetIdle drops clients whose allowance has refilled completely, which
+// is the state new clients start in anyway
+func (l *uploadLimiter) forgetIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ip, a := range l.clients {
+		l.refill(a, now)
+		if a.requests >= l.requestsPerMinute && a.bytes >= l.bytesPerHour {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// limitUploads refuses uploads beyond a client's allowance with 429 and a
+// Retry-After, and those bigger than the whole hourly allowance with 413.
+// Requests that create an upload count against the request
+// limit; every byte of image data counts against the byte limit.
+func limitUploads(c *fiber.Ctx) error {
+	if uploadLimits == nil {
+		return c.Next()
+	}
+	var requests float64
+	if c.Method() == fiber.MethodPost {
+		requests = 1
+	}
+	bytes := float64(requestBodySize(c))
+	if wait := uploadLimits.allow(c.IP(), requests, bytes, serverClock.Now()); wait != 0 {
+		return rateLimited(c, wait)
+	}
+	return c.Next()
+}
+
+// limitAuth refuses login and registration attempts beyond a client's
+// allowance with 429 and a Retry-After
+func limitAuth(c *fiber.Ctx) error {
+	if authLimits == nil {
+		return c.Next()
+	}
+	if wait := authLimits.allow(c.IP(), 1, 0, serverClock.Now()); wait > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.Status(429).JSON(fiber.Map{
+			"error":   "Too many attempts, try again later",
+			"success": false,
+		})
+	}
+	return c.Next()
+}
+
+// rateLimited refuses an upload until the client's allowance covers it,
+// or for good if it never will
+func rateLimited(c *fiber.Ctx, wait time.Duration) error {
+	if wait == neverAllowed {
+		return c.Status(413).JSON(fiber.Map{
+			"error":   "Upload is larger than the hourly upload allowance",
+			"success": false,
+		})
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return c.Status(429).JSON(fiber.Map{
+		"error":   "Upload rate limit exceeded, try again later",
+		"success": false,
+	})
+}
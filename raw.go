@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RAW uploads are stored as received so the original stays downloadable,
+// and the JPEG preview the camera embedded in them is extracted to
+// previews/ in storage and used wherever the image is displayed or resized.
+
+// acceptRaw enables RAW (DNG/CR2/NEF) uploads, via AFROBASE_ACCEPT_RAW=on
+var acceptRaw = os.Getenv("AFROBASE_ACCEPT_RAW") == "on"
+
+// rawExtensions are the RAW formats recognised at upload
+var rawExtensions = map[string]bool{".dng": true, ".cr2": true, ".nef": true}
+
+// TIFF tags used to identify RAW files and find their previews
+const (
+	tagCompression     = 0x103
+	tagMake            = 0x10F
+	tagStripOffsets    = 0x111
+	tagStripByteCounts = 0x117
+	tagSubIFDs         = 0x14A
+	tagJPEGOffset      = 0x201
+	tagJPEGLength      = 0x202
+	tagExifIFD         = 0x8769
+	tagDNGVersion      = 0xC612
+)
+
+// Compression values of JPEG-compressed strips
+const (
+	compressionOldJPEG = 6
+	compressionJPEG    = 7
+)
+
+// maxDirectories bounds how many IFDs a preview search visits
+const maxDirectories = 64
+
+// tiffTypeSizes are the byte sizes of TIFF field types
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// tiffEntry is one field of a TIFF directory
+type tiffEntry struct {
+	typ   uint16
+	count int
+	data  []byte
+}
+
+// tiffFile walks the directories of a TIFF-based container
+type tiffFile struct {
+	data  []byte
+	order binary.ByteOrder
+	first uint32
+}
+
+func parseTIFF(data []byte) (*tiffFile, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	return &tiffFile{data: data, order: order, first: order.Uint32(data[4:8])}, true
+}
+
+// directory reads the IFD at offset, returning its fields by tag and the
+// offset of the next IFD
+func (t *tiffFile) directory(offset uint32) (map[uint16]tiffEntry, uint32, error) {
+	start := int(offset)
+	if start < 8 || start+2 > len(t.data) {
+		return nil, 0, errors.New("directory out of range")
+	}
+	// Each entry is 12 bytes: tag, type, count and a value or offset
+	n := int(t.order.Uint16(t.data[start:]))
+	end := start + 2 + n*12
+	if end+4 > len(t.data) {
+		return nil, 0, errors.New("directory out of range")
+	}
+
+	entries := make(map[uint16]tiffEntry, n)
+	for i := 0; i < n; i++ {
+		raw := t.data[start+2+i*12:]
+		typ := t.order.Uint16(raw[2:])
+		count := int64(t.order.Uint32(raw[4:]))
+		size, ok := tiffTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		length := count * int64(size)
+		var value []byte
+		if length <= 4 {
+			value = raw[8 : 8+length]
+		} else {
+			at := int64(t.order.Uint32(raw[8:]))
+			if at+length > int64(len(t.data)) {
+				continue
+			}
+			value = t.data[at : at+length]
+		}
+		entries[t.order.Uint16(raw)] = tiffEntry{typ: typ, count: int(count), data: value}
+	}
+	return entries, t.order.Uint32(t.data[end:]), nil
+}
+
+// uints decodes an integer field
+func (t *tiffFile) uints(e tiffEntry) []uint32 {
+	values := make([]uint32, 0, e.count)
+	for i := 0; i < e.count; i++ {
+		switch e.typ {
+		case 3:
+			values = append(values, uint32(t.order.Uint16(e.data[i*2:])))
+		case 4, 13:
+			values = append(values, t.order.Uint32(e.data[i*4:]))
+		default:
+			return values
+		}
+	}
+	return values
+}
+
+// rawFormat identifies a RAW file by its container, returning its file
+// extension, or "" for anything else (including plain TIFFs)
+func rawFormat(data []byte) string {
+	t, ok := parseTIFF(data)
+	if !ok {
+		return ""
+	}
+	if len(data) >= 10 && string(data[8:10]) == "CR" {
+		return ".cr2"
+	}
+	entries, _, err := t.directory(t.first)
+	if err != nil {
+		return ""
+	}
+	if _, ok := entries[tagDNGVersion]; ok {
+		return ".dng"
+	}
+	if maker, ok := entries[tagMake]; ok && strings.HasPrefix(strings.ToUpper(string(maker.data)), "NIKON") {
+		return ".nef"
+	}
+	return ""
+}
+
+// extractRawPreview returns the largest JPEG embedded in a RAW file. Every
+// directory reachable from the first (chained, SubIFDs and EXIF) is
+// searched, and candidates the JPEG decoder can't read, such as lossless
+// JPEG sensor data, are skipped.
+func extractRawPreview(data []byte) ([]byte, error) {
+	t, ok := parseTIFF(data)
+	if !ok {
+		return nil, errors.New("not a TIFF-based RAW file")
+	}
+
+	var best []byte
+	bestArea := 0
+	consider := func(offset, length uint32) {
+		start, end := int64(offset), int64(offset)+int64(length)
+		if length < 2 || end > int64(len(data)) || data[start] != 0xFF || data[start+1] != 0xD8 {
+			return
+		}
+		candidate := data[start:end]
+		config, err := jpeg.DecodeConfig(bytes.NewReader(candidate))
+		if err != nil {
+			return
+		}
+		if area := config.Width * config.Height; area > bestArea {
+			best, bestArea = candidate, area
+		}
+	}
+
+	queue := []uint32{t.first}
+	seen := make(map[uint32]bool)
+	for len(queue) > 0 && len(seen) < maxDirectories {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || seen[offset] {
+			continue
+		}
+		seen[offset] = true
+
+		entries, next, err := t.directory(offset)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, next)
+		for _, tag := range []uint16{tagSubIFDs, tagExifIFD} {
+			if e, ok := entries[tag]; ok {
+				queue = append(queue, t.uints(e)...)
+			}
+		}
+
+		if jpegOffset, ok := entries[tagJPEGOffset]; ok {
+			if jpegLength, ok := entries[tagJPEGLength]; ok {
+				offsets, lengths := t.uints(jpegOffset), t.uints(jpegLength)
+				if len(offsets) == 1 && len(lengths) == 1 {
+					consider(offsets[0], lengths[0])
+				}
+			}
+		}
+		if compression, ok := entries[tagCompression]; ok {
+			values := t.uints(compression)
+			if len(values) == 1 && (values[0] == compressionOldJPEG || values[0] == compressionJPEG) {
+				offsets, lengths := t.uints(entries[tagStripOffsets]), t.uints(entries[tagStripByteCounts])
+				if len(offsets) == 1 && len(lengths) == 1 {
+					consider(offsets[0], lengths[0])
+				}
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, errors.New("no embedded JPEG preview")
+	}
+	return best, nil
+}
+
+// isRaw reports whether an upload is a RAW original
+func isRaw(name string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// previewKey is where a RAW upload's extracted preview is stored
+func previewKey(name string) string {
+	return "previews/" + imageID(name) + ".jpg"
+}
+
+// writeRawPreview stores a RAW upload's preview
+func writeRawPreview(name string, preview []byte) error {
+	if err := writeUpload(context.Background(), previewKey(name), preview); err != nil {
+		return err
+	}
+	if uploadMirror != nil {
+		uploadMirror.enqueue(previewKey(name))
+	}
+	return nil
+}
+
+// displayKey is the file an upload is displayed and resized from: the
+// preview for RAW uploads, the original otherwise
+func displayKey(name string) string {
+	if isRaw(name) {
+		return previewKey(name)
+	}
+	return name
+}
+
+// displayPath is the URL path of the displayable form of an upload. With
+// originals in cold storage it is the largest variant once one is ready,
+// so what pages show is served from the hot tier.
+func displayPath(name string) string {
+	if originalsTiered && !isRaw(name) {
+		urls, _ := variantURLs(name)
+		if path := largestVariantPath(name, urls); path != "" {
+			return path
+		}
+	}
+	return "/uploads/" + displayKey(name)
+}
+
+// readDisplaySource reads the displayable form of an upload, extracting a
+// RAW upload's preview again if it has gone missing
+func readDisplaySource(name string) ([]byte, error) {
+	data, err := readUpload(displayKey(name))
+	if err == nil || !isRaw(name) || !isMissing(err) {
+		return data, err
+	}
+
+	original, err := readUpload(name)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := extractRawPreview(original)
+	if err != nil {
+		return nil, err
+	}
+	return preview, writeRawPreview(name, preview)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getImage returns one image's record: GET /api/images/:id. The response
+// carries an ETag of its content and, once its variants are ready, the
+// time of the image's last journaled change as Last-Modified, so polling
+// clients can send If-None-Match or If-Modified-Since and get a 304.
+// version is the change journal sequence number of that change. Private
+// images are not found by requests that may not see them.
+func getImage(c *fiber.Ctx) error {
+	name, ok := findUpload(c.Params("id"))
+	if ok && isPrivate(name) && !seesPrivate(c, name) {
+		ok = false
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	object, err := uploadStore.Stat(c.Context(), name)
+	if isMissing(err) {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read image",
+			"success": false,
+		})
+	}
+	info := imageRecord(object.Info())
+
+	event, journaled := changes.latest(name)
+	if journaled {
+		info["version"] = event.Seq
+	}
+	body, etag, err := imageBody(info)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	// Variants still being generated change the record without a journal
+	// entry, so it has no modification time until they are done
+	var modified time.Time
+	if ready, _ := info["variants_ready"].(bool); ready && journaled {
+		modified = time.Unix(event.At, 0)
+		c.Set(fiber.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c, etag, modified) {
+		return c.SendStatus(304)
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
+
+// imageBody is the response carrying an image's record, and its ETag
+func imageBody(info map[string]interface{}) ([]byte, string, error) {
+	body, err := json.Marshal(fiber.Map{
+		"success": true,
+		"image":   info,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	return body, `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// notModified evaluates If-None-Match, or failing that If-Modified-Since,
+// against a response's validators as RFC 9110 section 13.2.2 orders them
+func notModified(c *fiber.Ctx, etag string, modified time.Time) bool {
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if since := c.Get(fiber.HeaderIfModifiedSince); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// imageChanges are the editable fields of PATCH /api/images/:id; fields
+// left out keep their value
+type imageChanges struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	Private     *bool     `json:"private"`
+	Unlisted    *bool     `json:"unlisted"`
+	// FocalPoint moves the subject crops keep in frame; the centre,
+	// {"x": 0.5, "y": 0.5}, clears it
+	FocalPoint *focalPoint `json:"focal_point"`
+}
+
+// parseImageChanges reads and checks an image edit, normalizing its tags
+func parseImageChanges(c *fiber.Ctx) (imageChanges, error) {
+	var req imageChanges
+	if err := c.BodyParser(&req); err != nil {
+		return req, errors.New("Body must be JSON")
+	}
+	if req.empty() {
+		return req, errors.New("Nothing to change: give a title, description, tags, private, unlisted or focal_point")
+	}
+	return req, req.check()
+}
+
+// empty reports whether an edit changes nothing
+func (req *imageChanges) empty() bool {
+	return req.Title == nil && req.Description == nil && req.Tags == nil && req.Private == nil && req.Unlisted == nil &&
+		req.FocalPoint == nil
+}
+
+// check checks an edit's fields, normalizing its tags
+func (req *imageChanges) check() error {
+	if req.Title != nil {
+		if err := checkTitle(*req.Title); err != nil {
+			return err
+		}
+	}
+	if req.Description != nil {
+		if err := checkDescription(*req.Description); err != nil {
+			return err
+		}
+	}
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			return err
+		}
+		req.Tags = &tags
+	}
+	if p := req.FocalPoint; p != nil && (p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1) {
+		return errors.New("focal_point x and y must be between 0 and 1")
+	}
+	return nil
+}
+
+// apply makes an edit to an image's metadata
+func (req *imageChanges) apply(meta *imageMeta) {
+	if req.Title != nil {
+		meta.Title = *req.Title
+	}
+	if req.Description != nil {
+		meta.Description = *req.Description
+	}
+	if req.Tags != nil {
+		meta.Tags = *req.Tags
+	}
+	if req.Private != nil {
+		meta.Private = *req.Private
+	}
+	if req.Unlisted != nil {
+		meta.Unlisted = *req.Unlisted
+	}
+	if p := req.FocalPoint; p != nil {
+		meta.FocalPoint = p
+		if *p == (focalPoint{0.5, 0.5}) {
+			meta.FocalPoint = nil
+		}
+	}
+}
+
+// imageEditMu orders edits of image metadata, so two edits made against
+// the same version can't both pass If-Match
+var imageEditMu sync.Mutex
+
+// updateImage edits an image's title, description, tags or focal point, or
+// marks it private or unlisted, without re-uploading it, and returns the
+// updated record: PATCH /api/images/:id with a JSON body such as
+// {"title": "Sunset"} or {"focal_point": {"x": 0.3, "y": 0.6}}. Moving the
+// focal point regenerates the image's cropped variants.
+//
+// Edits must send the version they were made against as If-Match: "12",
+// or the ETag of GET /api/images/:id, and get a 428 without it. If the
+// image has changed since, nothing is saved and a 412 carries the current
+// record, the changes sent and, while the journal has it, the metadata at
+// the version they started from, so clients that edited offline can merge.
+// The response carries the ETag of the updated record.
+func updateImage(c *fiber.Ctx) error {
+	req, err := parseImageChanges(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	object, err := uploadStore.Stat(c.Context(), name)
+	if isMissing(err) {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read image",
+			"success": false,
+		})
+	}
+	if !mayChange(c, name) {
+		return sendNotOwner(c, "Image belongs to another user")
+	}
+
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+	if imageSealed(name) {
+		return sendSealed(c, "metadata", name, "Image is in a sealed album")
+	}
+	imageEditMu.Lock()
+	defer imageEditMu.Unlock()
+	current, _ := changes.latest(name)
+	info := imageRecord(object.Info())
+	info["version"] = current.Seq
+	_, etag, err := imageBody(info)
+	if err != nil {
+		return err
+	}
+	switch err := checkVersion(c, current.Seq, etag); {
+	case errors.Is(err, errVersionRequired):
+		return sendVersionRequired(c)
+	case err != nil:
+		conflict := fiber.Map{
+			"error":   "Image has changed since the version in If-Match",
+			"success": false,
+			"current": info,
+			"yours":   req,
+		}
+		base, _ := strconv.ParseInt(strings.Trim(c.Get(fiber.HeaderIfMatch), `" `), 10, 64)
+		if event, ok := changes.event(name, base); ok && event.Meta != nil {
+			conflict["base"] = event.Meta
+		}
+		return c.Status(412).JSON(conflict)
+	}
+
+	// Files without stored metadata start from what can be derived, less
+	// the title, which is only derived for display
+	base := imageInfo(name)
+	base.Title = ""
+	_, err = metadata.update(name, base, func(meta *imageMeta) error {
+		req.apply(meta)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error updating metadata for %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save metadata",
+			"success": false,
+		})
+	}
+	changes.record(changeUpdate, name)
+	audit(c, "metadata", name)
+	if req.FocalPoint != nil {
+		recropVariants(name)
+	}
+
+	info = imageRecord(object.Info())
+	if event, ok := changes.latest(name); ok {
+		info["version"] = event.Seq
+	}
+	body, etag, err := imageBody(info)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// panicsRecovered counts handler panics, exposed on the admin listener
+var panicsRecovered = expvar.NewInt("panics_recovered")
+
+// recoverPanics turns a panicking handler into a 500 with the usual error
+// envelope and the request ID, logging the stack, so one bad request can't
+// take the server down
+func recoverPanics(c *fiber.Ctx) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicsRecovered.Add(1)
+			id, _ := c.Locals("requestid").(string)
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", c.Method(), c.OriginalURL(), id, r, debug.Stack())
+			err = c.Status(500).JSON(fiber.Map{
+				"error":      "Internal server error",
+				"request_id": id,
+				"success":    false,
+			})
+		}
+	}()
+	return c.Next()
+}
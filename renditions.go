@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Renditions are WebP and AVIF encodings of each variant, stored beside
+// its JPEG or PNG (thumbs/200/<id>.webp) and served in its place to
+// clients whose Accept header takes them. On-demand resizes are encoded the
+// same way. They need an image processor that can encode the formats, in
+// practice the vips backend.
+
+// renditionPreference orders the formats by how much smaller they tend to
+// be, which is also the order they are served in
+var renditionPreference = []string{"avif", "webp"}
+
+// renditionFormats are the formats renditions are made in, set from
+// AFROBASE_RENDITIONS by loadRenditionFormats
+var renditionFormats []string
+
+// loadRenditionFormats reads AFROBASE_RENDITIONS, a comma-separated list
+// of "webp" and "avif", or "off". By default WebP renditions are made if
+// the processor can encode them; AVIF is slow to encode and has to be
+// asked for.
+func loadRenditionFormats() {
+	v := os.Getenv("AFROBASE_RENDITIONS")
+	if v == "off" {
+		return
+	}
+	wanted := []string{"webp"}
+	if v != "" {
+		wanted = strings.Split(v, ",")
+	}
+	for _, w := range wanted {
+		if w = strings.TrimSpace(w); !slices.Contains(renditionPreference, w) {
+			log.Fatal("Invalid AFROBASE_RENDITIONS: unknown format ", w)
+		}
+	}
+	for _, format := range renditionPreference {
+		if !slices.ContainsFunc(wanted, func(w string) bool { return strings.TrimSpace(w) == format }) {
+			continue
+		}
+		if !imageProcessor.Encodes(format) {
+			if v != "" {
+				log.Printf("The %s image processor can't encode %s, not making %s renditions", imageProcessor.Name(), format, format)
+			}
+			continue
+		}
+		renditionFormats = append(renditionFormats, format)
+	}
+	if len(renditionFormats) > 0 {
+		log.Printf("Making %s renditions of variants", strings.Join(renditionFormats, " and "))
+	}
+}
+
+// isRendition reports whether a variant file is a rendition. Variants
+// themselves are always JPEG or PNG.
+func isRendition(key string) bool {
+	return slices.Contains(renditionPreference, strings.TrimPrefix(path.Ext(key), "."))
+}
+
+// acceptedFormat returns the preferred rendition format the client
+// accepts, or "" for the default formats
+func acceptedFormat(c *fiber.Ctx) string {
+	accept := c.Get(fiber.HeaderAccept)
+	for _, format := range renditionFormats {
+		if strings.Contains(accept, "image/"+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// negotiateRendition returns the key of the rendition of a variant to
+// serve in its place, if the client accepts one that exists
+func negotiateRendition(c *fiber.Ctx, key string) string {
+	if len(renditionFormats) == 0 || !strings.HasPrefix(key, "thumbs/") || isRendition(key) {
+		return key
+	}
+	c.Vary(fiber.HeaderAccept)
+	accept := c.Get(fiber.HeaderAccept)
+	base := strings.TrimSuffix(key, path.Ext(key))
+	for _, format := range renditionFormats {
+		if !strings.Contains(accept, "image/"+format) {
+			continue
+		}
+		if _, err := uploadStore.Stat(c.Context(), base+"."+format); err == nil {
+			return base + "." + format
+		}
+	}
+	return key
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// resizeCacheLimit bounds the on-disk cache of on-demand resizes
+// (AFROBASE_RESIZE_CACHE_MB, default 512)
+var resizeCacheLimit int64 = 512 << 20
+
+// resizeCache keeps resized images in ./data/resized, evicting the least
+// recently used once it outgrows its limit. Entries are keyed by the
+// source's content hash, so replaced uploads never serve stale sizes.
+type resizeCache struct {
+	dir   string
+	mu    sync.Mutex
+	size  int64
+	limit int64
+}
+
+// resized is nil in the sandbox, which resizes every request afresh
+var resized *resizeCache
+
+// openResizeCache measures what an earlier run left in the cache
+func openResizeCache(limit int64) (*resizeCache, error) {
+	rc := &resizeCache{dir: filepath.Join(dataDir, "resized"), limit: limit}
+	if err := os.MkdirAll(rc.dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(rc.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			rc.size += info.Size()
+		}
+	}
+	return rc, nil
+}
+
+// get returns a cached resize, marking it recently used
+func (rc *resizeCache) get(key string) ([]byte, bool) {
+	if rc == nil {
+		return nil, false
+	}
+	path := filepath.Join(rc.dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// put adds a resize, evicting the oldest entries if the cache is full
+func (rc *resizeCache) put(key string, data []byte) {
+	if rc == nil {
+		return
+	}
+	f, err := os.CreateTemp(rc.dir, key+".*.tmp")
+	if err != nil {
+		log.Printf("Error caching resize %s: %v", key, err)
+		return
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(rc.dir, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Printf("Error caching resize %s: %v", key, err)
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.size += int64(len(data))
+	if rc.size > rc.limit {
+		rc.evict()
+	}
+}
+
+// evict removes the least recently used entries until the cache is back
+// under 90% of its limit
+func (rc *resizeCache) evict() {
+	entries, err := os.ReadDir(rc.dir)
+	if err != nil {
+		log.Printf("Error reading resize cache: %v", err)
+		return
+	}
+	var files []os.FileInfo
+	rc.size = 0
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() && filepath.Ext(info.Name()) != ".tmp" {
+			files = append(files, info)
+			rc.size += info.Size()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, file := range files {
+		if rc.size <= rc.limit*9/10 {
+			break
+		}
+		if err := os.Remove(filepath.Join(rc.dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error evicting resize %s: %v", file.Name(), err)
+			continue
+		}
+		rc.size -= file.Size()
+	}
+}
+
+// resizeRequest is a parsed /img query
+type resizeRequest struct {
+	width, height, quality int
+	cover                  bool
+}
+
+// parseResizeRequest reads w, h, fit (contain or cover) and q
+func parseResizeRequest(c *fiber.Ctx) (resizeRequest, error) {
+	var r resizeRequest
+	for _, p := range []struct {
+		name     string
+		dst      *int
+		min, max int
+	}{
+		{"w", &r.width, 1, maxVariantSize},
+		{"h", &r.height, 1, maxVariantSize},
+		{"q", &r.quality, 1, 100},
+	} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < p.min || n > p.max {
+			return r, fmt.Errorf("%s must be between %d and %d", p.name, p.min, p.max)
+		}
+		*p.dst = n
+	}
+	if r.width == 0 && r.height == 0 {
+		return r, fmt.Errorf("w or h is required")
+	}
+	switch c.Query("fit", "contain") {
+	case "contain":
+	case "cover":
+		if r.width == 0 || r.height == 0 {
+			return r, fmt.Errorf("fit=cover needs both w and h")
+		}
+		r.cover = true
+	default:
+		return r, fmt.Errorf("fit must be contain or cover")
+	}
+	return r, nil
+}
+
+// getResizedImage resizes an upload on demand: GET /img/:name?w=400&h=300&fit=cover.
+// name is the stored filename or image ID. contain (the default) fits the
+// image inside the box and cover fills it, cropping around the image's focal
+// point or centre; images are never upscaled. q sets the JPEG quality.
+// Results are cached, so repeated sizes cost a disk read.
+func getResizedImage(c *fiber.Ctx) error {
+	req, err := parseResizeRequest(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+	name, ok := findUpload(imageID(c.Params("name")))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	if denied, err := privateDenied(c, name); denied {
+		return err
+	}
+	object, err := uploadStore.Stat(c.Context(), name)
+	var hash string
+	if err == nil {
+		hash, err = uploadHash(object.Info())
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read image",
+			"success": false,
+		})
+	}
+
+	fit := "contain"
+	if req.cover {
+		fit = "cover"
+	}
+	format := acceptedFormat(c)
+	if len(renditionFormats) > 0 {
+		c.Vary(fiber.HeaderAccept)
+	}
+	spec := fmt.Sprintf("%s %dx%d %s q%d", hash, req.width, req.height, fit, req.quality)
+	if format != "" {
+		spec += " " + format
+	}
+	meta, _ := metadata.get(name)
+	if req.cover && meta.FocalPoint != nil {
+		spec += fmt.Sprintf(" at %g,%g", meta.FocalPoint.X, meta.FocalPoint.Y)
+	}
+	sum := sha256.Sum256([]byte(spec))
+	key := hex.EncodeToString(sum[:16])
+	etag := `"` + key + `"`
+	c.Set(fiber.HeaderETag, etag)
+	if isPrivate(name) {
+		c.Set(fiber.HeaderCacheControl, "private")
+	} else {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=86400")
+	}
+	if notModified(c, etag, time.Time{}) {
+		return c.SendStatus(304)
+	}
+
+	out, ok := resized.get(key)
+	if !ok {
+		data, err := readDisplaySource(name)
+		if err == nil {
+			err = checkPixelBudget(data)
+		}
+		if err == nil {
+			out, _, err = imageProcessor.Resize(data, TransformOptions{
+				Width:   req.width,
+				Height:  req.height,
+				Quality: req.quality,
+				Crop:    req.cover,
+				Focus:   meta.FocalPoint,
+				Format:  format,
+			})
+		}
+		if err != nil {
+			log.Printf("Error resizing %s: %v", name, err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to resize image",
+				"success": false,
+			})
+		}
+		resized.put(key, out)
+	}
+
+	c.Type(strings.TrimPrefix(sniffExtension(out), "."))
+	return c.Send(out)
+}
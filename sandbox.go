@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"time"
+
+	"AfroBaseServer/storage"
+)
+
+// sandbox runs the server entirely in memory with a seeded library, for
+// frontend CI and local development: afrobase -sandbox, or
+// AFROBASE_SANDBOX=on. Nothing is written to disk, and every run starts
+// from the same images, albums and IDs.
+var sandbox bool
+
+// sandboxEpoch is when the first seeded image was uploaded; the rest follow
+// a day apart
+var sandboxEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// sandboxImages are the seeded images, drawn as labelled gradients and
+// tagged so filtering has something to find
+var sandboxImages = []struct {
+	title, description string
+	width, height      int
+	from, to           color.RGBA
+	alpha              bool
+	tags               []string
+}{
+	{"Sunrise", "Warm morning gradient", 1200, 800, color.RGBA{255, 94, 58, 255}, color.RGBA{255, 204, 112, 255}, false, []string{"sky", "warm"}},
+	{"Ocean", "Deep blue to turquoise", 1200, 800, color.RGBA{0, 40, 120, 255}, color.RGBA{64, 224, 208, 255}, false, []string{"water", "blue"}},
+	{"Forest", "Shades of green", 800, 1200, color.RGBA{16, 64, 32, 255}, color.RGBA{120, 200, 80, 255}, false, []string{"nature", "green"}},
+	{"Dusk", "Purple evening sky", 1600, 900, color.RGBA{48, 16, 96, 255}, color.RGBA{240, 128, 160, 255}, false, []string{"sky"}},
+	{"Sand", "Desert tones", 1000, 1000, color.RGBA{194, 154, 108, 255}, color.RGBA{250, 230, 190, 255}, false, []string{"nature", "warm"}},
+	{"Storm", "Grey clouds", 1600, 900, color.RGBA{40, 44, 52, 255}, color.RGBA{150, 160, 170, 255}, false, []string{"sky", "weather"}},
+	{"Savanna", "Golden grassland", 1200, 800, color.RGBA{170, 120, 30, 255}, color.RGBA{240, 210, 110, 255}, false, []string{"nature", "warm"}},
+	{"Lagoon", "Shallow water", 800, 1200, color.RGBA{0, 110, 130, 255}, color.RGBA{170, 240, 230, 255}, false, []string{"water", "blue"}},
+	{"Ember", "Glowing coals", 1000, 1000, color.RGBA{60, 0, 0, 255}, color.RGBA{255, 120, 0, 255}, false, []string{"warm"}},
+	{"Glacier", "Ice blues", 1600, 900, color.RGBA{180, 220, 250, 255}, color.RGBA{250, 252, 255, 255}, false, []string{"nature", "blue"}},
+	{"Baobab", "Tree at sunset", 800, 1200, color.RGBA{90, 40, 20, 255}, color.RGBA{250, 150, 60, 255}, false, []string{"nature", "tree"}},
+	{"Night", "Stars not included", 1200, 800, color.RGBA{5, 5, 25, 255}, color.RGBA{30, 40, 90, 255}, false, []string{"sky", "dark"}},
+	{"Kente", "Bold gold and green", 1000, 1000, color.RGBA{230, 180, 20, 255}, color.RGBA{20, 140, 60, 255}, false, []string{"pattern"}},
+	{"Clay", "Terracotta", 1200, 800, color.RGBA{160, 70, 40, 255}, color.RGBA{220, 140, 100, 255}, false, []string{"warm"}},
+	{"Mist", "Soft morning fog", 1600, 900, color.RGBA{200, 205, 210, 255}, color.RGBA{240, 242, 245, 255}, false, []string{"weather"}},
+	{"Coral", "Reef colours", 800, 1200, color.RGBA{255, 110, 100, 255}, color.RGBA{255, 200, 170, 255}, false, []string{"water"}},
+	{"Logo", "Transparent badge", 512, 512, color.RGBA{230, 60, 60, 255}, color.RGBA{60, 60, 230, 0}, true, []string{"graphic"}},
+	{"Icon", "Small transparent icon", 128, 128, color.RGBA{20, 160, 90, 255}, color.RGBA{20, 160, 90, 0}, true, []string{"graphic"}},
+	{"Banner", "Wide header image", 2400, 600, color.RGBA{30, 30, 30, 255}, color.RGBA{200, 60, 120, 255}, false, []string{"graphic"}},
+	{"Tall", "Long portrait strip", 600, 2400, color.RGBA{0, 90, 160, 255}, color.RGBA{250, 250, 250, 255}, false, nil},
+	{"Square", "Profile photo size", 400, 400, color.RGBA{120, 80, 160, 255}, color.RGBA{200, 170, 230, 255}, false, []string{"avatar"}},
+	{"Tiny", "Thumbnail sized", 64, 64, color.RGBA{255, 0, 128, 255}, color.RGBA{0, 255, 128, 255}, false, nil},
+	{"Harmattan", "Dusty haze", 1200, 800, color.RGBA{200, 170, 120, 255}, color.RGBA{230, 220, 200, 255}, false, []string{"weather", "warm"}},
+	{"Rain", "Heavy rain", 1600, 900, color.RGBA{50, 70, 90, 255}, color.RGBA{110, 140, 160, 255}, false, []string{"weather"}},
+}
+
+// sandboxAlbums group the seeded images by index
+var sandboxAlbums = []struct {
+	name, description string
+	images            []int
+}{
+	{"Landscapes", "Seeded landscape images", []int{0, 1, 3, 5, 6, 9, 11, 14, 22, 23}},
+	{"Portraits", "Seeded portrait images", []int{2, 7, 10, 15, 19}},
+	{"Graphics", "Seeded images with transparency", []int{16, 17}},
+}
+
+// seedSandbox fills the empty in-memory stores with the sandbox library
+func seedSandbox() error {
+	memory, _ := uploadStore.(*storage.Memory)
+	if memory != nil {
+		defer func() { memory.Now = serverClock.Now }()
+	}
+
+	names := make([]string, len(sandboxImages))
+	for i, s := range sandboxImages {
+		uploaded := sandboxEpoch.Add(time.Duration(i) * 24 * time.Hour)
+		data, ext, err := encodeImage(gradientImage(s.width, s.height, s.from, s.to, s.title), s.alpha, 0)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%d_%s%s", uploaded.Unix(), sanitizeFilename(s.title), ext)
+		if memory != nil {
+			memory.Now = func() time.Time { return uploaded }
+		}
+		if err := writeUpload(context.Background(), name, data); err != nil {
+			return err
+		}
+		meta := imageMeta{
+			Title:            s.title,
+			Description:      s.description,
+			OriginalFilename: sanitizeFilename(s.title) + ext,
+			UploadTime:       uploaded.Unix(),
+			Tags:             s.tags,
+			Content:          describeContent(data, data),
+		}
+		if err := metadata.put(name, meta); err != nil {
+			return err
+		}
+		names[i] = name
+	}
+
+	for i, s := range sandboxAlbums {
+		id, err := newID(8)
+		if err != nil {
+			return err
+		}
+		created := sandboxEpoch.Add(time.Duration(len(sandboxImages)+i) * 24 * time.Hour).Unix()
+		a := album{ID: id, Name: s.name, Description: s.description, Created: created, Updated: created}
+		for _, n := range s.images {
+			a.Images = append(a.Images, names[n])
+		}
+		if err := metadata.putAlbum(a); err != nil {
+			return err
+		}
+	}
+	log.Printf("Sandbox seeded with %d images and %d albums", len(sandboxImages), len(sandboxAlbums))
+	return nil
+}
+
+// gradientImage draws a diagonal gradient between two colours with a label
+func gradientImage(width, height int, from, to color.RGBA, label string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	span := width + height - 2
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := 0.0
+			if span > 0 {
+				t = float64(x+y) / float64(span)
+			}
+			img.SetRGBA(x, y, blendRGBA(from, to, t))
+		}
+	}
+	drawCenteredText(img, label, color.White)
+	return img
+}
+
+// blendRGBA mixes two colours, t of the way from a to b
+func blendRGBA(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t + 0.5) }
+	// Premultiplied, so colour channels can't exceed alpha
+	c := color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+	c.R, c.G, c.B = min(c.R, c.A), min(c.G, c.A), min(c.B, c.A)
+	return c
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// borderTolerance is how far, per channel, a pixel may drift from the
+// border colour and still count as border (JPEG noise, subtle gradients)
+const borderTolerance = 12
+
+// trimUniformBorders crops solid-colour borders (letterboxing, window
+// padding) off a screenshot. The border colour is taken from the top-left
+// pixel and edges are trimmed while every pixel on them matches it. The
+// result is re-encoded as PNG, since screenshots compress best losslessly.
+// It returns the original data unchanged if there is nothing to trim.
+func trimUniformBorders(data []byte) ([]byte, image.Rectangle, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	bounds := img.Bounds()
+	border := img.At(bounds.Min.X, bounds.Min.Y)
+	matches := func(x, y int) bool {
+		return colorsClose(img.At(x, y), border)
+	}
+	rowIsBorder := func(y, x0, x1 int) bool {
+		for x := x0; x < x1; x++ {
+			if !matches(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	colIsBorder := func(x, y0, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			if !matches(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	crop := bounds
+	for crop.Min.Y < crop.Max.Y && rowIsBorder(crop.Min.Y, crop.Min.X, crop.Max.X) {
+		crop.Min.Y++
+	}
+	for crop.Max.Y > crop.Min.Y && rowIsBorder(crop.Max.Y-1, crop.Min.X, crop.Max.X) {
+		crop.Max.Y--
+	}
+	for crop.Min.X < crop.Max.X && colIsBorder(crop.Min.X, crop.Min.Y, crop.Max.Y) {
+		crop.Min.X++
+	}
+	for crop.Max.X > crop.Min.X && colIsBorder(crop.Max.X-1, crop.Min.Y, crop.Max.Y) {
+		crop.Max.X--
+	}
+
+	// A completely uniform image, or one with no border, is kept as is
+	if crop.Empty() || crop == bounds {
+		return data, bounds, nil
+	}
+
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return data, bounds, nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sub.SubImage(crop)); err != nil {
+		return nil, image.Rectangle{}, err
+	}
+	return buf.Bytes(), crop, nil
+}
+
+// colorsClose reports whether two colours are within borderTolerance on
+// every channel
+func colorsClose(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	within := func(x, y uint32) bool {
+		if x > y {
+			x, y = y, x
+		}
+		return (y-x)>>8 <= borderTolerance
+	}
+	return within(r1, r2) && within(g1, g2) && within(b1, b2) && within(a1, a2)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Write-once albums, for archives and competition entries, are sealed when
+// published: their images can't then be edited or deleted, nor taken out
+// of or added to the album, until the seal is broken. Breaks are kept on
+// the album and audited, with the reason given.
+var errAlbumSealed = errors.New("album is sealed")
+
+// maxSealReasonLength bounds the reason given for breaking a seal
+const maxSealReasonLength = 500
+
+// albumSeal marks a write-once album as published and sealed
+type albumSeal struct {
+	Since int64 `json:"since"`
+}
+
+// sealBreak records a seal being broken: by whom, why, and when the seal
+// was made and broken
+type sealBreak struct {
+	Since  int64  `json:"since"`
+	At     int64  `json:"at"`
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+}
+
+// imageSealed reports whether an image is in a sealed album
+func imageSealed(name string) bool {
+	return slices.ContainsFunc(metadata.albumsOf(name), func(a album) bool { return a.Seal != nil })
+}
+
+// sendSealed answers a request a seal refused, auditing the attempt
+func sendSealed(c *fiber.Ctx, action, target, message string) error {
+	audit(c, action+"_refused", target)
+	return c.Status(409).JSON(fiber.Map{
+		"error":   message,
+		"success": false,
+		"sealed":  true,
+	})
+}
+
+// publishAlbum publishes an album: POST /api/albums/:id/publish. Write-once
+// albums are sealed as they are published, including any published again
+// after their seal was broken.
+func publishAlbum(c *fiber.Ctx) error {
+	holdMu.Lock()
+	defer holdMu.Unlock()
+
+	var sealed bool
+	a, err := metadata.updateAlbum(c.Params("id"), func(a *album) error {
+		if !ownedByCaller(c, a.Owner) {
+			return errNotOwner
+		}
+		now := serverClock.Now().Unix()
+		if a.Published == 0 {
+			a.Published = now
+		}
+		if a.WriteOnce && a.Seal == nil {
+			a.Seal = &albumSeal{Since: now}
+			sealed = true
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	case errors.Is(err, errNotOwner):
+		return sendNotOwner(c, "Album belongs to another user")
+	case err != nil:
+		log.Printf("Error publishing album %s: %v", c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to publish album",
+			"success": false,
+		})
+	}
+	audit(c, "album_publish", a.ID)
+	if sealed {
+		audit(c, "album_seal", a.ID)
+		log.Printf("Album sealed: %s", a.ID)
+	}
+
+	c.Set(fiber.HeaderETag, versionTag(a.Version))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"album":   a.view(),
+	})
+}
+
+// breakAlbumSeal breaks a write-once album's seal so its images can be
+// changed again: POST /api/albums/:id/seal/break with {"reason": "..."}.
+// The reason is required, and kept with who broke the seal and when.
+// Publishing the album again seals it again.
+func breakAlbumSeal(c *fiber.Ctx) error {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Body must be JSON with a reason",
+			"success": false,
+		})
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" || len(reason) > maxSealReasonLength {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "A reason of up to 500 bytes is required to break a seal",
+			"success": false,
+		})
+	}
+	by := apiKeyName(c)
+	if u, ok := requestUser(c); ok {
+		by = u.Username
+	}
+
+	holdMu.Lock()
+	defer holdMu.Unlock()
+
+	errNotSealed := errors.New("album is not sealed")
+	a, err := metadata.updateAlbum(c.Params("id"), func(a *album) error {
+		if !ownedByCaller(c, a.Owner) {
+			return errNotOwner
+		}
+		if a.Seal == nil {
+			return errNotSealed
+		}
+		a.SealBreaks = append(a.SealBreaks, sealBreak{
+			Since:  a.Seal.Since,
+			At:     serverClock.Now().Unix(),
+			By:     by,
+			Reason: reason,
+		})
+		a.Seal = nil
+		return nil
+	})
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	case errors.Is(err, errNotOwner):
+		return sendNotOwner(c, "Album belongs to another user")
+	case errors.Is(err, errNotSealed):
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Album is not sealed",
+			"success": false,
+		})
+	case err != nil:
+		log.Printf("Error breaking seal on album %s: %v", c.Params("id"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to break seal",
+			"success": false,
+		})
+	}
+	audit(c, "album_seal_break", a.ID+": "+reason)
+	log.Printf("Seal broken on album %s by %s: %s", a.ID, by, reason)
+
+	c.Set(fiber.HeaderETag, versionTag(a.Version))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"album":   a.view(),
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"AfroBaseServer/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxSearchLength bounds search queries
+const maxSearchLength = 200
+
+// searchImages finds images whose title, description or tags contain
+// every word of ?q=, ignoring case and accents: GET
+// /api/images/search?q=sunset+beach.
+// Results come oldest first, or sorted by ?sort=title, and are paged and
+// scoped to the user like GET /api/images.
+func searchImages(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "q is required",
+			"success": false,
+		})
+	}
+	if utf8.RuneCountInString(q) > maxSearchLength {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "q is too long",
+			"success": false,
+		})
+	}
+	terms := strings.Fields(foldText(q))
+	owner, err := listingOwner(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	objects, err := uploadStore.List(c.Context(), "")
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+	objects = slices.DeleteFunc(objects, func(o storage.Object) bool {
+		meta := imageInfo(o.Key)
+		return (owner != "" && meta.Owner != owner) || !meta.matches(terms) || hiddenFrom(c, o.Key)
+	})
+	if err := sortByTitle(c, objects); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	p, ok := listingPage(c, len(objects))
+	if !ok {
+		return sendPageError(c)
+	}
+	objects = objects[p.start:p.end]
+
+	images := make([]map[string]interface{}, 0, len(objects))
+	for _, object := range objects {
+		images = append(images, imageRecord(object.Info()))
+	}
+	return sendImagePage(c, images, imageListColumns, p, fiber.Map{"query": q})
+}
+
+// matches reports whether every term, folded by foldText, appears in the
+// title, description or a tag
+func (m imageMeta) matches(terms []string) bool {
+	text := foldText(m.Title + "\n" + m.Description + "\n" + strings.Join(m.Tags, "\n"))
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
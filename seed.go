@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+)
+
+// Words seeded titles, descriptions and tags are made from
+var (
+	seedAdjectives = []string{"Golden", "Misty", "Quiet", "Bright", "Hidden", "Ancient", "Wild", "Silver", "Crimson", "Gentle", "Distant", "Frozen"}
+	seedNouns      = []string{"Savanna", "Harbour", "Market", "Baobab", "Coastline", "Valley", "Skyline", "Festival", "Village", "Waterfall", "Desert", "Portrait"}
+	seedTags       = []string{"nature", "city", "people", "travel", "food", "music", "sky", "water", "night", "portrait", "street", "wildlife", "festival", "architecture", "texture"}
+	seedSizes      = []image.Point{{640, 480}, {480, 640}, {512, 512}, {1024, 576}, {576, 1024}, {800, 600}}
+)
+
+// runSeed implements `seed`, filling the uploads directory and metadata
+// store with synthetic images for trying out pagination, search and
+// performance: afrobase seed -count 500. The same -seed draws the same
+// images, titles and tags, and with -frozen-clock the same upload times.
+// Stop the server first; it generates variants for the new images when it
+// next starts.
+func runSeed(args []string) {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	count := flags.Int("count", 100, "number of images to generate")
+	albums := flags.Int("albums", 10, "number of albums to spread them across")
+	seed := flags.Uint64("seed", 1, "random seed")
+	frozen := flags.String("frozen-clock", os.Getenv("AFROBASE_FROZEN_CLOCK"), frozenClockUsage)
+	flags.Parse(args)
+	freezeClock(*frozen)
+	if *count < 1 || *albums < 0 {
+		log.Fatal("Invalid seed options: -count must be positive and -albums not negative")
+	}
+
+	store, err := openUploadStore()
+	if err != nil {
+		log.Fatal("Failed to open upload storage:", err)
+	}
+	uploadStore = store
+	metadata, err = openMetadataStore(false)
+	if err != nil {
+		log.Fatal("Failed to open metadata store (is the server running?):", err)
+	}
+	defer metadata.db.Close()
+
+	rng := rand.New(rand.NewPCG(*seed, 0))
+	pick := func(words []string) string { return words[rng.IntN(len(words))] }
+
+	// Spread upload times over the year before now, oldest first
+	start := serverClock.Now().Add(-365 * 24 * time.Hour).Unix()
+	step := max(1, 365*24*60*60/int64(*count))
+
+	metas := make(map[string]imageMeta, *count)
+	names := make([]string, 0, *count)
+	for i := 0; i < *count; i++ {
+		title := fmt.Sprintf("%s %s %d", pick(seedAdjectives), pick(seedNouns), i+1)
+		size := seedSizes[rng.IntN(len(seedSizes))]
+		from := color.RGBA{uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256)), 255}
+		to := color.RGBA{uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256)), 255}
+		img := gradientImage(size.X, size.Y, from, to, title)
+		if rng.IntN(3) == 0 {
+			addNoise(img, rng)
+		}
+		data, ext, err := encodeImage(img, false, 0)
+		if err != nil {
+			log.Fatal("Failed to encode seed image:", err)
+		}
+
+		uploaded := start + int64(i)*step + rng.Int64N(step)
+		name := fmt.Sprintf("%d_%s%s", uploaded, sanitizeFilename(title), ext)
+		if err := writeUpload(context.Background(), name, data); err != nil {
+			log.Fatal("Failed to save seed image:", err)
+		}
+
+		var tags []string
+		for n := 1 + rng.IntN(4); n > 0; n-- {
+			tags = append(tags, pick(seedTags))
+		}
+		tags, _ = normalizeTags(tags)
+		metas[name] = imageMeta{
+			Title:            title,
+			Description:      fmt.Sprintf("A %s %s, generated for testing", strings.ToLower(pick(seedAdjectives)), strings.ToLower(pick(seedNouns))),
+			OriginalFilename: fmt.Sprintf("IMG_%04d%s", i+1, ext),
+			UploadTime:       uploaded,
+			Tags:             tags,
+			Content:          describeContent(data, data),
+		}
+		names = append(names, name)
+	}
+	if err := metadata.putAll(metas); err != nil {
+		log.Fatal("Failed to save seed metadata:", err)
+	}
+
+	// Each album gets a random tenth or so of the images, some in several
+	now := serverClock.Now().Unix()
+	for i := 0; i < *albums; i++ {
+		id, err := newID(8)
+		if err != nil {
+			log.Fatal("Failed to generate album ID:", err)
+		}
+		a := album{
+			ID:          id,
+			Name:        fmt.Sprintf("%s %ss", pick(seedAdjectives), pick(seedNouns)),
+			Description: "Generated for testing",
+			Images:      []string{},
+			Created:     now,
+			Updated:     now,
+		}
+		for _, name := range names {
+			if rng.IntN(10) == 0 {
+				a.Images = append(a.Images, name)
+			}
+		}
+		if err := metadata.putAlbum(a); err != nil {
+			log.Fatal("Failed to save seed album:", err)
+		}
+	}
+
+	log.Printf("Seeded %d image(s) and %d album(s) into %s; variants are generated when the server starts", *count, *albums, uploadStore.Name())
+}
+
+// addNoise speckles an opaque image with random grain
+func addNoise(img *image.RGBA, rng *rand.Rand) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		d := rng.IntN(61) - 30
+		for j := i; j < i+3; j++ {
+			img.Pix[j] = uint8(min(255, max(0, int(img.Pix[j])+d)))
+		}
+	}
+}
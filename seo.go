@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sitemapURLSet is a sitemap of image landing pages using Google's image
+// extension, so each page is indexed along with its image
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Image   string       `xml:"xmlns:image,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string         `xml:"loc"`
+	LastMod string         `xml:"lastmod,omitempty"`
+	Images  []sitemapImage `xml:"image:image"`
+}
+
+type sitemapImage struct {
+	Loc   string `xml:"image:loc"`
+	Title string `xml:"image:title,omitempty"`
+}
+
+// getRobots serves robots.txt. AFROBASE_ROBOTS_FILE replaces it entirely;
+// otherwise AFROBASE_INDEXING=off keeps crawlers out of the whole instance.
+func getRobots(c *fiber.Ctx) error {
+	if path := os.Getenv("AFROBASE_ROBOTS_FILE"); path != "" {
+		return c.SendFile(path)
+	}
+
+	c.Type("txt")
+	if os.Getenv("AFROBASE_INDEXING") == "off" {
+		return c.SendString("User-agent: *\nDisallow: /\n")
+	}
+	return c.SendString("User-agent: *\nAllow: /\nDisallow: /api/\n\nSitemap: " + publicBaseURL + "/sitemap.xml\n")
+}
+
+// getSitemap lists every public image page so search engines can find them
+func getSitemap(c *fiber.Ctx) error {
+	if os.Getenv("AFROBASE_INDEXING") == "off" {
+		return c.Status(404).SendString("Not Found")
+	}
+
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+
+	var urls []sitemapURL
+	for _, file := range files {
+		if isPrivate(file.Name()) || isUnlisted(file.Name()) {
+			continue
+		}
+		urls = append(urls, sitemapURL{
+			Loc:     imagePageURL(file.Name()),
+			LastMod: file.ModTime().UTC().Format(time.RFC3339),
+			Images: []sitemapImage{{
+				Loc:   publicBaseURL + displayPath(file.Name()),
+				Title: imageInfo(file.Name()).Title,
+			}},
+		})
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].Loc < urls[j].Loc
+	})
+
+	out, err := xml.MarshalIndent(sitemapURLSet{
+		XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		Image: "http://www.google.com/schemas/sitemap-image/1.1",
+		URLs:  urls,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	c.Type("xml")
+	return c.Send(append([]byte(xml.Header), out...))
+}
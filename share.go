@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Images marked private are only served through signed links that expire:
+// /uploads/<name>?exp=<unix time>&sig=<hmac>. The signature covers the
+// image ID, so one link's parameters also open the image's variants and
+// resizes.
+
+// signingKey signs share links. It comes from AFROBASE_SIGNING_KEY, or is
+// random, in which case links stop working when the server restarts.
+var signingKey []byte
+
+// Lifetimes of share links
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+// loadSigningKey sets signingKey from the environment or at random
+func loadSigningKey() error {
+	if v := os.Getenv("AFROBASE_SIGNING_KEY"); v != "" {
+		signingKey = []byte(v)
+		return nil
+	}
+	signingKey = make([]byte, 32)
+	if _, err := rand.Read(signingKey); err != nil {
+		return err
+	}
+	log.Printf("No AFROBASE_SIGNING_KEY set, share links last until the server restarts")
+	return nil
+}
+
+// signImage signs an image ID and expiry time
+func signImage(id string, exp int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(id + "\n" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedPath adds an expiring signature for an image to a URL path
+func signedPath(p, id string, exp int64) string {
+	return p + "?" + url.Values{"exp": {strconv.FormatInt(exp, 10)}, "sig": {signImage(id, exp)}}.Encode()
+}
+
+// isPrivate reports whether an upload is only served through signed links,
+// as private images and those awaiting moderation are
+func isPrivate(name string) bool {
+	meta, _ := metadata.get(name)
+	return meta.Private || meta.Pending
+}
+
+// seesPrivate reports whether a request may see a private image without a
+// signed link: it carries a secret API key or the token of the image's owner
+func seesPrivate(c *fiber.Ctx, name string) bool {
+	if hasSecretKey(c) {
+		return true
+	}
+	u, ok := requestUser(c)
+	owner := imageInfo(name).Owner
+	return ok && owner != "" && u.ID == owner
+}
+
+// privateDenied answers requests for a private image that lack a valid
+// signature, reporting whether it did. name is the stored upload.
+func privateDenied(c *fiber.Ctx, name string) (bool, error) {
+	if !isPrivate(name) {
+		return false, nil
+	}
+	c.Set(fiber.HeaderCacheControl, "private")
+	exp, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	sig := c.Query("sig")
+	if err != nil || sig == "" || !hmac.Equal([]byte(sig), []byte(signImage(imageID(name), exp))) {
+		return true, c.Status(403).JSON(fiber.Map{
+			"error":   "This image is private and needs a signed link",
+			"success": false,
+		})
+	}
+	if serverClock.Now().Unix() > exp {
+		return true, c.Status(403).JSON(fiber.Map{
+			"error":   "Signed link has expired",
+			"success": false,
+		})
+	}
+	return false, nil
+}
+
+// uploadOwner returns the upload a stored key belongs to: the key itself
+// for originals, the upload a variant or RAW preview was made from
+// otherwise
+func uploadOwner(key string) (string, bool) {
+	if !strings.Contains(key, "/") {
+		return key, true
+	}
+	return findUpload(imageID(path.Base(key)))
+}
+
+// shareImage creates a signed link to an image and its variants that works
+// even if the image is private: POST /api/images/:id/share, optionally
+// with {"expires_in": "72h"}. Links last a day by default and 30 days at
+// most.
+func shareImage(c *fiber.Ctx) error {
+	var req struct {
+		ExpiresIn string `json:"expires_in"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Body must be JSON",
+				"success": false,
+			})
+		}
+	}
+	ttl := defaultShareTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxShareTTL {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "expires_in must be a duration such as 72h, up to 720h",
+				"success": false,
+			})
+		}
+		ttl = d
+	}
+
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+	if !mayChange(c, name) {
+		return sendNotOwner(c, "Image belongs to another user")
+	}
+	id := imageID(name)
+	expires := serverClock.Now().Add(ttl).Unix()
+
+	variants, _ := variantURLs(name)
+	for size, p := range variants {
+		variants[size] = publicBaseURL + signedPath(p, id, expires)
+	}
+	audit(c, "share", name)
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"id":         id,
+		"url":        publicBaseURL + signedPath(displayPath(name), id, expires),
+		"variants":   variants,
+		"expires_at": expires,
+	})
+}
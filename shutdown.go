@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// shutdownTimeout is how long in-flight requests and variant jobs get to
+// finish after SIGTERM or SIGINT, set via AFROBASE_SHUTDOWN_TIMEOUT
+var shutdownTimeout = loadShutdownTimeout()
+
+func loadShutdownTimeout() time.Duration {
+	v := os.Getenv("AFROBASE_SHUTDOWN_TIMEOUT")
+	if v == "" {
+		return 30 * time.Second
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatal("Invalid AFROBASE_SHUTDOWN_TIMEOUT: ", v)
+	}
+	return d
+}
+
+// serveUntilSignalled serves app until SIGTERM or SIGINT, then stops
+// accepting connections, lets in-flight uploads finish writing and
+// running variant jobs complete, and closes the metadata store. A second
+// signal exits at once.
+func serveUntilSignalled(app *fiber.App, addr string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- app.Listen(addr)
+	}()
+
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case sig := <-signals:
+		log.Printf("Received %v, finishing in-flight requests (up to %v)", sig, shutdownTimeout)
+	}
+	signal.Stop(signals)
+
+	deadline := time.Now().Add(shutdownTimeout)
+	if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+		log.Printf("Requests still running at shutdown, abandoning uploads: %v", err)
+	}
+	cancelUploads()
+	// Idle workers stop at once, even if requests used up the timeout
+	if !stopVariantWorkers(max(time.Second, time.Until(deadline))) {
+		log.Printf("Variant jobs were still running at shutdown; their uploads are requeued on the next start")
+	}
+	if err := metadata.db.Close(); err != nil {
+		log.Printf("Error closing metadata store: %v", err)
+	}
+	log.Printf("Server stopped")
+}
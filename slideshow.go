@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Seconds each slideshow image is shown for
+const (
+	defaultSlideSeconds = 8
+	maxSlideSeconds     = 3600
+)
+
+// slideshowItems is an album's playlist: its public images in album order,
+// each shown for seconds and sourced from the variant named size where it
+// has one ready, its display image otherwise. Every variant ready is also
+// listed, so players can preload the one that fits the screen.
+func slideshowItems(c *fiber.Ctx, a album, size string, seconds int) []fiber.Map {
+	items := make([]fiber.Map, 0, len(a.Images))
+	for _, name := range a.Images {
+		if isPrivate(name) || hiddenFrom(c, name) {
+			continue
+		}
+		variants, _ := variantURLs(name)
+		src := displayPath(name)
+		if path, ok := variants[size]; ok {
+			src = path
+		}
+		for key, path := range variants {
+			variants[key] = publicBaseURL + path
+		}
+		item := fiber.Map{
+			"id":       imageID(name),
+			"title":    imageInfo(name).Title,
+			"url":      publicBaseURL + src,
+			"variants": variants,
+			"duration": seconds,
+		}
+		if header, err := imageHeader(name); err == nil {
+			item["width"] = header.Width
+			item["height"] = header.Height
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// slideSizes lists the variant names a slideshow can be sourced from
+func slideSizes() []string {
+	var sizes []string
+	for _, specs := range variantSets() {
+		for _, spec := range specs {
+			if !slices.Contains(sizes, spec.Key) {
+				sizes = append(sizes, spec.Key)
+			}
+		}
+	}
+	sort.Strings(sizes)
+	return sizes
+}
+
+// getAlbumSlideshow returns an album as a looping playlist for displays:
+// GET /api/albums/:id/slideshow. ?size= picks the variant each slide shows
+// where it is ready, and ?duration= how many seconds it stays up.
+// Private and unlisted images are left out.
+func getAlbumSlideshow(c *fiber.Ctx) error {
+	a, ok := metadata.album(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	}
+	size := c.Query("size")
+	if size != "" && !slices.Contains(slideSizes(), size) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "size must be one of " + strings.Join(slideSizes(), ", "),
+			"success": false,
+		})
+	}
+	seconds := c.QueryInt("duration", defaultSlideSeconds)
+	if seconds < 1 || seconds > maxSlideSeconds {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "duration must be between 1 and 3600 seconds",
+			"success": false,
+		})
+	}
+
+	items := slideshowItems(c, a, size, seconds)
+	return c.JSON(fiber.Map{
+		"success":        true,
+		"album":          a.ID,
+		"name":           a.Name,
+		"loop":           true,
+		"items":          items,
+		"count":          len(items),
+		"total_duration": len(items) * seconds,
+		"updated_at":     a.Updated,
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxSlugLength bounds slugs, in characters
+const maxSlugLength = 80
+
+// The same title can arrive as different bytes: "é" as one code point from
+// most keyboards, or "e" and a combining accent from macOS file names.
+// Titles and descriptions are stored in NFC, the composed form, so they
+// compare equal however they were typed. Slugs and search go further and
+// decompose with NFKD, dropping the accents, so "Café" and "cafe" match.
+
+// normalize puts the text of an image's metadata in NFC and derives its
+// slug from the title
+func (m *imageMeta) normalize() {
+	m.Title = norm.NFC.String(m.Title)
+	m.Description = norm.NFC.String(m.Description)
+	m.Slug = slugify(m.Title)
+}
+
+// foldText reduces text to the form searches compare: decomposed with
+// NFKD, without combining marks and in lower case. Compatibility forms
+// such as ligatures and full-width letters become their plain letters.
+func foldText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// slugify makes a URL-friendly name from a title: folded like a search,
+// with letters and digits of any script kept and everything between them
+// turned into single hyphens, e.g. "Café au Lait!" becomes "cafe-au-lait"
+// and "Ночь в Москве" becomes "ночь-в-москве"
+func slugify(title string) string {
+	var b strings.Builder
+	n, hyphen := 0, false
+	for _, r := range foldText(title) {
+		if n == maxSlugLength {
+			break
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			hyphen = b.Len() > 0
+			continue
+		}
+		if hyphen {
+			b.WriteByte('-')
+			n++
+			hyphen = false
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
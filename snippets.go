@@ -0,0 +1,70 @@
+package main
+
+import (
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getImageSnippets returns ready-to-paste Markdown, BBCode and HTML for an
+// image. ?size= picks a variant (e.g. 200, 800) or "original"; by default
+// the largest generated variant is used so forum posts don't hotlink the
+// full-size file.
+func getImageSnippets(c *fiber.Ctx) error {
+	name, ok := findUpload(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Image not found",
+			"success": false,
+		})
+	}
+
+	variants, _ := variantURLs(name)
+	size := c.Query("size")
+	imageURL := publicBaseURL + displayPath(name)
+	switch {
+	case size == "original":
+	case size != "":
+		path, ok := variants[size]
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Unknown or not yet generated size: " + size,
+				"success": false,
+			})
+		}
+		imageURL = publicBaseURL + path
+	default:
+		size = "original"
+		for i := len(variantSizes) - 1; i >= 0; i-- {
+			if path, ok := variants[strconv.Itoa(variantSizes[i])]; ok {
+				size = strconv.Itoa(variantSizes[i])
+				imageURL = publicBaseURL + path
+				break
+			}
+		}
+	}
+
+	title := imageInfo(name).Title
+	pageURL := imagePageURL(name)
+	return c.JSON(fiber.Map{
+		"success":  true,
+		"id":       imageID(name),
+		"size":     size,
+		"markdown": "[![" + markdownEscape(title) + "](" + imageURL + ")](" + pageURL + ")",
+		"bbcode":   "[url=" + pageURL + "][img]" + imageURL + "[/img][/url]",
+		"html":     embedHTML(pageURL, imageURL, title),
+	})
+}
+
+// embedHTML is the HTML snippet that links an image back to its page
+func embedHTML(pageURL, imageURL, title string) string {
+	return `<a href="` + template.HTMLEscapeString(pageURL) + `"><img src="` +
+		template.HTMLEscapeString(imageURL) + `" alt="` + template.HTMLEscapeString(title) + `"></a>`
+}
+
+// markdownEscape escapes the characters that would end a Markdown link label
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(s)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runSoak implements `soak`, driving a running server with synthetic
+// traffic for hours while sampling its goroutine count and heap through
+// the admin listener's /metrics, and exiting with an error if either keeps
+// growing:
+//
+//	afrobase -sandbox &   # with AFROBASE_ADMIN_ADDR and AFROBASE_ADMIN_TOKEN set
+//	afrobase soak -duration 4h
+//
+// Each round uploads a new generated image, fetches it, a resize of it and
+// a listing page, then deletes it, so the library stays the same size. The
+// change journal still grows by a few hundred bytes per round by design.
+func runSoak(args []string) {
+	flags := flag.NewFlagSet("soak", flag.ExitOnError)
+	target := flags.String("url", "http://127.0.0.1:5174", "server to drive")
+	admin := flags.String("admin", "http://127.0.0.1:6060", "the server's admin listener")
+	token := flags.String("admin-token", os.Getenv("AFROBASE_ADMIN_TOKEN"), "admin bearer token")
+	key := flags.String("key", "", "API key for uploads and deletes, if the server requires one")
+	duration := flags.Duration("duration", 4*time.Hour, "how long to run")
+	warmup := flags.Duration("warmup", 10*time.Minute, "time to let caches and pools fill before judging growth")
+	interval := flags.Duration("interval", time.Minute, "time between samples")
+	concurrency := flags.Int("concurrency", 4, "rounds run at once")
+	goroutineSlack := flags.Int("goroutine-slack", 20, "goroutines the count may settle above its early peak")
+	heapGrowth := flags.Float64("heap-growth", 0.5, "fraction the heap may settle above its early peak")
+	flags.Parse(args)
+	if *concurrency < 1 || *interval <= 0 || *warmup >= *duration {
+		log.Fatal("Invalid soak options: -concurrency and -interval must be positive and -warmup shorter than -duration")
+	}
+
+	s := &soaker{
+		client: &http.Client{Timeout: time.Minute},
+		target: strings.TrimSuffix(*target, "/"),
+		key:    *key,
+	}
+	sampleMetrics := func() (soakSample, error) {
+		return readSoakSample(s.client, strings.TrimSuffix(*admin, "/")+"/metrics", *token)
+	}
+	if _, err := sampleMetrics(); err != nil {
+		log.Fatal("Failed to read the server's metrics (is the admin listener up?): ", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(stop)
+		}()
+	}
+
+	log.Printf("Soaking %s for %v", s.target, *duration)
+	start := time.Now()
+	var samples []soakSample
+	for tick := time.Tick(*interval); time.Since(start) < *duration; <-tick {
+		sample, err := sampleMetrics()
+		if err != nil {
+			log.Printf("Error sampling metrics: %v", err)
+			continue
+		}
+		sample.elapsed = time.Since(start)
+		log.Printf("Soak %v: %d rounds, %d errors, %d goroutines, %d MB heap",
+			sample.elapsed.Round(time.Second), s.rounds.Load(), s.errors.Load(), sample.goroutines, sample.heap>>20)
+		if sample.elapsed >= *warmup {
+			samples = append(samples, sample)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	log.Printf("Soak finished: %d rounds, %d errors", s.rounds.Load(), s.errors.Load())
+	if len(samples) < 6 {
+		log.Printf("Only %d samples after warm-up, too few to judge growth; run longer or sample more often", len(samples))
+		return
+	}
+	var leaks []string
+	goroutines := func(s soakSample) float64 { return float64(s.goroutines) }
+	heap := func(s soakSample) float64 { return float64(s.heap) }
+	if early, late := settledRange(samples, goroutines); late > early+float64(*goroutineSlack) {
+		leaks = append(leaks, fmt.Sprintf("goroutines grew from at most %.0f to at least %.0f", early, late))
+	}
+	if early, late := settledRange(samples, heap); late > early*(1+*heapGrowth) {
+		leaks = append(leaks, fmt.Sprintf("heap grew from at most %d MB to at least %d MB", int64(early)>>20, int64(late)>>20))
+	}
+	if len(leaks) > 0 {
+		log.Fatal("Soak found unbounded growth: ", strings.Join(leaks, "; "))
+	}
+	log.Printf("No unbounded growth in goroutines or heap")
+}
+
+// settledRange returns the peak of a measure over the first third of the
+// samples and its low point over the last third. Garbage collection makes
+// the heap saw up and down; only a leak lifts the low points above the
+// earlier peaks.
+func settledRange(samples []soakSample, measure func(soakSample) float64) (early, late float64) {
+	third := len(samples) / 3
+	var first, last []float64
+	for _, s := range samples[:third] {
+		first = append(first, measure(s))
+	}
+	for _, s := range samples[len(samples)-third:] {
+		last = append(last, measure(s))
+	}
+	return slices.Max(first), slices.Min(last)
+}
+
+// soakSample is the server's state at one point of a soak
+type soakSample struct {
+	elapsed    time.Duration
+	goroutines int64
+	heap       int64
+}
+
+// readSoakSample reads goroutine and heap figures from /metrics
+func readSoakSample(client *http.Client, url, token string) (soakSample, error) {
+	var sample soakSample
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return sample, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return sample, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return sample, fmt.Errorf("metrics returned %s", resp.Status)
+	}
+	var found int
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		var dst *int64
+		switch name {
+		case "go_goroutines":
+			dst = &sample.goroutines
+		case "go_memstats_heap_inuse_bytes":
+			dst = &sample.heap
+		default:
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return sample, fmt.Errorf("metric %s has value %q", name, value)
+		}
+		*dst = int64(v)
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return sample, err
+	}
+	if found < 2 {
+		return sample, fmt.Errorf("metrics are missing go_goroutines or go_memstats_heap_inuse_bytes")
+	}
+	return sample, nil
+}
+
+// soaker runs rounds of traffic against a server
+type soaker struct {
+	client         *http.Client
+	target, key    string
+	rounds, errors atomic.Int64
+}
+
+func (s *soaker) run(stop <-chan struct{}) {
+	rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err := s.round(rng); err != nil {
+			s.errors.Add(1)
+			log.Printf("Soak round failed: %v", err)
+			// Don't spin against a server that is down
+			time.Sleep(time.Second)
+		}
+		s.rounds.Add(1)
+	}
+}
+
+// round uploads an image, reads it back in several ways and deletes it
+func (s *soaker) round(rng *rand.Rand) error {
+	title := fmt.Sprintf("Soak %d", rng.Uint32())
+	from := color.RGBA{uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256)), 255}
+	to := color.RGBA{uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256)), 255}
+	data, _, err := encodeImage(gradientImage(320, 240, from, to, title), false, 0)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ImagePayload{Title: title, Image: base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return err
+	}
+
+	var uploaded struct {
+		URL string `json:"url"`
+	}
+	if err := s.do("POST", "/upload", body, &uploaded); err != nil {
+		return err
+	}
+	name := path.Base(uploaded.URL)
+	id := strings.TrimSuffix(name, path.Ext(name))
+
+	for _, p := range []string{uploaded.URL, "/img/" + id + "?w=120", "/api/images/" + id, "/api/images?limit=20"} {
+		if err := s.do("GET", p, nil, nil); err != nil {
+			return err
+		}
+	}
+	return s.do("DELETE", "/api/images/"+id, nil, nil)
+}
+
+// do makes a request, decoding a JSON response into out if it is set
+func (s *soaker) do(method, p string, body []byte, out any) error {
+	req, err := http.NewRequest(method, s.target+p, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.key != "" {
+		req.Header.Set(apiKeyHeader, s.key)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned %s", method, p, resp.Status)
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// stagingDir holds the bytes of two-phase uploads until they are committed
+var stagingDir = filepath.Join(dataDir, "staging")
+
+// stagedBytes keeps the bytes of upload sessions by session ID
+type stagedBytes interface {
+	// reset discards whatever a previous run left
+	reset() error
+	// write replaces a session's bytes, giving up if ctx is done
+	write(ctx context.Context, id string, data []byte) error
+	// writeAt writes a chunk at offset, dropping anything after it
+	writeAt(id string, offset int64, chunk []byte) error
+	read(id string) ([]byte, error)
+	// remove drops a session's bytes, if it has any
+	remove(id string) error
+	// ids lists the sessions that have bytes
+	ids() ([]string, error)
+}
+
+// stagedData is where session bytes are kept: files in stagingDir, or
+// memory in the sandbox
+var stagedData stagedBytes = stagingFiles{}
+
+// stagingFiles keeps each session's bytes in a file in stagingDir
+type stagingFiles struct{}
+
+func (stagingFiles) reset() error {
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	return os.MkdirAll(stagingDir, 0755)
+}
+
+func (stagingFiles) write(ctx context.Context, id string, data []byte) error {
+	return writeFileContext(ctx, stagedPath(id), data, 0644)
+}
+
+func (stagingFiles) writeAt(id string, offset int64, chunk []byte) error {
+	return appendChunk(stagedPath(id), offset, chunk)
+}
+
+func (stagingFiles) read(id string) ([]byte, error) {
+	return os.ReadFile(stagedPath(id))
+}
+
+func (stagingFiles) remove(id string) error {
+	if err := os.Remove(stagedPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (stagingFiles) ids() ([]string, error) {
+	entries, err := os.ReadDir(stagingDir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.Name())
+	}
+	return ids, nil
+}
+
+func stagedPath(id string) string {
+	return filepath.Join(stagingDir, id)
+}
+
+// stagingMemory keeps session bytes in memory
+type stagingMemory struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (m *stagingMemory) reset() error {
+	m.mu.Lock()
+	m.data = map[string][]byte{}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *stagingMemory) write(ctx context.Context, id string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.data[id] = slices.Clone(data)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *stagingMemory) writeAt(id string, offset int64, chunk []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data := m.data[id]
+	if int64(len(data)) < offset {
+		data = append(data, make([]byte, offset-int64(len(data)))...)
+	}
+	m.data[id] = append(data[:offset], chunk...)
+	return nil
+}
+
+func (m *stagingMemory) read(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.data[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return slices.Clone(data), nil
+}
+
+func (m *stagingMemory) remove(id string) error {
+	m.mu.Lock()
+	delete(m.data, id)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *stagingMemory) ids() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.data))
+	for id := range m.data {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// uploadTTL is how long a reserved upload waits for its bytes and commit
+// before it is discarded (AFROBASE_UPLOAD_TTL, default 1h)
+var uploadTTL = time.Hour
+
+// Upload session states
+const (
+	sessionReserved   = "reserved"
+	sessionReceiving  = "receiving"
+	sessionUploaded   = "uploaded"
+	sessionCommitting = "committing"
+	sessionCommitted  = "committed"
+)
+
+// stagedUpload is an upload session. Size is -1 until bytes are PUT, and
+// name is the stored filename once committed. Resumable (tus) sessions
+// declare their length up front, receive their bytes in chunks and carry
+// the metadata they are committed with.
+type stagedUpload struct {
+	id           string
+	state        string
+	created      time.Time
+	expires      time.Time
+	size         int64
+	declaredType string
+	name         string
+
+	length   int64
+	payload  ImagePayload
+	profile  string
+	patching bool
+}
+
+// stagingArea tracks upload sessions. Sessions live in memory, so the
+// staged bytes are discarded at startup.
+type stagingArea struct {
+	mu      sync.Mutex
+	uploads map[string]*stagedUpload
+}
+
+var staged = &stagingArea{uploads: map[string]*stagedUpload{}}
+
+// startStaging clears leftovers from a previous run and expires abandoned
+// uploads in the background
+func startStaging() error {
+	if err := stagedData.reset(); err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			staged.expire(serverClock.Now())
+		}
+	}()
+	return nil
+}
+
+// lookup returns an unexpired reservation
+func (s *stagingArea) lookup(id string) (*stagedUpload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok || serverClock.Now().After(u.expires) {
+		return nil, false
+	}
+	return u, true
+}
+
+// expire discards sessions past their TTL, and removes staged bytes that
+// no longer belong to a session, such as those of committed uploads
+func (s *stagingArea) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, u := range s.uploads {
+		if now.After(u.expires) && u.state != sessionCommitting {
+			delete(s.uploads, id)
+		}
+	}
+
+	ids, err := stagedData.ids()
+	if err != nil {
+		log.Printf("Error reading staged uploads: %v", err)
+		return
+	}
+	for _, id := range ids {
+		u, ok := s.uploads[id]
+		if ok && u.state != sessionCommitted {
+			continue
+		}
+		if err := stagedData.remove(id); err != nil {
+			log.Printf("Error removing staged upload %s: %v", id, err)
+		}
+	}
+}
+
+// setState moves a session to a new state
+func (s *stagingArea) setState(u *stagedUpload, state string) {
+	s.mu.Lock()
+	u.state = state
+	s.mu.Unlock()
+}
+
+// view describes a session for its client
+func (u *stagedUpload) view() fiber.Map {
+	view := fiber.Map{
+		"success":    true,
+		"id":         u.id,
+		"state":      u.state,
+		"created_at": u.created.UTC().Format(time.RFC3339),
+		"expires_at": u.expires.UTC().Format(time.RFC3339),
+		"upload_url": publicBaseURL + "/api/uploads/" + u.id,
+		"commit_url": publicBaseURL + "/api/uploads/" + u.id + "/commit",
+	}
+	if u.size >= 0 {
+		view["size"] = u.size
+	}
+	if u.length > 0 {
+		view["upload_url"] = tusLocation(u.id)
+		view["length"] = u.length
+	}
+	if u.declaredType != "" {
+		view["declared_type"] = u.declaredType
+	}
+	if u.name != "" {
+		view["name"] = u.name
+		view["url"] = publicBaseURL + displayPath(u.name)
+	}
+	return view
+}
+
+// reserveUpload starts a two-phase upload: POST /api/uploads returns an ID
+// and the URL to PUT the image bytes to
+func reserveUpload(c *fiber.Ctx) error {
+	id, err := newID(16)
+	if err != nil {
+		log.Printf("Error generating upload ID: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to reserve upload",
+			"success": false,
+		})
+	}
+	now := serverClock.Now()
+	u := &stagedUpload{
+		id:      id,
+		state:   sessionReserved,
+		created: now,
+		expires: now.Add(uploadTTL),
+		size:    -1,
+	}
+	staged.mu.Lock()
+	staged.uploads[u.id] = u
+	view := u.view()
+	staged.mu.Unlock()
+
+	return c.Status(201).JSON(view)
+}
+
+// getUploadSession reports the state of an upload session:
+// GET /api/uploads/:id
+func getUploadSession(c *fiber.Ctx) error {
+	u, ok := staged.lookup(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Upload not found or expired",
+			"success": false,
+		})
+	}
+	staged.mu.Lock()
+	view := u.view()
+	staged.mu.Unlock()
+	return c.JSON(view)
+}
+
+// putUploadBytes stores the raw image bytes of a reserved upload:
+// PUT /api/uploads/:id. A repeated PUT replaces the bytes.
+func putUploadBytes(c *fiber.Ctx) error {
+	u, ok := staged.lookup(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Upload not found or expired",
+			"success": false,
+		})
+	}
+	if len(c.Body()) == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Image data is required",
+			"success": false,
+		})
+	}
+
+	ctx, cancel, err := uploadContext(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid " + uploadDeadlineHeader + " header",
+			"success": false,
+		})
+	}
+	defer cancel()
+
+	staged.mu.Lock()
+	state, resumable := u.state, u.length > 0
+	staged.mu.Unlock()
+	if state == sessionCommitting || state == sessionCommitted {
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Upload is already " + state,
+			"success": false,
+		})
+	}
+	if resumable {
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Resumable uploads take their bytes by PATCH",
+			"success": false,
+		})
+	}
+
+	if err := stagedData.write(ctx, u.id, c.Body()); err != nil {
+		if ctx.Err() != nil {
+			return abortedUpload(c, ctx.Err())
+		}
+		log.Printf("Error staging upload %s: %v", u.id, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save image",
+			"success": false,
+		})
+	}
+
+	staged.mu.Lock()
+	u.state = sessionUploaded
+	u.size = int64(len(c.Body()))
+	u.declaredType = ""
+	if contentType := strings.ToLower(strings.Clone(c.Get(fiber.HeaderContentType))); strings.HasPrefix(contentType, "image/") {
+		u.declaredType, _, _ = strings.Cut(contentType, ";")
+	}
+	staged.mu.Unlock()
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      u.id,
+		"size":    u.size,
+	})
+}
+
+// commitUpload finishes a two-phase upload with its metadata:
+// POST /api/uploads/:id/commit takes the JSON upload payload without the
+// image, and the same profile and mode options as /upload
+func commitUpload(c *fiber.Ctx) error {
+	var payload ImagePayload
+	attempt := &uploadAttempt{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		log.Printf("Error parsing request body: %v", err)
+		return attempt.reject(c, 400, "request_body", "Invalid request body")
+	}
+
+	u, ok := staged.lookup(c.Params("id"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Upload not found or expired",
+			"success": false,
+		})
+	}
+
+	profileName := c.Query("profile")
+	attempt.profile = profileName
+	var profile *uploadProfile
+	if profileName != "" {
+		if profile, ok = uploadProfiles[profileName]; !ok {
+			return attempt.reject(c, 400, "unknown_profile", "Unknown upload profile: "+profileName)
+		}
+	}
+
+	ctx, cancel, err := uploadContext(c)
+	if err != nil {
+		return attempt.reject(c, 400, "deadline_header", "Invalid "+uploadDeadlineHeader+" header")
+	}
+	defer cancel()
+
+	return commitStaged(c, ctx, u, attempt, payload, profile)
+}
+
+// commitStaged stores the bytes of an uploaded session as an image
+func commitStaged(c *fiber.Ctx, ctx context.Context, u *stagedUpload, attempt *uploadAttempt, payload ImagePayload, profile *uploadProfile) error {
+	// Only one commit of an upload can run at a time
+	staged.mu.Lock()
+	if u.state != sessionUploaded {
+		state := u.state
+		staged.mu.Unlock()
+		message := "Upload is already " + state
+		switch state {
+		case sessionReserved:
+			message = "No image data has been uploaded"
+		case sessionReceiving:
+			message = "Upload is incomplete"
+		}
+		return c.Status(409).JSON(fiber.Map{
+			"error":   message,
+			"success": false,
+		})
+	}
+	u.state = sessionCommitting
+	attempt.declaredType = u.declaredType
+	staged.mu.Unlock()
+
+	imageData, err := stagedData.read(u.id)
+	if err != nil {
+		staged.setState(u, sessionUploaded)
+		log.Printf("Error reading staged upload %s: %v", u.id, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploaded image",
+			"success": false,
+		})
+	}
+	attempt.data = imageData
+
+	// A rejected commit keeps the bytes, so the client can retry with
+	// different metadata until the session expires. A committed session is
+	// kept for its state until then, but its bytes are dropped.
+	err = storeUpload(c, ctx, attempt, payload, profile, imageData)
+	staged.mu.Lock()
+	u.state = sessionUploaded
+	if attempt.stored != "" {
+		u.state = sessionCommitted
+		u.name = attempt.stored
+	}
+	staged.mu.Unlock()
+	if attempt.stored != "" {
+		stagedData.remove(u.id)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// usage is the number and total size of a set of uploads
+type usage struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (u *usage) add(size int64) {
+	u.Count++
+	u.Bytes += size
+}
+
+// statsGroupings name the ways uploads can be grouped in a breakdown. Each
+// returns the groups an upload belongs to, which may be several.
+var statsGroupings = map[string]func(name string) []string{
+	"format": func(name string) []string { return []string{uploadFormat(name)} },
+	"album":  uploadAlbums,
+}
+
+// uploadAlbums names the albums an upload is in by ID, or "none"
+func uploadAlbums(name string) []string {
+	var ids []string
+	for _, a := range metadata.albumsOf(name) {
+		ids = append(ids, a.ID)
+	}
+	if len(ids) == 0 {
+		return []string{"none"}
+	}
+	return ids
+}
+
+// uploadFormat names an upload's format from its extension, using the
+// names of the format policy where it has one
+func uploadFormat(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".jpeg":
+		ext = ".jpg"
+	case ".tiff":
+		ext = ".tif"
+	}
+	for format, e := range formatExtensions {
+		if e == ext {
+			return format
+		}
+	}
+	if ext == "" {
+		return "unknown"
+	}
+	return ext[1:]
+}
+
+// getStatsBreakdown counts uploads and the bytes their originals take up,
+// grouped every way statsGroupings knows: GET /api/admin/stats/breakdown
+func getStatsBreakdown(c *fiber.Ctx) error {
+	files, err := uploadedFiles()
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+
+	var total usage
+	breakdown := make(map[string]map[string]*usage, len(statsGroupings))
+	for grouping := range statsGroupings {
+		breakdown[grouping] = map[string]*usage{}
+	}
+	for _, file := range files {
+		total.add(file.Size())
+		for grouping, groupsOf := range statsGroupings {
+			for _, group := range groupsOf(file.Name()) {
+				u, ok := breakdown[grouping][group]
+				if !ok {
+					u = &usage{}
+					breakdown[grouping][group] = u
+				}
+				u.add(file.Size())
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success":   true,
+		"total":     total,
+		"breakdown": breakdown,
+	})
+}
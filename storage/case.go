@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CaseSensitive reports whether the filesystem holding dir tells names
+// apart by case. The default filesystems on macOS and Windows don't, so
+// "Photo.jpg" and "photo.jpg" are the same file there. It finds out by
+// creating a file and looking it up under an upper-case name.
+func CaseSensitive(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, ".casecheck-*")
+	if err != nil {
+		return false, err
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+
+	upper := filepath.Join(filepath.Dir(name), strings.ToUpper(filepath.Base(name)))
+	_, err = os.Stat(upper)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
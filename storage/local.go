@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// copyChunk is how much Put copies between cancellation checks
+const copyChunk = 1 << 20
+
+// Local stores objects as files under a directory
+type Local struct {
+	root string
+	// TempDir is where Put writes files before renaming them into place,
+	// by default .tmp under the root. When it is on another filesystem the
+	// rename fails with EXDEV and Put copies the file across instead.
+	TempDir string
+}
+
+// NewLocal returns a store rooted at dir, creating it if needed
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{root: dir}, nil
+}
+
+func (l *Local) Name() string {
+	return "local:" + l.root
+}
+
+// Root is the directory objects are stored in
+func (l *Local) Root() string {
+	return l.root
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	if err := checkPlatformKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) tempDir() string {
+	if l.TempDir != "" {
+		return l.TempDir
+	}
+	return filepath.Join(l.root, ".tmp")
+}
+
+// Put writes to a temporary file and renames it into place, checking ctx
+// between chunks
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	dst, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.tempDir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(l.tempDir(), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		n, err := io.CopyN(f, r, copyChunk)
+		written += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if size >= 0 && written != size {
+		return fail(fmt.Errorf("storage: wrote %d of %d bytes", written, size))
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Rename(f.Name(), dst)
+	if errors.Is(err, syscall.EXDEV) {
+		err = moveAcross(f.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// moveAcross moves a file to another filesystem. It copies it beside dst,
+// syncs the copy and renames it into place, so readers still never see a
+// partial file, then removes the original.
+func moveAcross(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(0644)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, Object{}, localError(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Object{}, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, Object{}, ErrNotExist
+	}
+	return f, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	r, object, err := l.Get(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	n, err := rangeLength(offset, length, object.Size)
+	if err == nil {
+		_, err = r.(*os.File).Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		r.Close()
+		return nil, Object{}, err
+	}
+	return limitedReadCloser{io.LimitReader(r, n), r}, object, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return Object{}, localError(err)
+	}
+	if !info.Mode().IsRegular() {
+		return Object{}, ErrNotExist
+	}
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List reads the directory named by prefix, skipping subdirectories and
+// temporary files
+func (l *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	dir, name := splitPrefix(prefix)
+	if dir != "" {
+		if err := CheckKey(strings.TrimSuffix(dir, "/")); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(l.root, filepath.FromSlash(dir)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []Object
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), name) ||
+			strings.HasPrefix(entry.Name(), ".") || path.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, Object{Key: dir + entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	return err
+}
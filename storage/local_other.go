@@ -0,0 +1,9 @@
+//go:build !windows
+
+package storage
+
+// checkPlatformKey accepts any clean key; only Windows restricts names
+// further
+func checkPlatformKey(key string) error {
+	return nil
+}
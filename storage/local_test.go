@@ -0,0 +1,19 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"AfroBaseServer/storage"
+	"AfroBaseServer/storage/storagetest"
+)
+
+func TestLocal(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storagetest.TestStorage(context.Background(), local, "conformance"); err != nil {
+		t.Fatal(err)
+	}
+}
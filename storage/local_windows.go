@@ -0,0 +1,15 @@
+package storage
+
+import "strings"
+
+// checkPlatformKey refuses keys Windows can't store under the same name.
+// Long paths need no handling here; the os package adds the \\?\ prefix
+// itself.
+func checkPlatformKey(key string) error {
+	for _, elem := range strings.Split(key, "/") {
+		if !PortableName(elem) {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
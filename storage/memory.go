@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps objects in memory. Nothing touches the disk and everything
+// is gone when the process exits.
+type Memory struct {
+	// Now stamps objects with their modification time as they are put. It
+	// defaults to time.Now.
+	Now func() time.Time
+
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty store
+func NewMemory() *Memory {
+	return &Memory{Now: time.Now, objects: map[string]memoryObject{}}
+}
+
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+// Put reads the whole object before storing it, checking ctx between chunks
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if size > 0 {
+		buf.Grow(int(size))
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := io.CopyN(&buf, r, copyChunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if size >= 0 && int64(buf.Len()) != size {
+		return fmt.Errorf("storage: wrote %d of %d bytes", buf.Len(), size)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{data: buf.Bytes(), modTime: m.Now()}
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	object, err := m.Stat(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	m.mu.RLock()
+	data := m.objects[key].data
+	m.mu.RUnlock()
+	// Put replaces objects rather than changing them, so readers can keep
+	// the old bytes
+	return io.NopCloser(bytes.NewReader(data)), object, nil
+}
+
+func (m *Memory) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	object, err := m.Stat(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	n, err := rangeLength(offset, length, object.Size)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	m.mu.RLock()
+	data := m.objects[key].data
+	m.mu.RUnlock()
+	return io.NopCloser(bytes.NewReader(data[offset : offset+n])), object, nil
+}
+
+func (m *Memory) Stat(ctx context.Context, key string) (Object, error) {
+	if err := CheckKey(key); err != nil {
+		return Object{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	o, ok := m.objects[key]
+	if !ok {
+		return Object{}, ErrNotExist
+	}
+	return Object{Key: key, Size: int64(len(o.data)), ModTime: o.modTime}, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]Object, error) {
+	dir, _ := splitPrefix(prefix)
+	if dir != "" {
+		if err := CheckKey(strings.TrimSuffix(dir, "/")); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var objects []Object
+	for key, o := range m.objects {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(dir):], "/") {
+			objects = append(objects, Object{Key: key, Size: int64(len(o.data)), ModTime: o.modTime})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
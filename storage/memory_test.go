@@ -0,0 +1,15 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"AfroBaseServer/storage"
+	"AfroBaseServer/storage/storagetest"
+)
+
+func TestMemory(t *testing.T) {
+	if err := storagetest.TestStorage(context.Background(), storage.NewMemory(), "conformance"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// windowsDeviceNames are reserved on Windows in any case and with any
+// extension: "nul.txt" opens the NUL device rather than a file
+var windowsDeviceNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM0", "COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9", "COM¹", "COM²", "COM³",
+	"LPT0", "LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9", "LPT¹", "LPT²", "LPT³",
+}
+
+// maxNameLength is the longest path element most filesystems allow, in
+// bytes on Unix and UTF-16 units on NTFS; bytes are never fewer
+const maxNameLength = 255
+
+// PortableName reports whether a path element can be stored under the
+// same name on Windows, macOS and Linux. Windows refuses the characters
+// <>:"/\|?* and control characters, and opens device names like CON and
+// NUL instead of files. It also drops trailing dots and spaces, so "a."
+// and "a" name the same file.
+func PortableName(name string) bool {
+	if name == "" || len(name) > maxNameLength || !utf8.ValidString(name) {
+		return false
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return false
+		}
+	}
+	base, _, _ := strings.Cut(name, ".")
+	base = strings.TrimRight(base, " ")
+	for _, device := range windowsDeviceNames {
+		if strings.EqualFold(base, device) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+// Package storage abstracts where uploads and their derived files live.
+//
+// Keys are slash-separated paths relative to the root of a store, such as
+// "1751220909_Drum_Circle.png" or "thumbs/200/1751220909_Drum_Circle.jpg".
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned for keys with no object
+var ErrNotExist = errors.New("storage: object does not exist")
+
+// ErrInvalidKey is returned for keys that aren't clean relative paths
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// ErrInvalidRange is returned for ranges that are empty or start at or
+// past the end of an object
+var ErrInvalidRange = errors.New("storage: invalid range")
+
+// Object describes a stored object
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Info presents the object as file info named by the last element of its
+// key, for code written against directory listings
+func (o Object) Info() fs.FileInfo {
+	return objectInfo{o}
+}
+
+type objectInfo struct{ o Object }
+
+func (i objectInfo) Name() string       { return path.Base(i.o.Key) }
+func (i objectInfo) Size() int64        { return i.o.Size }
+func (i objectInfo) Mode() fs.FileMode  { return 0644 }
+func (i objectInfo) ModTime() time.Time { return i.o.ModTime }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() any           { return nil }
+
+// Storage is a flat store of objects addressed by key
+type Storage interface {
+	// Name identifies the backend in logs
+	Name() string
+	// Put stores size bytes read from r under key, replacing any existing
+	// object. Readers never see a partially written object.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens an object for reading
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	// GetRange opens length bytes of an object from offset for reading,
+	// fewer if the object ends first, or the rest of it if length is
+	// negative. The Object describes the whole object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error)
+	// Stat describes an object without reading it
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete removes an object. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose keys start with prefix and have no
+	// further "/" after it, sorted by key. List("thumbs/200/") lists that
+	// directory; List("") lists the top level.
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// CheckKey reports whether key is a clean relative path
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || strings.ContainsRune(key, 0) {
+		return ErrInvalidKey
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// ReadAll reads a whole object
+func ReadAll(ctx context.Context, s Storage, key string) ([]byte, error) {
+	r, _, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// rangeLength checks a range of an object of size bytes, returning how
+// many bytes of it there are to read
+func rangeLength(offset, length, size int64) (int64, error) {
+	if offset < 0 || length == 0 || offset >= size {
+		return 0, ErrInvalidRange
+	}
+	if length < 0 || length > size-offset {
+		length = size - offset
+	}
+	return length, nil
+}
+
+// limitedReadCloser reads at most a range's length from an object
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// splitPrefix splits a List prefix into its directory, which ends in "/"
+// unless empty, and the start of the names within it
+func splitPrefix(prefix string) (dir, name string) {
+	i := strings.LastIndex(prefix, "/")
+	return prefix[:i+1], prefix[i+1:]
+}
@@ -0,0 +1,309 @@
+// Package storagetest checks that a storage.Storage implementation keeps
+// the promises the rest of AfroBase relies on: whole-object replacement,
+// failed writes leaving nothing behind, ranged reads, the ErrNotExist,
+// ErrInvalidKey and ErrInvalidRange errors, and List's ordering and
+// one-level semantics.
+//
+// A backend's tests call it with a fresh store:
+//
+//	if err := storagetest.TestStorage(ctx, store, "conformance"); err != nil {
+//		t.Fatal(err)
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"AfroBaseServer/storage"
+)
+
+// TestStorage exercises s under keys starting with dir + "/", deleting
+// what it wrote before returning. It reports every check that failed.
+// Backends with real directories may keep the empty ones it created.
+func TestStorage(ctx context.Context, s storage.Storage, dir string) error {
+	if err := storage.CheckKey(dir); err != nil {
+		return fmt.Errorf("storagetest: dir %q is not a valid key", dir)
+	}
+	t := &tester{ctx: ctx, s: s, dir: dir + "/"}
+	defer t.cleanup()
+
+	t.roundTrip()
+	t.replace()
+	t.ranges()
+	t.missing()
+	t.invalidKeys()
+	t.failedPut()
+	t.cancelledPut()
+	t.list()
+	return errors.Join(t.errs...)
+}
+
+type tester struct {
+	ctx     context.Context
+	s       storage.Storage
+	dir     string
+	written []string
+	errs    []error
+}
+
+func (t *tester) errorf(format string, args ...any) {
+	t.errs = append(t.errs, fmt.Errorf("%s: "+format, append([]any{t.s.Name()}, args...)...))
+}
+
+// key returns a key under the test directory
+func (t *tester) key(name string) string {
+	return t.dir + name
+}
+
+func (t *tester) put(key string, data []byte) bool {
+	t.written = append(t.written, key)
+	if err := t.s.Put(t.ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.errorf("Put(%q): %v", key, err)
+		return false
+	}
+	return true
+}
+
+// content checks the bytes and size of an object
+func (t *tester) content(key string, want []byte) {
+	r, object, err := t.s.Get(t.ctx, key)
+	if err != nil {
+		t.errorf("Get(%q): %v", key, err)
+		return
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.errorf("reading %q: %v", key, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.errorf("Get(%q) read %d bytes that differ from the %d put", key, len(got), len(want))
+	}
+	if object.Key != key || object.Size != int64(len(want)) {
+		t.errorf("Get(%q) described the object as %q of %d bytes, want %d bytes", key, object.Key, object.Size, len(want))
+	}
+
+	stat, err := t.s.Stat(t.ctx, key)
+	if err != nil {
+		t.errorf("Stat(%q): %v", key, err)
+		return
+	}
+	if stat.Key != key || stat.Size != int64(len(want)) {
+		t.errorf("Stat(%q) = %q of %d bytes, want %d bytes", key, stat.Key, stat.Size, len(want))
+	}
+	if stat.ModTime.IsZero() {
+		t.errorf("Stat(%q) has no modification time", key)
+	}
+}
+
+// absent checks that an object doesn't exist
+func (t *tester) absent(key, after string) {
+	if _, err := t.s.Stat(t.ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		t.errorf("Stat(%q) after %s = %v, want ErrNotExist", key, after, err)
+	}
+	if r, _, err := t.s.Get(t.ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		if err == nil {
+			r.Close()
+		}
+		t.errorf("Get(%q) after %s = %v, want ErrNotExist", key, after, err)
+	}
+}
+
+func (t *tester) roundTrip() {
+	// Larger than the chunks backends copy in, and not a multiple of them
+	data := bytes.Repeat([]byte("afrobase\x00\xff"), 100_003)
+	key := t.key("round-trip.bin")
+	if t.put(key, data) {
+		t.content(key, data)
+	}
+	empty := t.key("empty.bin")
+	if t.put(empty, nil) {
+		t.content(empty, nil)
+	}
+	nested := t.key("a/b/c.txt")
+	if t.put(nested, []byte("nested")) {
+		t.content(nested, []byte("nested"))
+	}
+}
+
+func (t *tester) replace() {
+	key := t.key("replace.txt")
+	if t.put(key, []byte("the first, longer version")) && t.put(key, []byte("second")) {
+		t.content(key, []byte("second"))
+	}
+}
+
+func (t *tester) ranges() {
+	data := bytes.Repeat([]byte("0123456789"), 100_003)
+	key := t.key("ranges.bin")
+	if !t.put(key, data) {
+		return
+	}
+	size := int64(len(data))
+	for _, r := range []struct{ offset, length, want int64 }{
+		{0, 10, 10},
+		{5, 3, 3},
+		{size - 4, 4, 4},
+		{size - 4, 100, 4},
+		{123_457, -1, size - 123_457},
+		{0, -1, size},
+		{size - 1, 1, 1},
+	} {
+		rc, object, err := t.s.GetRange(t.ctx, key, r.offset, r.length)
+		if err != nil {
+			t.errorf("GetRange(%q, %d, %d): %v", key, r.offset, r.length, err)
+			continue
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.errorf("reading GetRange(%q, %d, %d): %v", key, r.offset, r.length, err)
+			continue
+		}
+		if !bytes.Equal(got, data[r.offset:r.offset+r.want]) {
+			t.errorf("GetRange(%q, %d, %d) read %d bytes, want bytes %d to %d", key, r.offset, r.length, len(got), r.offset, r.offset+r.want)
+		}
+		if object.Key != key || object.Size != size {
+			t.errorf("GetRange(%q, %d, %d) described the object as %q of %d bytes, want %d bytes", key, r.offset, r.length, object.Key, object.Size, size)
+		}
+	}
+	for _, r := range [][2]int64{{size, 1}, {size + 10, -1}, {0, 0}, {-1, 5}} {
+		rc, _, err := t.s.GetRange(t.ctx, key, r[0], r[1])
+		if !errors.Is(err, storage.ErrInvalidRange) {
+			if err == nil {
+				rc.Close()
+			}
+			t.errorf("GetRange(%q, %d, %d) = %v, want ErrInvalidRange", key, r[0], r[1], err)
+		}
+	}
+	if rc, _, err := t.s.GetRange(t.ctx, t.key("never-written.txt"), 0, 1); !errors.Is(err, storage.ErrNotExist) {
+		if err == nil {
+			rc.Close()
+		}
+		t.errorf("GetRange of a missing key = %v, want ErrNotExist", err)
+	}
+}
+
+func (t *tester) missing() {
+	t.absent(t.key("never-written.txt"), "no Put")
+	if err := t.s.Delete(t.ctx, t.key("never-written.txt")); err != nil {
+		t.errorf("Delete of a missing key: %v", err)
+	}
+
+	key := t.key("deleted.txt")
+	if t.put(key, []byte("gone soon")) {
+		if err := t.s.Delete(t.ctx, key); err != nil {
+			t.errorf("Delete(%q): %v", key, err)
+		}
+		t.absent(key, "Delete")
+	}
+}
+
+func (t *tester) invalidKeys() {
+	for _, key := range []string{"", "/abs", t.dir + "../escape", t.dir + "./x", t.dir + "a//b", t.dir + `back\slash`, t.dir + "nul\x00"} {
+		if err := t.s.Put(t.ctx, key, strings.NewReader("x"), 1); !errors.Is(err, storage.ErrInvalidKey) {
+			t.errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := t.s.Stat(t.ctx, key); !errors.Is(err, storage.ErrInvalidKey) {
+			t.errorf("Stat(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if err := t.s.Delete(t.ctx, key); !errors.Is(err, storage.ErrInvalidKey) {
+			t.errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+// errReader fails after some bytes, like a client disconnecting
+type errReader struct {
+	r io.Reader
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func (t *tester) failedPut() {
+	// Writes that fail leave new keys absent and old objects untouched
+	key := t.key("failed.txt")
+	t.written = append(t.written, key)
+	if err := t.s.Put(t.ctx, key, strings.NewReader("short"), 100); err == nil {
+		t.errorf("Put(%q) of fewer bytes than its size succeeded", key)
+	}
+	t.absent(key, "a short Put")
+	if err := t.s.Put(t.ctx, key, &errReader{strings.NewReader("partial")}, 7); err == nil {
+		t.errorf("Put(%q) from a failing reader succeeded", key)
+	}
+	t.absent(key, "a failed Put")
+
+	existing := t.key("kept.txt")
+	if t.put(existing, []byte("original")) {
+		if err := t.s.Put(t.ctx, existing, &errReader{strings.NewReader("replacement")}, 11); err == nil {
+			t.errorf("Put(%q) from a failing reader succeeded", existing)
+		}
+		t.content(existing, []byte("original"))
+	}
+}
+
+func (t *tester) cancelledPut() {
+	ctx, cancel := context.WithCancel(t.ctx)
+	cancel()
+	key := t.key("cancelled.txt")
+	t.written = append(t.written, key)
+	if err := t.s.Put(ctx, key, strings.NewReader("never"), 5); err == nil {
+		t.errorf("Put(%q) with a cancelled context succeeded", key)
+	}
+	t.absent(key, "a cancelled Put")
+}
+
+func (t *tester) list() {
+	base := t.key("list/")
+	names := []string{"b.txt", "a.txt", "ab.txt", "c/nested.txt", "Z.txt"}
+	for _, name := range names {
+		if !t.put(base+name, []byte(name)) {
+			return
+		}
+	}
+
+	check := func(prefix string, want ...string) {
+		objects, err := t.s.List(t.ctx, prefix)
+		if err != nil {
+			t.errorf("List(%q): %v", prefix, err)
+			return
+		}
+		var got []string
+		for _, object := range objects {
+			got = append(got, strings.TrimPrefix(object.Key, base))
+			if name := strings.TrimPrefix(object.Key, base); object.Size != int64(len(name)) {
+				t.errorf("List(%q) gave %q a size of %d, want %d", prefix, object.Key, object.Size, len(name))
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.errorf("List(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+	// Sorted by key, byte-wise, and one level deep. Z and b don't differ
+	// only in case, which some filesystems ignore.
+	check(base, "Z.txt", "a.txt", "ab.txt", "b.txt")
+	check(base+"a", "a.txt", "ab.txt")
+	check(base+"c/", "c/nested.txt")
+	check(base + "missing/")
+	check(base + "zzz")
+}
+
+func (t *tester) cleanup() {
+	ctx := context.WithoutCancel(t.ctx)
+	for _, key := range t.written {
+		t.s.Delete(ctx, key)
+	}
+}
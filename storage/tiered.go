@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Tiered keeps originals, the objects at the top level, in Cold, such as
+// an infrequent-access bucket, and everything derived from them in Hot,
+// where it is served from fast. Reads of an original go to Cold, falling
+// back to Hot for originals stored before tiering was turned on.
+type Tiered struct {
+	Hot  Storage
+	Cold Storage
+}
+
+// NewTiered returns a store that puts originals in cold and everything
+// else in hot
+func NewTiered(hot, cold Storage) *Tiered {
+	return &Tiered{Hot: hot, Cold: cold}
+}
+
+// isOriginal reports whether key names an object at the top level
+func isOriginal(key string) bool {
+	return !strings.Contains(key, "/")
+}
+
+func (t *Tiered) Name() string {
+	return t.Hot.Name() + " with originals in " + t.Cold.Name()
+}
+
+// Put stores an original in Cold, removing any copy left in Hot from before
+// tiering so it can't be read instead
+func (t *Tiered) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if !isOriginal(key) {
+		return t.Hot.Put(ctx, key, r, size)
+	}
+	if err := t.Cold.Put(ctx, key, r, size); err != nil {
+		return err
+	}
+	return t.Hot.Delete(ctx, key)
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	if !isOriginal(key) {
+		return t.Hot.Get(ctx, key)
+	}
+	r, o, err := t.Cold.Get(ctx, key)
+	if errors.Is(err, ErrNotExist) {
+		return t.Hot.Get(ctx, key)
+	}
+	return r, o, err
+}
+
+func (t *Tiered) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	if !isOriginal(key) {
+		return t.Hot.GetRange(ctx, key, offset, length)
+	}
+	r, o, err := t.Cold.GetRange(ctx, key, offset, length)
+	if errors.Is(err, ErrNotExist) {
+		return t.Hot.GetRange(ctx, key, offset, length)
+	}
+	return r, o, err
+}
+
+func (t *Tiered) Stat(ctx context.Context, key string) (Object, error) {
+	if !isOriginal(key) {
+		return t.Hot.Stat(ctx, key)
+	}
+	o, err := t.Cold.Stat(ctx, key)
+	if errors.Is(err, ErrNotExist) {
+		return t.Hot.Stat(ctx, key)
+	}
+	return o, err
+}
+
+// Delete removes an original from both tiers
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	if isOriginal(key) {
+		if err := t.Cold.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return t.Hot.Delete(ctx, key)
+}
+
+// List lists the top level from both tiers, preferring Cold's object where
+// an original is in both
+func (t *Tiered) List(ctx context.Context, prefix string) ([]Object, error) {
+	if !isOriginal(prefix) {
+		return t.Hot.List(ctx, prefix)
+	}
+	cold, err := t.Cold.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	hot, err := t.Hot.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(cold))
+	for _, o := range cold {
+		seen[o.Key] = true
+	}
+	objects := cold
+	for _, o := range hot {
+		if !seen[o.Key] {
+			objects = append(objects, o)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
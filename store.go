@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"AfroBaseServer/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadStore holds uploads and the files derived from them: originals at
+// the top level, variants under thumbs/ and RAW previews under previews/
+var uploadStore storage.Storage
+
+// originalsTiered is set when originals are kept in a store of their own
+var originalsTiered bool
+
+// openUploadStore opens the storage backend named by AFROBASE_STORAGE:
+//
+//	local  files under ./uploads (the default)
+//	s3     a bucket on S3 or an S3-compatible service, configured with
+//	       AFROBASE_S3_ENDPOINT, AFROBASE_S3_BUCKET, AFROBASE_S3_REGION,
+//	       AFROBASE_S3_PREFIX and AFROBASE_S3_ACCESS_KEY and _SECRET_KEY
+//	       (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)
+//	memory in memory, lost when the process exits; for tests and demos
+//
+// AFROBASE_ORIGINALS_STORAGE, if set, names a second backend that
+// originals are kept in instead, so they can go to cheaper, slower
+// storage while variants are served from the first; requests for an
+// original are read from it transparently, and images are displayed from
+// their largest variant. Local originals go under
+// AFROBASE_ORIGINALS_DIR (./originals by default). S3 originals go to
+// AFROBASE_ORIGINALS_S3_BUCKET, under AFROBASE_ORIGINALS_S3_PREFIX, in
+// storage class AFROBASE_ORIGINALS_S3_STORAGE_CLASS (STANDARD_IA by
+// default); the endpoint, region and keys can be set the same way and
+// default to those of AFROBASE_S3_*.
+//
+// The sandbox always uses memory, in one tier.
+func openUploadStore() (storage.Storage, error) {
+	if sandbox {
+		return openStorageBackend("memory", false)
+	}
+	store, err := openStorageBackend(os.Getenv("AFROBASE_STORAGE"), false)
+	if err != nil {
+		return nil, err
+	}
+	if backend := os.Getenv("AFROBASE_ORIGINALS_STORAGE"); backend != "" {
+		originals, err := openStorageBackend(backend, true)
+		if err != nil {
+			return nil, fmt.Errorf("originals: %w", err)
+		}
+		store = storage.NewTiered(store, originals)
+		originalsTiered = true
+	}
+	return store, nil
+}
+
+// openStorageBackend opens a storage backend, configured for originals
+// from the AFROBASE_ORIGINALS_ variables if originals is set
+func openStorageBackend(backend string, originals bool) (storage.Storage, error) {
+	switch backend {
+	case "memory":
+		m := storage.NewMemory()
+		m.Now = serverClock.Now
+		return m, nil
+	case "", "local":
+		root := "./uploads"
+		if originals {
+			root = "./originals"
+			if dir := os.Getenv("AFROBASE_ORIGINALS_DIR"); dir != "" {
+				root = dir
+			}
+		}
+		local, err := storage.NewLocal(root)
+		if err != nil {
+			return nil, err
+		}
+		local.TempDir = os.Getenv("AFROBASE_TEMP_DIR")
+		return local, nil
+	case "s3":
+		cfg := storage.S3Config{
+			Endpoint:  os.Getenv("AFROBASE_S3_ENDPOINT"),
+			Region:    os.Getenv("AFROBASE_S3_REGION"),
+			Bucket:    os.Getenv("AFROBASE_S3_BUCKET"),
+			Prefix:    os.Getenv("AFROBASE_S3_PREFIX"),
+			AccessKey: envOr("AFROBASE_S3_ACCESS_KEY", "AWS_ACCESS_KEY_ID"),
+			SecretKey: envOr("AFROBASE_S3_SECRET_KEY", "AWS_SECRET_ACCESS_KEY"),
+		}
+		if originals {
+			cfg = storage.S3Config{
+				Endpoint:     envOr("AFROBASE_ORIGINALS_S3_ENDPOINT", "AFROBASE_S3_ENDPOINT"),
+				Region:       envOr("AFROBASE_ORIGINALS_S3_REGION", "AFROBASE_S3_REGION"),
+				Bucket:       os.Getenv("AFROBASE_ORIGINALS_S3_BUCKET"),
+				Prefix:       os.Getenv("AFROBASE_ORIGINALS_S3_PREFIX"),
+				AccessKey:    envOr("AFROBASE_ORIGINALS_S3_ACCESS_KEY", "AFROBASE_S3_ACCESS_KEY", "AWS_ACCESS_KEY_ID"),
+				SecretKey:    envOr("AFROBASE_ORIGINALS_S3_SECRET_KEY", "AFROBASE_S3_SECRET_KEY", "AWS_SECRET_ACCESS_KEY"),
+				StorageClass: os.Getenv("AFROBASE_ORIGINALS_S3_STORAGE_CLASS"),
+			}
+			if cfg.StorageClass == "" {
+				cfg.StorageClass = "STANDARD_IA"
+			}
+		}
+		return storage.NewS3(cfg)
+	default:
+		return nil, errors.New("unknown storage backend " + backend)
+	}
+}
+
+// envOr returns the first of the environment variables that is set
+func envOr(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// readUpload reads a stored file
+func readUpload(key string) ([]byte, error) {
+	return storage.ReadAll(context.Background(), uploadStore, key)
+}
+
+// writeUpload stores a file, leaving nothing behind if ctx is cancelled
+// part way
+func writeUpload(ctx context.Context, key string, data []byte) error {
+	return uploadStore.Put(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// copyUploadTo copies a stored file to a local path
+func copyUploadTo(key, dst string) error {
+	r, _, err := uploadStore.Get(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// isMissing reports whether err means a stored file doesn't exist
+func isMissing(err error) bool {
+	return errors.Is(err, storage.ErrNotExist) || errors.Is(err, os.ErrNotExist)
+}
+
+// uploadedFiles returns the stored images, newest first
+func uploadedFiles() ([]os.FileInfo, error) {
+	objects, err := uploadStore.List(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(objects))
+	for _, object := range objects {
+		files = append(files, object.Info())
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	return files, nil
+}
+
+// findUpload resolves an image ID (its stored filename without extension)
+// to the filename in storage
+func findUpload(id string) (string, bool) {
+	if id == "" || strings.ContainsAny(id, "/\\") {
+		return "", false
+	}
+	objects, err := uploadStore.List(context.Background(), id+".")
+	if err != nil {
+		log.Printf("Error listing uploads: %v", err)
+		return "", false
+	}
+	for _, object := range objects {
+		if imageID(object.Key) == id {
+			return object.Key, true
+		}
+	}
+	return "", false
+}
+
+// serveUpload serves a stored file: GET /uploads/<key>
+func serveUpload(c *fiber.Ctx) error {
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		key = c.Params("*")
+	}
+	if name, ok := uploadOwner(key); ok {
+		if denied, err := privateDenied(c, name); denied {
+			return err
+		}
+	}
+	key = negotiateRendition(c, key)
+	r, object, err := uploadStore.Get(c.Context(), key)
+	// Fall back to the mirror when the primary can't serve a file, whether
+	// it is missing there or the read failed
+	if err != nil && !errors.Is(err, storage.ErrInvalidKey) && uploadMirror != nil {
+		if mr, mo, merr := uploadMirror.secondary.Get(c.Context(), key); merr == nil {
+			if !isMissing(err) {
+				log.Printf("Error reading %s, serving it from the mirror: %v", key, err)
+			}
+			r, object, err = mr, mo, nil
+		}
+	}
+	if isMissing(err) || errors.Is(err, storage.ErrInvalidKey) {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "File not found",
+			"success": false,
+		})
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", key, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read file",
+			"success": false,
+		})
+	}
+
+	c.Type(strings.TrimPrefix(path.Ext(key), "."))
+	c.Set(fiber.HeaderLastModified, object.ModTime.UTC().Format(http.TimeFormat))
+	return c.SendStream(r, int(object.Size))
+}
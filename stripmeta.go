@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"slices"
+)
+
+// Photos often carry GPS coordinates, camera serial numbers and editing
+// history in EXIF, IPTC and XMP. These are removed from JPEG, PNG and WebP
+// uploads before they are stored, without re-encoding the pixels. Colour
+// profiles are kept, and so is the EXIF orientation, rewritten as the only
+// remaining tag so photos still display upright.
+
+// stripMetadata is on unless AFROBASE_STRIP_METADATA=off. Profiles can
+// opt out with keep_metadata.
+var stripMetadata = os.Getenv("AFROBASE_STRIP_METADATA") != "off"
+
+// tagOrientation is the EXIF orientation tag
+const tagOrientation = 0x112
+
+// keepsMetadata reports whether uploads made with profile are stored with
+// their metadata
+func keepsMetadata(profile *uploadProfile) bool {
+	return !stripMetadata || (profile != nil && profile.KeepMetadata)
+}
+
+// stripImageMetadata returns data without its metadata and whether anything
+// was removed. Formats without a stripper are returned unchanged.
+func stripImageMetadata(data []byte) ([]byte, bool, error) {
+	switch sniffExtension(data) {
+	case ".jpg":
+		return stripJPEGMetadata(data)
+	case ".png":
+		return stripPNGMetadata(data)
+	case ".webp":
+		return stripWebPMetadata(data)
+	}
+	return data, false, nil
+}
+
+// exifOrientation reads the orientation from a TIFF-structured EXIF block,
+// returning 0 when it is missing or already upright
+func exifOrientation(exif []byte) uint16 {
+	t, ok := parseTIFF(bytes.TrimPrefix(exif, []byte("Exif\x00\x00")))
+	if !ok {
+		return 0
+	}
+	entries, _, err := t.directory(t.first)
+	if err != nil {
+		return 0
+	}
+	e, ok := entries[tagOrientation]
+	if !ok {
+		return 0
+	}
+	if v := t.uints(e); len(v) == 1 && v[0] >= 2 && v[0] <= 8 {
+		return uint16(v[0])
+	}
+	return 0
+}
+
+// orientationEXIF is an EXIF block holding only an orientation
+func orientationEXIF(orientation uint16) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	b = binary.LittleEndian.AppendUint16(b, tagOrientation)
+	b = binary.LittleEndian.AppendUint16(b, 3) // SHORT
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, orientation)
+	return append(b, 0, 0, 0, 0, 0, 0) // padding and no next IFD
+}
+
+// keepJPEGSegment reports whether a marker segment before the image data
+// survives stripping: JFIF, Adobe colour transforms and ICC profiles do,
+// other application segments and comments don't
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0, marker == 0xEE:
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+	return true
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, bool, error) {
+	out := make([]byte, 2, len(data))
+	copy(out, data[:2])
+	insertAt := 2
+	var orientation uint16
+	var stripped bool
+
+	i := 2
+	for {
+		if i+2 > len(data) || data[i] != 0xFF {
+			return nil, false, errors.New("malformed JPEG")
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a length
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		// Everything from the start of scan on is image data
+		if marker == 0xDA {
+			break
+		}
+		if i+4 > len(data) {
+			return nil, false, errors.New("malformed JPEG")
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return nil, false, errors.New("malformed JPEG")
+		}
+		payload := data[i+4 : end]
+		if keepJPEGSegment(marker, payload) {
+			out = append(out, data[i:end]...)
+			// JFIF has to stay the first segment
+			if marker == 0xE0 && insertAt == 2 {
+				insertAt = len(out)
+			}
+		} else {
+			stripped = true
+			if marker == 0xE1 && orientation == 0 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(payload)
+			}
+		}
+		i = end
+	}
+	if !stripped {
+		return data, false, nil
+	}
+
+	if orientation != 0 {
+		exif := append([]byte("Exif\x00\x00"), orientationEXIF(orientation)...)
+		segment := []byte{0xFF, 0xE1, 0, 0}
+		binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+		out = slices.Insert(out, insertAt, append(segment, exif...)...)
+	}
+	return append(out, data[i:]...), true, nil
+}
+
+// pngMetadataChunks are the PNG chunks removed when stripping
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// pngChunk encodes a PNG chunk
+func pngChunk(typ string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+func stripPNGMetadata(data []byte) ([]byte, bool, error) {
+	if len(data) < 8 {
+		return nil, false, errors.New("malformed PNG")
+	}
+	out := make([]byte, 8, len(data))
+	copy(out, data[:8])
+	insertAt := 0
+	var orientation uint16
+	var stripped bool
+
+	for i := 8; i < len(data); {
+		if i+12 > len(data) {
+			return nil, false, errors.New("malformed PNG")
+		}
+		length := int64(binary.BigEndian.Uint32(data[i:]))
+		end := int64(i) + 12 + length
+		if end > int64(len(data)) {
+			return nil, false, errors.New("malformed PNG")
+		}
+		typ := string(data[i+4 : i+8])
+		if pngMetadataChunks[typ] {
+			stripped = true
+			if typ == "eXIf" {
+				orientation = exifOrientation(data[i+8 : int(end)-4])
+			}
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		// eXIf has to come before the image data, and IHDR first
+		if typ == "IHDR" {
+			insertAt = len(out)
+		}
+		i = int(end)
+	}
+	if !stripped {
+		return data, false, nil
+	}
+
+	if orientation != 0 && insertAt != 0 {
+		out = slices.Insert(out, insertAt, pngChunk("eXIf", orientationEXIF(orientation))...)
+	}
+	return out, true, nil
+}
+
+// WebP VP8X flags for the metadata chunks
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func stripWebPMetadata(data []byte) ([]byte, bool, error) {
+	if len(data) < 12 || string(data[8:12]) != "WEBP" {
+		return nil, false, errors.New("malformed WebP")
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	flagsAt := 0
+	var orientation uint16
+	var stripped bool
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, false, errors.New("malformed WebP")
+		}
+		size := int64(binary.LittleEndian.Uint32(data[i+4:]))
+		end := int64(i) + 8 + size + size%2
+		if end > int64(len(data)) {
+			// Writers sometimes leave off the final padding byte
+			if end-1 != int64(len(data)) {
+				return nil, false, errors.New("malformed WebP")
+			}
+			end--
+		}
+		switch fourCC := string(data[i : i+4]); fourCC {
+		case "EXIF", "XMP ":
+			stripped = true
+			if fourCC == "EXIF" {
+				orientation = exifOrientation(data[i+8 : int64(i)+8+size])
+			}
+		case "VP8X":
+			if size > 0 {
+				flagsAt = len(out) + 8
+			}
+			out = append(out, data[i:end]...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = int(end)
+	}
+	if !stripped {
+		return data, false, nil
+	}
+
+	if flagsAt != 0 {
+		out[flagsAt] &^= webpFlagXMP | webpFlagEXIF
+		// EXIF follows the image data
+		if orientation != 0 {
+			exif := orientationEXIF(orientation)
+			out = append(out, "EXIF"...)
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(exif)))
+			out = append(out, exif...)
+			out[flagsAt] |= webpFlagEXIF
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, true, nil
+}
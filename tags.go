@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on the tags of one image
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+// normalizeTags lowercases and trims tags, dropping empty ones and
+// duplicates. Tags can't contain commas, which separate them in forms,
+// tus metadata and CSV.
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || slices.Contains(out, tag) {
+			continue
+		}
+		if !utf8.ValidString(tag) || strings.IndexFunc(tag, unicode.IsControl) >= 0 || strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tag %q contains invalid characters", tag)
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		out = append(out, tag)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("images can have at most %d tags", maxTags)
+	}
+	return out, nil
+}
+
+// splitTags splits a comma-separated list of tags
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// tagList is tags as the API returns them, an empty list rather than null
+func tagList(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// hasTag reports whether an image is tagged with tag, which is normalized
+func (m imageMeta) hasTag(tag string) bool {
+	return slices.Contains(m.Tags, tag)
+}
+
+// getTags lists the tags in use with how many images have each, most
+// used first: GET /api/tags
+func getTags(c *fiber.Ctx) error {
+	objects, err := uploadStore.List(c.Context(), "")
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read uploads directory",
+			"success": false,
+		})
+	}
+
+	counts := map[string]int{}
+	for _, object := range objects {
+		if meta, ok := metadata.get(object.Key); ok && !hiddenFrom(c, object.Key) {
+			for _, tag := range meta.Tags {
+				counts[tag]++
+			}
+		}
+	}
+	type tagCount struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	tags := make([]tagCount, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, tagCount{name, count})
+	}
+	slices.SortFunc(tags, func(a, b tagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return c.JSON(fiber.Map{
+		"success": true,
+		"tags":    tags,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/collate"
+)
+
+// getTree returns albums nested as folders, each with its image counts and
+// cover thumbnail, so file manager views can be drawn in one request:
+// GET /api/tree. image_count counts an album's own images and total_count
+// those inside it at any depth, each image once. Albums are ordered by
+// name, and scoped like GET /api/albums; an album whose parent is out of
+// scope shows at the top.
+func getTree(c *fiber.Ctx) error {
+	owner, err := listingOwner(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+	albums, err := metadata.albums()
+	if err != nil {
+		log.Printf("Error reading albums: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read albums",
+			"success": false,
+		})
+	}
+	albums = slices.DeleteFunc(albums, func(a album) bool { return owner != "" && a.Owner != owner })
+
+	collator := collate.New(collationLocale, collate.Numeric)
+	slices.SortStableFunc(albums, func(a, b album) int {
+		return collator.CompareString(a.Name, b.Name)
+	})
+	byID := make(map[string]bool, len(albums))
+	for _, a := range albums {
+		byID[a.ID] = true
+	}
+	children := map[string][]album{}
+	for _, a := range albums {
+		parent := a.Parent
+		if !byID[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], a)
+	}
+
+	// node builds an album's subtree, adding every image in it to images
+	var node func(a album, images map[string]bool) fiber.Map
+	node = func(a album, images map[string]bool) fiber.Map {
+		inside := make(map[string]bool, len(a.Images))
+		for _, name := range a.Images {
+			inside[name] = true
+		}
+		kids := make([]fiber.Map, 0, len(children[a.ID]))
+		for _, child := range children[a.ID] {
+			kids = append(kids, node(child, inside))
+		}
+		for name := range inside {
+			images[name] = true
+		}
+		view := fiber.Map{
+			"id":          a.ID,
+			"name":        a.Name,
+			"image_count": len(a.Images),
+			"total_count": len(inside),
+			"children":    kids,
+		}
+		if cover := a.coverImage(); cover != "" {
+			view["cover_id"] = imageID(cover)
+			view["cover_url"] = a.coverURL()
+		}
+		return view
+	}
+	roots := make([]fiber.Map, 0, len(children[""]))
+	all := map[string]bool{}
+	for _, a := range children[""] {
+		roots = append(roots, node(a, all))
+	}
+	return c.JSON(fiber.Map{
+		"success":     true,
+		"tree":        roots,
+		"album_count": len(albums),
+		"image_count": len(all),
+	})
+}
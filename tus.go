@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Resumable uploads speak tus 1.0.0 (https://tus.io/protocols/resumable-upload)
+// with the creation, expiration and termination extensions. They are upload
+// sessions like two-phase uploads, so GET /api/uploads/:id reports on them.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+	tusChunkType  = "application/offset+octet-stream"
+)
+
+// The request headers tus clients send and the response headers they read,
+// for CORS
+const (
+	tusRequestHeaders  = "Tus-Resumable,Upload-Length,Upload-Metadata,Upload-Offset"
+	tusResponseHeaders = "Location,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Max-Size,Upload-Offset,Upload-Length,Upload-Expires"
+)
+
+func tusLocation(id string) string {
+	return publicBaseURL + "/api/tus/" + id
+}
+
+// tusResumable checks the client speaks our version of the protocol and
+// answers with it
+func tusResumable(c *fiber.Ctx) bool {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return false
+	}
+	return true
+}
+
+func tusUnsupported(c *fiber.Ctx) error {
+	return c.Status(412).JSON(fiber.Map{
+		"error":   "Unsupported Tus-Resumable version, expected " + tusVersion,
+		"success": false,
+	})
+}
+
+func tusNotFound(c *fiber.Ctx) error {
+	return c.Status(404).JSON(fiber.Map{
+		"error":   "Upload not found or expired",
+		"success": false,
+	})
+}
+
+// getTusOptions describes the server to tus clients: OPTIONS /api/tus
+func getTusOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Max-Size", strconv.Itoa(uploadBodyLimit))
+	return c.SendStatus(204)
+}
+
+// parseTusMetadata decodes Upload-Metadata: comma-separated pairs of a key
+// and an optional base64 value
+func parseTusMetadata(header string) (map[string]string, bool) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, true
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if key == "" || err != nil {
+			return nil, false
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, true
+}
+
+// createTusUpload starts a resumable upload: POST /api/tus with
+// Upload-Length and Upload-Metadata. The metadata keys title, description,
+// filename, mode, profile and tags mean what they do for /upload, and filetype
+// is the declared content type. The upload is committed with them as soon
+// as its last byte arrives.
+func createTusUpload(c *fiber.Ctx) error {
+	if !tusResumable(c) {
+		return tusUnsupported(c)
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Upload-Length must be a positive number of bytes",
+			"success": false,
+		})
+	}
+	if length > uploadBodyLimit {
+		return c.Status(413).JSON(fiber.Map{
+			"error":   "Upload-Length exceeds Tus-Max-Size",
+			"success": false,
+		})
+	}
+	meta, ok := parseTusMetadata(c.Get("Upload-Metadata"))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid Upload-Metadata header",
+			"success": false,
+		})
+	}
+	if name := meta["profile"]; name != "" {
+		if _, ok := uploadProfiles[name]; !ok {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Unknown upload profile: " + name,
+				"success": false,
+			})
+		}
+	}
+	tags, err := normalizeTags(splitTags(meta["tags"]))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid tags: " + err.Error(),
+			"success": false,
+		})
+	}
+
+	id, err := newID(16)
+	if err != nil {
+		log.Printf("Error generating upload ID: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to reserve upload",
+			"success": false,
+		})
+	}
+	now := serverClock.Now()
+	u := &stagedUpload{
+		id:      id,
+		state:   sessionReserved,
+		created: now,
+		expires: now.Add(uploadTTL),
+		length:  length,
+		payload: ImagePayload{
+			Title:       meta["title"],
+			Description: meta["description"],
+			Filename:    meta["filename"],
+			Mode:        meta["mode"],
+			Tags:        tags,
+		},
+		profile: meta["profile"],
+	}
+	if filetype := strings.ToLower(meta["filetype"]); strings.HasPrefix(filetype, "image/") {
+		u.declaredType, _, _ = strings.Cut(filetype, ";")
+	}
+	staged.mu.Lock()
+	staged.uploads[u.id] = u
+	staged.mu.Unlock()
+
+	c.Set(fiber.HeaderLocation, tusLocation(u.id))
+	c.Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+	return c.SendStatus(201)
+}
+
+// headTusUpload reports how much of a resumable upload has arrived:
+// HEAD /api/tus/:id
+func headTusUpload(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if !tusResumable(c) {
+		return c.SendStatus(412)
+	}
+	u, ok := staged.lookup(c.Params("id"))
+	if !ok || u.length == 0 {
+		return c.SendStatus(404)
+	}
+	staged.mu.Lock()
+	c.Set("Upload-Offset", strconv.FormatInt(max(u.size, 0), 10))
+	c.Set("Upload-Length", strconv.FormatInt(u.length, 10))
+	c.Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+	staged.mu.Unlock()
+	return c.Status(200).Send(nil)
+}
+
+// patchTusUpload appends a chunk to a resumable upload:
+// PATCH /api/tus/:id with Upload-Offset set to the bytes received so far.
+// Chunks may be up to Tus-Max-Size; a connection that drops mid-chunk
+// loses only that chunk. The chunk that completes the upload is answered
+// with the result of committing it, as from /upload. A rejected upload
+// keeps its bytes and can be committed again with POST
+// /api/uploads/:id/commit until the session expires.
+func patchTusUpload(c *fiber.Ctx) error {
+	if !tusResumable(c) {
+		return tusUnsupported(c)
+	}
+	if c.Get(fiber.HeaderContentType) != tusChunkType {
+		return c.Status(415).JSON(fiber.Map{
+			"error":   "Chunks must be sent as " + tusChunkType,
+			"success": false,
+		})
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid Upload-Offset header",
+			"success": false,
+		})
+	}
+	u, ok := staged.lookup(c.Params("id"))
+	if !ok || u.length == 0 {
+		return tusNotFound(c)
+	}
+
+	// One chunk at a time, and only at the end of what has arrived
+	chunk := c.Body()
+	staged.mu.Lock()
+	received := max(u.size, 0)
+	var conflict string
+	switch {
+	case u.patching:
+		conflict = "Another chunk of this upload is being written"
+	case u.state != sessionReserved && u.state != sessionReceiving:
+		conflict = "Upload is already " + u.state
+	case offset != received:
+		conflict = "Upload-Offset does not match the " + strconv.FormatInt(received, 10) + " bytes received"
+	}
+	if conflict == "" && offset+int64(len(chunk)) > u.length {
+		staged.mu.Unlock()
+		return c.Status(413).JSON(fiber.Map{
+			"error":   "Chunk extends past Upload-Length",
+			"success": false,
+		})
+	}
+	if conflict != "" {
+		staged.mu.Unlock()
+		c.Set("Upload-Offset", strconv.FormatInt(received, 10))
+		return c.Status(409).JSON(fiber.Map{
+			"error":   conflict,
+			"success": false,
+		})
+	}
+	u.patching = true
+	staged.mu.Unlock()
+
+	err = stagedData.writeAt(u.id, offset, chunk)
+
+	staged.mu.Lock()
+	u.patching = false
+	if err == nil {
+		u.size = offset + int64(len(chunk))
+		u.state = sessionReceiving
+		if u.size == u.length {
+			u.state = sessionUploaded
+		}
+		// Progress keeps a slow upload alive
+		u.expires = serverClock.Now().Add(uploadTTL)
+	}
+	complete := u.state == sessionUploaded
+	c.Set("Upload-Offset", strconv.FormatInt(max(u.size, 0), 10))
+	c.Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+	payload, profileName := u.payload, u.profile
+	staged.mu.Unlock()
+	if err != nil {
+		log.Printf("Error staging upload %s: %v", u.id, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save chunk",
+			"success": false,
+		})
+	}
+	if !complete {
+		return c.SendStatus(204)
+	}
+
+	attempt := &uploadAttempt{profile: profileName}
+	ctx, cancel, err := uploadContext(c)
+	if err != nil {
+		return attempt.reject(c, 400, "deadline_header", "Invalid "+uploadDeadlineHeader+" header")
+	}
+	defer cancel()
+	return commitStaged(c, ctx, u, attempt, payload, uploadProfiles[profileName])
+}
+
+// appendChunk writes a chunk at offset, dropping anything after it that a
+// failed earlier write may have left
+func appendChunk(path string, offset int64, chunk []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteAt(chunk, offset); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// deleteTusUpload abandons a resumable upload: DELETE /api/tus/:id
+func deleteTusUpload(c *fiber.Ctx) error {
+	if !tusResumable(c) {
+		return tusUnsupported(c)
+	}
+	u, ok := staged.lookup(c.Params("id"))
+	if !ok || u.length == 0 {
+		return tusNotFound(c)
+	}
+	staged.mu.Lock()
+	if u.patching || u.state == sessionCommitting {
+		staged.mu.Unlock()
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Upload is busy",
+			"success": false,
+		})
+	}
+	delete(staged.uploads, u.id)
+	staged.mu.Unlock()
+	if err := stagedData.remove(u.id); err != nil {
+		log.Printf("Error removing staged upload %s: %v", u.id, err)
+	}
+	return c.SendStatus(204)
+}
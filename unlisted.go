@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// viewsBucket counts how often each unlisted image's page has been
+// opened, keyed by stored filename
+var viewsBucket = []byte("unlisted_views")
+
+// isUnlisted reports whether an upload is left out of listings and search,
+// while its own URLs keep working for anyone who has them
+func isUnlisted(name string) bool {
+	meta, _ := metadata.get(name)
+	return meta.Unlisted
+}
+
+// hiddenFrom reports whether an upload is left out of a request's listings:
+// private and unlisted images and those awaiting moderation are, except for
+// those who may see private ones
+func hiddenFrom(c *fiber.Ctx, name string) bool {
+	meta, _ := metadata.get(name)
+	return (meta.Private || meta.Unlisted || meta.Pending) && !seesPrivate(c, name)
+}
+
+// countView records that an unlisted image's link was opened. Concurrent
+// views are batched into one write.
+func (s *metadataStore) countView(name string) {
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(viewsBucket)
+		var n uint64
+		if v := bucket.Get([]byte(name)); len(v) == 8 {
+			n = binary.BigEndian.Uint64(v)
+		}
+		return bucket.Put([]byte(name), binary.BigEndian.AppendUint64(nil, n+1))
+	})
+	if err != nil {
+		log.Printf("Error counting view of %s: %v", name, err)
+	}
+}
+
+// views returns how often an unlisted image's link has been opened
+func (s *metadataStore) views(name string) uint64 {
+	var n uint64
+	s.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(viewsBucket); bucket != nil {
+			if v := bucket.Get([]byte(name)); len(v) == 8 {
+				n = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+	return n
+}
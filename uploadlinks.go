@@ -0,0 +1,385 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// uploadLink lets anyone holding its token upload images into an album,
+// without an account or API key, until it expires or runs out of uploads
+type uploadLink struct {
+	Album   string `json:"album"`
+	Created int64  `json:"created"`
+	// Expires is when the link stops working, 0 for never
+	Expires int64 `json:"expires,omitempty"`
+	// MaxUploads bounds the images uploaded with the link, 0 for no bound
+	MaxUploads int `json:"max_uploads,omitempty"`
+	Uploads    int `json:"uploads"`
+	// Moderated links hold uploads for the album owner's approval, as for
+	// an event's kiosk
+	Moderated bool `json:"moderated,omitempty"`
+}
+
+// uploadLinksBucket holds upload links keyed by the hex SHA-256 of their
+// token
+var uploadLinksBucket = []byte("upload_links")
+
+// uploadTokenHeader carries an upload link's token, which may also be
+// given as ?upload_token=
+const uploadTokenHeader = "X-Upload-Token"
+
+// maxUploadLinkTTL bounds how long an upload link can be made to last
+const maxUploadLinkTTL = 365 * 24 * time.Hour
+
+var errUploadLinkUsedUp = errors.New("upload link has expired or reached its upload limit")
+
+// usable reports whether the link can take another upload at now
+func (l uploadLink) usable(now time.Time) bool {
+	return (l.Expires == 0 || now.Unix() < l.Expires) && (l.MaxUploads == 0 || l.Uploads < l.MaxUploads)
+}
+
+// view is the API representation of an upload link
+func (l uploadLink) view(id string) fiber.Map {
+	v := fiber.Map{
+		"id":         id,
+		"album":      l.Album,
+		"uploads":    l.Uploads,
+		"created_at": time.Unix(l.Created, 0).UTC().Format(time.RFC3339),
+		"usable":     l.usable(serverClock.Now()),
+	}
+	if l.Expires != 0 {
+		v["expires_at"] = time.Unix(l.Expires, 0).UTC().Format(time.RFC3339)
+	}
+	if l.MaxUploads != 0 {
+		v["max_uploads"] = l.MaxUploads
+	}
+	if l.Moderated {
+		v["moderated"] = true
+	}
+	return v
+}
+
+func (s *metadataStore) putUploadLink(token string, l uploadLink) error {
+	value, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadLinksBucket).Put(secretKey(token), value)
+	})
+}
+
+// uploadLink loads the link a token belongs to, by its key
+func (s *metadataStore) uploadLink(key []byte) (uploadLink, bool) {
+	var l uploadLink
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(uploadLinksBucket).Get(key); value != nil {
+			found = json.Unmarshal(value, &l) == nil
+		}
+		return nil
+	})
+	return l, found
+}
+
+// changeUploadLink applies change to a link in one transaction
+func (s *metadataStore) changeUploadLink(key []byte, change func(l *uploadLink) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(uploadLinksBucket)
+		value := bucket.Get(key)
+		if value == nil {
+			return errUploadLinkUsedUp
+		}
+		var l uploadLink
+		if err := json.Unmarshal(value, &l); err != nil {
+			return err
+		}
+		if err := change(&l); err != nil {
+			return err
+		}
+		value, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+}
+
+// claimUploadLink counts an upload against a link, failing with
+// errUploadLinkUsedUp if it can take no more. Links to sealed albums take
+// none.
+func (s *metadataStore) claimUploadLink(key []byte) error {
+	if l, ok := s.uploadLink(key); ok {
+		if a, ok := s.album(l.Album); ok && a.Seal != nil {
+			return errUploadLinkUsedUp
+		}
+	}
+	return s.changeUploadLink(key, func(l *uploadLink) error {
+		if !l.usable(serverClock.Now()) {
+			return errUploadLinkUsedUp
+		}
+		l.Uploads++
+		return nil
+	})
+}
+
+// releaseUploadLink gives back an upload claimed for one that failed
+func (s *metadataStore) releaseUploadLink(key []byte) {
+	err := s.changeUploadLink(key, func(l *uploadLink) error {
+		l.Uploads = max(0, l.Uploads-1)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error releasing upload link %s: %v", secretID(key), err)
+	}
+}
+
+// albumUploadLinks lists an album's links by ID
+func (s *metadataStore) albumUploadLinks(albumID string) (map[string]uploadLink, error) {
+	links := map[string]uploadLink{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadLinksBucket).ForEach(func(k, v []byte) error {
+			var l uploadLink
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			if l.Album == albumID {
+				links[secretID(k)] = l
+			}
+			return nil
+		})
+	})
+	return links, err
+}
+
+// acceptUploadLinks lets uploads to POST /upload that carry a valid upload
+// link token through without an API key. The upload is added to the
+// link's album once stored. Invalid, expired or used up links are refused
+// with 403.
+func acceptUploadLinks(c *fiber.Ctx) error {
+	token := cmp.Or(c.Get(uploadTokenHeader), c.Query("upload_token"))
+	if token == "" || c.Method() != fiber.MethodPost || c.Path() != "/upload" {
+		return c.Next()
+	}
+	key := secretKey(token)
+	l, ok := metadata.uploadLink(key)
+	if ok {
+		_, ok = metadata.album(l.Album)
+	}
+	if !ok || !l.usable(serverClock.Now()) {
+		return c.Status(403).JSON(fiber.Map{
+			"error":   "Invalid, expired or used up upload link",
+			"success": false,
+		})
+	}
+	c.Locals("upload_link", key)
+	return c.Next()
+}
+
+// requestUploadLink returns the key of the upload link a request was
+// accepted with, if any
+func requestUploadLink(c *fiber.Ctx) ([]byte, bool) {
+	key, ok := c.Locals("upload_link").([]byte)
+	return key, ok
+}
+
+// addToLinkAlbum adds an upload made with an upload link to the link's
+// album, or to its moderation queue if the link is moderated
+func addToLinkAlbum(c *fiber.Ctx, key []byte, name string) {
+	l, ok := metadata.uploadLink(key)
+	if !ok {
+		return
+	}
+	_, err := metadata.updateAlbum(l.Album, func(a *album) error {
+		switch {
+		case a.contains(name) || slices.Contains(a.Pending, name):
+		case l.Moderated:
+			a.Pending = append(a.Pending, name)
+		default:
+			a.Images = append(a.Images, name)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error adding %s to album %s: %v", name, l.Album, err)
+		return
+	}
+	audit(c, "album_add", l.Album+"/"+name)
+}
+
+// linkAlbumOwner is the owner of the album an upload link is for
+func linkAlbumOwner(key []byte) string {
+	l, _ := metadata.uploadLink(key)
+	a, _ := metadata.album(l.Album)
+	return a.Owner
+}
+
+// createUploadLink makes a link guests can upload into an album with:
+// POST /api/albums/:id/upload-links with an optional {"expires_in": "48h",
+// "max_uploads": 50, "moderated": true}. Moderated links also get an event
+// kiosk page and slideshow. The token is only ever shown in this response.
+func createUploadLink(c *fiber.Ctx) error {
+	var req struct {
+		ExpiresIn  string `json:"expires_in"`
+		MaxUploads int    `json:"max_uploads"`
+		Moderated  bool   `json:"moderated"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "Body must be JSON",
+				"success": false,
+			})
+		}
+	}
+	now := serverClock.Now()
+	l := uploadLink{Album: c.Params("id"), Created: now.Unix(), MaxUploads: req.MaxUploads, Moderated: req.Moderated}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxUploadLinkTTL {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   "expires_in must be a duration of up to " + maxUploadLinkTTL.String(),
+				"success": false,
+			})
+		}
+		l.Expires = now.Add(d).Unix()
+	}
+	if req.MaxUploads < 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "max_uploads can't be negative",
+			"success": false,
+		})
+	}
+	if err := checkAlbumOwner(c, l.Album); err != nil {
+		return err
+	}
+
+	token, err := newID(16)
+	if err != nil {
+		log.Printf("Error generating upload link token: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create upload link",
+			"success": false,
+		})
+	}
+	if err := metadata.putUploadLink(token, l); err != nil {
+		log.Printf("Error saving upload link: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create upload link",
+			"success": false,
+		})
+	}
+	id := secretID(secretKey(token))
+	audit(c, "upload_link_create", l.Album+"/"+id)
+
+	view := l.view(id)
+	view["token"] = token
+	view["upload_url"] = publicBaseURL + "/upload?" + url.Values{"upload_token": {token}}.Encode()
+	if l.Moderated {
+		view["kiosk_url"] = publicBaseURL + "/events/" + token
+		view["slideshow_url"] = publicBaseURL + "/events/" + token + "/slideshow"
+	}
+	return c.Status(201).JSON(fiber.Map{
+		"success": true,
+		"link":    view,
+	})
+}
+
+// listUploadLinks lists an album's upload links, newest first:
+// GET /api/albums/:id/upload-links
+func listUploadLinks(c *fiber.Ctx) error {
+	if err := checkAlbumOwner(c, c.Params("id")); err != nil {
+		return err
+	}
+	links, err := metadata.albumUploadLinks(c.Params("id"))
+	if err != nil {
+		log.Printf("Error reading upload links: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to read upload links",
+			"success": false,
+		})
+	}
+	ids := make([]string, 0, len(links))
+	for id := range links {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return links[ids[i]].Created > links[ids[j]].Created
+	})
+	views := make([]fiber.Map, 0, len(ids))
+	for _, id := range ids {
+		views = append(views, links[id].view(id))
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"links":   views,
+	})
+}
+
+// deleteUploadLink revokes an upload link:
+// DELETE /api/albums/:id/upload-links/:link
+func deleteUploadLink(c *fiber.Ctx) error {
+	if err := checkAlbumOwner(c, c.Params("id")); err != nil {
+		return err
+	}
+	var found bool
+	err := metadata.db.Update(func(tx *bolt.Tx) (err error) {
+		found, err = revokeSecret(tx, uploadLinksBucket, c.Params("link"), func(value []byte) error {
+			var l uploadLink
+			if err := json.Unmarshal(value, &l); err != nil {
+				return err
+			}
+			if l.Album != c.Params("id") {
+				return errUploadLinkUsedUp
+			}
+			return nil
+		})
+		return err
+	})
+	if errors.Is(err, errUploadLinkUsedUp) {
+		found, err = false, nil
+	}
+	if err != nil {
+		log.Printf("Error revoking upload link %s: %v", c.Params("link"), err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to revoke upload link",
+			"success": false,
+		})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Upload link not found",
+			"success": false,
+		})
+	}
+	audit(c, "upload_link_revoke", c.Params("id")+"/"+c.Params("link"))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"id":      c.Params("link"),
+	})
+}
+
+// checkAlbumOwner answers with 404 or 403 unless the album exists and the
+// request may manage it, returning the response's error if it did
+func checkAlbumOwner(c *fiber.Ctx, id string) error {
+	a, ok := metadata.album(id)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error":   "Album not found",
+			"success": false,
+		})
+	}
+	if !ownedByCaller(c, a.Owner) {
+		return sendNotOwner(c, "Album belongs to another user")
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Uploads to /upload are decoded as they arrive: the base64 image in a
+// JSON body, or the file in a multipart form, is written straight to a
+// spool file, which is then mapped into memory rather than read into it.
+// Memory used per upload no longer grows with the size of the encoded
+// body.
+
+// maxUploadField bounds the fields of an upload other than the image
+const maxUploadField = 64 << 10
+
+// uploadBodyError is a fault in an upload's body, with the status and rule
+// it is rejected under
+type uploadBodyError struct {
+	status  int
+	rule    string
+	message string
+}
+
+func (e *uploadBodyError) Error() string {
+	return e.message
+}
+
+var errInvalidBody = &uploadBodyError{400, "request_body", "Invalid request body"}
+
+// rejectUploadBody turns an error from reading an upload's body into its
+// response
+func rejectUploadBody(c *fiber.Ctx, ctx context.Context, attempt *uploadAttempt, err error) error {
+	var bodyErr *uploadBodyError
+	var corrupt base64.CorruptInputError
+	var pathErr *fs.PathError
+	switch {
+	case ctx.Err() != nil:
+		return abortedUpload(c, ctx.Err())
+	case errors.Is(err, errBodyTooLarge):
+		return attempt.reject(c, 413, "body_limit", "Request body too large")
+	case errors.As(err, &bodyErr):
+		return attempt.reject(c, bodyErr.status, bodyErr.rule, bodyErr.message)
+	case errors.As(err, &corrupt):
+		log.Printf("Error decoding base64 image: %v", err)
+		return attempt.reject(c, 400, "base64", "Invalid base64 image data")
+	case errors.As(err, &pathErr):
+		// Only the spool file is a file
+		log.Printf("Error spooling upload: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to save image",
+			"success": false,
+		})
+	default:
+		log.Printf("Error parsing request body: %v", err)
+		return attempt.reject(c, 400, "request_body", "Invalid request body")
+	}
+}
+
+// spoolFile holds the decoded image of an upload being received. It is
+// created in AFROBASE_TEMP_DIR, or the system's temporary directory.
+type spoolFile struct {
+	f     *os.File
+	size  int64
+	unmap func()
+}
+
+func newSpoolFile() (*spoolFile, error) {
+	f, err := os.CreateTemp(os.Getenv("AFROBASE_TEMP_DIR"), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spoolFile{f: f}, nil
+}
+
+func (s *spoolFile) Write(p []byte) (int, error) {
+	n, err := s.f.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// data maps the spooled image into memory. It stays valid until close.
+func (s *spoolFile) data() ([]byte, error) {
+	if s.size == 0 {
+		return nil, nil
+	}
+	data, unmap, err := mapFile(s.f, int(s.size))
+	if err != nil {
+		return nil, err
+	}
+	s.unmap = unmap
+	return data, nil
+}
+
+// close unmaps and removes the spool file
+func (s *spoolFile) close() {
+	if s.unmap != nil {
+		s.unmap()
+	}
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
+
+// readJSONUpload reads the JSON body of an upload, decoding the base64 or
+// data URI image into dst as it arrives and the other fields into payload.
+// It returns the MIME type a data URI declares.
+func readJSONUpload(ctx context.Context, body io.Reader, dst io.Writer, payload *ImagePayload) (string, error) {
+	br := bufio.NewReaderSize(body, 64<<10)
+	if c, err := nextJSONByte(br); err != nil || c != '{' {
+		return "", jsonError(err)
+	}
+
+	var declaredType string
+	var seenImage bool
+	fields := map[string]json.RawMessage{}
+	for first := true; ; first = false {
+		c, err := nextJSONByte(br)
+		if err != nil {
+			return "", jsonError(err)
+		}
+		if c == '}' {
+			break
+		}
+		if !first {
+			if c != ',' {
+				return "", errInvalidBody
+			}
+			if c, err = nextJSONByte(br); err != nil {
+				return "", jsonError(err)
+			}
+		}
+		if c != '"' {
+			return "", errInvalidBody
+		}
+		br.UnreadByte()
+		raw, err := readJSONValue(br, maxUploadField)
+		if err != nil {
+			return "", err
+		}
+		var key string
+		if err := json.Unmarshal(raw, &key); err != nil {
+			return "", errInvalidBody
+		}
+		if c, err := nextJSONByte(br); err != nil || c != ':' {
+			return "", jsonError(err)
+		}
+
+		// Field names match case-insensitively, as they do for BodyParser
+		if strings.EqualFold(key, "image") {
+			if seenImage {
+				return "", errInvalidBody
+			}
+			seenImage = true
+			if declaredType, err = copyJSONImage(ctx, br, dst); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if fields[key], err = readJSONValue(br, maxUploadField); err != nil {
+			return "", err
+		}
+	}
+	if _, err := nextJSONByte(br); err != io.EOF {
+		return "", jsonError(err)
+	}
+
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return "", errInvalidBody
+	}
+	if err := json.Unmarshal(rest, payload); err != nil {
+		return "", errInvalidBody
+	}
+	return declaredType, nil
+}
+
+// jsonError reports a body that ended or failed part way through as
+// invalid, keeping errors from reading it
+func jsonError(err error) error {
+	if err == nil || err == io.EOF {
+		return errInvalidBody
+	}
+	return err
+}
+
+// nextJSONByte returns the next byte of JSON that isn't whitespace
+func nextJSONByte(br *bufio.Reader) (byte, error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return c, nil
+		}
+	}
+}
+
+// readJSONValue reads one JSON value of at most limit bytes without
+// checking it, which is left to encoding/json
+func readJSONValue(br *bufio.Reader, limit int) (json.RawMessage, error) {
+	c, err := nextJSONByte(br)
+	if err != nil {
+		return nil, jsonError(err)
+	}
+	br.UnreadByte()
+
+	var raw []byte
+	depth, inString, escaped := 0, false, false
+	for {
+		c, err = br.ReadByte()
+		if err == io.EOF && depth == 0 && !inString && len(raw) > 0 {
+			return raw, nil
+		}
+		if err != nil {
+			return nil, jsonError(err)
+		}
+		if !inString && depth == 0 && len(raw) > 0 && strings.IndexByte(",}] \t\n\r", c) >= 0 {
+			br.UnreadByte()
+			return raw, nil
+		}
+		if raw = append(raw, c); len(raw) > limit {
+			return nil, &uploadBodyError{400, "request_body", "Request field too large"}
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case inString && c == '"':
+			inString = false
+			if depth == 0 {
+				return raw, nil
+			}
+		case inString:
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth--; depth <= 0 {
+				if depth < 0 {
+					return nil, errInvalidBody
+				}
+				return raw, nil
+			}
+		}
+	}
+}
+
+// copyJSONImage decodes the image field's string into dst, returning the
+// MIME type of a data URI. A null image is taken as missing.
+func copyJSONImage(ctx context.Context, br *bufio.Reader, dst io.Writer) (string, error) {
+	c, err := nextJSONByte(br)
+	if err != nil {
+		return "", jsonError(err)
+	}
+	if c != '"' {
+		br.UnreadByte()
+		raw, err := readJSONValue(br, maxUploadField)
+		if err != nil {
+			return "", err
+		}
+		if string(raw) != "null" {
+			return "", errInvalidBody
+		}
+		return "", nil
+	}
+
+	// Data URIs are accepted too; their MIME type is kept as a format hint
+	s := bufio.NewReader(&jsonStringReader{br: br})
+	var declaredType string
+	if prefix, _ := s.Peek(len("data:")); string(prefix) == "data:" {
+		header, err := s.ReadSlice(',')
+		if errors.Is(err, bufio.ErrBufferFull) || err == io.EOF {
+			return "", &uploadBodyError{400, "data_uri", "Invalid data URI: data URI has no payload"}
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, declaredType, err = splitDataURI(string(header)); err != nil {
+			return "", &uploadBodyError{400, "data_uri", "Invalid data URI: " + err.Error()}
+		}
+	}
+	if _, err := copyContext(ctx, dst, newBase64Decoder(s)); err != nil {
+		return "", err
+	}
+	return declaredType, nil
+}
+
+// jsonStringReader reads the contents of a JSON string whose opening quote
+// has been read, unescaping them, and stops at the closing quote. Base64
+// needs no escapes, so only those encoders use on ASCII are accepted.
+type jsonStringReader struct {
+	br   *bufio.Reader
+	done bool
+}
+
+func (s *jsonStringReader) Read(p []byte) (int, error) {
+	if s.done {
+		return 0, io.EOF
+	}
+	n := 0
+	// Return what is in hand rather than wait for more of the body
+	for n < len(p) && (n == 0 || s.br.Buffered() > 0) {
+		c, err := s.br.ReadByte()
+		if err == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+		switch {
+		case c == '"':
+			s.done = true
+			if n == 0 {
+				return 0, io.EOF
+			}
+			return n, nil
+		case c < 0x20:
+			return n, errInvalidBody
+		case c == '\\':
+			if c, err = s.unescape(); err != nil {
+				return n, err
+			}
+		}
+		p[n] = c
+		n++
+	}
+	return n, nil
+}
+
+// unescape reads an escape sequence after its backslash
+func (s *jsonStringReader) unescape() (byte, error) {
+	c, err := s.br.ReadByte()
+	if err != nil {
+		return 0, jsonError(err)
+	}
+	switch c {
+	case '"', '\\', '/':
+		return c, nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'u':
+		var hex [4]byte
+		if _, err := io.ReadFull(s.br, hex[:]); err != nil {
+			return 0, jsonError(err)
+		}
+		r, err := strconv.ParseUint(string(hex[:]), 16, 16)
+		if err != nil || r >= 0x80 {
+			return 0, errInvalidBody
+		}
+		return byte(r), nil
+	}
+	return 0, errInvalidBody
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on fetching images for URL uploads
+var (
+	// remoteFetchTimeout bounds a whole fetch, from connecting to the last
+	// byte (AFROBASE_URL_UPLOAD_TIMEOUT, default 30s)
+	remoteFetchTimeout = loadRemoteFetchTimeout()
+	// remoteFetchAllowPrivate lets URL uploads reach loopback and private
+	// addresses (AFROBASE_URL_UPLOAD_ALLOW_PRIVATE=on), for testing only:
+	// otherwise anyone who can upload can make the server probe its own
+	// network
+	remoteFetchAllowPrivate = os.Getenv("AFROBASE_URL_UPLOAD_ALLOW_PRIVATE") == "on"
+)
+
+// maxRemoteRedirects is how many redirects a fetch follows
+const maxRemoteRedirects = 5
+
+func loadRemoteFetchTimeout() time.Duration {
+	v := os.Getenv("AFROBASE_URL_UPLOAD_TIMEOUT")
+	if v == "" {
+		return 30 * time.Second
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatal("Invalid AFROBASE_URL_UPLOAD_TIMEOUT: ", v)
+	}
+	return d
+}
+
+var errPrivateAddress = errors.New("address is not publicly routable")
+
+// remoteClient fetches URL uploads. Addresses are checked as they are
+// dialled, after DNS resolution, so neither redirects nor DNS tricks reach
+// the server's own network. Proxies from the environment are ignored for
+// the same reason.
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				if remoteFetchAllowPrivate {
+					return nil
+				}
+				addr, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := addr.Addr().Unmap(); !ip.IsGlobalUnicast() || ip.IsPrivate() {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRemoteRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRemoteRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// urlUploadPayload is the body of POST /upload/url
+type urlUploadPayload struct {
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Filename    string   `json:"filename"`
+	Mode        string   `json:"mode"`
+	Tags        []string `json:"tags"`
+}
+
+// handleURLUpload fetches an image from another host and stores it like any
+// other upload: POST /upload/url with {"url": "https://…", "title": "…"}
+// and optionally ?profile=. The image is limited to the size of a normal
+// upload, or the profile's max_bytes, and must arrive within the fetch
+// timeout.
+func handleURLUpload(c *fiber.Ctx) error {
+	var req urlUploadPayload
+	attempt := &uploadAttempt{}
+	if err := c.BodyParser(&req); err != nil {
+		return attempt.reject(c, 400, "request_body", "Invalid request body")
+	}
+	source, err := url.Parse(req.URL)
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return attempt.reject(c, 400, "url", "url must be an absolute http or https URL")
+	}
+
+	profileName := c.Query("profile")
+	attempt.profile = profileName
+	var profile *uploadProfile
+	if profileName != "" {
+		var ok bool
+		if profile, ok = uploadProfiles[profileName]; !ok {
+			return attempt.reject(c, 400, "unknown_profile", "Unknown upload profile: "+profileName)
+		}
+	}
+	limit := int64(uploadBodyLimit)
+	if profile != nil && profile.MaxBytes > 0 {
+		limit = min(limit, profile.MaxBytes)
+	}
+
+	ctx, cancel, err := uploadContext(c)
+	if err != nil {
+		return attempt.reject(c, 400, "deadline_header", "Invalid "+uploadDeadlineHeader+" header")
+	}
+	defer cancel()
+
+	imageData, declaredType, err := fetchRemoteImage(ctx, source.String(), limit)
+	if ctx.Err() != nil {
+		return abortedUpload(c, ctx.Err())
+	}
+	var tooLarge *remoteTooLargeError
+	if errors.As(err, &tooLarge) {
+		return attempt.reject(c, 413, "max_bytes", err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching %s: %v", source.Redacted(), err)
+		return attempt.reject(c, 422, "remote_fetch", "Could not fetch image: "+err.Error())
+	}
+	attempt.declaredType = declaredType
+	attempt.data = imageData
+
+	// The request body is tiny; the bytes fetched for the client are what
+	// count against its upload allowance
+	if uploadLimits != nil {
+		if wait := uploadLimits.allow(c.IP(), 0, float64(len(imageData)), serverClock.Now()); wait != 0 {
+			return rateLimited(c, wait)
+		}
+	}
+
+	markStep(c, "fetch")
+
+	payload := ImagePayload{
+		Title:       req.Title,
+		Description: req.Description,
+		Filename:    req.Filename,
+		Mode:        req.Mode,
+		Tags:        req.Tags,
+	}
+	if payload.Filename == "" {
+		if name := path.Base(source.Path); name != "/" && name != "." {
+			payload.Filename = name
+		}
+	}
+	return storeUpload(c, ctx, attempt, payload, profile, imageData)
+}
+
+// remoteTooLargeError reports a remote image over the size limit
+type remoteTooLargeError struct {
+	limit int64
+}
+
+func (e *remoteTooLargeError) Error() string {
+	return fmt.Sprintf("remote image is larger than %d bytes", e.limit)
+}
+
+// fetchRemoteImage downloads up to limit bytes from rawURL, returning them
+// with the image MIME type the server declared, if any
+func fetchRemoteImage(ctx context.Context, rawURL string, limit int64) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "image/*")
+	req.Header.Set("User-Agent", "AfroBase URL upload")
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, "", fmt.Errorf("no response within %v", remoteFetchTimeout)
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("remote server returned %s", resp.Status)
+	}
+	if resp.ContentLength > limit {
+		return nil, "", &remoteTooLargeError{limit}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, "", fmt.Errorf("image not received within %v", remoteFetchTimeout)
+		}
+		return nil, "", err
+	}
+	if int64(len(data)) > limit {
+		return nil, "", &remoteTooLargeError{limit}
+	}
+	if len(data) == 0 {
+		return nil, "", errors.New("remote image is empty")
+	}
+
+	var declaredType string
+	if contentType := strings.ToLower(resp.Header.Get("Content-Type")); strings.HasPrefix(contentType, "image/") {
+		declaredType, _, _ = strings.Cut(contentType, ";")
+	}
+	return data, declaredType, nil
+}
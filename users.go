@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"AfroBaseServer/storage"
+
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// user is an account. Images uploaded with its access token are owned by
+// it, and listings made with the token show its images by default.
+type user struct {
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Created      int64  `json:"created"`
+	// Version counts changes to the profile, for If-Match
+	Version int64 `json:"version"`
+}
+
+var (
+	usersBucket     = []byte("users")     // keyed by ID
+	usernamesBucket = []byte("usernames") // lower-cased username to ID
+	// OAuth identities, "<provider>:<account ID>", to user ID
+	identitiesBucket = []byte("identities")
+)
+
+// registrationOpen lets anyone create an account. It is set at startup by
+// setRegistration.
+var registrationOpen bool
+
+// setRegistration reads AFROBASE_REGISTRATION: "open" lets anyone sign up
+// and "closed" stops new sign-ups while existing users can still log in.
+// Unset, registration is closed once API keys are configured, since an
+// access token stands in for a key.
+func setRegistration(keysConfigured bool) error {
+	switch v := os.Getenv("AFROBASE_REGISTRATION"); v {
+	case "open":
+		registrationOpen = true
+	case "closed":
+		registrationOpen = false
+	case "":
+		registrationOpen = !keysConfigured
+	default:
+		return fmt.Errorf("%q is not open or closed", v)
+	}
+	return nil
+}
+
+// Limits on account details
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+	// Passwords are hashed on every login, so their length is bounded
+	maxPasswordLength = 256
+)
+
+// passwordIterations is the PBKDF2-SHA256 work factor for new passwords.
+// The count is stored with each hash, so it can be raised later.
+const passwordIterations = 600000
+
+var (
+	errUsernameTaken      = errors.New("username is taken")
+	errRegistrationClosed = errors.New("registration is closed")
+)
+
+// view is the API representation of a user
+func (u user) view() fiber.Map {
+	return fiber.Map{
+		"id":         u.ID,
+		"username":   u.Username,
+		"created_at": u.Created,
+		"version":    u.Version,
+	}
+}
+
+// checkUsername enforces the rules for usernames: letters, digits, dots,
+// hyphens and underscores, starting with a letter or digit
+func checkUsername(name string) error {
+	if n := len(name); n < minUsernameLength || n > maxUsernameLength {
+		return fmt.Errorf("username must be %d to %d characters", minUsernameLength, maxUsernameLength)
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case i > 0 && (r == '.' || r == '-' || r == '_'):
+		default:
+			return errors.New("username may only contain letters, digits, '.', '-' and '_', and must start with a letter or digit")
+		}
+	}
+	return nil
+}
+
+// checkPassword enforces the length limits on passwords
+func checkPassword(password string) error {
+	if n := utf8.RuneCountInString(password); n < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordLength)
+	}
+	return nil
+}
+
+// hashPassword hashes a password as
+// pbkdf2-sha256$<iterations>$<salt>$<hash>, base64 encoded
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPasswordHash reports whether password matches a hash made by
+// hashPassword
+func checkPasswordHash(password, hash string) bool {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 4 || fields[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(fields[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(fields[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// dummyPasswordHash is checked against when a login names no user, so the
+// response takes as long as for a wrong password
+var dummyPasswordHash, _ = hashPassword("not a password")
+
+// createUser saves a new user, failing with errUsernameTaken if the name
+// is in use in any case. An invite code, if given, is used up, failing
+// with errInvalidInvite if it can't be. Any identities given, from OAuth
+// providers, are linked to the user as it is created.
+func (s *metadataStore) createUser(u user, invite string, identities ...string) error {
+	value, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		names := tx.Bucket(usernamesBucket)
+		key := []byte(strings.ToLower(u.Username))
+		if names.Get(key) != nil {
+			return errUsernameTaken
+		}
+		if err := names.Put(key, []byte(u.ID)); err != nil {
+			return err
+		}
+		if invite != "" {
+			if err := redeemInviteTx(tx, invite); err != nil {
+				return err
+			}
+		}
+		for _, identity := range identities {
+			if err := linkIdentityTx(tx, identity, u.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(usersBucket).Put([]byte(u.ID), value)
+	})
+}
+
+// user loads a user by ID
+func (s *metadataStore) user(id string) (user, bool) {
+	var u user
+	found := false
+	if s == nil {
+		return u, false
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(usersBucket).Get([]byte(id)); value != nil {
+			found = json.Unmarshal(value, &u) == nil
+		}
+		return nil
+	})
+	return u, found
+}
+
+// updateUser applies change to a user in one transaction, keeping the
+// username index in step and failing with errUsernameTaken if a new name
+// is in use
+func (s *metadataStore) updateUser(id string, change func(u *user) error) (user, error) {
+	var u user
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		value := bucket.Get([]byte(id))
+		if value == nil {
+			return errInvalidToken
+		}
+		if err := json.Unmarshal(value, &u); err != nil {
+			return err
+		}
+		oldKey := []byte(strings.ToLower(u.Username))
+		if err := change(&u); err != nil {
+			return err
+		}
+		if key := []byte(strings.ToLower(u.Username)); !bytes.Equal(key, oldKey) {
+			names := tx.Bucket(usernamesBucket)
+			if names.Get(key) != nil {
+				return errUsernameTaken
+			}
+			if err := names.Delete(oldKey); err != nil {
+				return err
+			}
+			if err := names.Put(key, []byte(u.ID)); err != nil {
+				return err
+			}
+		}
+		u.Version++
+		value, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), value)
+	})
+	return u, err
+}
+
+// userByName loads a user by username, ignoring case
+func (s *metadataStore) userByName(name string) (user, bool) {
+	var id []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		id = append(id, tx.Bucket(usernamesBucket).Get([]byte(strings.ToLower(name)))...)
+		return nil
+	})
+	if id == nil {
+		return user{}, false
+	}
+	return s.user(string(id))
+}
+
+// credentials is the body of register and login requests
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Invite is a code from an admin, needed while registration is closed
+	Invite string `json:"invite,omitempty"`
+}
+
+// sendToken responds with a new access token for u
+func sendToken(c *fiber.Ctx, status int, u user) error {
+	token, exp, err := issueToken(u)
+	if err != nil {
+		log.Printf("Error issuing token for %s: %v", u.Username, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to issue token",
+			"success": false,
+		})
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"success":      true,
+		"user":         u.view(),
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_at":   exp.UTC().Format(time.RFC3339),
+	})
+}
+
+// register creates an account and logs it in: POST /api/auth/register
+// with {"username": "amani", "password": "…"}. While registration is
+// closed it needs an invite code, as "invite" or ?invite=.
+func register(c *fiber.Ctx) error {
+	var req credentials
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"success": false,
+		})
+	}
+	invite := cmp.Or(req.Invite, c.Query("invite"))
+	if registrationOpen {
+		invite = ""
+	} else if invite == "" {
+		return c.Status(403).JSON(fiber.Map{
+			"error":   "Registration is closed",
+			"success": false,
+		})
+	}
+	err := checkUsername(req.Username)
+	if err == nil {
+		err = checkPassword(req.Password)
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	id, err := newID(8)
+	if err != nil {
+		log.Printf("Error generating user ID: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create user",
+			"success": false,
+		})
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create user",
+			"success": false,
+		})
+	}
+	u := user{ID: id, Username: req.Username, PasswordHash: hash, Created: serverClock.Now().Unix()}
+	if err := metadata.createUser(u, invite); err != nil {
+		if errors.Is(err, errUsernameTaken) {
+			return c.Status(409).JSON(fiber.Map{
+				"error":   "Username is taken",
+				"success": false,
+			})
+		}
+		if errors.Is(err, errInvalidInvite) {
+			return c.Status(403).JSON(fiber.Map{
+				"error":   "Invalid or expired invite",
+				"success": false,
+			})
+		}
+		log.Printf("Error saving user %s: %v", u.Username, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to create user",
+			"success": false,
+		})
+	}
+	c.Locals("user", u)
+	audit(c, "register", u.Username)
+	return sendToken(c, 201, u)
+}
+
+// login exchanges a username and password for an access token:
+// POST /api/auth/login with {"username": "amani", "password": "…"}
+func login(c *fiber.Ctx) error {
+	var req credentials
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"success": false,
+		})
+	}
+	u, ok := metadata.userByName(req.Username)
+	hash := dummyPasswordHash
+	if ok {
+		hash = u.PasswordHash
+	}
+	if len(req.Password) > maxPasswordLength || !checkPasswordHash(req.Password, hash) || !ok {
+		audit(c, "login_failed", req.Username)
+		return c.Status(401).JSON(fiber.Map{
+			"error":   "Invalid username or password",
+			"success": false,
+		})
+	}
+	c.Locals("user", u)
+	audit(c, "login", u.Username)
+	return sendToken(c, 200, u)
+}
+
+// getCurrentUser returns the user a token belongs to: GET /api/auth/me.
+// The ETag is the profile's version.
+func getCurrentUser(c *fiber.Ctx) error {
+	u, ok := requestUser(c)
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return c.Status(401).JSON(fiber.Map{
+			"error":   "An access token is required",
+			"success": false,
+		})
+	}
+	c.Set(fiber.HeaderETag, versionTag(u.Version))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"user":    u.view(),
+	})
+}
+
+// updateCurrentUser changes the username or password of the user a token
+// belongs to: PATCH /api/auth/me with {"username"} or {"password",
+// "current_password"}. Accounts made through OAuth may set a first
+// password without a current one. If-Match must carry the profile's
+// version: a 428 answers requests without it and a 412, with the current
+// profile, those made against another version.
+func updateCurrentUser(c *fiber.Ctx) error {
+	u, ok := requestUser(c)
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return c.Status(401).JSON(fiber.Map{
+			"error":   "An access token is required",
+			"success": false,
+		})
+	}
+	var req struct {
+		Username        *string `json:"username"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"success": false,
+		})
+	}
+	var err error
+	switch {
+	case req.Username == nil && req.Password == nil:
+		err = errors.New("Nothing to change: give a username or password")
+	case req.Username != nil:
+		err = checkUsername(*req.Username)
+	}
+	if err == nil && req.Password != nil {
+		err = checkPassword(*req.Password)
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   err.Error(),
+			"success": false,
+		})
+	}
+
+	var hash string
+	if req.Password != nil {
+		if u.PasswordHash != "" && (len(req.CurrentPassword) > maxPasswordLength || !checkPasswordHash(req.CurrentPassword, u.PasswordHash)) {
+			audit(c, "profile_update_refused", u.Username)
+			return c.Status(403).JSON(fiber.Map{
+				"error":   "Current password is wrong",
+				"success": false,
+			})
+		}
+		if hash, err = hashPassword(*req.Password); err != nil {
+			log.Printf("Error hashing password: %v", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   "Failed to update user",
+				"success": false,
+			})
+		}
+	}
+
+	var stale user
+	updated, err := metadata.updateUser(u.ID, func(u *user) error {
+		if err := checkVersion(c, u.Version); err != nil {
+			stale = *u
+			return err
+		}
+		if req.Username != nil {
+			u.Username = *req.Username
+		}
+		if hash != "" {
+			u.PasswordHash = hash
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errVersionRequired):
+		return sendVersionRequired(c)
+	case errors.Is(err, errStaleVersion):
+		return c.Status(412).JSON(fiber.Map{
+			"error":   "Profile has changed since the version in If-Match",
+			"success": false,
+			"current": stale.view(),
+			"yours":   fiber.Map{"username": req.Username},
+		})
+	case errors.Is(err, errUsernameTaken):
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Username is taken",
+			"success": false,
+		})
+	case err != nil:
+		log.Printf("Error updating user %s: %v", u.ID, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   "Failed to update user",
+			"success": false,
+		})
+	}
+	audit(c, "profile_update", updated.Username)
+
+	c.Set(fiber.HeaderETag, versionTag(updated.Version))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"user":    updated.view(),
+	})
+}
+
+// listingOwner is the user whose images a listing is limited to: the one
+// making the request, unless it asks for ?scope=all. Anonymous requests
+// and API keys list every image.
+func listingOwner(c *fiber.Ctx) (string, error) {
+	u, ok := requestUser(c)
+	switch c.Query("scope") {
+	case "all":
+		return "", nil
+	case "mine":
+		if !ok {
+			return "", errors.New("scope=mine needs an access token")
+		}
+	case "":
+		if !ok {
+			return "", nil
+		}
+	default:
+		return "", errors.New(`scope must be "mine" or "all"`)
+	}
+	return u.ID, nil
+}
+
+// ownedBy keeps the images in a listing that belong to a user
+func ownedBy(objects []storage.Object, owner string) []storage.Object {
+	return slices.DeleteFunc(objects, func(o storage.Object) bool { return imageInfo(o.Key).Owner != owner })
+}
+
+// mayChange reports whether a request may change or delete an image.
+// Images uploaded by a user are theirs alone, though secret API keys may
+// still manage them; images without an owner are open to any request
+// that gets past requireAPIKey.
+func mayChange(c *fiber.Ctx, name string) bool {
+	return ownedByCaller(c, imageInfo(name).Owner)
+}
+
+// ownedByCaller reports whether a request may manage something owned by
+// the user with ID owner, or by nobody if owner is ""
+func ownedByCaller(c *fiber.Ctx, owner string) bool {
+	if owner == "" || hasSecretKey(c) {
+		return true
+	}
+	u, ok := requestUser(c)
+	return ok && u.ID == owner
+}
+
+var errNotOwner = errors.New("belongs to another user")
+
+// sendNotOwner refuses a change to someone else's image or album with
+// message, or asks for a token if the request had none
+func sendNotOwner(c *fiber.Ctx, message string) error {
+	if _, ok := requestUser(c); !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return c.Status(401).JSON(fiber.Map{
+			"error":   "An access token is required",
+			"success": false,
+		})
+	}
+	return c.Status(403).JSON(fiber.Map{
+		"error":   message,
+		"success": false,
+	})
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// variantSizes are the bounding boxes, in pixels, generated for uploads
+// made without a profile. AFROBASE_THUMBNAIL_SIZES overrides them.
+var variantSizes = []int{200, 800}
+
+// maxVariantSize bounds configured thumbnail sizes
+const maxVariantSize = 4096
+
+// parseVariantSizes parses a comma-separated list of sizes such as
+// "200,800", returning them smallest first
+func parseVariantSizes(v string) ([]int, error) {
+	var sizes []int
+	seen := map[int]bool{}
+	for _, field := range strings.Split(v, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size <= 0 || size > maxVariantSize {
+			return nil, fmt.Errorf("invalid size %q", strings.TrimSpace(field))
+		}
+		if !seen[size] {
+			seen[size] = true
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+// variantSpec describes one generated rendition of an upload. Dir is its
+// subdirectory of thumbs/ in storage and Key the name the API exposes it under.
+type variantSpec struct {
+	Key    string
+	Dir    string
+	Width  int
+	Height int
+	Crop   bool
+}
+
+// defaultVariantSpecs are the variants of uploads made without a profile
+func defaultVariantSpecs() []variantSpec {
+	specs := make([]variantSpec, 0, len(variantSizes))
+	for _, size := range variantSizes {
+		key := strconv.Itoa(size)
+		specs = append(specs, variantSpec{Key: key, Dir: key, Width: size, Height: size})
+	}
+	return specs
+}
+
+// variantSets returns every set of variants an upload can have: the
+// default set first, then one per upload profile
+func variantSets() [][]variantSpec {
+	sets := [][]variantSpec{defaultVariantSpecs()}
+	for _, name := range profileNames() {
+		sets = append(sets, uploadProfiles[name].variantSpecs(name))
+	}
+	return sets
+}
+
+// variantJob asks the worker to render an upload's variants. Recrop jobs
+// replace variants already stored.
+type variantJob struct {
+	name   string
+	specs  []variantSpec
+	recrop bool
+}
+
+// variantQueue feeds uploads to the variant workers
+var variantQueue = make(chan variantJob, 1024)
+
+// variantsStop is closed at shutdown, after which workers take no new jobs.
+// Jobs still queued are found missing and requeued on the next start.
+var (
+	variantsStop   = make(chan struct{})
+	variantWorkers sync.WaitGroup
+)
+
+// startVariantWorker generates variants in the background and queues any
+// existing uploads that are missing them
+func startVariantWorker() error {
+	for i := 0; i < maxTransforms; i++ {
+		variantWorkers.Add(1)
+		go runVariantWorker()
+	}
+
+	if err := queueMissingVariants(); err != nil {
+		return err
+	}
+
+	// Uploads made outside the processing window are picked up when it opens
+	if processingWindow != nil {
+		go func() {
+			for {
+				// A frozen clock never reaches the next opening, so sweeps are
+				// at least a minute apart
+				now := serverClock.Now()
+				time.Sleep(max(time.Minute, processingWindow.nextOpen(now).Sub(now)))
+				log.Printf("Processing window open, queueing deferred variants")
+				if err := queueMissingVariants(); err != nil {
+					log.Printf("Error queueing deferred variants: %v", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// runVariantWorker renders queued variant jobs, at a lower priority than
+// request handling if AFROBASE_WORKER_NICE is set
+func runVariantWorker() {
+	defer variantWorkers.Done()
+	if err := lowerWorkerPriority(workerNice); err != nil {
+		log.Printf("Could not lower variant worker priority: %v", err)
+	}
+	for {
+		var job variantJob
+		select {
+		case <-variantsStop:
+			return
+		case job = <-variantQueue:
+		}
+		if !processingAllowed() {
+			now := serverClock.Now()
+			select {
+			case <-variantsStop:
+				return
+			case <-time.After(processingWindow.nextOpen(now).Sub(now)):
+			}
+		}
+		if job.recrop {
+			removeVariantFiles(job.name, job.specs)
+		} else if variantsComplete(job.name, job.specs) {
+			// A sweep queued the upload again before this job ran
+			continue
+		}
+		if err := generateVariants(job.name, job.specs); err != nil {
+			log.Printf("Error generating variants for %s: %v", job.name, err)
+		}
+	}
+}
+
+// stopVariantWorkers lets running jobs finish and stops the workers,
+// reporting whether they stopped within timeout
+func stopVariantWorkers(timeout time.Duration) bool {
+	close(variantsStop)
+	done := make(chan struct{})
+	go func() {
+		variantWorkers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// queueMissingVariants queues every upload that is missing variants
+func queueMissingVariants() error {
+	files, err := uploadedFiles()
+	if err != nil {
+		return err
+	}
+	go func() {
+		for _, file := range files {
+			name := file.Name()
+			if specs := variantSetFor(name); !variantsComplete(name, specs) {
+				variantQueue <- variantJob{name: name, specs: specs}
+			}
+		}
+	}()
+	return nil
+}
+
+// enqueueVariants schedules variant generation for a newly saved upload.
+// Outside the processing window it does nothing; the sweep when the window
+// opens finds the upload instead.
+func enqueueVariants(name string, specs []variantSpec) {
+	if !processingAllowed() {
+		return
+	}
+	select {
+	case variantQueue <- variantJob{name: name, specs: specs}:
+	default:
+		log.Printf("Variant queue full, %s will be processed on next restart", name)
+	}
+}
+
+// recropVariants regenerates the cropped variants of an upload, as after
+// its focal point moves. Outside the processing window the job waits in
+// the queue, and the old crops are served until it runs.
+func recropVariants(name string) {
+	var specs []variantSpec
+	for _, spec := range variantSetFor(name) {
+		if spec.Crop {
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		return
+	}
+	select {
+	case variantQueue <- variantJob{name: name, specs: specs, recrop: true}:
+	default:
+		log.Printf("Variant queue full, %s keeps its old crops", name)
+	}
+}
+
+// removeVariantFiles deletes the stored files of some of an upload's
+// variants
+func removeVariantFiles(name string, specs []variantSpec) {
+	for _, spec := range specs {
+		for _, key := range variantFiles(name, spec) {
+			if uploadMirror != nil {
+				uploadMirror.remove(key)
+			}
+			if err := uploadStore.Delete(context.Background(), key); err != nil {
+				log.Printf("Error deleting %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// generateVariants stores each variant of an upload and its renditions,
+// skipping those already stored. Storage never exposes a partly written
+// object, so a variant that can be found is complete.
+func generateVariants(name string, specs []variantSpec) error {
+	data, err := readDisplaySource(name)
+	if err != nil {
+		return err
+	}
+	if err := checkPixelBudget(data); err != nil {
+		return err
+	}
+
+	meta, _ := metadata.get(name)
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, spec := range specs {
+		files := variantFiles(name, spec)
+		formats := missingFormats(files)
+		if !slices.ContainsFunc(files, func(key string) bool { return !isRendition(key) }) {
+			formats = append([]string{""}, formats...)
+		}
+		for _, format := range formats {
+			opts := TransformOptions{Width: spec.Width, Height: spec.Height, Crop: spec.Crop, Focus: meta.FocalPoint, Format: format}
+			out, ext, err := imageProcessor.Resize(data, opts)
+			if err != nil {
+				return err
+			}
+			key := "thumbs/" + spec.Dir + "/" + base + ext
+			if err := writeUpload(context.Background(), key, out); err != nil {
+				return err
+			}
+			if uploadMirror != nil {
+				uploadMirror.enqueue(key)
+			}
+		}
+	}
+	return nil
+}
+
+// variantFiles lists the stored files of one variant of an upload, its
+// JPEG or PNG and any renditions, in key order
+func variantFiles(name string, spec variantSpec) []string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	objects, _ := uploadStore.List(context.Background(), "thumbs/"+spec.Dir+"/"+base+".")
+	var keys []string
+	for _, object := range objects {
+		if imageID(path.Base(object.Key)) == base {
+			keys = append(keys, object.Key)
+		}
+	}
+	return keys
+}
+
+// missingFormats returns the rendition formats a variant's files lack
+func missingFormats(files []string) []string {
+	var missing []string
+	for _, format := range renditionFormats {
+		if !slices.ContainsFunc(files, func(key string) bool { return path.Ext(key) == "."+format }) {
+			missing = append(missing, format)
+		}
+	}
+	return missing
+}
+
+// variantsComplete reports whether every variant of an upload and all of
+// their renditions are stored
+func variantsComplete(name string, specs []variantSpec) bool {
+	for _, spec := range specs {
+		files := variantFiles(name, spec)
+		if !slices.ContainsFunc(files, func(key string) bool { return !isRendition(key) }) || len(missingFormats(files)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// variantSetFor works out which variant set an upload belongs to from the
+// variants already on disk, defaulting to the standard set
+func variantSetFor(name string) []variantSpec {
+	sets := variantSets()
+	for _, specs := range sets {
+		if len(findVariants(name, specs)) > 0 {
+			return specs
+		}
+	}
+	return sets[0]
+}
+
+// variantURLs returns the path of each generated variant of an upload keyed
+// by variant name, and whether all of them are ready
+func variantURLs(name string) (map[string]string, bool) {
+	specs := variantSetFor(name)
+	urls := findVariants(name, specs)
+	return urls, len(urls) == len(specs)
+}
+
+// thumbnailPath returns the path of the smallest generated variant of an
+// upload, or "" if none is ready yet
+func thumbnailPath(name string, urls map[string]string) string {
+	best, bestArea := "", 0
+	for _, spec := range variantSetFor(name) {
+		path, ok := urls[spec.Key]
+		if !ok {
+			continue
+		}
+		if area := spec.Width * spec.Height; best == "" || area < bestArea {
+			best, bestArea = path, area
+		}
+	}
+	return best
+}
+
+// largestVariantPath returns the path of the largest generated variant of
+// an upload, or "" if none is ready yet
+func largestVariantPath(name string, urls map[string]string) string {
+	best, bestArea := "", 0
+	for _, spec := range variantSetFor(name) {
+		path, ok := urls[spec.Key]
+		if !ok {
+			continue
+		}
+		if area := spec.Width * spec.Height; area > bestArea {
+			best, bestArea = path, area
+		}
+	}
+	return best
+}
+
+// findVariants returns the paths of the variants in specs that exist for an
+// upload, keyed by variant name
+func findVariants(name string, specs []variantSpec) map[string]string {
+	urls := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		for _, key := range variantFiles(name, spec) {
+			if !isRendition(key) {
+				urls[spec.Key] = "/uploads/" + key
+				break
+			}
+		}
+	}
+	return urls
+}
+
+// variantKey maps a variant path returned by variantURLs to its key in
+// storage
+func variantKey(path string) string {
+	return strings.TrimPrefix(path, "/uploads/")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Changes to images, albums and the user's own profile must say which
+// version they were made against, so concurrent editors can't lose each
+// other's changes
+var (
+	errVersionRequired = errors.New("If-Match is required")
+	errStaleVersion    = errors.New("changed since the version in If-Match")
+)
+
+// versionTag is the entity tag of a version, as sent in If-Match
+func versionTag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// checkVersion checks a change request's If-Match against the version of
+// what it changes, or any other entity tag that stands for it, failing
+// with errVersionRequired or errStaleVersion
+func checkVersion(c *fiber.Ctx, version int64, tags ...string) error {
+	match := c.Get(fiber.HeaderIfMatch)
+	if match == "" {
+		return errVersionRequired
+	}
+	tags = append(tags, versionTag(version))
+	for _, tag := range strings.Split(match, ",") {
+		tag = strings.TrimSpace(tag)
+		for _, want := range tags {
+			if tag == "*" || tag == want {
+				return nil
+			}
+		}
+	}
+	return errStaleVersion
+}
+
+// sendVersionRequired answers a change request that lacks If-Match
+func sendVersionRequired(c *fiber.Ctx) error {
+	return c.Status(428).JSON(fiber.Map{
+		"error":   "If-Match is required: send the version being changed, from its version field or ETag",
+		"success": false,
+	})
+}
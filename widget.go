@@ -0,0 +1,299 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getUploadWidget serves the upload widget frontends include instead of
+// writing their own upload code:
+//
+//	<script src="https://host/widget/upload.js"></script>
+//	<div data-afrobase-upload data-key="..." data-profile="avatar"></div>
+//
+// Elements marked data-afrobase-upload get a drop zone with previews and
+// progress bars, and fire afrobase:uploaded and afrobase:error events.
+// Scripts can call AfroBaseUpload.mount(element, options) or
+// AfroBaseUpload.upload(file, options) directly. Files go up in chunks
+// over the resumable upload API and resume after dropped connections.
+func getUploadWidget(c *fiber.Ctx) error {
+	c.Type("js")
+	c.Set("Cache-Control", "public, max-age=3600")
+	return c.SendString(strings.ReplaceAll(uploadWidgetScript, "{{BASE_URL}}", publicBaseURL))
+}
+
+const uploadWidgetScript = `(function () {
+  "use strict";
+  var BASE_URL = "{{BASE_URL}}";
+  var CHUNK_SIZE = 5 * 1024 * 1024;
+  var RETRY_DELAYS = [1000, 3000, 5000, 10000, 20000];
+
+  function encodeMeta(value) {
+    return btoa(unescape(encodeURIComponent(String(value))));
+  }
+
+  function uploadError(message, status, retryable) {
+    var err = new Error(message);
+    err.status = status;
+    err.retryable = retryable;
+    return err;
+  }
+
+  // Turns a failed response into an error, retryable if trying again later
+  // could succeed
+  function responseError(xhr) {
+    var message = "Upload failed (" + xhr.status + ")";
+    try {
+      var body = JSON.parse(xhr.responseText);
+      if (body && body.error) message = body.error;
+    } catch (e) {}
+    var s = xhr.status;
+    return uploadError(message, s, s === 0 || s >= 500 || s === 409 || s === 423 || s === 429);
+  }
+
+  function send(method, url, headers, body, onProgress, track) {
+    return new Promise(function (resolve, reject) {
+      var xhr = new XMLHttpRequest();
+      xhr.open(method, url);
+      for (var name in headers) {
+        if (Object.prototype.hasOwnProperty.call(headers, name)) xhr.setRequestHeader(name, headers[name]);
+      }
+      if (onProgress && xhr.upload) {
+        xhr.upload.onprogress = function (event) { onProgress(event.loaded); };
+      }
+      xhr.onload = function () { resolve(xhr); };
+      xhr.onerror = function () { reject(uploadError("Network error", 0, true)); };
+      xhr.ontimeout = xhr.onerror;
+      xhr.onabort = function () { reject(uploadError("Upload aborted", 0, false)); };
+      if (track) track(xhr);
+      xhr.send(body === undefined ? null : body);
+    });
+  }
+
+  // upload sends a File or Blob and resolves with the server's upload
+  // result. Options: apiKey, title, description, tags (an array), profile,
+  // mode, chunkSize, retryDelays, signal (an AbortSignal),
+  // onProgress(sent, total) and endpoint (defaults to the server the script
+  // came from).
+  function upload(file, options) {
+    options = options || {};
+    var endpoint = (options.endpoint || BASE_URL).replace(/\/+$/, "");
+    var chunkSize = options.chunkSize || CHUNK_SIZE;
+    var delays = options.retryDelays || RETRY_DELAYS;
+    var signal = options.signal;
+
+    function headers(extra) {
+      var h = { "Tus-Resumable": "1.0.0" };
+      if (options.apiKey) h["X-API-Key"] = options.apiKey;
+      for (var name in extra) h[name] = extra[name];
+      return h;
+    }
+
+    var meta = {
+      filename: file.name || "",
+      filetype: file.type || "",
+      title: options.title !== undefined ? options.title : (file.name || "").replace(/\.[^.]*$/, ""),
+      description: options.description || "",
+      profile: options.profile || "",
+      mode: options.mode || "",
+      tags: (options.tags || []).join(",")
+    };
+    var pairs = [];
+    for (var key in meta) {
+      if (meta[key]) pairs.push(key + " " + encodeMeta(meta[key]));
+    }
+
+    return new Promise(function (resolve, reject) {
+      var location = null;
+      var offset = 0;
+      var attempt = 0;
+      var current = null;
+      var finished = false;
+
+      function done(err, result) {
+        if (finished) return;
+        finished = true;
+        if (err) reject(err); else resolve(result);
+      }
+      function track(xhr) { current = xhr; }
+      function progress(sent) {
+        if (options.onProgress) options.onProgress(Math.min(sent, file.size), file.size);
+      }
+
+      if (signal) {
+        if (signal.aborted) return done(uploadError("Upload aborted", 0, false));
+        signal.addEventListener("abort", function () {
+          if (current) current.abort();
+          if (location) send("DELETE", location, headers({})).catch(function () {});
+          done(uploadError("Upload aborted", 0, false));
+        });
+      }
+
+      function fail(err) {
+        if (finished) return;
+        if (err.retryable && attempt < delays.length) {
+          setTimeout(resume, delays[attempt++]);
+        } else {
+          done(err);
+        }
+      }
+
+      function create() {
+        send("POST", endpoint + "/api/tus", headers({
+          "Upload-Length": String(file.size),
+          "Upload-Metadata": pairs.join(",")
+        }), undefined, null, track).then(function (xhr) {
+          if (xhr.status !== 201) throw responseError(xhr);
+          location = xhr.getResponseHeader("Location");
+          offset = 0;
+          sendChunk();
+        }).catch(fail);
+      }
+
+      // Asks the server how much arrived before carrying on from there
+      function resume() {
+        if (finished) return;
+        if (!location) return create();
+        send("HEAD", location, headers({}), undefined, null, track).then(function (xhr) {
+          if (xhr.status === 404) {
+            location = null;
+            return create();
+          }
+          if (xhr.status !== 200) throw responseError(xhr);
+          offset = parseInt(xhr.getResponseHeader("Upload-Offset"), 10) || 0;
+          progress(offset);
+          if (offset < file.size) return sendChunk();
+          // Every byte arrived, so the upload was committed or rejected
+          return send("GET", endpoint + "/api/uploads/" + location.split("/").pop(), headers({}), undefined, null, track)
+            .then(function (session) {
+              var body = JSON.parse(session.responseText);
+              if (body.state === "committed") {
+                done(null, { success: true, url: body.url, name: body.name });
+              } else {
+                throw uploadError("Upload was not accepted", session.status, body.state === "committing");
+              }
+            });
+        }).catch(fail);
+      }
+
+      function sendChunk() {
+        if (finished) return;
+        var start = offset;
+        var chunk = file.slice(start, start + chunkSize);
+        send("PATCH", location, headers({
+          "Upload-Offset": String(start),
+          "Content-Type": "application/offset+octet-stream"
+        }), chunk, function (loaded) { progress(start + loaded); }, track).then(function (xhr) {
+          if (xhr.status === 204) {
+            offset = parseInt(xhr.getResponseHeader("Upload-Offset"), 10);
+            attempt = 0;
+            return sendChunk();
+          }
+          if (xhr.status !== 200) throw responseError(xhr);
+          progress(file.size);
+          done(null, JSON.parse(xhr.responseText));
+        }).catch(fail);
+      }
+
+      create();
+    });
+  }
+
+  // mount turns an element into a drop zone with a file picker, showing a
+  // preview and progress bar per file. Options are those of upload, plus
+  // onUploaded(result, file) and onError(error, file).
+  function mount(element, options) {
+    options = options || {};
+    var zone = document.createElement("div");
+    zone.className = "afrobase-upload";
+    zone.style.cssText = "border:2px dashed #bbb;border-radius:8px;padding:16px;text-align:center;font:14px sans-serif";
+    var input = document.createElement("input");
+    input.type = "file";
+    input.accept = "image/*";
+    input.multiple = true;
+    var list = document.createElement("ul");
+    list.style.cssText = "list-style:none;margin:12px 0 0;padding:0;text-align:left";
+    zone.appendChild(input);
+    zone.appendChild(list);
+    element.appendChild(zone);
+
+    function addFile(file) {
+      var item = document.createElement("li");
+      item.style.cssText = "display:flex;align-items:center;gap:8px;margin:6px 0";
+      if (file.type.indexOf("image/") === 0 && window.URL && URL.createObjectURL) {
+        var preview = document.createElement("img");
+        preview.src = URL.createObjectURL(file);
+        preview.alt = "";
+        preview.style.cssText = "width:48px;height:48px;object-fit:cover;border-radius:4px";
+        preview.onload = function () { URL.revokeObjectURL(preview.src); };
+        item.appendChild(preview);
+      }
+      var bar = document.createElement("progress");
+      bar.max = 1;
+      bar.value = 0;
+      var status = document.createElement("span");
+      status.textContent = file.name;
+      item.appendChild(bar);
+      item.appendChild(status);
+      list.appendChild(item);
+
+      var opts = {};
+      for (var name in options) opts[name] = options[name];
+      opts.onProgress = function (sent, total) { bar.value = total ? sent / total : 1; };
+      upload(file, opts).then(function (result) {
+        bar.value = 1;
+        status.textContent = file.name + (result.duplicate ? " (already uploaded)" : " uploaded");
+        if (options.onUploaded) options.onUploaded(result, file);
+        element.dispatchEvent(new CustomEvent("afrobase:uploaded", { detail: { result: result, file: file } }));
+      }, function (err) {
+        status.textContent = file.name + ": " + err.message;
+        status.style.color = "#b00020";
+        if (options.onError) options.onError(err, file);
+        element.dispatchEvent(new CustomEvent("afrobase:error", { detail: { error: err, file: file } }));
+      });
+    }
+
+    function addFiles(files) {
+      for (var i = 0; i < files.length; i++) addFile(files[i]);
+    }
+    input.addEventListener("change", function () {
+      addFiles(input.files);
+      input.value = "";
+    });
+    zone.addEventListener("dragover", function (event) {
+      event.preventDefault();
+      zone.style.borderColor = "#666";
+    });
+    zone.addEventListener("dragleave", function () { zone.style.borderColor = "#bbb"; });
+    zone.addEventListener("drop", function (event) {
+      event.preventDefault();
+      zone.style.borderColor = "#bbb";
+      if (event.dataTransfer) addFiles(event.dataTransfer.files);
+    });
+    return zone;
+  }
+
+  window.AfroBaseUpload = { upload: upload, mount: mount };
+
+  function mountAll() {
+    var elements = document.querySelectorAll("[data-afrobase-upload]");
+    for (var i = 0; i < elements.length; i++) {
+      var el = elements[i];
+      if (el.getAttribute("data-afrobase-mounted")) continue;
+      el.setAttribute("data-afrobase-mounted", "true");
+      mount(el, {
+        apiKey: el.getAttribute("data-key") || undefined,
+        profile: el.getAttribute("data-profile") || undefined,
+        mode: el.getAttribute("data-mode") || undefined,
+        tags: el.getAttribute("data-tags") ? el.getAttribute("data-tags").split(",") : undefined
+      });
+    }
+  }
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", mountAll);
+  } else {
+    mountAll();
+  }
+})();
+`